@@ -0,0 +1,71 @@
+// Command blobmigrate copies uploaded files from one blob store backend to
+// another, e.g. from the local uploads directory to an S3 bucket:
+//
+//	go run ./cmd/blobmigrate -from local -to s3
+//
+// Backends are configured with the same settings as the forum (UPLOADS_DIR,
+// S3_ENDPOINT, S3_BUCKET, ...), read from the environment and from the config
+// file given with -config (./.env when present).
+// Files that already exist in the destination are skipped unless -overwrite
+// is given, so an interrupted run can simply be repeated.
+package main
+
+import (
+	"context"
+	"flag"
+	"forum/internal/config"
+	"forum/internal/storage"
+	"log"
+	"os"
+	"os/signal"
+)
+
+func main() {
+	from := flag.String("from", "local", "source backend: local or s3")
+	to := flag.String("to", "s3", "destination backend: local or s3")
+	fromDir := flag.String("from-dir", "", "root directory when the source is local (default UPLOADS_DIR)")
+	toDir := flag.String("to-dir", "", "root directory when the destination is local (default UPLOADS_DIR)")
+	overwrite := flag.Bool("overwrite", false, "replace files that already exist in the destination")
+	dryRun := flag.Bool("dry-run", false, "only report what would be copied")
+	configFile := flag.String("config", "", "config file in .env format (default ./.env when present)")
+	flag.Parse()
+
+	var configArgs []string
+	if *configFile != "" {
+		configArgs = []string{"-config", *configFile}
+	}
+	cfg, err := config.Load(configArgs)
+	if err != nil {
+		log.Fatalf("configuration: %v", err)
+	}
+
+	src, err := openStore(cfg.Storage, *from, *fromDir)
+	if err != nil {
+		log.Fatalf("source: %v", err)
+	}
+	dst, err := openStore(cfg.Storage, *to, *toDir)
+	if err != nil {
+		log.Fatalf("destination: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	res, err := storage.Copy(ctx, src, dst, *overwrite, *dryRun, log.Printf)
+	if err != nil {
+		log.Fatalf("migration stopped after %d files: %v", res.Copied, err)
+	}
+	if *dryRun {
+		log.Printf("dry run: %d files would be copied, %d already present", res.Copied, res.Skipped)
+		return
+	}
+	log.Printf("done: %d files copied, %d already present", res.Copied, res.Skipped)
+}
+
+func openStore(cfg config.Storage, backend, dir string) (storage.BlobStore, error) {
+	cfg.Backend = backend
+	if dir != "" {
+		cfg.LocalDir = dir
+	}
+	return storage.Open(cfg)
+}
@@ -3,8 +3,8 @@ package db
 import (
 	"database/sql"
 	"fmt"
+	"forum/internal/config"
 	"forum/internal/security"
-	"github.com/joho/godotenv"
 	"log"
 	"os"
 	"path/filepath"
@@ -12,20 +12,9 @@ import (
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 )
 
-func SetupDatabase() error {
-
-	err := godotenv.Load()
-	if err != nil {
-		return err
-	}
-
-	fmt.Println("DB_PATH =", os.Getenv("DB_PATH"))
-	fmt.Println("DB_ENCRYPTION_KEY", os.Getenv("DB_ENCRYPTION_KEY"))
-	// Get database path from environment variable or use default
-	dbPath := os.Getenv("DB_PATH")
-	if dbPath == "" {
-		dbPath = filepath.Join("app", "database", "forum.db")
-	}
+// SetupDatabase creates the database file and its schema if needed
+func SetupDatabase(cfg config.Database) error {
+	dbPath := cfg.Path
 
 	// Create database directory if it doesn't exist
 	dbDir := filepath.Dir(dbPath)
@@ -34,14 +23,11 @@ func SetupDatabase() error {
 	}
 
 	// Open (or create) database file
-	key := os.Getenv("DB_ENCRYPTION_KEY")
-	if key == "" {
+	if cfg.EncryptionKey == "" {
 		return fmt.Errorf("encryption key not set in DB_ENCRYPTION_KEY")
 	}
 
-	dsn := fmt.Sprintf("%s?_pragma_key=%s&_pragma_cipher_page_size=4096", dbPath, key)
-
-	db, err := sql.Open("sqlite3", dsn)
+	db, err := sql.Open("sqlite3", cfg.DSN())
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
@@ -166,20 +152,7 @@ func SetupDatabase() error {
         FOREIGN KEY (reviewed_by) REFERENCES users(id)
     );
 
-	CREATE TABLE IF NOT EXISTS notifications (
-    id INTEGER PRIMARY KEY AUTOINCREMENT,
-    user_id INTEGER NOT NULL,          -- who the notification is intended for
-    type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment', 'reply')),
-    post_id INTEGER,
-    comment_id INTEGER,                -- for the answer
-    actor_id INTEGER,                  -- who did the action (answer)
-    is_read BOOLEAN DEFAULT 0,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-    FOREIGN KEY (user_id) REFERENCES users(id),
-    FOREIGN KEY (post_id) REFERENCES posts(id),
-    FOREIGN KEY (comment_id) REFERENCES comments(id),
-    FOREIGN KEY (actor_id) REFERENCES users(id)
-);
+	` + notificationsTable("notifications") + `;
 
 	CREATE INDEX IF NOT EXISTS idx_tags_name ON tags(name);
 
@@ -190,6 +163,133 @@ func SetupDatabase() error {
 		expires_at DATETIME NOT NULL,
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
+
+	CREATE TABLE IF NOT EXISTS post_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		editor_id INTEGER NOT NULL,        -- who made this version (author or moderator)
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		tags TEXT DEFAULT '',              -- comma separated snapshot
+		categories TEXT DEFAULT '',        -- comma separated category IDs
+		reason TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+		FOREIGN KEY (editor_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS comment_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		comment_id INTEGER NOT NULL,
+		editor_id INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		reason TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+		FOREIGN KEY (editor_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS post_pins (
+		post_id INTEGER NOT NULL,
+		category_id INTEGER NOT NULL DEFAULT 0, -- 0 pins the post globally (home page)
+		pinned_by INTEGER NOT NULL,
+		pinned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (post_id, category_id),
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+		FOREIGN KEY (pinned_by) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS moderation_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		moderator_id INTEGER NOT NULL,
+		action TEXT NOT NULL,              -- move, merge, split
+		post_id INTEGER NOT NULL,          -- the post acted on
+		target_post_id INTEGER,            -- merge target or post created by a split
+		details TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (moderator_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS post_attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		file_path TEXT NOT NULL,           -- upload path, shared between identical files
+		original_name TEXT NOT NULL,       -- file name offered when downloading
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		download_count INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS upload_orphans (
+		key TEXT PRIMARY KEY,              -- blob store key with no referencing row
+		first_seen DATETIME NOT NULL       -- when the garbage collector first found it unreferenced
+	);
+
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,              -- policy and client, e.g. /login-submit|ip:203.0.113.5
+		tat INTEGER NOT NULL               -- theoretical arrival time of the next request, unix nanoseconds
+	);
+
+	CREATE TABLE IF NOT EXISTS ip_blocks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		cidr TEXT NOT NULL,                -- blocked network; single addresses are /32 or /128
+		scope TEXT NOT NULL,               -- registration, posting or all
+		reason TEXT NOT NULL DEFAULT '',
+		created_by INTEGER REFERENCES users(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME                -- NULL for blocks lifted only by removing them
+	);
+
+	CREATE TABLE IF NOT EXISTS held_content (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,                -- post or comment
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		post_id INTEGER,                   -- post a comment is for; the created post once approved
+		parent_comment_id INTEGER,
+		title TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL,
+		payload TEXT NOT NULL DEFAULT '{}', -- categories, tags and uploads of a post, as JSON
+		reasons TEXT NOT NULL DEFAULT '',  -- why it was held, one per line
+		spam_score REAL,                   -- classifier's spam probability, NULL when it had no say
+		status TEXT NOT NULL DEFAULT 'pending', -- pending, approved or rejected
+		reviewed_by INTEGER REFERENCES users(id),
+		reviewed_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+
+	CREATE TABLE IF NOT EXISTS spam_tokens (
+		token TEXT PRIMARY KEY,
+		spam INTEGER NOT NULL DEFAULT 0,   -- spam documents containing the token
+		ham INTEGER NOT NULL DEFAULT 0     -- legitimate documents containing the token
+	);
+
+	CREATE TABLE IF NOT EXISTS spam_training (
+		class TEXT PRIMARY KEY,            -- spam or ham
+		documents INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS user_badges (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		badge TEXT NOT NULL,               -- key of the rule that awarded it
+		ref_id INTEGER NOT NULL DEFAULT 0, -- what earned a repeatable badge, e.g. a post; 0 for badges awarded once
+		awarded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		notified BOOLEAN NOT NULL DEFAULT 0,
+		UNIQUE(user_id, badge, ref_id)
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_user_badges_badge ON user_badges(badge);
+
+	-- Rules whose awards for past activity were already made; the first run
+	-- of a rule awards those without notifying anyone
+	CREATE TABLE IF NOT EXISTS badge_rules (
+		badge TEXT PRIMARY KEY,
+		backfilled_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
 	`
 
 	// Execute schema to create tables
@@ -197,6 +297,14 @@ func SetupDatabase() error {
 		return fmt.Errorf("failed to create tables: %v", err)
 	}
 
+	// Add columns introduced after the tables were first created
+	if err := ApplyColumnMigrations(db); err != nil {
+		return err
+	}
+	if err := ApplyNotificationTypes(db); err != nil {
+		return err
+	}
+
 	// Check if admin already exists in the users table
 	var count int
 	err = db.QueryRow(`SELECT COUNT(*) FROM users WHERE role = 'admin'`).Scan(&count)
@@ -225,9 +333,8 @@ func SetupDatabase() error {
 }
 
 // DropTable drops a table if it exists
-func DropTable(tableName string) error {
-	// Use consistent database path
-	dbCon, err := sql.Open("sqlite3", "app/database/forum.db")
+func DropTable(cfg config.Database, tableName string) error {
+	dbCon, err := sql.Open("sqlite3", cfg.DSN())
 	if err != nil {
 		return fmt.Errorf("failed to open database: %v", err)
 	}
@@ -250,20 +357,20 @@ func DropTable(tableName string) error {
 }
 
 // RecreateDatabase drops and recreates all tables (use with caution!)
-func RecreateDatabase() error {
+func RecreateDatabase(cfg config.Database) error {
 	// List of tables in dependency order (reverse order for dropping)
 	tables := []string{"sessions", "likes", "post_categories", "comments", "posts", "categories", "users"}
 
 	// Drop all tables
 	for _, table := range tables {
-		err := DropTable(table)
+		err := DropTable(cfg, table)
 		if err != nil {
 			log.Printf("Warning: failed to drop table %s: %v", table, err)
 		}
 	}
 
 	// Recreate all tables
-	return SetupDatabase()
+	return SetupDatabase(cfg)
 }
 
 // EnableForeignKeys enables foreign key constraints on a database connection
@@ -0,0 +1,203 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// columnMigration describes a column added to a table after it was first created.
+// CREATE TABLE IF NOT EXISTS never changes existing tables, so these are applied
+// with ALTER TABLE when the column is missing.
+type columnMigration struct {
+	Table      string
+	Column     string
+	Definition string
+}
+
+var columnMigrations = []columnMigration{
+	// Soft delete
+	{"posts", "deleted_at", "DATETIME"},
+	{"posts", "deleted_by", "INTEGER REFERENCES users(id)"},
+	{"posts", "delete_reason", "TEXT DEFAULT ''"},
+	{"comments", "deleted_at", "DATETIME"},
+	{"comments", "deleted_by", "INTEGER REFERENCES users(id)"},
+	{"comments", "delete_reason", "TEXT DEFAULT ''"},
+	// Thread states
+	{"posts", "locked_at", "DATETIME"},
+	{"posts", "locked_by", "INTEGER REFERENCES users(id)"},
+	{"posts", "is_announcement", "BOOLEAN DEFAULT 0"},
+	{"posts", "archived_at", "DATETIME"},
+	{"posts", "state_changed_at", "DATETIME"},
+	// Thread merging
+	{"posts", "merged_into", "INTEGER REFERENCES posts(id)"},
+	// Image thumbnails
+	{"post_images", "thumb_small", "TEXT"},
+	{"post_images", "thumb_medium", "TEXT"},
+	// Gallery text
+	{"post_images", "caption", "TEXT DEFAULT ''"},
+	{"post_images", "alt_text", "TEXT DEFAULT ''"},
+	// Where accounts come from, for moderators
+	{"users", "registration_ip", "TEXT DEFAULT ''"},
+	{"users", "last_login_ip", "TEXT DEFAULT ''"},
+	// Pre-moderation
+	{"users", "trusted_at", "DATETIME"},
+	{"held_content", "decision_reason", "TEXT DEFAULT ''"},
+	{"notifications", "message", "TEXT DEFAULT ''"},
+	// Reputation and trust levels
+	{"users", "reputation", "INTEGER DEFAULT 0"},
+	{"users", "trust_level", "INTEGER DEFAULT 0"},
+	{"users", "reputation_updated_at", "DATETIME"},
+	{"posts", "is_wiki", "BOOLEAN DEFAULT 0"},
+}
+
+// ApplyColumnMigrations adds every missing column from columnMigrations
+func ApplyColumnMigrations(db *sql.DB) error {
+	for _, m := range columnMigrations {
+		exists, err := columnExists(db, m.Table, m.Column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", m.Table, m.Column, m.Definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %v", m.Table, m.Column, err)
+		}
+		log.Printf("Migration: added column %s.%s", m.Table, m.Column)
+	}
+	return nil
+}
+
+// PendingColumnMigrations lists the columns from columnMigrations that are
+// still missing, as table.column
+func PendingColumnMigrations(db *sql.DB) ([]string, error) {
+	var pending []string
+	for _, m := range columnMigrations {
+		exists, err := columnExists(db, m.Table, m.Column)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			pending = append(pending, m.Table+"."+m.Column)
+		}
+	}
+	return pending, nil
+}
+
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to read columns of %s: %v", table, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, dataType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &dataType, &notNull, &defaultValue, &pk); err != nil {
+			return false, fmt.Errorf("failed to scan column of %s: %v", table, err)
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+// NotificationTypes are the kinds of notifications users receive. The
+// notifications table checks its type column against them.
+var NotificationTypes = []string{"like", "dislike", "comment", "reply", "approved", "rejected", "badge"}
+
+// notificationColumns are copied when the notifications table is rebuilt
+const notificationColumns = "id, user_id, type, post_id, comment_id, actor_id, is_read, created_at, message"
+
+// notificationsTable is the CREATE statement of the notifications table
+// under name, allowing every type in NotificationTypes
+func notificationsTable(name string) string {
+	quoted := make([]string, len(NotificationTypes))
+	for i, t := range NotificationTypes {
+		quoted[i] = "'" + t + "'"
+	}
+	return `CREATE TABLE IF NOT EXISTS ` + name + ` (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,          -- who the notification is intended for
+    type TEXT NOT NULL CHECK (type IN (` + strings.Join(quoted, ", ") + `)),
+    post_id INTEGER,
+    comment_id INTEGER,                -- for the answer
+    actor_id INTEGER,                  -- who did the action (answer)
+    is_read BOOLEAN DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+    message TEXT DEFAULT '',           -- e.g. why a moderator rejected a post
+    FOREIGN KEY (user_id) REFERENCES users(id),
+    FOREIGN KEY (post_id) REFERENCES posts(id),
+    FOREIGN KEY (comment_id) REFERENCES comments(id),
+    FOREIGN KEY (actor_id) REFERENCES users(id)
+)`
+}
+
+// ApplyNotificationTypes rebuilds the notifications table when its CHECK
+// constraint lacks a type from NotificationTypes. SQLite cannot change a
+// constraint in place. Run it after ApplyColumnMigrations, which adds the
+// columns the copy needs. Foreign keys are off on the connection doing the
+// rebuild, as SQLite recommends, so notifications left pointing at removed
+// rows do not stop the copy.
+func ApplyNotificationTypes(db *sql.DB) error {
+	var schema string
+	err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = 'notifications'").Scan(&schema)
+	if err != nil {
+		return fmt.Errorf("failed to read notifications schema: %v", err)
+	}
+	missing := false
+	for _, t := range NotificationTypes {
+		missing = missing || !strings.Contains(schema, "'"+t+"'")
+	}
+	if !missing {
+		return nil
+	}
+
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	// The pragma has no effect inside a transaction
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return fmt.Errorf("failed to check foreign keys: %v", err)
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			return fmt.Errorf("failed to disable foreign keys: %v", err)
+		}
+		defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"DROP TABLE IF EXISTS notifications_new",
+		notificationsTable("notifications_new"),
+		"INSERT INTO notifications_new (" + notificationColumns + ") SELECT " + notificationColumns + " FROM notifications",
+		"DROP TABLE notifications",
+		"ALTER TABLE notifications_new RENAME TO notifications",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return fmt.Errorf("failed to rebuild notifications: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	log.Printf("Migration: notifications now allow types %s", strings.Join(NotificationTypes, ", "))
+	return nil
+}
@@ -0,0 +1,785 @@
+// Package config loads the forum's settings into one typed struct.
+//
+// Values are layered, each source overriding the one before it:
+//
+//  1. the defaults from Defaults
+//  2. a dotenv style config file (-config, CONFIG_FILE or ./.env when present)
+//  3. the process environment
+//  4. command-line flags
+//
+// Every setting has an environment name (DB_PATH) and a flag named after it
+// (-db-path). Secrets are of type Secret and never show up in a dump or log.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// DefaultFile is the config file read when none is given and it exists
+const DefaultFile = ".env"
+
+// Environments the forum knows about
+const (
+	Development = "development"
+	Production  = "production"
+)
+
+// Config is the complete runtime configuration of the forum
+type Config struct {
+	Env     string `env:"APP_ENV" help:"development or production; production requires secure cookies and a session secret"`
+	BaseURL string `env:"BASE_URL" help:"public URL of the forum, used in emails"`
+
+	Log       Logging
+	Server    Server
+	TLS       TLS
+	Database  Database
+	Session   Session
+	Google    OAuthProvider `prefix:"GOOGLE_"`
+	GitHub    OAuthProvider `prefix:"GITHUB_"`
+	Email     Email
+	Storage   Storage
+	Uploads   Uploads
+	Scanner   Scanner
+	Content   Content
+	RateLimit RateLimit
+	PoW       ProofOfWork
+	Spam      Spam
+	PreMod    PreModeration
+	Trust     Trust
+	Badges    Badges
+	Metrics   Metrics
+	Templates Templates
+
+	// File is the config file that was read, empty when there was none
+	File string `env:"-"`
+	// PrintOnly is set by -print-config: dump the configuration and exit
+	PrintOnly bool `env:"-"`
+}
+
+// Logging configures the structured logger
+type Logging struct {
+	Level  string `env:"LOG_LEVEL" help:"debug, info, warn or error"`
+	Format string `env:"LOG_FORMAT" help:"text or json"`
+}
+
+// Server configures the HTTP listener
+type Server struct {
+	Addr            string        `env:"ADDR" help:"address to listen on"`
+	ReadTimeout     time.Duration `env:"READ_TIMEOUT_SECONDS" unit:"second" help:"maximum time to read a request"`
+	WriteTimeout    time.Duration `env:"WRITE_TIMEOUT_SECONDS" unit:"second" help:"maximum time to write a response"`
+	IdleTimeout     time.Duration `env:"IDLE_TIMEOUT_SECONDS" unit:"second" help:"how long idle keep-alive connections stay open"`
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT_SECONDS" unit:"second" help:"how long to wait for requests to finish on shutdown"`
+	TrustedProxies  string        `env:"TRUSTED_PROXIES" help:"comma separated IPs or CIDRs of proxies whose X-Forwarded-For and X-Forwarded-Proto are believed"`
+}
+
+// ProxyPrefixes parses TrustedProxies; a bare IP is a single-address prefix
+func (s Server) ProxyPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, item := range strings.Split(s.TrustedProxies, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(item); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("%q is neither an IP nor a CIDR", item)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// TLS serving modes
+const (
+	TLSOff      = "off"      // plain HTTP, usually behind a TLS terminating proxy
+	TLSFiles    = "files"    // certificate and key files, reloaded when they change
+	TLSAutocert = "autocert" // certificates from an ACME CA such as Let's Encrypt
+)
+
+// TLS configures how the forum serves HTTPS
+type TLS struct {
+	Mode           string        `env:"TLS_MODE" help:"off, files or autocert"`
+	CertFile       string        `env:"TLS_CERT_FILE" help:"PEM certificate chain with TLS_MODE=files"`
+	KeyFile        string        `env:"TLS_KEY_FILE" help:"PEM private key with TLS_MODE=files"`
+	ReloadInterval time.Duration `env:"TLS_RELOAD_INTERVAL_SECONDS" unit:"second" help:"how often the certificate files are checked for changes"`
+	Domains        string        `env:"TLS_DOMAINS" help:"comma separated host names autocert may request certificates for"`
+	CacheDir       string        `env:"TLS_CACHE_DIR" help:"directory autocert keeps certificates in"`
+	ACMEDirectory  string        `env:"ACME_DIRECTORY_URL" help:"ACME directory; empty for Let's Encrypt production"`
+	ACMEEmail      string        `env:"ACME_EMAIL" help:"contact address registered with the ACME CA"`
+	RedirectAddr   string        `env:"TLS_REDIRECT_ADDR" help:"plain HTTP listener redirecting to HTTPS (and answering ACME challenges); empty disables it"`
+	HSTSMaxAge     time.Duration `env:"HSTS_MAX_AGE_DAYS" unit:"day" help:"Strict-Transport-Security max-age on HTTPS responses; 0 disables the header"`
+	HSTSSubdomains bool          `env:"HSTS_INCLUDE_SUBDOMAINS" help:"add includeSubDomains to Strict-Transport-Security"`
+}
+
+// Enabled reports whether the forum terminates TLS itself
+func (t TLS) Enabled() bool {
+	return t.Mode == TLSFiles || t.Mode == TLSAutocert
+}
+
+// DomainList splits Domains
+func (t TLS) DomainList() []string {
+	var domains []string
+	for _, d := range strings.Split(t.Domains, ",") {
+		if d = strings.TrimSpace(d); d != "" {
+			domains = append(domains, d)
+		}
+	}
+	return domains
+}
+
+// Database configures the SQLCipher database
+type Database struct {
+	Path          string `env:"DB_PATH" help:"database file"`
+	EncryptionKey Secret `env:"DB_ENCRYPTION_KEY" help:"SQLCipher key"`
+}
+
+// DSN is the data source name for the SQLCipher driver
+func (d Database) DSN() string {
+	return d.Path + "?_pragma_key=" + d.EncryptionKey.Value() + "&_pragma_cipher_page_size=4096"
+}
+
+// Session configures login sessions
+type Session struct {
+	HMACSecret Secret `env:"SESSION_HMAC_SECRET" help:"key session IDs are signed with; random per start when empty outside production"`
+}
+
+// OAuthProvider holds the client of one OAuth login provider
+type OAuthProvider struct {
+	ClientID     string `env:"CLIENT_ID" help:"OAuth client ID"`
+	ClientSecret Secret `env:"CLIENT_SECRET" help:"OAuth client secret"`
+	RedirectURL  string `env:"REDIRECT_URL" help:"OAuth callback URL"`
+}
+
+// Enabled reports whether the provider is configured at all
+func (p OAuthProvider) Enabled() bool {
+	return p.ClientID != "" || p.ClientSecret != "" || p.RedirectURL != ""
+}
+
+// Email configures outgoing mail through SendGrid
+type Email struct {
+	SendGridAPIKey Secret `env:"SENDGRID_API_KEY" help:"SendGrid API key; password reset emails are disabled without it"`
+	FromName       string `env:"EMAIL_FROM_NAME" help:"sender name"`
+	FromAddress    string `env:"EMAIL_FROM_ADDRESS" help:"sender address, verified in SendGrid"`
+}
+
+// Enabled reports whether emails can be sent
+func (e Email) Enabled() bool {
+	return e.SendGridAPIKey != ""
+}
+
+// Storage configures the blob store uploads are kept in
+type Storage struct {
+	Backend     string        `env:"BLOB_BACKEND" help:"local or s3"`
+	LocalDir    string        `env:"UPLOADS_DIR" help:"root of the local store (default static/uploads next to the binary)"`
+	S3Endpoint  string        `env:"S3_ENDPOINT" help:"S3 compatible endpoint URL"`
+	S3Bucket    string        `env:"S3_BUCKET" help:"S3 bucket"`
+	S3Region    string        `env:"S3_REGION" help:"S3 region"`
+	S3AccessKey string        `env:"S3_ACCESS_KEY" help:"S3 access key"`
+	S3SecretKey Secret        `env:"S3_SECRET_KEY" help:"S3 secret key"`
+	S3PathStyle bool          `env:"S3_PATH_STYLE" help:"endpoint/bucket/key URLs instead of virtual-hosted buckets"`
+	URLExpiry   time.Duration `env:"BLOB_URL_EXPIRY_MINUTES" unit:"minute" help:"lifetime of signed S3 URLs"`
+}
+
+// Uploads configures what users may upload
+type Uploads struct {
+	AttachmentTypes string        `env:"ATTACHMENT_TYPES" help:"allowed attachments as extension:megabytes, comma separated"`
+	AnimatedAvatars bool          `env:"AVATAR_ANIMATED" help:"keep the frames of animated GIF avatars"`
+	GCGrace         time.Duration `env:"UPLOAD_GC_GRACE_HOURS" unit:"hour" help:"how long a file stays unreferenced before it is removed"`
+	GCInterval      time.Duration `env:"UPLOAD_GC_INTERVAL_HOURS" unit:"hour" help:"how often unreferenced files are collected"`
+}
+
+// Scanner configures the malware scanner attachments must pass
+type Scanner struct {
+	Backend      string        `env:"SCANNER_BACKEND" help:"none or clamd"`
+	ClamdAddress string        `env:"CLAMD_ADDRESS" help:"tcp://host:port or unix:///path of clamd"`
+	ClamdTimeout time.Duration `env:"CLAMD_TIMEOUT_SECONDS" unit:"second" help:"limit for a single scan"`
+}
+
+// Content configures the trash and the archiver
+type Content struct {
+	TrashRetention time.Duration `env:"TRASH_RETENTION_DAYS" unit:"day" help:"how long deleted content stays in the trash"`
+	ArchiveAfter   time.Duration `env:"ARCHIVE_AFTER_DAYS" unit:"day" help:"inactivity after which posts are archived"`
+	SweepInterval  time.Duration `env:"CONTENT_SWEEP_INTERVAL_HOURS" unit:"hour" help:"how often the trash is purged and posts are archived"`
+}
+
+// Rate limit stores
+const (
+	LimitStoreMemory = "memory" // per process
+	LimitStoreSQLite = "sqlite" // in the database, shared by instances using it
+)
+
+// RateLimit configures the request limiter: a default policy per client IP
+// for every request, and stricter policies for some routes
+type RateLimit struct {
+	Interval time.Duration `env:"RATE_LIMIT_INTERVAL_MS" unit:"millisecond" help:"one request token is refilled per interval"`
+	Burst    int           `env:"RATE_LIMIT_BURST" help:"requests a client may make at once"`
+	Routes   string        `env:"RATE_LIMIT_ROUTES" help:"comma separated path=requests/period policies, e.g. /login-submit=5/1m; :user limits signed-in users by account"`
+	Store    string        `env:"RATE_LIMIT_STORE" help:"memory or sqlite"`
+}
+
+// RoutePolicy allows Requests per Period on Path, a path or, ending in a
+// slash, a subtree as in http.ServeMux
+type RoutePolicy struct {
+	Path     string
+	Requests int
+	Period   time.Duration
+	// PerUser counts signed-in users by account instead of by IP
+	PerUser bool
+}
+
+// RoutePolicies parses Routes
+func (r RateLimit) RoutePolicies() ([]RoutePolicy, error) {
+	var policies []RoutePolicy
+	for _, item := range strings.Split(r.Routes, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		path, rule, ok := strings.Cut(item, "=")
+		if !ok || !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("%q is not path=requests/period", item)
+		}
+		p := RoutePolicy{Path: path}
+		rule, p.PerUser = strings.CutSuffix(rule, ":user")
+		requests, period, ok := strings.Cut(rule, "/")
+		var err error
+		if p.Requests, err = strconv.Atoi(requests); !ok || err != nil || p.Requests <= 0 {
+			return nil, fmt.Errorf("%q needs a positive number of requests", item)
+		}
+		if p.Period, err = time.ParseDuration(period); err != nil || p.Period <= 0 {
+			return nil, fmt.Errorf("%q needs a period such as 30s or 1m", item)
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// ProofOfWork configures the challenge the registration, sign-in and
+// password reset forms must solve once a client submitted them often
+type ProofOfWork struct {
+	Enabled       bool          `env:"POW_ENABLED" help:"require proof-of-work challenges after suspicious activity"`
+	Threshold     int           `env:"POW_THRESHOLD" help:"form submissions per client IP, failed ones counting twice, before a challenge is required; 0 requires one always"`
+	Window        time.Duration `env:"POW_WINDOW_MINUTES" unit:"minute" help:"how long submissions count towards POW_THRESHOLD"`
+	Difficulty    int           `env:"POW_DIFFICULTY" help:"leading zero bits of the first challenge; each further submission adds one"`
+	MaxDifficulty int           `env:"POW_MAX_DIFFICULTY" help:"leading zero bits challenges stop growing at"`
+	TTL           time.Duration `env:"POW_TTL_MINUTES" unit:"minute" help:"how long a challenge can be solved and used"`
+}
+
+// Spam configures the checks new posts and comments pass before they are
+// published. Each one may let content through, hold it for a moderator or
+// reject it.
+type Spam struct {
+	Enabled            bool          `env:"SPAM_FILTER_ENABLED" help:"check new posts and comments for spam"`
+	NewAccountAge      time.Duration `env:"SPAM_NEW_ACCOUNT_DAYS" unit:"day" help:"accounts younger than this are limited to SPAM_NEW_ACCOUNT_MAX_LINKS"`
+	NewAccountMaxLinks int           `env:"SPAM_NEW_ACCOUNT_MAX_LINKS" help:"links a new account may post at once before it is held for review"`
+	DuplicateWindow    time.Duration `env:"SPAM_DUPLICATE_WINDOW_HOURS" unit:"hour" help:"content repeating a post or comment from this period is held for review"`
+	BannedWords        string        `env:"SPAM_BANNED_WORDS" help:"comma separated words and phrases that are not allowed"`
+	BannedPatterns     string        `env:"SPAM_BANNED_PATTERNS_FILE" help:"file of regular expressions that are not allowed, one per line"`
+	BannedAction       string        `env:"SPAM_BANNED_ACTION" help:"hold or reject content with banned words or patterns"`
+	BayesMinTraining   int           `env:"SPAM_BAYES_MIN_TRAINING" help:"spam and not spam decisions each the classifier needs before it is used"`
+	BayesHoldPercent   int           `env:"SPAM_BAYES_HOLD_PERCENT" help:"spam probability at which the classifier holds content for review"`
+	BayesRejectPercent int           `env:"SPAM_BAYES_REJECT_PERCENT" help:"spam probability at which the classifier rejects content; 0 never rejects"`
+}
+
+// PreModeration configures which content waits for a moderator before it
+// is published, whatever the spam filter thinks of it
+type PreModeration struct {
+	FirstPosts int    `env:"PREMOD_FIRST_POSTS" help:"posts and comments of a member held for review until this many are published; 0 turns it off"`
+	Categories string `env:"PREMOD_CATEGORIES" help:"comma separated categories whose new posts are held for review"`
+	TrustAfter int    `env:"PREMOD_TRUST_AFTER" help:"approved submissions after which a member is trusted and skips pre-moderation; 0 never"`
+}
+
+// CategoryList splits Categories
+func (p PreModeration) CategoryList() []string {
+	var names []string
+	for _, name := range strings.Split(p.Categories, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Enabled reports whether any content is pre-moderated
+func (p PreModeration) Enabled() bool {
+	return p.FirstPosts > 0 || p.Categories != ""
+}
+
+// Trust configures the reputation members need for each trust level and
+// the level each capability needs. Moderators and admins have every
+// capability.
+type Trust struct {
+	Levels         string        `env:"TRUST_LEVEL_REPUTATION" help:"comma separated reputation needed for trust levels 1, 2 and 3"`
+	ImagesLevel    int           `env:"TRUST_IMAGES_LEVEL" help:"trust level needed to post images"`
+	LinksLevel     int           `env:"TRUST_LINKS_LEVEL" help:"trust level needed to post links"`
+	TagsLevel      int           `env:"TRUST_TAGS_LEVEL" help:"trust level needed to create tags"`
+	WikiLevel      int           `env:"TRUST_WIKI_LEVEL" help:"trust level needed to edit other members' wiki posts"`
+	RecalcInterval time.Duration `env:"TRUST_RECALC_INTERVAL_HOURS" unit:"hour" help:"how often members whose account age counts for more, or whose reputation is a week old, are recalculated"`
+}
+
+// TrustLevels is the number of trust levels above the first
+const TrustLevels = 3
+
+// Thresholds parses Levels: the reputation needed for each level above the
+// first, in increasing order
+func (t Trust) Thresholds() ([]int, error) {
+	parts := strings.Split(t.Levels, ",")
+	if len(parts) != TrustLevels {
+		return nil, fmt.Errorf("needs %d values, got %q", TrustLevels, t.Levels)
+	}
+	thresholds := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n <= 0 || (i > 0 && n <= thresholds[i-1]) {
+			return nil, fmt.Errorf("needs increasing positive numbers, got %q", t.Levels)
+		}
+		thresholds[i] = n
+	}
+	return thresholds, nil
+}
+
+// Badges configures the job that awards badges
+type Badges struct {
+	Interval time.Duration `env:"BADGE_INTERVAL_MINUTES" unit:"minute" help:"how often badge rules are evaluated over posts, comments and likes"`
+}
+
+// Spam actions for banned content
+const (
+	SpamActionHold   = "hold"
+	SpamActionReject = "reject"
+)
+
+// BannedWordList splits BannedWords
+func (s Spam) BannedWordList() []string {
+	var words []string
+	for _, w := range strings.Split(s.BannedWords, ",") {
+		if w = strings.TrimSpace(w); w != "" {
+			words = append(words, w)
+		}
+	}
+	return words
+}
+
+// BannedPatternList reads and compiles BannedPatterns. Blank lines and lines
+// starting with # are skipped.
+func (s Spam) BannedPatternList() ([]*regexp.Regexp, error) {
+	if s.BannedPatterns == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(s.BannedPatterns)
+	if err != nil {
+		return nil, err
+	}
+	var patterns []*regexp.Regexp
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		re, err := regexp.Compile("(?i)" + line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// Metrics configures the Prometheus endpoint. It is served on its own
+// listener when Addr is set, otherwise on the main one behind Token; with
+// neither it is off.
+type Metrics struct {
+	Addr  string `env:"METRICS_ADDR" help:"separate listener for /metrics, e.g. 127.0.0.1:9090"`
+	Token Secret `env:"METRICS_TOKEN" help:"bearer token scrapers must send for /metrics"`
+}
+
+// Enabled reports whether /metrics is served anywhere
+func (m Metrics) Enabled() bool {
+	return m.Addr != "" || m.Token != ""
+}
+
+// Templates configures where pages are parsed from
+type Templates struct {
+	Dir    string `env:"TEMPLATES_DIR" help:"directory of the page templates"`
+	Reload bool   `env:"TEMPLATES_RELOAD" help:"parse templates again when a file changes; development only"`
+}
+
+// Defaults returns the configuration used when nothing else is set
+func Defaults() Config {
+	return Config{
+		Env:     Development,
+		BaseURL: "http://localhost:8080",
+		Log:     Logging{Level: "info", Format: "text"},
+		Server: Server{
+			Addr:            ":8080",
+			ReadTimeout:     5 * time.Second,
+			WriteTimeout:    10 * time.Second,
+			IdleTimeout:     120 * time.Second,
+			ShutdownTimeout: 15 * time.Second,
+		},
+		TLS: TLS{
+			Mode:           TLSOff,
+			ReloadInterval: 30 * time.Second,
+			CacheDir:       "certs",
+			RedirectAddr:   ":80",
+			HSTSMaxAge:     365 * 24 * time.Hour,
+		},
+		Database: Database{Path: "app/database/forum.db"},
+		Storage: Storage{
+			Backend:     "local",
+			S3PathStyle: true,
+			URLExpiry:   15 * time.Minute,
+		},
+		Uploads: Uploads{
+			AttachmentTypes: "pdf:10,txt:1,log:5,md:1,csv:5,json:2,zip:20",
+			GCGrace:         24 * time.Hour,
+			GCInterval:      12 * time.Hour,
+		},
+		Scanner: Scanner{
+			Backend:      "none",
+			ClamdAddress: "tcp://127.0.0.1:3310",
+			ClamdTimeout: 30 * time.Second,
+		},
+		Content: Content{
+			TrashRetention: 30 * 24 * time.Hour,
+			ArchiveAfter:   180 * 24 * time.Hour,
+			SweepInterval:  6 * time.Hour,
+		},
+		RateLimit: RateLimit{
+			Interval: 50 * time.Millisecond,
+			Burst:    30,
+			Routes:   "/login-submit=5/1m, /register-submit=3/10m, /createin=10/1m:user, /like=60/1m:user",
+			Store:    LimitStoreMemory,
+		},
+		PoW: ProofOfWork{
+			Enabled:       true,
+			Threshold:     3,
+			Window:        15 * time.Minute,
+			Difficulty:    16,
+			MaxDifficulty: 22,
+			TTL:           5 * time.Minute,
+		},
+		Spam: Spam{
+			Enabled:            true,
+			NewAccountAge:      7 * 24 * time.Hour,
+			NewAccountMaxLinks: 2,
+			DuplicateWindow:    24 * time.Hour,
+			BannedAction:       SpamActionReject,
+			BayesMinTraining:   20,
+			BayesHoldPercent:   90,
+			BayesRejectPercent: 99,
+		},
+		PreMod: PreModeration{TrustAfter: 3},
+		Trust: Trust{
+			Levels:         "10,50,150",
+			ImagesLevel:    1,
+			LinksLevel:     1,
+			TagsLevel:      2,
+			WikiLevel:      3,
+			RecalcInterval: 24 * time.Hour,
+		},
+		Badges:    Badges{Interval: time.Hour},
+		Templates: Templates{Dir: "templates"},
+	}
+}
+
+// Production reports whether the forum runs in production
+func (c *Config) Production() bool {
+	return c.Env == Production
+}
+
+// Load builds the configuration from the defaults, the config file, the
+// environment and args, which are the command-line arguments without the
+// program name. It does not validate the result; call Validate for that.
+func Load(args []string) (*Config, error) {
+	return LoadFrom(args, os.LookupEnv)
+}
+
+// LoadFrom is Load with the environment read through lookupEnv
+func LoadFrom(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	cfg := Defaults()
+	settings := settingsOf(&cfg)
+
+	fs := flag.NewFlagSet("forum", flag.ContinueOnError)
+	file := fs.String("config", "", "config file in .env format (default CONFIG_FILE or ./.env when present)")
+	fs.BoolVar(&cfg.PrintOnly, "print-config", false, "print the configuration with secrets redacted and exit")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.flagName()] = fs.String(s.flagName(), "", s.usage())
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	var errs []error
+
+	// Config file
+	path, explicit := *file, *file != ""
+	if !explicit {
+		path, explicit = lookupEnv("CONFIG_FILE")
+	}
+	if !explicit {
+		path = DefaultFile
+	}
+	values, err := godotenv.Read(path)
+	switch {
+	case err == nil:
+		cfg.File = path
+		for _, s := range settings {
+			if v, ok := values[s.key]; ok {
+				errs = append(errs, s.set(v, path))
+			}
+		}
+	case explicit || !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	// Environment
+	for _, s := range settings {
+		if v, ok := lookupEnv(s.key); ok {
+			errs = append(errs, s.set(v, "environment"))
+		}
+	}
+
+	// Flags
+	fs.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if s.flagName() == f.Name {
+				errs = append(errs, s.set(*flagValues[f.Name], "-"+f.Name))
+			}
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// Validate checks the configuration as a whole and reports every problem at
+// once, so a broken deployment can be fixed in one go
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if c.Env != Development && c.Env != Production {
+		add("APP_ENV must be %q or %q, got %q", Development, Production, c.Env)
+	}
+	if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		add("BASE_URL must be an absolute http(s) URL, got %q", c.BaseURL)
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.Log.Level)); err != nil {
+		add("LOG_LEVEL must be debug, info, warn or error, got %q", c.Log.Level)
+	}
+	if c.Log.Format != "text" && c.Log.Format != "json" {
+		add("LOG_FORMAT must be text or json, got %q", c.Log.Format)
+	}
+
+	if c.Server.Addr == "" {
+		add("ADDR must not be empty")
+	}
+	if _, err := c.Server.ProxyPrefixes(); err != nil {
+		add("TRUSTED_PROXIES: %v", err)
+	}
+
+	switch c.TLS.Mode {
+	case TLSOff:
+	case TLSFiles:
+		if c.TLS.CertFile == "" || c.TLS.KeyFile == "" {
+			add("TLS_CERT_FILE and TLS_KEY_FILE are required with TLS_MODE=files")
+		}
+		if c.TLS.ReloadInterval <= 0 {
+			add("TLS_RELOAD_INTERVAL_SECONDS must be greater than zero")
+		}
+	case TLSAutocert:
+		if len(c.TLS.DomainList()) == 0 {
+			add("TLS_DOMAINS is required with TLS_MODE=autocert")
+		}
+		if c.TLS.CacheDir == "" {
+			add("TLS_CACHE_DIR is required with TLS_MODE=autocert")
+		}
+		if c.TLS.ACMEDirectory != "" {
+			if u, err := url.Parse(c.TLS.ACMEDirectory); err != nil || u.Host == "" {
+				add("ACME_DIRECTORY_URL must be an absolute URL, got %q", c.TLS.ACMEDirectory)
+			}
+		}
+	default:
+		add("TLS_MODE must be %s, %s or %s, got %q", TLSOff, TLSFiles, TLSAutocert, c.TLS.Mode)
+	}
+	if c.TLS.Enabled() && c.TLS.RedirectAddr != "" && c.TLS.RedirectAddr == c.Server.Addr {
+		add("TLS_REDIRECT_ADDR must differ from ADDR")
+	}
+	if c.TLS.HSTSMaxAge < 0 {
+		add("HSTS_MAX_AGE_DAYS must not be negative")
+	}
+
+	if c.Templates.Dir == "" {
+		add("TEMPLATES_DIR must not be empty")
+	}
+	if c.Templates.Reload && c.Production() {
+		add("TEMPLATES_RELOAD is only allowed with APP_ENV=%s", Development)
+	}
+	if c.Metrics.Addr != "" && c.Metrics.Addr == c.Server.Addr {
+		add("METRICS_ADDR must differ from ADDR; leave it empty to serve /metrics on ADDR")
+	}
+	for _, d := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"READ_TIMEOUT_SECONDS", c.Server.ReadTimeout},
+		{"WRITE_TIMEOUT_SECONDS", c.Server.WriteTimeout},
+		{"IDLE_TIMEOUT_SECONDS", c.Server.IdleTimeout},
+		{"SHUTDOWN_TIMEOUT_SECONDS", c.Server.ShutdownTimeout},
+		{"BLOB_URL_EXPIRY_MINUTES", c.Storage.URLExpiry},
+		{"UPLOAD_GC_GRACE_HOURS", c.Uploads.GCGrace},
+		{"UPLOAD_GC_INTERVAL_HOURS", c.Uploads.GCInterval},
+		{"CLAMD_TIMEOUT_SECONDS", c.Scanner.ClamdTimeout},
+		{"TRASH_RETENTION_DAYS", c.Content.TrashRetention},
+		{"ARCHIVE_AFTER_DAYS", c.Content.ArchiveAfter},
+		{"CONTENT_SWEEP_INTERVAL_HOURS", c.Content.SweepInterval},
+		{"RATE_LIMIT_INTERVAL_MS", c.RateLimit.Interval},
+		{"POW_WINDOW_MINUTES", c.PoW.Window},
+		{"POW_TTL_MINUTES", c.PoW.TTL},
+		{"SPAM_DUPLICATE_WINDOW_HOURS", c.Spam.DuplicateWindow},
+		{"TRUST_RECALC_INTERVAL_HOURS", c.Trust.RecalcInterval},
+		{"BADGE_INTERVAL_MINUTES", c.Badges.Interval},
+	} {
+		if d.value <= 0 {
+			add("%s must be greater than zero", d.key)
+		}
+	}
+	if c.RateLimit.Burst <= 0 {
+		add("RATE_LIMIT_BURST must be greater than zero")
+	}
+	if _, err := c.RateLimit.RoutePolicies(); err != nil {
+		add("RATE_LIMIT_ROUTES: %v", err)
+	}
+	if c.RateLimit.Store != LimitStoreMemory && c.RateLimit.Store != LimitStoreSQLite {
+		add("RATE_LIMIT_STORE must be %q or %q, got %q", LimitStoreMemory, LimitStoreSQLite, c.RateLimit.Store)
+	}
+	if c.PoW.Threshold < 0 {
+		add("POW_THRESHOLD must not be negative")
+	}
+	// Beyond 32 bits a challenge takes a browser hours
+	if c.PoW.Difficulty < 1 || c.PoW.MaxDifficulty < c.PoW.Difficulty || c.PoW.MaxDifficulty > 32 {
+		add("POW_DIFFICULTY and POW_MAX_DIFFICULTY must satisfy 1 <= POW_DIFFICULTY <= POW_MAX_DIFFICULTY <= 32")
+	}
+	if c.Spam.NewAccountAge < 0 || c.Spam.NewAccountMaxLinks < 0 || c.Spam.BayesMinTraining < 0 {
+		add("SPAM_NEW_ACCOUNT_DAYS, SPAM_NEW_ACCOUNT_MAX_LINKS and SPAM_BAYES_MIN_TRAINING must not be negative")
+	}
+	if c.Spam.BannedAction != SpamActionHold && c.Spam.BannedAction != SpamActionReject {
+		add("SPAM_BANNED_ACTION must be %q or %q, got %q", SpamActionHold, SpamActionReject, c.Spam.BannedAction)
+	}
+	if _, err := c.Spam.BannedPatternList(); err != nil {
+		add("SPAM_BANNED_PATTERNS_FILE: %v", err)
+	}
+	if c.PreMod.FirstPosts < 0 || c.PreMod.TrustAfter < 0 {
+		add("PREMOD_FIRST_POSTS and PREMOD_TRUST_AFTER must not be negative")
+	}
+	if _, err := c.Trust.Thresholds(); err != nil {
+		add("TRUST_LEVEL_REPUTATION %v", err)
+	}
+	for _, l := range []struct {
+		key   string
+		level int
+	}{
+		{"TRUST_IMAGES_LEVEL", c.Trust.ImagesLevel},
+		{"TRUST_LINKS_LEVEL", c.Trust.LinksLevel},
+		{"TRUST_TAGS_LEVEL", c.Trust.TagsLevel},
+		{"TRUST_WIKI_LEVEL", c.Trust.WikiLevel},
+	} {
+		if l.level < 0 || l.level > TrustLevels {
+			add("%s must be between 0 and %d", l.key, TrustLevels)
+		}
+	}
+	if c.Spam.BayesHoldPercent < 1 || c.Spam.BayesHoldPercent > 100 {
+		add("SPAM_BAYES_HOLD_PERCENT must be between 1 and 100")
+	}
+	if c.Spam.BayesRejectPercent != 0 && (c.Spam.BayesRejectPercent < c.Spam.BayesHoldPercent || c.Spam.BayesRejectPercent > 100) {
+		add("SPAM_BAYES_REJECT_PERCENT must be 0 or between SPAM_BAYES_HOLD_PERCENT and 100")
+	}
+
+	if c.Database.Path == "" {
+		add("DB_PATH must not be empty")
+	}
+	if c.Database.EncryptionKey == "" {
+		add("DB_ENCRYPTION_KEY is required")
+	}
+	if c.Production() && c.Session.HMACSecret == "" {
+		add("SESSION_HMAC_SECRET is required in production")
+	}
+
+	for i, p := range []OAuthProvider{c.Google, c.GitHub} {
+		if p.Enabled() && (p.ClientID == "" || p.ClientSecret == "" || p.RedirectURL == "") {
+			prefix := [...]string{"GOOGLE_", "GITHUB_"}[i]
+			add("%sCLIENT_ID, %sCLIENT_SECRET and %sREDIRECT_URL must be set together", prefix, prefix, prefix)
+		}
+	}
+	if c.Email.Enabled() && c.Email.FromAddress == "" {
+		add("EMAIL_FROM_ADDRESS is required when SENDGRID_API_KEY is set")
+	}
+
+	switch c.Storage.Backend {
+	case "local":
+	case "s3":
+		if c.Storage.S3Endpoint == "" || c.Storage.S3Bucket == "" {
+			add("S3_ENDPOINT and S3_BUCKET are required with BLOB_BACKEND=s3")
+		}
+		if c.Storage.S3AccessKey == "" || c.Storage.S3SecretKey == "" {
+			add("S3_ACCESS_KEY and S3_SECRET_KEY are required with BLOB_BACKEND=s3")
+		}
+	default:
+		add("BLOB_BACKEND must be local or s3, got %q", c.Storage.Backend)
+	}
+
+	switch c.Scanner.Backend {
+	case "none":
+	case "clamd":
+		if c.Scanner.ClamdAddress == "" {
+			add("CLAMD_ADDRESS is required with SCANNER_BACKEND=clamd")
+		}
+	default:
+		add("SCANNER_BACKEND must be none or clamd, got %q", c.Scanner.Backend)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+// Dump renders the configuration in config file format, one KEY=value line
+// per setting, with secrets redacted
+func (c *Config) Dump() string {
+	var b strings.Builder
+	if c.File != "" {
+		fmt.Fprintf(&b, "# loaded from %s\n", c.File)
+	}
+	for _, s := range settingsOf(c) {
+		fmt.Fprintf(&b, "%s=%s\n", s.key, s.String())
+	}
+	return b.String()
+}
@@ -0,0 +1,155 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Secret is a string that must not be logged. It prints as "[redacted]";
+// use Value to get at the real thing.
+type Secret string
+
+const redacted = "[redacted]"
+
+// Value returns the secret itself
+func (s Secret) Value() string {
+	return string(s)
+}
+
+// String implements fmt.Stringer; an empty secret prints as empty so a
+// dump still shows whether it is set
+func (s Secret) String() string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// GoString keeps %#v from printing the secret
+func (s Secret) GoString() string {
+	return strconv.Quote(s.String())
+}
+
+// MarshalText keeps encoders from printing the secret
+func (s Secret) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// units a duration setting can be given in
+var units = map[string]time.Duration{
+	"millisecond": time.Millisecond,
+	"second":      time.Second,
+	"minute":      time.Minute,
+	"hour":        time.Hour,
+	"day":         24 * time.Hour,
+}
+
+// setting is one field of Config together with its environment name
+type setting struct {
+	key   string
+	help  string
+	unit  time.Duration
+	value reflect.Value
+}
+
+// settingsOf lists the settings of cfg in declaration order
+func settingsOf(cfg *Config) []setting {
+	return collect(reflect.ValueOf(cfg).Elem(), "")
+}
+
+func collect(v reflect.Value, prefix string) []setting {
+	var settings []setting
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			settings = append(settings, collect(v.Field(i), prefix+field.Tag.Get("prefix"))...)
+			continue
+		}
+		key := field.Tag.Get("env")
+		if key == "" || key == "-" {
+			continue
+		}
+		s := setting{key: prefix + key, help: field.Tag.Get("help"), value: v.Field(i)}
+		if unit := field.Tag.Get("unit"); unit != "" {
+			s.unit = units[unit]
+		}
+		settings = append(settings, s)
+	}
+	return settings
+}
+
+// flagName is the command-line flag of a setting: DB_PATH becomes db-path
+func (s setting) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(s.key), "_", "-")
+}
+
+func (s setting) usage() string {
+	return fmt.Sprintf("%s (%s)", s.help, s.key)
+}
+
+// set parses raw into the setting; source names where raw came from
+func (s setting) set(raw, source string) error {
+	raw = strings.TrimSpace(raw)
+	invalid := func(expected string) error {
+		return fmt.Errorf("%s from %s: %q is not %s", s.key, source, raw, expected)
+	}
+
+	switch s.value.Interface().(type) {
+	case string:
+		s.value.SetString(raw)
+	case Secret:
+		s.value.SetString(raw)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return invalid("true or false")
+		}
+		s.value.SetBool(b)
+	case int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return invalid("a whole number")
+		}
+		s.value.SetInt(int64(n))
+	case time.Duration:
+		// A plain number is in the setting's unit; "90m" style values work too
+		if n, err := strconv.ParseFloat(raw, 64); err == nil && s.unit != 0 {
+			s.value.SetInt(int64(n * float64(s.unit)))
+			return nil
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return invalid("a number of " + s.unitName() + "s or a duration such as 90m")
+		}
+		s.value.SetInt(int64(d))
+	default:
+		panic("config: unsupported setting type " + s.value.Type().String())
+	}
+	return nil
+}
+
+// String renders the value the way it would be written in a config file
+func (s setting) String() string {
+	switch v := s.value.Interface().(type) {
+	case time.Duration:
+		if s.unit != 0 {
+			return strconv.FormatFloat(float64(v)/float64(s.unit), 'f', -1, 64)
+		}
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+func (s setting) unitName() string {
+	for name, unit := range units {
+		if unit == s.unit {
+			return name
+		}
+	}
+	return "second"
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"database/sql"
+	errors "forum/internal"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminBlocklistPage lists IP blocks and, given ?network=, the accounts
+// registered or last signed in from that network. Moderators may look;
+// only admins see the forms that change blocks.
+func AdminBlocklistPage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can view IP blocks.")
+			return
+		}
+		renderBlocklist(w, r, db, user, "")
+	}
+}
+
+// renderBlocklist shows the blocklist page, with formError above the form
+// when an entry was refused
+func renderBlocklist(w http.ResponseWriter, r *http.Request, db *sql.DB, user *models.User, formError string) {
+	blocks, err := utils.GetIPBlocks(db, time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("failed to load IP blocks", "err", err)
+		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not load IP blocks.")
+		return
+	}
+
+	data := models.IPBlocklistPageData{
+		Blocks:      blocks,
+		Scopes:      utils.BlockScopes,
+		CurrentUser: user,
+		CanManage:   utils.IsAdmin(user),
+		Error:       formError,
+		Network:     strings.TrimSpace(r.URL.Query().Get("network")),
+	}
+	if data.Network != "" {
+		network, err := utils.ParseBlockedNetwork(data.Network)
+		if err != nil {
+			data.Error = err.Error()
+		} else if data.Accounts, err = utils.GetAccountsInNetwork(db, network); err != nil {
+			logging.FromContext(r.Context()).Error("failed to look up accounts in network", "network", network, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not look up accounts.")
+			return
+		}
+	}
+
+	utils.Render(w, r, "admin_blocklist", data)
+}
+
+// AddIPBlockHandler blocks an IP or CIDR range for a scope, optionally for a
+// limited time
+func AddIPBlockHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method not allowed", "Method not allowed")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsAdmin(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only admins can block networks.")
+			return
+		}
+
+		var expiresAt time.Time
+		if v := r.FormValue("expires_in"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				renderBlocklist(w, r, db, user, "Invalid expiry.")
+				return
+			}
+			expiresAt = time.Now().Add(d)
+		}
+
+		// Blocking everything from one's own network would lock the admin
+		// out of this page too
+		if network, err := utils.ParseBlockedNetwork(r.FormValue("network")); err == nil && r.FormValue("scope") == utils.BlockAll {
+			if addr, err := netip.ParseAddr(utils.RequestIP(r)); err == nil && network.Contains(addr.Unmap()) {
+				renderBlocklist(w, r, db, user, "That network includes your own address; blocking all of it would lock you out.")
+				return
+			}
+		}
+
+		if err := utils.AddIPBlock(db, r.FormValue("network"), r.FormValue("scope"), r.FormValue("reason"), user.ID, expiresAt); err != nil {
+			logging.FromContext(r.Context()).Error("failed to add IP block", "err", err)
+			renderBlocklist(w, r, db, user, err.Error())
+			return
+		}
+		logging.FromContext(r.Context()).Info("IP block added", "network", r.FormValue("network"), "scope", r.FormValue("scope"))
+
+		http.Redirect(w, r, "/admin/blocklist", http.StatusSeeOther)
+	}
+}
+
+// RemoveIPBlockHandler lifts an IP block
+func RemoveIPBlockHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method not allowed", "Method not allowed")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsAdmin(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only admins can lift IP blocks.")
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid ID.")
+			return
+		}
+		if err := utils.RemoveIPBlock(db, id); err != nil {
+			logging.FromContext(r.Context()).Error("failed to remove IP block", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not lift the block.")
+			return
+		}
+
+		http.Redirect(w, r, "/admin/blocklist", http.StatusSeeOther)
+	}
+}
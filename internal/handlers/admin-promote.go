@@ -7,6 +7,7 @@ import (
 	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"net/http"
+	"strconv"
 )
 
 func PromoteHandler(db *sql.DB) http.HandlerFunc {
@@ -30,6 +31,9 @@ func PromoteHandler(db *sql.DB) http.HandlerFunc {
 
 			return
 		}
+		if id, err := strconv.Atoi(userID); err == nil {
+			utils.RefreshReputation(r.Context(), db, id)
+		}
 
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 	}
@@ -82,6 +86,7 @@ func ApproveModeratorRequest(db *sql.DB) http.HandlerFunc {
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to update user role")
 			return
 		}
+		utils.RefreshReputation(r.Context(), db, userID)
 
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 	}
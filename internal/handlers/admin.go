@@ -7,7 +7,7 @@ import (
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"log"
 	"net/http"
-	"text/template"
+	"strconv"
 )
 
 func AdminUsersHandler(db *sql.DB) http.HandlerFunc {
@@ -44,18 +44,7 @@ func AdminUsersHandler(db *sql.DB) http.HandlerFunc {
 			ModerationRequests: modRequests,
 		}
 
-		tmpl := template.Must(template.ParseFiles(
-			"templates/layout_admin.html",
-			"templates/header_auth.html",
-			"templates/admin_users.html",
-			"templates/nav_admin.html",
-		))
-
-		err = tmpl.ExecuteTemplate(w, "layout", data)
-		if err != nil {
-			log.Printf("Template execution error: %v", err)
-			http.Error(w, "Error rendering template", http.StatusInternalServerError)
-		}
+		utils.Render(w, r, "admin_users", data)
 	}
 }
 
@@ -83,6 +72,9 @@ func BanUserHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Failed to ban user", http.StatusInternalServerError)
 			return
 		}
+		if id, err := strconv.Atoi(userID); err == nil {
+			utils.RefreshReputation(r.Context(), db, id)
+		}
 
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 	}
@@ -112,6 +104,9 @@ func UnbanUserHandler(db *sql.DB) http.HandlerFunc {
 			http.Error(w, "Failed to unban user", http.StatusInternalServerError)
 			return
 		}
+		if id, err := strconv.Atoi(userID); err == nil {
+			utils.RefreshReputation(r.Context(), db, id)
+		}
 
 		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
 	}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"database/sql"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/storage"
+	"forum/internal/utils"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// DownloadAttachment serves /attachments/{id}. Files are always sent as a
+// download with the name they were uploaded under, never opened inline, and
+// each GET is counted. They are streamed even from stores that can sign URLs
+// so the Content-Disposition header is under the forum's control.
+func DownloadAttachment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "Method not allowed.")
+			return
+		}
+
+		id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/attachments/"))
+		if err != nil {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Attachment not found.")
+			return
+		}
+		attachment, err := utils.GetAttachment(db, id)
+		if err == sql.ErrNoRows {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Attachment not found.")
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to load attachment", "attachment_id", id, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to load attachment.")
+			return
+		}
+
+		// Attachments of deleted posts are only visible to moderators, like the post
+		var postDeleted bool
+		if err := db.QueryRow("SELECT deleted_at IS NOT NULL FROM posts WHERE id = ?", attachment.PostID).Scan(&postDeleted); err != nil {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Attachment not found.")
+			return
+		}
+		if postDeleted {
+			user, _ := utils.GetUserFromSession(w, r, db)
+			if !utils.IsModerator(user) {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", "Attachment not found.")
+				return
+			}
+		}
+
+		key, err := utils.UploadKey(attachment.Path)
+		if err != nil {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Attachment not found.")
+			return
+		}
+		body, err := utils.UploadStore().Get(r.Context(), key)
+		if err == storage.ErrNotFound {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Attachment not found.")
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to read attachment", "key", key, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to load attachment.")
+			return
+		}
+		defer body.Close()
+
+		disposition := mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name})
+		if disposition == "" {
+			disposition = "attachment"
+		}
+		w.Header().Set("Content-Type", attachment.ContentType)
+		w.Header().Set("Content-Disposition", disposition)
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "private, no-cache")
+		if r.Method == http.MethodHead {
+			return
+		}
+
+		if err := utils.RecordAttachmentDownload(r.Context(), db, attachment.ID); err != nil {
+			logging.FromContext(r.Context()).Warn("failed to count attachment download", "attachment_id", attachment.ID, "err", err)
+		}
+		io.Copy(w, body)
+	}
+}
@@ -2,18 +2,18 @@ package handlers
 
 import (
 	"database/sql"
-	"fmt"
 	"forum/internal"
+	"forum/internal/logging"
 	"forum/internal/utils"
-	_ "github.com/mutecomm/go-sqlcipher/v4"
-	"io"
-
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"net/http"
-	"os"
+	"strconv"
+	"strings"
 )
 
-func UploadAvatarHandler(db *sql.DB) http.HandlerFunc {
+// UploadAvatarHandler replaces the user's avatar. Animated GIFs keep their
+// frames only when animated is set.
+func UploadAvatarHandler(db *sql.DB, animated bool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		user, err := utils.GetUserFromSession(w, r, db)
 		if err != nil || user == nil {
@@ -33,23 +33,56 @@ func UploadAvatarHandler(db *sql.DB) http.HandlerFunc {
 		}
 		defer file.Close()
 
-		// Save file
-		filename := fmt.Sprintf("%d_%s", user.ID, handler.Filename)
-		filePath := "static/uploads/" + filename
-		out, err := os.Create(filePath)
+		// Cropped and stored in every avatar size
+		avatarURL, err := utils.SaveAvatar(r.Context(), file, handler, animated)
 		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Error", "Failed to save avatar.")
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Failed to save avatar: "+err.Error())
 			return
 		}
-		defer out.Close()
-		io.Copy(out, file)
 
-		_, err = db.Exec("UPDATE users SET avatar_url = ? WHERE id = ?", "/static/uploads/"+filename, user.ID)
+		var oldAvatar sql.NullString
+		db.QueryRow("SELECT avatar_url FROM users WHERE id = ?", user.ID).Scan(&oldAvatar)
+
+		_, err = db.Exec("UPDATE users SET avatar_url = ? WHERE id = ?", avatarURL, user.ID)
 		if err != nil {
+			utils.ReleaseUpload(r.Context(), db, avatarURL)
 			errors.RenderError(w, http.StatusInternalServerError, "Error", "Failed to update avatar URL.")
 			return
 		}
 
+		// The previous avatar is removed unless it is still used elsewhere
+		if oldAvatar.Valid && oldAvatar.String != avatarURL {
+			if _, err := utils.ReleaseUpload(r.Context(), db, oldAvatar.String); err != nil {
+				logging.FromContext(r.Context()).Warn("failed to release old avatar", "path", oldAvatar.String, "err", err)
+			}
+		}
+
 		http.Redirect(w, r, "/profile", http.StatusSeeOther)
 	}
 }
+
+// IdenticonHandler serves the generated avatar of users without an uploaded
+// one: /identicon/{userID}.png?size=64
+func IdenticonHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		idStr := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/identicon/"), ".png")
+		userID, err := strconv.Atoi(idStr)
+		if err != nil || userID < 0 {
+			http.NotFound(w, r)
+			return
+		}
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+
+		data, err := utils.Identicon("user:"+idStr, size)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to render identicon", "identicon_user_id", userID, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		// The image only depends on the URL, so browsers may keep it for long
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("Cache-Control", "public, max-age=604800, immutable")
+		w.Write(data)
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"database/sql"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+)
+
+// BadgesHandler lists every badge with the members holding it
+func BadgesHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, _ := utils.GetUserFromSession(w, r, db) // guests may look too
+
+		badges, err := utils.GetBadgeSummaries(r.Context(), db)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to get badges", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error retrieving badges.")
+			return
+		}
+
+		utils.Render(w, r, "badges", models.BadgesPageData{CurrentUser: user, Badges: badges})
+	}
+}
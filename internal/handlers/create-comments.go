@@ -4,6 +4,8 @@ import (
 	"database/sql"
 	"fmt"
 	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/models"
 	"forum/internal/utils"
 	"log"
 	"net/http"
@@ -35,6 +37,16 @@ func CreateCommentHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		// Locked and archived threads take no new comments
+		if err := utils.CheckPostOpen(db, postID); err != nil {
+			if err == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", utils.PostClosedMessage(err))
+				return
+			}
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.PostClosedMessage(err))
+			return
+		}
+
 		parentCommentID, err := strconv.Atoi(r.FormValue("parentCommentId"))
 		if err != nil {
 			parentCommentID = 0 // or other default value for the root comment
@@ -46,12 +58,38 @@ func CreateCommentHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
+		if msg, err := utils.CheckPostingCapabilities(r.Context(), db, userID, content, 0, nil); err != nil {
+			logging.FromContext(r.Context()).Error("capability check failed", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error adding comment to database.")
+			return
+		} else if msg != "" {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", msg)
+			return
+		}
+
+		submission := utils.Submission{Kind: "comment", UserID: userID, PostID: postID, ParentCommentID: parentCommentID, Content: content}
+		switch verdict := utils.CheckSpam(r.Context(), db, submission); verdict.Verdict {
+		case utils.SpamReject:
+			logging.FromContext(r.Context()).Info("spam filter rejected a comment", "reasons", verdict.Reasons)
+			errors.RenderError(w, http.StatusUnprocessableEntity, "Comment Rejected", utils.SpamRejectedMessage)
+			return
+		case utils.SpamHold:
+			if _, err := utils.HoldContent(r.Context(), db, submission, models.HeldPostPayload{}, verdict); err != nil {
+				logging.FromContext(r.Context()).Error("failed to hold comment", "err", err)
+				errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error adding comment to database.")
+				return
+			}
+			http.Redirect(w, r, fmt.Sprintf("/post_page/%d?held=1", postID), http.StatusSeeOther)
+			return
+		}
+
 		commentID, err := utils.AddComment(db, postID, userID, parentCommentID, content)
 		if err != nil {
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error adding comment to database.")
 			return
 		}
 		log.Printf("Created comment with ID: %d", commentID)
+		utils.RefreshReputation(r.Context(), db, userID)
 		http.Redirect(w, r, fmt.Sprintf("/post_page/%d", postID), http.StatusSeeOther)
 	}
 }
@@ -3,8 +3,11 @@ package handlers
 import (
 	"context"
 	"database/sql"
+	stderrors "errors"
 	"fmt"
 	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/metrics"
 	"forum/internal/models"
 	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
@@ -13,7 +16,6 @@ import (
 	"strconv"
 	"strings"
 	"sync"
-	"text/template"
 	"time"
 )
 
@@ -22,7 +24,7 @@ var (
 	mu    sync.Mutex
 )
 
-func ServeFormCreatePost(db *sql.DB) http.HandlerFunc {
+func ServeFormCreatePost(db *sql.DB, attachmentTypes []models.AttachmentType) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		categories, err := utils.GetCategories(w, db)
 		if err != nil {
@@ -37,32 +39,17 @@ func ServeFormCreatePost(db *sql.DB) http.HandlerFunc {
 		}
 
 		data := models.CreatePostPageData{
-			Categories:  categories,
-			CurrentUser: CurrentUser,
-			CSRFToken:   "example-token",
+			Categories:      categories,
+			CurrentUser:     CurrentUser,
+			CSRFToken:       "example-token",
+			AttachmentTypes: attachmentTypes,
 		}
 
-		tmpl, err := template.ParseFiles(
-			"templates/layout.html",
-			"templates/header.html",
-			"templates/nav.html",
-			"templates/create_post.html",
-			"templates/form_group_post.html",
-			"templates/images-post.html",
-			"templates/notifications.html",
-		)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template error: "+err.Error())
-			return
-		}
-
-		if err := tmpl.ExecuteTemplate(w, "layout", data); err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Render error: "+err.Error())
-		}
+		utils.Render(w, r, "create_post", data)
 	}
 }
 
-func HandlerCreatePost(db *sql.DB) http.HandlerFunc {
+func HandlerCreatePost(db *sql.DB, attachmentTypes []models.AttachmentType) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -96,6 +83,19 @@ func HandlerCreatePost(db *sql.DB) http.HandlerFunc {
 
 		// Process tags
 		tags := parseTags(tagsInput)
+		wiki := r.FormValue("wiki") != ""
+
+		// Images, links and new tags need the trust level that unlocks them
+		msg, err := utils.CheckPostingCapabilities(r.Context(), db, userID, title+"\n"+content, utils.CountUploadedImages(r), tags)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("capability check failed", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error adding post to database.")
+			return
+		}
+		if msg != "" {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", msg)
+			return
+		}
 
 		// Process images
 		imagePaths, primaryImageIndex, err := utils.ProcessUploadedImages(r)
@@ -113,14 +113,54 @@ func HandlerCreatePost(db *sql.DB) http.HandlerFunc {
 			primaryImageIndex = 0
 		}
 
-		err = CreatePost(db, userID, title, content, createdAt, categoryIDs, tags, imagePaths, primaryImageIndex)
+		// Process attachments; every file must pass the scanner before the post is published
+		attachments, err := utils.ProcessUploadedAttachments(r, attachmentTypes)
+		if err != nil {
+			renderAttachmentError(w, r, err)
+			return
+		}
+
+		// The spam filter may refuse the post, and it or pre-moderation may
+		// hold it for review; held posts keep everything needed to create
+		// them later
+		submission := utils.Submission{Kind: "post", UserID: userID, Title: title, Content: content, CategoryIDs: categoryIDs}
+		switch verdict := utils.CheckSpam(r.Context(), db, submission); verdict.Verdict {
+		case utils.SpamReject:
+			logging.FromContext(r.Context()).Info("spam filter rejected a post", "reasons", verdict.Reasons)
+			errors.RenderError(w, http.StatusUnprocessableEntity, "Post Rejected", utils.SpamRejectedMessage)
+			return
+		case utils.SpamHold:
+			payload := models.HeldPostPayload{
+				CategoryIDs:  categoryIDs,
+				Tags:         tags,
+				Images:       imagePaths,
+				PrimaryImage: primaryImageIndex,
+				Attachments:  attachments,
+				Wiki:         wiki,
+			}
+			if _, err := utils.HoldContent(r.Context(), db, submission, payload, verdict); err != nil {
+				logging.FromContext(r.Context()).Error("failed to hold post", "err", err)
+				errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error adding post to database.")
+				return
+			}
+			http.Redirect(w, r, "/user_page?held=1", http.StatusSeeOther)
+			return
+		}
+
+		var makeWiki func(tx *sql.Tx, postID int64) error
+		if wiki {
+			makeWiki = func(tx *sql.Tx, postID int64) error {
+				return utils.SetPostWiki(r.Context(), tx, int(postID), true)
+			}
+		}
+		postID, err := createPost(db, userID, title, content, createdAt, categoryIDs, tags, imagePaths, primaryImageIndex, attachments, makeWiki)
 		if err != nil {
 			log.Printf("Error creating post: %v", err)
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error adding post to database.")
 			return
 		}
 
-		log.Printf("Post created successfully with %d images", len(imagePaths))
+		logging.FromContext(r.Context()).Info("post created", "post_id", postID, "images", len(imagePaths), "attachments", len(attachments))
 		http.Redirect(w, r, "/user_page", http.StatusSeeOther)
 	}
 }
@@ -174,13 +214,21 @@ func parseTags(tagsInput string) []string {
 	return tags
 }
 
-func CreatePost(db *sql.DB, userID int, title, content string, created_at time.Time, categoryIDs []int, tags []string, imagePaths []string, primaryImageIndex int) error {
+func CreatePost(db *sql.DB, userID int, title, content string, created_at time.Time, categoryIDs []int, tags []string, imagePaths []string, primaryImageIndex int, attachments []models.Attachment) error {
+	_, err := createPost(db, userID, title, content, created_at, categoryIDs, tags, imagePaths, primaryImageIndex, attachments, nil)
+	return err
+}
+
+// createPost is CreatePost returning the ID of the new post. then, when set,
+// runs in the same transaction once the post is recorded, so the post is only
+// created if it succeeds too.
+func createPost(db *sql.DB, userID int, title, content string, created_at time.Time, categoryIDs []int, tags []string, imagePaths []string, primaryImageIndex int, attachments []models.Attachment, then func(tx *sql.Tx, postID int64) error) (postID int64, err error) {
 	// Start of transaction
 	ctx := context.Background()
 	tx, err := db.Begin()
 	if err != nil {
 		log.Printf("Error starting transaction: %v", err)
-		return err
+		return 0, err
 	}
 
 	// Ensure transaction is rolled back on any error
@@ -191,57 +239,55 @@ func CreatePost(db *sql.DB, userID int, title, content string, created_at time.T
 	}()
 
 	// Log the values being inserted
-	log.Printf("Inserting post: userID=%d, title=%s, content=%s, created_at=%v", userID, title, content, created_at, imagePaths)
-	log.Printf("Categories to insert: %+v", categoryIDs)
 
 	// Adding a post
 	query := `INSERT INTO posts (user_id, title, content, created_at) VALUES (?, ?, ?, ?)`
 	result, err := tx.Exec(query, userID, title, content, created_at)
 	if err != nil {
 		log.Printf("Error inserting post: %v", err)
-		return err
+		return 0, err
 	}
 
 	// Getting the ID of a new post
-	postID, err := result.LastInsertId()
+	postID, err = result.LastInsertId()
 	if err != nil {
 		log.Printf("Error getting last insert ID: %v", err)
-		return err
+		return 0, err
 	}
 
 	log.Printf("New post ID: %d", postID)
 	// Check maximum number of categories (3)
 	if len(categoryIDs) > 3 {
-		return fmt.Errorf("can select up to 3 categories only")
+		return 0, fmt.Errorf("can select up to 3 categories only")
 	}
 
 	if len(categoryIDs) > 0 {
 		valid, err := validateCategoriesExist(tx, categoryIDs)
 		if err != nil {
 			log.Printf("Error validating categories: %v", err)
-			return err
+			return 0, err
 		}
 		if !valid {
-			return fmt.Errorf("one or more categories do not exist")
+			return 0, fmt.Errorf("one or more categories do not exist")
 		}
 
 		// Delete old categories (if any)
 		if _, err := tx.Exec("DELETE FROM post_categories WHERE post_id = ?", postID); err != nil {
 			log.Printf("Error deleting old categories: %v", err)
-			return err
+			return 0, err
 		}
 
 		stmt, err := tx.Prepare("INSERT INTO post_categories (post_id, category_id) VALUES (?, ?)")
 		if err != nil {
 			log.Printf("Error preparing statement: %v", err)
-			return err
+			return 0, err
 		}
 		defer stmt.Close()
 
 		for _, categoryID := range categoryIDs {
 			if _, err := stmt.Exec(postID, categoryID); err != nil {
 				log.Printf("Error adding category %d to post %d: %v", categoryID, postID, err)
-				return err
+				return 0, err
 			}
 			log.Printf("Post %d linked to category %d", postID, categoryID)
 		}
@@ -253,24 +299,38 @@ func CreatePost(db *sql.DB, userID int, title, content string, created_at time.T
 	err = utils.ProcessPostTags(ctx, tx, postID, tags)
 	if err != nil {
 		log.Printf("Error processing tags for post %d: %v", postID, err)
-		return err
+		return 0, err
 	}
 
 	// Calling a function to process images
 	err = utils.ProcessPostImages(ctx, tx, postID, imagePaths, primaryImageIndex)
 	if err != nil {
 		log.Printf("Error processing images for post %d: %v", postID, err)
-		return err
+		return 0, err
+	}
+
+	// Calling a function to record attachments
+	err = utils.AddPostAttachments(ctx, tx, postID, userID, attachments)
+	if err != nil {
+		log.Printf("Error adding attachments to post %d: %v", postID, err)
+		return 0, err
+	}
+
+	if then != nil {
+		if err = then(tx, postID); err != nil {
+			return 0, err
+		}
 	}
 
 	// Completion of the transaction
 	if err := tx.Commit(); err != nil {
 		log.Printf("Error committing transaction: %v", err)
-		return err
+		return 0, err
 	}
 
 	log.Println("Post and categories added successfully")
-	return nil
+	metrics.PostsCreated.Inc()
+	return postID, nil
 
 }
 
@@ -294,3 +354,13 @@ func validateCategoriesExist(tx *sql.Tx, ids []int) (bool, error) {
 
 	return count == len(ids), nil
 }
+
+// renderAttachmentError explains why uploaded attachments were not accepted
+func renderAttachmentError(w http.ResponseWriter, r *http.Request, err error) {
+	if stderrors.Is(err, utils.ErrAttachmentRejected) {
+		errors.RenderError(w, http.StatusBadRequest, "Bad Request", err.Error())
+		return
+	}
+	logging.FromContext(r.Context()).Error("failed to process attachments", "err", err)
+	errors.RenderError(w, http.StatusServiceUnavailable, "Service Unavailable", "Attachments could not be checked right now. Please try again later.")
+}
@@ -136,7 +136,25 @@ func HandleReaction(db *sql.DB) http.HandlerFunc {
 			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid reaction type.")
 			return
 		}
-		log.Printf("User %d reaction == contentType %d", user.ID, contentType)
+		log.Printf("User %d reaction == contentType %s", user.ID, contentType)
+
+		// Reactions are frozen on locked and archived threads
+		var stateErr error
+		switch contentType {
+		case "post":
+			stateErr = utils.CheckPostOpen(db, contentID)
+		case "comment":
+			stateErr = utils.CheckCommentOpen(db, contentID)
+		}
+		if stateErr != nil {
+			if stateErr == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", utils.PostClosedMessage(stateErr))
+				return
+			}
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.PostClosedMessage(stateErr))
+			return
+		}
+
 		if contentType == "post" {
 			err = ProcessReactionForPost(db, user.ID, contentID, reaction)
 		} else if contentType == "comment" {
@@ -150,6 +168,8 @@ func HandleReaction(db *sql.DB) http.HandlerFunc {
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to process reaction")
 			return
 		}
+		// Reactions count towards the reputation of the author
+		utils.RefreshContentOwner(r.Context(), db, contentType, contentID)
 
 		//Redirecting back
 		referer := r.Header.Get("Referer")
@@ -163,7 +183,7 @@ func HandleReaction(db *sql.DB) http.HandlerFunc {
 // Function for handling reactions to comments
 func ProcessReactionForComment(db *sql.DB, userID int, commentID int, newReaction string) error {
 	dbReaction := strings.Title(strings.ToLower(newReaction))
-	log.Printf("ProcessReactionForComment %d", dbReaction)
+	log.Printf("ProcessReactionForComment %s", dbReaction)
 
 	//Checking the user's current reaction to the comment
 	var currentReaction string
@@ -3,8 +3,9 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"forum/internal/logging"
 	// "sync"
-	// "forum/internal/models"
+	"forum/internal/models"
 	"forum/internal/utils"
 	"net/http"
 	"strconv"
@@ -75,33 +76,74 @@ func HandlerAddReply(db *sql.DB, hub *Hub) http.HandlerFunc {
 			return
 		}
 
-		// Add comment
-		commentID, err := utils.AddComment(db, postID, userID, parentCommentID, content)
-		if err != nil {
-			utils.RespondWithError(w, http.StatusInternalServerError, "Could not add reply")
+		// Locked and archived threads take no new replies
+		if err := utils.CheckPostOpen(db, postID); err != nil {
+			status := http.StatusForbidden
+			if err == sql.ErrNoRows {
+				status = http.StatusNotFound
+			}
+			utils.RespondWithError(w, status, utils.PostClosedMessage(err))
 			return
 		}
 
-		// If this is a reply to another comment
+		// Deleted comments take no replies
+		var parentAuthorID int
+		var parentAuthorName, parentContent string
 		if parentCommentID != 0 {
-			// Get parent comment author info
-			var parentAuthorID int
-			var parentAuthorName, parentContent string
 			err := db.QueryRow(`
 				SELECT c.user_id, u.username, c.content
 				FROM comments c
 				JOIN users u ON c.user_id = u.id
-				WHERE c.id = ?`, parentCommentID).Scan(&parentAuthorID, &parentAuthorName, &parentContent)
-
+				WHERE c.id = ? AND c.deleted_at IS NULL`, parentCommentID).Scan(&parentAuthorID, &parentAuthorName, &parentContent)
 			if err != nil {
 				if err == sql.ErrNoRows {
-					utils.RespondWithError(w, http.StatusBadRequest, "Parent comment not found")
+					utils.RespondWithError(w, http.StatusNotFound, "Parent comment not found")
 					return
 				}
 				utils.RespondWithError(w, http.StatusInternalServerError, "Database error")
 				return
 			}
+		}
+
+		if msg, err := utils.CheckPostingCapabilities(r.Context(), db, userID, content, 0, nil); err != nil {
+			logging.FromContext(r.Context()).Error("capability check failed", "err", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not add reply")
+			return
+		} else if msg != "" {
+			utils.RespondWithError(w, http.StatusForbidden, msg)
+			return
+		}
+
+		submission := utils.Submission{Kind: "comment", UserID: userID, PostID: postID, ParentCommentID: parentCommentID, Content: content}
+		switch verdict := utils.CheckSpam(r.Context(), db, submission); verdict.Verdict {
+		case utils.SpamReject:
+			logging.FromContext(r.Context()).Info("spam filter rejected a reply", "reasons", verdict.Reasons)
+			utils.RespondWithError(w, http.StatusUnprocessableEntity, utils.SpamRejectedMessage)
+			return
+		case utils.SpamHold:
+			if _, err := utils.HoldContent(r.Context(), db, submission, models.HeldPostPayload{}, verdict); err != nil {
+				logging.FromContext(r.Context()).Error("failed to hold reply", "err", err)
+				utils.RespondWithError(w, http.StatusInternalServerError, "Could not add reply")
+				return
+			}
+			utils.RespondWithJSON(w, http.StatusAccepted, map[string]interface{}{
+				"success": true,
+				"held":    true,
+				"message": utils.SpamHeldMessage,
+			})
+			return
+		}
+
+		// Add comment
+		commentID, err := utils.AddComment(db, postID, userID, parentCommentID, content)
+		if err != nil {
+			utils.RespondWithError(w, http.StatusInternalServerError, "Could not add reply")
+			return
+		}
+		utils.RefreshReputation(r.Context(), db, userID)
 
+		// If this is a reply to another comment
+		if parentCommentID != 0 {
 			// Don't notify yourself
 			if parentAuthorID != userID {
 				// Get post info
@@ -2,26 +2,33 @@ package handlers
 
 import (
 	"database/sql"
+	"forum/internal/metrics"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"log"
 	"time"
 )
 
-// Creates a regular user (with password)
-func СreateRegularUser(db *sql.DB, username, email, hashedPassword string) error {
+// Creates a regular user (with password), registered from ip
+func СreateRegularUser(db *sql.DB, username, email, hashedPassword, ip string) error {
 	_, err := db.Exec(`
-        INSERT INTO users (username, email, password, created_at)
-        VALUES (?, ?, ?, ?)`,
-		username, email, hashedPassword, time.Now())
+        INSERT INTO users (username, email, password, created_at, registration_ip)
+        VALUES (?, ?, ?, ?, ?)`,
+		username, email, hashedPassword, time.Now(), ip)
+	if err == nil {
+		metrics.Registrations.With("password").Inc()
+	}
 	return err
 }
 
-// Creates an OAuth user (without password)
-func СreateOAuthUser(db *sql.DB, username, email, provider, providerID, avatarURL string) error {
+// Creates an OAuth user (without password), registered from ip
+func СreateOAuthUser(db *sql.DB, username, email, provider, providerID, avatarURL, ip string) error {
 	_, err := db.Exec(`
-        INSERT INTO users (username, email, password, provider, provider_id, avatar_url, created_at)
-        VALUES (?, ?, '', ?, ?, ?, ?)`,
-		username, email, provider, providerID, avatarURL, time.Now())
+        INSERT INTO users (username, email, password, provider, provider_id, avatar_url, created_at, registration_ip)
+        VALUES (?, ?, '', ?, ?, ?, ?, ?)`,
+		username, email, provider, providerID, avatarURL, time.Now(), ip)
+	if err == nil {
+		metrics.Registrations.With(provider).Inc()
+	}
 	return err
 }
 
@@ -28,7 +28,7 @@ func HandlerDeleteComment(db *sql.DB) http.HandlerFunc {
 
 		// Get user_id and post_id for permissions check and redirect
 		var authorID, postID int
-		err = db.QueryRow("SELECT user_id, post_id FROM comments WHERE id = ?", commentID).Scan(&authorID, &postID)
+		err = db.QueryRow("SELECT user_id, post_id FROM comments WHERE id = ? AND deleted_at IS NULL", commentID).Scan(&authorID, &postID)
 		if err != nil {
 			errors.RenderError(w, http.StatusNotFound, "Not Found", "Comment not found.")
 			return
@@ -40,8 +40,18 @@ func HandlerDeleteComment(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		// Delete comment
-		_, err = db.Exec("DELETE FROM comments WHERE id = ?", commentID)
+		// Locked and archived threads are read-only for members
+		if err := utils.CheckPostWritable(db, user, postID); err != nil {
+			if err == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", utils.PostClosedMessage(err))
+				return
+			}
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.PostClosedMessage(err))
+			return
+		}
+
+		// Move the comment to the trash; replies keep a "[deleted]" parent
+		err = utils.SoftDeleteComment(r.Context(), db, commentID, user.ID, r.FormValue("reason"))
 		if err != nil {
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error deleting comment.")
 			return
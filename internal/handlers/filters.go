@@ -6,7 +6,6 @@ import (
 	"forum/internal"
 	"forum/internal/models"
 	"forum/internal/utils"
-	"html/template"
 	// "log"
 	"net/http"
 	"strconv"
@@ -27,12 +26,16 @@ func HandlePostsFilter(db *sql.DB) http.HandlerFunc {
 		myPosts := r.URL.Query().Get("mine") == "true"
 
 		var joins []string
-		var whereClauses []string
+		// Deleted posts never show up in filtered listings
+		whereClauses := []string{"p.deleted_at IS NULL"}
 		var args []interface{}
 		havingClause := ""
+		pinScope := "0"
+		var pinArgs []interface{}
 
 		if len(categoryFilter) > 0 {
-			joins = append(joins, "JOIN post_categories pc ON p.id = pc.post_id")
+			// Announcements are shown whatever categories are selected
+			joins = append(joins, "LEFT JOIN post_categories pc ON p.id = pc.post_id")
 
 			var catIDs []interface{}
 			for _, catStr := range categoryFilter {
@@ -47,10 +50,14 @@ func HandlePostsFilter(db *sql.DB) http.HandlerFunc {
 			placeholders := strings.Repeat("?,", len(catIDs))
 			placeholders = strings.TrimSuffix(placeholders, ",")
 
-			whereClauses = append(whereClauses, fmt.Sprintf("pc.category_id IN (%s)", placeholders))
+			whereClauses = append(whereClauses, fmt.Sprintf("(pc.category_id IN (%s) OR p.is_announcement = 1)", placeholders))
 			args = append(args, catIDs...)
 
-			havingClause = fmt.Sprintf("HAVING COUNT(DISTINCT pc.category_id) = %d", len(catIDs))
+			havingClause = fmt.Sprintf("HAVING COUNT(DISTINCT pc.category_id) = %d OR p.is_announcement = 1", len(catIDs))
+
+			// A post pinned in any of the selected categories goes to the top
+			pinScope = fmt.Sprintf("0,%s", placeholders)
+			pinArgs = catIDs
 		}
 
 		if likedOnly {
@@ -65,12 +72,16 @@ func HandlePostsFilter(db *sql.DB) http.HandlerFunc {
 		}
 
 		sqlQuery := `
-        SELECT p.id, p.title, p.content, p.user_id, u.username as user_name,
+        SELECT p.id, p.title, p.content, p.user_id, u.username as user_name, COALESCE(u.trust_level, 0),
                COUNT(DISTINCT l.id) as likes,
                COUNT(DISTINCT c.id) as comment_count,
-               p.created_at
+               p.created_at,
+               EXISTS(SELECT 1 FROM post_pins pp WHERE pp.post_id = p.id AND pp.category_id IN (` + pinScope + `)) AS pinned,
+               p.locked_at IS NOT NULL, COALESCE(p.is_announcement, 0) AS announcement, p.archived_at IS NOT NULL
         FROM posts p
     `
+		// The pin placeholders come first in the query
+		args = append(append([]interface{}{}, pinArgs...), args...)
 
 		if len(joins) > 0 {
 			sqlQuery += " " + strings.Join(joins, " ")
@@ -79,7 +90,7 @@ func HandlePostsFilter(db *sql.DB) http.HandlerFunc {
 		sqlQuery += `
         LEFT JOIN users u ON p.user_id = u.id
         LEFT JOIN likes l ON p.id = l.post_id
-        LEFT JOIN comments c ON p.id = c.post_id
+        LEFT JOIN comments c ON p.id = c.post_id AND c.deleted_at IS NULL
     `
 
 		if len(whereClauses) > 0 {
@@ -92,7 +103,7 @@ func HandlePostsFilter(db *sql.DB) http.HandlerFunc {
 			sqlQuery += " " + havingClause
 		}
 
-		sqlQuery += " ORDER BY p.created_at DESC"
+		sqlQuery += " ORDER BY announcement DESC, pinned DESC, p.created_at DESC"
 
 		fmt.Println("SQL Query:", sqlQuery)
 		fmt.Println("Args:", args)
@@ -109,7 +120,8 @@ func HandlePostsFilter(db *sql.DB) http.HandlerFunc {
 		for rows.Next() {
 			var p models.PostView
 			var rawCreatedAt time.Time
-			err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.UserID, &p.UserName, &p.Likes, &p.CommentsCount, &rawCreatedAt)
+			err := rows.Scan(&p.ID, &p.Title, &p.Content, &p.UserID, &p.UserName, &p.UserTrustLevel, &p.Likes, &p.CommentsCount, &rawCreatedAt,
+				&p.IsPinned, &p.IsLocked, &p.IsAnnouncement, &p.IsArchived)
 			if err != nil {
 				errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Row scan error: %v")
 				return
@@ -160,27 +172,6 @@ func HandlePostsFilter(db *sql.DB) http.HandlerFunc {
 			SelectedCategories: selectedCategories,
 		}
 
-		tmpl, err := template.ParseFiles(
-			"templates/layout.html",
-			"templates/filters.html",
-			"templates/filters_page.html",
-			"templates/header.html",
-			"templates/post_list_item.html",
-			"templates/post_list_filter.html",
-			"templates/nav.html",
-			"templates/notifications.html",
-		)
-		if err != nil {
-			fmt.Printf("Template parsing error: %v\n", err)
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template error.")
-			return
-		}
-
-		err = tmpl.ExecuteTemplate(w, "layout", data)
-		if err != nil {
-			fmt.Printf("Template execution error: %v\n", err)
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template rendering error.")
-			return
-		}
+		utils.Render(w, r, "filters", data)
 	}
 }
@@ -5,38 +5,27 @@ import (
 
 	"database/sql"
 	"forum/internal"
+	"forum/internal/config"
+	"forum/internal/logging"
 	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"github.com/sendgrid/sendgrid-go"
 	"github.com/sendgrid/sendgrid-go/helpers/mail"
-	"log"
 	"net/http"
-	"os"
 	"strings"
-	"text/template"
 	"time"
 )
 
 func ForgotPasswordHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl, err := template.ParseFiles(
-			"templates/layout_auth.html",
-			"templates/forgot_password.html",
-			"templates/header.html",
-		)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template not loaded.")
-			return
-		}
 
-		err = tmpl.ExecuteTemplate(w, "layout", nil)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template execution error.")
-		}
+		utils.Render(w, r, "forgot_password", nil)
 	}
 }
 
-func ForgotPasswordSubmitHandler(db *sql.DB) http.HandlerFunc {
+// ForgotPasswordSubmitHandler stores a reset token and mails a link to it.
+// Links point at baseURL.
+func ForgotPasswordSubmitHandler(db *sql.DB, emailCfg config.Email, baseURL string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		email := r.FormValue("email")
 
@@ -56,9 +45,9 @@ func ForgotPasswordSubmitHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		err = sendResetEmail(email, token)
+		err = sendResetEmail(emailCfg, baseURL, email, token)
 		if err != nil {
-			log.Printf("Send email error: %v", err)
+			logging.FromContext(r.Context()).Error("failed to send password reset email", "user_id", userID, "err", err)
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to send reset email.")
 			return
 		}
@@ -71,13 +60,10 @@ func ForgotPasswordSubmitHandler(db *sql.DB) http.HandlerFunc {
 	}
 }
 
-func sendResetEmail(email, token string) error {
-	// 0. Пconfiguration check
-	requiredVars := []string{"EMAIL_FROM_NAME", "EMAIL_FROM_ADDRESS", "SENDGRID_API_KEY"}
-	for _, v := range requiredVars {
-		if os.Getenv(v) == "" {
-			return fmt.Errorf("%s environment variable not set", v)
-		}
+func sendResetEmail(cfg config.Email, baseURL, email, token string) error {
+	// 0. Configuration check
+	if !cfg.Enabled() {
+		return fmt.Errorf("email is not configured: SENDGRID_API_KEY is not set")
 	}
 
 	// 1.Email validation
@@ -87,16 +73,12 @@ func sendResetEmail(email, token string) error {
 
 	// 2. Sender settings
 	from := mail.NewEmail(
-		os.Getenv("EMAIL_FROM_NAME"),    // "DiscuZone Forum"
-		os.Getenv("EMAIL_FROM_ADDRESS"), // Verified address in SendGrid
+		cfg.FromName,    // "DiscuZone Forum"
+		cfg.FromAddress, // Verified address in SendGrid
 	)
 
 	// 3. Link generation
-	baseURL := os.Getenv("BASE_URL")
-	if baseURL == "" {
-		baseURL = "http://localhost:8080" // Default для development
-	}
-	resetLink := fmt.Sprintf("%s/reset-password?token=%s", baseURL, token)
+	resetLink := fmt.Sprintf("%s/reset-password?token=%s", strings.TrimSuffix(baseURL, "/"), token)
 
 	// 4.HTML content (optimized)
 	htmlContent := fmt.Sprintf(`<!DOCTYPE html>
@@ -114,16 +96,15 @@ func sendResetEmail(email, token string) error {
 	message := mail.NewSingleEmail(from, "Password Reset Request", mail.NewEmail("", email),
 		fmt.Sprintf("Password Reset Link: %s", resetLink), htmlContent)
 
-	client := sendgrid.NewSendClient(os.Getenv("SENDGRID_API_KEY"))
+	client := sendgrid.NewSendClient(cfg.SendGridAPIKey.Value())
 	response, err := client.Send(message)
 
 	if err != nil {
-		log.Printf("SendGrid error: %v, Status: %d", err, response.StatusCode)
-		log.Printf("SendGrid response body: %s", response.Body)
-		return fmt.Errorf("failed to send email")
+		return fmt.Errorf("sendgrid: %w", err)
+	}
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("sendgrid returned status %d: %s", response.StatusCode, response.Body)
 	}
-
-	log.Printf("Email sent to %s (Status: %d)", email, response.StatusCode)
 	return nil
 }
 
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	stderrors "errors"
+	"forum/internal/logging"
+	"forum/internal/utils"
+	"net/http"
+)
+
+// galleryRequest is the JSON body of the post image endpoints
+type galleryRequest struct {
+	PostID   int    `json:"post_id"`
+	ImageID  int    `json:"image_id"`
+	ImageIDs []int  `json:"image_ids"`
+	Caption  string `json:"caption"`
+	AltText  string `json:"alt_text"`
+}
+
+// HandlerReorderImages sets the order of a post's images: {"post_id", "image_ids"}
+func HandlerReorderImages(db *sql.DB) http.HandlerFunc {
+	return galleryHandler(db, func(r *http.Request, req galleryRequest) error {
+		return utils.ReorderPostImages(r.Context(), db, req.PostID, req.ImageIDs)
+	})
+}
+
+// HandlerSetPrimaryImage changes a post's primary image: {"post_id", "image_id"}
+func HandlerSetPrimaryImage(db *sql.DB) http.HandlerFunc {
+	return galleryHandler(db, func(r *http.Request, req galleryRequest) error {
+		return utils.SetPrimaryPostImage(r.Context(), db, req.PostID, req.ImageID)
+	})
+}
+
+// HandlerUpdateImageText sets an image's caption and alt text:
+// {"post_id", "image_id", "caption", "alt_text"}
+func HandlerUpdateImageText(db *sql.DB) http.HandlerFunc {
+	return galleryHandler(db, func(r *http.Request, req galleryRequest) error {
+		return utils.UpdatePostImageText(r.Context(), db, req.PostID, req.ImageID, req.Caption, req.AltText)
+	})
+}
+
+// galleryHandler decodes the request, checks that the user may edit the post,
+// applies the change and responds with the post's images in their new state
+func galleryHandler(db *sql.DB, apply func(*http.Request, galleryRequest) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != http.MethodPost {
+			utils.RespondWithError(w, http.StatusMethodNotAllowed, "Method not allowed")
+			return
+		}
+
+		var req galleryRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			utils.RespondWithError(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || user == nil {
+			utils.RespondWithError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+
+		post, err := utils.GetPostByID(db, req.PostID)
+		if err != nil || post.IsDeleted {
+			utils.RespondWithError(w, http.StatusNotFound, "Post not found")
+			return
+		}
+		if !utils.HasPermission(user, post.UserID, "edit") {
+			utils.RespondWithError(w, http.StatusForbidden, "You don't have permission to edit this post")
+			return
+		}
+		// Locked and archived threads are read-only for members
+		if err := utils.CheckPostWritable(db, user, req.PostID); err != nil {
+			if err == sql.ErrNoRows {
+				utils.RespondWithError(w, http.StatusNotFound, "Post not found")
+				return
+			}
+			utils.RespondWithError(w, http.StatusForbidden, utils.PostClosedMessage(err))
+			return
+		}
+
+		if err := apply(r, req); err != nil {
+			if stderrors.Is(err, utils.ErrInvalidGallery) {
+				utils.RespondWithError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+			logging.FromContext(r.Context()).Error("gallery update failed", "post_id", req.PostID, "err", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to update images")
+			return
+		}
+
+		images, err := utils.GetPostImages(db, req.PostID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to load post images", "post_id", req.PostID, "err", err)
+			utils.RespondWithError(w, http.StatusInternalServerError, "Failed to load images")
+			return
+		}
+		utils.RespondWithJSON(w, http.StatusOK, images)
+	}
+}
@@ -2,12 +2,10 @@ package handlers
 
 import (
 	"database/sql"
-	"forum/internal"
 	"forum/internal/models"
 	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"net/http"
-	"text/template"
 )
 
 type Category struct {
@@ -71,20 +69,6 @@ func AdminCategoriesPage(db *sql.DB) http.HandlerFunc {
 			CurrentUser: user,
 		}
 
-		tmpl, err := template.ParseFiles(
-			"templates/layout_admin.html",
-			"templates/header.html",
-			"templates/nav_admin.html",
-			"templates/admin_categories.html",
-		)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template not loaded.")
-			return
-		}
-
-		err = tmpl.ExecuteTemplate(w, "layout", data)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template execution failed.")
-		}
+		utils.Render(w, r, "admin_categories", data)
 	}
 }
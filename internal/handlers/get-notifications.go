@@ -21,7 +21,7 @@ func HandlerGetNotifications(db *sql.DB) http.HandlerFunc {
 
 		// Запит тільки для непрочитаних сповіщень
 		rows, err := db.Query(`
-            SELECT n.id, n.type, n.post_id, n.actor_id, u.username, n.created_at
+            SELECT n.id, n.type, COALESCE(n.post_id, 0), n.actor_id, u.username, n.created_at, COALESCE(n.message, '')
             FROM notifications n
             JOIN users u ON n.actor_id = u.id
             WHERE n.user_id = ? AND n.is_read = FALSE
@@ -37,25 +37,38 @@ func HandlerGetNotifications(db *sql.DB) http.HandlerFunc {
 		var notifications []map[string]interface{}
 		for rows.Next() {
 			var id, postID, actorID int
-			var notifType, actorUsername, createdAt string
+			var notifType, actorUsername, createdAt, message string
 
-			if err := rows.Scan(&id, &notifType, &postID, &actorID, &actorUsername, &createdAt); err != nil {
+			if err := rows.Scan(&id, &notifType, &postID, &actorID, &actorUsername, &createdAt, &message); err != nil {
 				log.Printf("Error scanning notification row: %v", err)
 				continue
 			}
 
-			// Отримуємо пост для заголовка
-			post, err := utils.GetPostByID(db, postID)
-			if err != nil {
-				log.Printf("Error getting post title: %v", err)
-				continue
+			// Rejected submissions were never published, so there is no post
+			// and the link goes to the author's page, which lists them
+			var postTitle string
+			link := "/user_page"
+			if notifType == "badge" {
+				link = "/badges"
+			}
+			if postID != 0 {
+				// Отримуємо пост для заголовка
+				post, err := utils.GetPostByID(db, postID)
+				if err != nil {
+					log.Printf("Error getting post title: %v", err)
+					continue
+				}
+				postTitle = post.Title
+				link = fmt.Sprintf("/post_page/%d", postID)
 			}
 
 			notifications = append(notifications, map[string]interface{}{
 				"id":         id,
 				"type":       notifType,
 				"post_id":    postID,
-				"post_title": post.Title, // Додаємо заголовок посту
+				"post_title": postTitle, // Додаємо заголовок посту
+				"link":       link,
+				"message":    message,
 				"actor":      actorUsername,
 				"is_read":    false,
 				"created_at": createdAt,
@@ -139,16 +152,17 @@ func GetAllNotifications(db *sql.DB, userID int) ([]models.Notification, error)
         SELECT 
             n.id, 
             n.type, 
-            n.post_id, 
-            p.title as post_title,
+            COALESCE(n.post_id, 0), 
+            COALESCE(p.title, '') as post_title,
             n.actor_id, 
             u.username as actor_name,
             n.is_read,
-            n.created_at
+            n.created_at,
+            COALESCE(n.message, '')
         FROM notifications n
         JOIN users u ON n.actor_id = u.id
-        JOIN posts p ON n.post_id = p.id
-        WHERE n.user_id = ?
+        LEFT JOIN posts p ON n.post_id = p.id
+        WHERE n.user_id = ? AND (n.post_id IS NULL OR p.id IS NOT NULL)
         ORDER BY n.created_at DESC`
 
 	rows, err := db.Query(query, userID)
@@ -170,6 +184,7 @@ func GetAllNotifications(db *sql.DB, userID int) ([]models.Notification, error)
 			&n.ActorName,
 			&n.IsRead,
 			&n.CreatedAt,
+			&n.Message,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan notification: %w", err)
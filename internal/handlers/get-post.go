@@ -2,14 +2,15 @@ package handlers
 
 import (
 	"database/sql"
+	"fmt"
 	errors "forum/internal"
+	"forum/internal/logging"
 	"forum/internal/models"
 	"forum/internal/utils"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
-	"text/template"
 
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 )
@@ -34,25 +35,26 @@ func ServePostByID(db *sql.DB) http.HandlerFunc {
 		}
 		// log.Printf("[DEBUG] Post found: ID=%d, Title=%s", post.ID, post.Title)
 
-		// Get comments with debug output
-		comments, err := utils.GetCommentsByPostID(db, postID)
+		// Merged posts only remain as a redirect to the thread they were merged into
+		if post.MergedInto != 0 {
+			http.Redirect(w, r, fmt.Sprintf("/post_page/%d", post.MergedInto), http.StatusMovedPermanently)
+			return
+		}
+
+		// Comments are loaded as a tree; ?thread= shows a single subtree and ?sort= orders siblings
+		commentSort := utils.NormalizeCommentSort(r.URL.Query().Get("sort"))
+		threadRootID, _ := strconv.Atoi(r.URL.Query().Get("thread"))
+
+		comments, err := utils.GetCommentTree(db, postID, threadRootID, commentSort, utils.MaxThreadDepth)
 		if err != nil {
 			log.Printf("[ERROR] Failed to fetch comments for post %d: %v", postID, err)
 			http.Error(w, "Error fetching comments", http.StatusInternalServerError)
 			return
 		}
-		// log.Printf("[DEBUG] %d comments fetched for post %d", len(comments), postID)
-
-		// Debug: Print number of comments found
-		// for i, c := range comments {
-		// 	log.Printf("[DEBUG] Comment %d: ID=%d, UserID=%d, UserName=%s, Content=%s, Likes=%d, Dislikes=%d, CreatedAt=%s",
-		// 		i+1, c.ID, c.UserID, c.UserName, c.Content, c.Likes, c.Dislikes, c.CreatedAt)
-		// }
-
-		// // Debug: Print each comment
-		// for i, comment := range comments {
-		// 	log.Printf("[DEBUG] Comment %d: ID=%d, UserName=%s, Content=%s", i+1, comment.ID, comment.UserName, comment.Content)
-		// }
+		if threadRootID != 0 && len(comments) == 0 {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Comment thread not found.")
+			return
+		}
 
 		// Try to get user but don't fail if not logged in
 		user, err := utils.GetUserFromSession(w, r, db)
@@ -61,21 +63,21 @@ func ServePostByID(db *sql.DB) http.HandlerFunc {
 			user = nil
 		}
 
-		var canModifyPost bool
-		canModifyComments := make(map[int]bool)
-
-		if user != nil {
-			canModifyPost = utils.HasPermission(user, post.UserID, "edit")
-			for _, c := range comments {
-				canModifyComments[c.ID] = utils.HasPermission(user, c.UserID, "edit")
-			}
+		// Deleted posts are only visible to moderators (from the trash)
+		if post.IsDeleted && !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Post not found.")
+			return
 		}
 
-		// Initialize variables for reactions
+		var canModifyPost, canEditWiki bool
 		var postReaction string
-		commentReactions := make(map[int]string)
+		// Locked and archived threads are read-only
+		closed := post.IsLocked || post.IsArchived
 
 		if user != nil {
+			canModifyPost = !post.IsDeleted && utils.HasPermission(user, post.UserID, "edit")
+			canEditWiki = !canModifyPost && utils.CanEditPost(user, post)
+
 			postReaction, err = getUserReaction(db, user.ID, "post", postID)
 			if err != nil {
 				log.Printf("[ERROR] Failed to get user reaction for post %d: %v", postID, err)
@@ -83,36 +85,21 @@ func ServePostByID(db *sql.DB) http.HandlerFunc {
 				return
 			}
 
-			for _, comment := range comments {
-				reaction, err := getUserReaction(db, user.ID, "comment", comment.ID)
-				if err != nil {
-					log.Printf("[ERROR] Failed to get user reaction for comment %d: %v", comment.ID, err)
-					errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error getting user reaction for comment.")
-					return
-				}
-				commentReactions[comment.ID] = reaction
-			}
-		}
-
-		// Get replies
-		repliesMap, err := utils.GetRepliesForComments(db, postID)
-		if err != nil {
-			log.Printf("[ERROR] Failed to fetch replies for post %d: %v", postID, err)
-			http.Error(w, "Error getting replies", http.StatusInternalServerError)
-			return
-		}
-		// log.Printf("[DEBUG] Replies fetched for post %d: %d", postID, len(repliesMap))
-
-		// Creating a reaction slice for a template
-		var commentsWithReactions []models.CommentWithReaction
-		for _, c := range comments {
-			reaction := commentReactions[c.ID]
-			commentsWithReactions = append(commentsWithReactions, models.CommentWithReaction{
-				Comment:      c,
-				UserReaction: reaction,
+			err = utils.WalkCommentTree(comments, func(c *models.CommentNode) error {
+				c.CanModify = !c.IsDeleted && utils.HasPermission(user, c.UserID, "edit")
+				c.CanReact = !c.IsDeleted && !closed
+				c.CanReply = !c.IsDeleted && !post.IsDeleted && !closed
+				c.CanSplit = !c.IsDeleted && !post.IsDeleted && utils.IsModerator(user)
+				reaction, err := getUserReaction(db, user.ID, "comment", c.ID)
+				c.UserReaction = reaction
+				return err
 			})
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to get user reactions for comments", "post_id", postID, "err", err)
+				errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Error getting user reaction for comment.")
+				return
+			}
 		}
-		// log.Printf("[DEBUG] commentsWithReactions slice created, length=%d", len(commentsWithReactions))
 
 		tags, err := utils.GetPostTags(db, postID)
 		if err != nil {
@@ -120,41 +107,56 @@ func ServePostByID(db *sql.DB) http.HandlerFunc {
 			tags = []string{} // Set empty slice if error
 		}
 
-		data := models.PostPageData{
-			Post:             post,
-			Comments:         commentsWithReactions,
-			CurrentUser:      user,
-			UserReaction:     postReaction,
-			CommentReactions: commentReactions,
-			Tags:             tags,
-			CanModifyPost:    canModifyPost,
-			CanModifyComment: canModifyComments,
-			Replies:          repliesMap,
+		var categoryPins []models.CategoryPin
+		var categories []models.CategoryOption
+		if utils.IsModerator(user) {
+			categoryPins, err = utils.GetCategoryPins(db, postID)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("failed to get category pins", "post_id", postID, "err", err)
+			}
+			all, err := utils.GetCategories(w, db)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("failed to get categories", "err", err)
+			}
+			current := make(map[int]bool)
+			for _, pin := range categoryPins {
+				current[pin.ID] = true
+			}
+			for _, c := range all {
+				categories = append(categories, models.CategoryOption{ID: c.ID, Name: c.Name, Selected: current[c.ID]})
+			}
 		}
 
-		// Debug: Print final data
-		log.Printf("[DEBUG] Final PostPageData prepared: PostID=%d, Comments=%d, Replies=%d", data.Post.ID, len(data.Comments), len(data.Replies))
-
-		tmpl, err := template.ParseFiles(
-			"templates/layout.html",
-			"templates/post_page.html",
-			"templates/header.html",
-			"templates/nav.html",
-			"templates/post_item.html",
-			"templates/comment.html",
-			"templates/add_comment.html",
-			"templates/notifications.html",
-		)
-		if err != nil {
-			log.Printf("[ERROR] Template parsing failed: %v", err)
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template error.")
-			return
+		data := models.PostPageData{
+			Post:          post,
+			Comments:      comments,
+			CurrentUser:   user,
+			UserReaction:  postReaction,
+			Tags:          tags,
+			CanModifyPost: canModifyPost,
+			CanEditWiki:   canEditWiki,
+			CommentSort:   commentSort,
+			ThreadRootID:  threadRootID,
+			CanModerate:   utils.IsModerator(user) && !post.IsDeleted,
+			CategoryPins:  categoryPins,
+			Categories:    categories,
 		}
-
-		err = tmpl.ExecuteTemplate(w, "layout", data)
-		if err != nil {
-			log.Printf("[ERROR] Template execution failed: %v", err)
-			http.Error(w, "Template execution error", http.StatusInternalServerError)
+		if r.URL.Query().Get("held") != "" {
+			data.Notice = utils.SpamHeldMessage
+		}
+		// Moderators see every comment awaiting review, members their own
+		if user != nil {
+			authorID := user.ID
+			if utils.IsModerator(user) {
+				authorID = 0
+			}
+			held, err := utils.GetHeldComments(r.Context(), db, postID, authorID)
+			if err != nil {
+				logging.FromContext(r.Context()).Warn("failed to get held comments", "post_id", postID, "err", err)
+			}
+			data.HeldComments = models.HeldList{Heading: "Comments awaiting review", Items: held, ShowAuthor: authorID == 0}
 		}
+
+		utils.Render(w, r, "post_page", data)
 	}
 }
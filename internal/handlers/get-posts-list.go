@@ -11,10 +11,13 @@ import (
 
 func GetPosts(db *sql.DB, currentUser *models.User) ([]models.PostView, error) {
 	rows, err := db.Query(`
-        SELECT p.id, p.user_id, p.title, p.content, p.created_at, u.username
+        SELECT p.id, p.user_id, p.title, p.content, p.created_at, u.username, COALESCE(u.trust_level, 0),
+               EXISTS(SELECT 1 FROM post_pins pp WHERE pp.post_id = p.id AND pp.category_id = 0) AS pinned,
+               p.locked_at IS NOT NULL, COALESCE(p.is_announcement, 0) AS announcement, p.archived_at IS NOT NULL
         FROM posts p
         JOIN users u ON p.user_id = u.id
-        ORDER BY p.created_at DESC
+        WHERE p.deleted_at IS NULL
+        ORDER BY announcement DESC, pinned DESC, p.created_at DESC
     `)
 	if err != nil {
 		return nil, err
@@ -26,7 +29,8 @@ func GetPosts(db *sql.DB, currentUser *models.User) ([]models.PostView, error) {
 		var post models.PostView
 		var rawCreatedAt time.Time
 
-		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &rawCreatedAt, &post.UserName); err != nil {
+		if err := rows.Scan(&post.ID, &post.UserID, &post.Title, &post.Content, &rawCreatedAt, &post.UserName, &post.UserTrustLevel,
+			&post.IsPinned, &post.IsLocked, &post.IsAnnouncement, &post.IsArchived); err != nil {
 			return nil, err
 		}
 
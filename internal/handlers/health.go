@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"context"
+	"forum/internal/utils"
+	"net/http"
+	"time"
+)
+
+// ReadinessCheck is one thing /readyz verifies; Check returns nil when ready
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// readinessTimeout bounds all checks of one probe together
+const readinessTimeout = 2 * time.Second
+
+// Healthz is the liveness probe: it answers as long as the process serves
+// HTTP at all and checks nothing else, so a slow database never gets the
+// forum restarted
+func Healthz() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Write([]byte("ok\n"))
+	}
+}
+
+// Readyz is the readiness probe: 200 when every check passes, 503 with the
+// failing checks otherwise
+func Readyz(checks ...ReadinessCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		status, code := "ok", http.StatusOK
+		results := make(map[string]string, len(checks))
+		for _, c := range checks {
+			if err := c.Check(ctx); err != nil {
+				results[c.Name] = err.Error()
+				status, code = "unavailable", http.StatusServiceUnavailable
+				continue
+			}
+			results[c.Name] = "ok"
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		utils.RespondWithJSON(w, code, map[string]any{
+			"status": status,
+			"checks": results,
+		})
+	}
+}
@@ -3,11 +3,9 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
-	"forum/internal"
 	"forum/internal/utils"
 	"net/http"
 	"strings"
-	"text/template"
 )
 
 // Function for rendering an HTML form
@@ -22,20 +20,7 @@ func ServeFormLogin(db *sql.DB) http.HandlerFunc {
 			Password: r.URL.Query().Get("password"),
 		}
 
-		tmpl, err := template.ParseFiles(
-			"templates/layout_auth.html",
-			"templates/login.html",
-			"templates/header_auth.html",
-		)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template not loaded.")
-			return
-		}
-
-		err = tmpl.ExecuteTemplate(w, "layout", data)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template execution error.")
-		}
+		utils.Render(w, r, "login", data)
 	}
 }
 
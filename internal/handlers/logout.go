@@ -2,20 +2,17 @@ package handlers
 
 import (
 	"database/sql"
+	"forum/internal/logging"
 	"forum/internal/security"
-	"log"
 	"net/http"
-	"os"
 )
 
-var isProduction = os.Getenv("APP_ENV") == "production" // automatic environment detection
-
 // Function to delete the session cookie
 func LogoutHandler(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// 1. Call DestroySession for the main exit logic
 		if err := security.DestroySession(w, r, db); err != nil {
-			log.Printf("Failed to destroy session: %v", err)
+			logging.FromContext(r.Context()).Warn("failed to destroy session", "err", err)
 			// Continue the process even if there is an error
 		}
 
@@ -27,7 +24,7 @@ func LogoutHandler(db *sql.DB) http.HandlerFunc {
 				Path:     "/",
 				MaxAge:   -1,
 				HttpOnly: true,
-				Secure:   isProduction,
+				Secure:   security.SecureCookies(),
 				SameSite: http.SameSiteStrictMode,
 			})
 		}
@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"strconv"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// HandlerMovePost moves a post to another set of categories
+func HandlerMovePost(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, postID, ok := moderatorPostForm(w, r, db)
+		if !ok {
+			return
+		}
+
+		categoryIDs, err := formInts(r, "categories[]")
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid category ID.")
+			return
+		}
+
+		err = utils.MovePost(r.Context(), db, postID, user.ID, categoryIDs)
+		if !threadActionOK(w, r, err, "move") {
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/post_page/%d", postID), http.StatusSeeOther)
+	}
+}
+
+// HandlerMergePost merges a duplicate post into another one
+func HandlerMergePost(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, postID, ok := moderatorPostForm(w, r, db)
+		if !ok {
+			return
+		}
+
+		targetID, err := strconv.Atoi(r.FormValue("target_id"))
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid target post ID.")
+			return
+		}
+
+		err = utils.MergePost(r.Context(), db, postID, targetID, user.ID)
+		if !threadActionOK(w, r, err, "merge") {
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/post_page/%d", targetID), http.StatusSeeOther)
+	}
+}
+
+// HandlerSplitComments moves selected comments out into a new post
+func HandlerSplitComments(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, postID, ok := moderatorPostForm(w, r, db)
+		if !ok {
+			return
+		}
+
+		commentIDs, err := formInts(r, "comment_ids[]")
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid comment ID.")
+			return
+		}
+
+		newPostID, err := utils.SplitComments(r.Context(), db, postID, commentIDs, user.ID, r.FormValue("title"))
+		if !threadActionOK(w, r, err, "split") {
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("/post_page/%d", newPostID), http.StatusSeeOther)
+	}
+}
+
+// AdminModerationLogPage shows recent move, merge and split actions
+func AdminModerationLogPage(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can view the moderation log.")
+			return
+		}
+
+		entries, err := utils.GetModerationLog(db, 200)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to load moderation log", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not load moderation log.")
+			return
+		}
+
+		data := models.ModerationLogPageData{Entries: entries, CurrentUser: user}
+		utils.Render(w, r, "admin_moderation_log", data)
+	}
+}
+
+// moderatorPostForm checks the method and role and reads post_id from the form
+func moderatorPostForm(w http.ResponseWriter, r *http.Request, db *sql.DB) (*models.User, int, bool) {
+	if r.Method != http.MethodPost {
+		errors.RenderError(w, http.StatusMethodNotAllowed, "Method not allowed", "Method not allowed")
+		return nil, 0, false
+	}
+
+	user, err := utils.GetUserFromSession(w, r, db)
+	if err != nil || !utils.IsModerator(user) {
+		errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can move, merge or split threads.")
+		return nil, 0, false
+	}
+
+	postID, err := strconv.Atoi(r.FormValue("post_id"))
+	if err != nil {
+		errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid post ID.")
+		return nil, 0, false
+	}
+	return user, postID, true
+}
+
+func formInts(r *http.Request, key string) ([]int, error) {
+	if err := r.ParseForm(); err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, v := range r.Form[key] {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// threadActionOK renders the error page for a failed thread action
+func threadActionOK(w http.ResponseWriter, r *http.Request, err error, action string) bool {
+	switch {
+	case err == nil:
+		return true
+	case err == sql.ErrNoRows:
+		errors.RenderError(w, http.StatusNotFound, "Not Found", "Post not found.")
+	case err == utils.ErrInvalidThreadAction:
+		errors.RenderError(w, http.StatusBadRequest, "Bad Request", "This "+action+" cannot be done with the selected posts or comments.")
+	default:
+		logging.FromContext(r.Context()).Error("thread action failed", "action", action, "err", err)
+		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not "+action+" the thread.")
+	}
+	return false
+}
@@ -5,8 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"forum/internal/logging"
 	"forum/internal/utils"
-	"log"
 	"net/http"
 )
 
@@ -19,6 +19,7 @@ func HandleGitHubLogin(w http.ResponseWriter, r *http.Request) {
 // HandleGitHubCallback handles the response from GitHub OAuth
 func HandleGitHubCallback(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context()).With("provider", "github")
 		// 1. Check state
 		if r.FormValue("state") != "state-token" {
 			http.Error(w, "Invalid OAuth state", http.StatusBadRequest)
@@ -28,7 +29,7 @@ func HandleGitHubCallback(db *sql.DB) http.HandlerFunc {
 		// 2. GitHub error handling
 		if errMsg := r.URL.Query().Get("error"); errMsg != "" {
 			errorDesc := r.URL.Query().Get("error_description")
-			log.Printf("[GitHub OAuth] Error from GitHub: %s - %s\n", errMsg, errorDesc)
+			logger.Warn("provider returned an error", "error", errMsg, "description", errorDesc)
 			handleOAuthError(w, r, "GitHub authentication failed: "+errorDesc, http.StatusUnauthorized)
 			return
 		}
@@ -36,22 +37,22 @@ func HandleGitHubCallback(db *sql.DB) http.HandlerFunc {
 		// 3. Get authorization code
 		code := r.FormValue("code")
 		if code == "" {
-			log.Println("[GitHub OAuth] Missing authorization code")
+			logger.Warn("missing authorization code")
 			handleOAuthError(w, r, "Missing authorization code", http.StatusBadRequest)
 			return
 		}
 
 		// 4. Exchange code for token
-		log.Println("[GitHub OAuth] Exchanging code for token")
+		logger.Debug("exchanging code for token")
 		token, err := utils.GitHubOAuthConfig.Exchange(context.Background(), code)
 		if err != nil {
-			log.Printf("[GitHub OAuth] Token exchange failed: %v\n", err)
+			logger.Error("token exchange failed", "err", err)
 			handleOAuthError(w, r, "Failed to authenticate with GitHub", http.StatusInternalServerError)
 			return
 		}
 
 		// 5. Get user profile
-		log.Println("[GitHub OAuth] Fetching user info")
+		logger.Debug("fetching user info")
 		client := utils.GitHubOAuthConfig.Client(context.Background(), token)
 
 		// Get main profile info
@@ -106,11 +107,11 @@ func HandleGitHubCallback(db *sql.DB) http.HandlerFunc {
 		userID := fmt.Sprintf("%d", profile.ID)
 		provider := "github"
 
-		log.Printf("[GitHub OAuth] User info received: %s (%s)\n", email, userID)
+		logger.Debug("user info received", "provider_user_id", userID)
 
 		// 6. Check if user exists
 		if IsUserExists(db, "", email, provider, userID) {
-			log.Printf("[GitHub OAuth] User already exists: %s\n", email)
+			logger.Info("existing user signing in", "provider_user_id", userID)
 			credentials := utils.LoginCredentials{
 				Email:    email,
 				Password: "",
@@ -120,16 +121,17 @@ func HandleGitHubCallback(db *sql.DB) http.HandlerFunc {
 		}
 
 		// 7. Register new user
+		if utils.IPBlocked(r, utils.BlockRegistration) {
+			handleOAuthError(w, r, "Registration from your network has been blocked", http.StatusForbidden)
+			return
+		}
 		username := generateUsername(profile.Name, email)
 		avatar := profile.AvatarURL
-		if avatar == "" {
-			avatar = "/static/images/default-avatar.png"
-		}
 
-		log.Printf("[GitHub OAuth] Registering new user: %s (%s)\n", username, email)
-		err = СreateOAuthUser(db, username, email, provider, userID, avatar)
+		logger.Info("registering new user", "username", username)
+		err = СreateOAuthUser(db, username, email, provider, userID, avatar, utils.RequestIP(r))
 		if err != nil {
-			log.Printf("[GitHub OAuth] Failed to create user: %v\n", err)
+			logger.Error("failed to create user", "err", err)
 			handleOAuthError(w, r, "Failed to create user account", http.StatusInternalServerError)
 			return
 		}
@@ -140,6 +142,6 @@ func HandleGitHubCallback(db *sql.DB) http.HandlerFunc {
 			Password: "",
 		}
 		utils.ValidateAndLoginUser(w, r, db, credentials, true)
-		log.Printf("[GitHub OAuth] User successfully registered and logged in: %s\n", email)
+		logger.Info("user registered", "username", username)
 	}
 }
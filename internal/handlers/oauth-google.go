@@ -5,8 +5,8 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"forum/internal/logging"
 	"forum/internal/utils"
-	"log"
 	"net/http"
 	"net/url"
 	"strings"
@@ -23,6 +23,7 @@ func HandleGoogleLogin(w http.ResponseWriter, r *http.Request) {
 // HandleGoogleCallback handles the response from Google OAuth
 func HandleGoogleCallback(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		logger := logging.FromContext(r.Context()).With("provider", "google")
 		// 1. Logging the start of processing
 		if r.FormValue("state") != "state-token" {
 			http.Error(w, "Invalid OAuth state", http.StatusBadRequest)
@@ -32,7 +33,7 @@ func HandleGoogleCallback(db *sql.DB) http.HandlerFunc {
 		// 2. Google error handling
 		if errMsg := r.URL.Query().Get("error"); errMsg != "" {
 			errorDesc := r.URL.Query().Get("error_description")
-			log.Printf("[Google OAuth] Error from Google: %s - %s\n", errMsg, errorDesc)
+			logger.Warn("provider returned an error", "error", errMsg, "description", errorDesc)
 			handleOAuthError(w, r, "Google authentication failed: "+errorDesc, http.StatusUnauthorized)
 			return
 		}
@@ -40,34 +41,34 @@ func HandleGoogleCallback(db *sql.DB) http.HandlerFunc {
 		// 3. Obtaining the authorization code
 		code := r.URL.Query().Get("code")
 		if code == "" {
-			log.Println("[Google OAuth] Missing authorization code")
+			logger.Warn("missing authorization code")
 			handleOAuthError(w, r, "Missing authorization code", http.StatusBadRequest)
 			return
 		}
 
 		// 4. Exchange code for token
-		log.Println("[Google OAuth] Exchanging code for token")
+		logger.Debug("exchanging code for token")
 		token, err := utils.GoogleOAuthConfig.Exchange(context.Background(), code)
 		if err != nil {
-			log.Printf("[Google OAuth] Token exchange failed: %v\n", err)
+			logger.Error("token exchange failed", "err", err)
 			handleOAuthError(w, r, "Failed to authenticate with Google", http.StatusInternalServerError)
 			return
 		}
 
 		// 5. Getting user information
-		log.Println("[Google OAuth] Fetching user info")
+		logger.Debug("fetching user info")
 		userInfo, err := getGoogleUserInfo(token.AccessToken)
 		if err != nil {
-			log.Printf("[Google OAuth] Failed to get user info: %v\n", err)
+			logger.Error("failed to get user info", "err", err)
 			handleOAuthError(w, r, "Failed to get user information", http.StatusInternalServerError)
 			return
 		}
 
-		log.Printf("[Google OAuth] User info received: %s (%s)\n", userInfo.Email, userInfo.Sub)
+		logger.Debug("user info received", "provider_user_id", userInfo.Sub)
 
 		// 6. Checking for user existence
 		if IsUserExists(db, "", userInfo.Email, "google", userInfo.Sub) {
-			log.Printf("[Google OAuth] User already exists: %s\n", userInfo.Email)
+			logger.Info("existing user signing in", "provider_user_id", userInfo.Sub)
 
 			// Authorize an existing user
 			credentials := utils.LoginCredentials{
@@ -79,16 +80,17 @@ func HandleGoogleCallback(db *sql.DB) http.HandlerFunc {
 		}
 
 		// 7. Registering a new user
+		if utils.IPBlocked(r, utils.BlockRegistration) {
+			handleOAuthError(w, r, "Registration from your network has been blocked", http.StatusForbidden)
+			return
+		}
 		username := generateUsername(userInfo.Name, userInfo.Email)
 		avatarURL := userInfo.Picture
-		if avatarURL == "" {
-			avatarURL = "/static/images/default-avatar.png"
-		}
 
-		log.Printf("[Google OAuth] Registering new user: %s (%s)\n", username, userInfo.Email)
-		err = СreateOAuthUser(db, username, userInfo.Email, "google", userInfo.Sub, avatarURL)
+		logger.Info("registering new user", "username", username)
+		err = СreateOAuthUser(db, username, userInfo.Email, "google", userInfo.Sub, avatarURL, utils.RequestIP(r))
 		if err != nil {
-			log.Printf("[Google OAuth] Failed to create user: %v\n", err)
+			logger.Error("failed to create user", "err", err)
 			handleOAuthError(w, r, "Failed to create user account", http.StatusInternalServerError)
 			return
 		}
@@ -99,7 +101,7 @@ func HandleGoogleCallback(db *sql.DB) http.HandlerFunc {
 			Password: "",
 		}
 		utils.ValidateAndLoginUser(w, r, db, credentials, true)
-		log.Printf("[Google OAuth] User successfully registered and logged in: %s\n", userInfo.Email)
+		logger.Info("user registered", "username", username)
 	}
 }
 
@@ -136,7 +138,7 @@ func getGoogleUserInfo(accessToken string) (*GoogleUserInfo, error) {
 
 // handleOAuthError handles OAuth errors
 func handleOAuthError(w http.ResponseWriter, r *http.Request, message string, statusCode int) {
-	log.Printf("[OAuth Error] %s\n", message)
+	logging.FromContext(r.Context()).Warn("OAuth sign-in failed", "reason", message)
 
 	if strings.Contains(strings.ToLower(r.Header.Get("Accept")), "application/json") {
 		w.Header().Set("Content-Type", "application/json")
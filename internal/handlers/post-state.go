@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/utils"
+	"net/http"
+	"strconv"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// HandlerPostState lets moderators pin, lock, announce and archive posts
+func HandlerPostState(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method not allowed", "Method not allowed")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can change thread state.")
+			return
+		}
+
+		postID, err := strconv.Atoi(r.FormValue("post_id"))
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid post ID.")
+			return
+		}
+
+		ctx := r.Context()
+		switch action := r.FormValue("action"); action {
+		case "pin", "unpin":
+			// category_id selects a per-category pin; without it the post is pinned globally
+			categoryID, _ := strconv.Atoi(r.FormValue("category_id"))
+			err = utils.SetPostPinned(ctx, db, postID, categoryID, user.ID, action == "pin")
+		case "lock", "unlock":
+			err = utils.SetPostLocked(ctx, db, postID, user.ID, action == "lock")
+		case "announce", "unannounce":
+			err = utils.SetPostAnnouncement(ctx, db, postID, action == "announce")
+		case "archive", "unarchive":
+			err = utils.SetPostArchived(ctx, db, postID, action == "archive")
+		default:
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Unknown action.")
+			return
+		}
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", "Post not found.")
+				return
+			}
+			if err == utils.ErrPostNotInCategory {
+				errors.RenderError(w, http.StatusBadRequest, "Bad Request", "The post is not in that category.")
+				return
+			}
+			logging.FromContext(r.Context()).Error("post state change failed", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not update the post.")
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/post_page/%d", postID), http.StatusSeeOther)
+	}
+}
@@ -4,12 +4,12 @@ import (
 	"database/sql"
 
 	"forum/internal"
+	"forum/internal/logging"
 	"forum/internal/models"
 	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"log"
 	"net/http"
-	"text/template"
 
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 )
@@ -18,9 +18,8 @@ func HandlerProfile(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		// Get the current user
 		user, err := utils.GetUserFromSession(w, r, db)
-		log.Printf("user: %+v", user)
 		if err != nil {
-			log.Printf("Error getting user from session: %v", err)
+			logging.FromContext(r.Context()).Error("failed to load user from session", "err", err)
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to get user data")
 			return
 		}
@@ -44,30 +43,28 @@ func HandlerProfile(db *sql.DB) http.HandlerFunc {
 			log.Printf("Error receiving notifications: %v", err)
 		}
 
+		// Recalculated here so the member sees where they stand right now
+		reputation, err := utils.RecalculateReputation(r.Context(), db, user.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to calculate reputation", "err", err)
+		}
+		user.TrustLevel = reputation.Level
+
+		badges, err := utils.GetUserBadges(r.Context(), db, user.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to get badges", "err", err)
+		}
+
 		data := models.ProfilePageData{
 			User:             *user,
 			CurrentUser:      user,
 			Notifications:    notifications,
 			PostsWithComment: posts,
+			Reputation:       reputation,
+			Capabilities:     utils.Capabilities(user),
+			Badges:           badges,
 		}
 
-		tmpl, err := template.ParseFiles(
-			"templates/layout.html",
-			"templates/header.html",
-			"templates/nav.html",
-			"templates/profile.html",
-			"templates/notifications.html",
-			"templates/user_comments.html",
-			"templates/notification_list.html",
-		)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template loading error")
-			return
-		}
-
-		err = tmpl.ExecuteTemplate(w, "layout", data)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Render error: "+err.Error())
-		}
+		utils.Render(w, r, "profile", data)
 	}
 }
@@ -5,7 +5,6 @@ import (
 	"forum/internal"
 	"forum/internal/models"
 	"forum/internal/utils"
-	"html/template"
 	"log"
 	"net/http"
 	"strings"
@@ -37,27 +36,6 @@ func HandlerUserActivitySearch(db *sql.DB) http.HandlerFunc {
 		// ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
 		// defer cancel()
 
-		// Parse templates
-		tmpl := template.New("base").Funcs(template.FuncMap{
-			"formatDate": utils.FormatDate,
-		})
-
-		tmpl, err = tmpl.ParseFiles(
-			"templates/layout.html",
-			"templates/header.html",
-			"templates/nav.html",
-			"templates/profile.html",
-			"templates/notifications.html",
-			"templates/user_comments.html",
-			"templates/notification_list.html",
-			"templates/profile_activity_search.html",
-		)
-		if err != nil {
-			log.Printf("Template parsing error: %v", err)
-			errors.RenderError(w, http.StatusInternalServerError, "Template Error", "Could not load page templates.")
-			return
-		}
-
 		// Get current user from session
 		currentUser, _ := utils.GetUserFromSession(w, r, db) // Ignore error if not logged in
 
@@ -73,10 +51,7 @@ func HandlerUserActivitySearch(db *sql.DB) http.HandlerFunc {
 				PostsWithComment: []models.PostView{},
 			}
 
-			if err := tmpl.ExecuteTemplate(w, "layout", data); err != nil {
-				log.Printf("Template execution error: %v", err)
-				errors.RenderError(w, http.StatusInternalServerError, "Render Error", "Could not display search page.")
-			}
+			utils.Render(w, r, "profile_activity_search", data)
 			return
 		}
 
@@ -117,9 +92,6 @@ func HandlerUserActivitySearch(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Execute template
-		if err := tmpl.ExecuteTemplate(w, "layout", data); err != nil {
-			log.Printf("Template execution failed: %v", err)
-			errors.RenderError(w, http.StatusInternalServerError, "Render Error", "Could not display results.")
-		}
+		utils.Render(w, r, "profile_activity_search", data)
 	}
 }
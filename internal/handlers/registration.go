@@ -3,27 +3,18 @@ package handlers
 import (
 	"database/sql"
 	"encoding/json"
+	"forum/internal/logging"
 	"forum/internal/models"
 	"forum/internal/security"
+	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
-	"log"
 	"net/http"
 	"strings"
-	"text/template"
 )
 
 // ServeFormRegister renders the registration form template with empty fields
 func ServeFormRegister(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tmpl, err := template.ParseFiles(
-			"templates/layout_auth.html",
-			"templates/registration.html",
-			"templates/header_auth.html",
-		)
-		if err != nil {
-			http.Error(w, "Internal Server Error: Template not loaded", http.StatusInternalServerError)
-			return
-		}
 
 		emptyForm := models.RegisterPageData{
 			Username: "",
@@ -32,10 +23,7 @@ func ServeFormRegister(db *sql.DB) http.HandlerFunc {
 			Error:    "",
 		}
 
-		err = tmpl.ExecuteTemplate(w, "layout", emptyForm)
-		if err != nil {
-			http.Error(w, "Internal Server Error: Template execution error", http.StatusInternalServerError)
-		}
+		utils.Render(w, r, "registration", emptyForm)
 	}
 }
 
@@ -99,7 +87,7 @@ func HandlerRegistration(db *sql.DB) http.HandlerFunc {
 		// Hash password
 		hashedPassword, err := security.HashPassword(password)
 		if err != nil {
-			log.Println("Password hashing error:", err)
+			logging.FromContext(r.Context()).Error("failed to hash password", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": "Internal server error",
@@ -123,8 +111,8 @@ func HandlerRegistration(db *sql.DB) http.HandlerFunc {
 		}
 
 		// Create user
-		if err := СreateRegularUser(db, username, email, hashedPassword); err != nil {
-			log.Println("Database error:", err)
+		if err := СreateRegularUser(db, username, email, hashedPassword, utils.RequestIP(r)); err != nil {
+			logging.FromContext(r.Context()).Error("failed to create user", "err", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			json.NewEncoder(w).Encode(map[string]string{
 				"error": "Failed to register user",
@@ -8,7 +8,6 @@ import (
 	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"net/http"
-	"text/template"
 
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 )
@@ -42,15 +41,14 @@ func HandleRequestModerator(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		profileData := models.ProfilePageData{CurrentUser: user}
+		profileData := models.ProfilePageData{User: *user, CurrentUser: user}
 		if err != nil {
 			profileData.RequestError = err.Error()
 		} else {
 			profileData.RequestSent = true
 		}
 
-		tmpl := template.Must(template.ParseFiles("templates/profile.html"))
-		tmpl.ExecuteTemplate(w, "profile", profileData)
+		utils.Render(w, r, "profile", profileData)
 	}
 }
 
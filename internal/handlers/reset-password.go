@@ -3,10 +3,10 @@ package handlers
 import (
 	"database/sql"
 	"forum/internal"
+	"forum/internal/utils"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"golang.org/x/crypto/bcrypt"
 	"net/http"
-	"text/template"
 )
 
 func ResetPasswordHandler(db *sql.DB) http.HandlerFunc {
@@ -26,21 +26,7 @@ func ResetPasswordHandler(db *sql.DB) http.HandlerFunc {
 			Token: token,
 		}
 
-		tmpl, err := template.ParseFiles(
-			"templates/layout_auth.html",
-			"templates/reset_password.html",
-			"templates/header_auth.html",
-		)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template not loaded.")
-			return
-		}
-
-		err = tmpl.ExecuteTemplate(w, "layout", data)
-		if err != nil {
-			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template execution error.")
-		}
-		tmpl.Execute(w, struct{ Token string }{Token: token})
+		utils.Render(w, r, "reset_password", data)
 	}
 }
 
@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/metrics"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AdminReviewQueuePage lists the posts and comments held for review
+func AdminReviewQueuePage(db *sql.DB, minTraining int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can review held content.")
+			return
+		}
+
+		items, err := utils.GetHeldContent(r.Context(), db)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to load review queue", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not load the review queue.")
+			return
+		}
+		training, err := utils.GetSpamTraining(r.Context(), db)
+		if err != nil {
+			logging.FromContext(r.Context()).Warn("failed to load spam training", "err", err)
+		}
+
+		data := models.ReviewQueuePageData{
+			Items:       items,
+			CurrentUser: user,
+			Training:    training,
+			MinTraining: minTraining,
+		}
+
+		utils.Render(w, r, "admin_review", data)
+	}
+}
+
+// ReviewHeldContentHandler decides on held content: "ham" publishes it,
+// "spam" rejects it as spam and "reject" turns it down for another reason.
+// The classifier learns from spam decisions, the author is notified with the
+// moderator's reason and, once trustAfter of their submissions were approved,
+// is trusted and no longer pre-moderated.
+func ReviewHeldContentHandler(db *sql.DB, trustAfter int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method not allowed", "Method not allowed")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can review held content.")
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid ID.")
+			return
+		}
+		decision := r.FormValue("decision")
+		status := utils.HeldApproved
+		switch decision {
+		case "ham":
+		case "spam", "reject":
+			status = utils.HeldRejected
+		default:
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Decide ham, spam or reject.")
+			return
+		}
+		reason := strings.TrimSpace(r.FormValue("reason"))
+		if len(reason) > 500 {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "The reason is limited to 500 characters.")
+			return
+		}
+
+		item, err := utils.GetHeldItem(r.Context(), db, id)
+		if err == nil {
+			err = utils.ClaimHeldItem(r.Context(), db, id, status, user.ID, reason)
+		}
+		if err != nil {
+			if err == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", "Item is not waiting for review.")
+				return
+			}
+			logging.FromContext(r.Context()).Error("review failed", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Review failed.")
+			return
+		}
+
+		var postID, commentID int
+		if status == utils.HeldApproved {
+			if postID, commentID, err = publishHeld(r, db, item); err != nil {
+				logging.FromContext(r.Context()).Error("failed to publish held content", "kind", item.Kind, "held_id", item.ID, "err", err)
+				if err := utils.ReleaseHeldItem(r.Context(), db, item.ID); err != nil {
+					logging.FromContext(r.Context()).Error("failed to return held content to the queue", "kind", item.Kind, "held_id", item.ID, "err", err)
+				}
+				errors.RenderError(w, http.StatusConflict, "Conflict", fmt.Sprintf("The %s could not be published: %v", item.Kind, err))
+				return
+			}
+		}
+
+		if decision != "reject" {
+			if err := utils.TrainSpam(r.Context(), db, utils.Submission{Title: item.Title, Content: item.Content}.Text(), decision == "spam"); err != nil {
+				logging.FromContext(r.Context()).Warn("failed to train spam classifier", "err", err)
+			}
+		}
+		metrics.SpamReviews.With(decision).Inc()
+
+		if err := utils.NotifyHeldDecision(r.Context(), db, item.UserID, user.ID, status == utils.HeldApproved, postID, commentID, reason); err != nil {
+			logging.FromContext(r.Context()).Warn("failed to notify author of review", "author_id", item.UserID, "kind", item.Kind, "held_id", item.ID, "err", err)
+		}
+		if status == utils.HeldApproved {
+			if promoted, err := utils.PromoteIfTrusted(r.Context(), db, item.UserID, trustAfter); err != nil {
+				logging.FromContext(r.Context()).Error("failed to promote author", "author_id", item.UserID, "err", err)
+			} else if promoted {
+				logging.FromContext(r.Context()).Info("author is now trusted", "author_id", item.UserID)
+			}
+		}
+		// Approved and rejected submissions count towards reputation
+		utils.RefreshReputation(r.Context(), db, item.UserID)
+
+		http.Redirect(w, r, "/admin/review", http.StatusSeeOther)
+	}
+}
+
+// publishHeld creates approved held content as its author submitted it and
+// returns the post it is, or is in, and the comment it is
+func publishHeld(r *http.Request, db *sql.DB, item models.HeldContent) (postID, commentID int, err error) {
+	switch item.Kind {
+	case "post":
+		// The post, its wiki flag and the link from the held item are created
+		// together, so a failure leaves nothing behind to publish twice
+		p := item.Payload
+		id, err := createPost(db, item.UserID, item.Title, item.Content, time.Now(), p.CategoryIDs, p.Tags, p.Images, p.PrimaryImage, p.Attachments,
+			func(tx *sql.Tx, postID int64) error {
+				if p.Wiki {
+					if err := utils.SetPostWiki(r.Context(), tx, int(postID), true); err != nil {
+						return err
+					}
+				}
+				return utils.SetHeldPostID(r.Context(), tx, item.ID, int(postID))
+			})
+		return int(id), 0, err
+	case "comment":
+		// The thread may have been removed, locked or archived while the comment waited
+		if err := utils.CheckPostOpen(db, item.PostID); err == sql.ErrNoRows {
+			return 0, 0, fmt.Errorf("the post no longer exists")
+		} else if err != nil {
+			return 0, 0, fmt.Errorf("%s", utils.PostClosedMessage(err))
+		}
+		// Replies to comments deleted while they waited are not published, like new ones
+		if item.ParentCommentID != 0 {
+			var parentExists bool
+			err := db.QueryRowContext(r.Context(), `
+				SELECT EXISTS(SELECT 1 FROM comments WHERE id = ? AND post_id = ? AND deleted_at IS NULL)`,
+				item.ParentCommentID, item.PostID).Scan(&parentExists)
+			if err != nil {
+				return 0, 0, err
+			}
+			if !parentExists {
+				return 0, 0, fmt.Errorf("the comment it replies to no longer exists")
+			}
+		}
+		id, err := utils.AddComment(db, item.PostID, item.UserID, item.ParentCommentID, item.Content)
+		return item.PostID, id, err
+	}
+	return 0, 0, fmt.Errorf("unknown kind %q", item.Kind)
+}
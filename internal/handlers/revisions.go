@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"strconv"
+	"strings"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// HandlerPostHistory shows every revision of a post with diffs between them
+func HandlerPostHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		postID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/post_history/"))
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid post ID.")
+			return
+		}
+
+		post, err := utils.GetPostByID(db, postID)
+		if err != nil {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Post not found.")
+			return
+		}
+
+		// Deleted posts are only visible to moderators, and so is their history
+		user, _ := utils.GetUserFromSession(w, r, db)
+		if post.IsDeleted && !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Post not found.")
+			return
+		}
+
+		revisions, authorID, err := utils.GetPostRevisions(db, postID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to load post revisions", "post_id", postID, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not load post history.")
+			return
+		}
+
+		mode := historyMode(r)
+
+		renderRevisionHistory(w, r, models.RevisionHistoryPageData{
+			Kind:        "post",
+			TargetID:    postID,
+			PostID:      postID,
+			Title:       post.Title,
+			AuthorID:    authorID,
+			Mode:        mode,
+			Revisions:   utils.BuildRevisionViews(revisions, mode),
+			CurrentUser: user,
+			CanRollback: utils.IsModerator(user),
+		})
+	}
+}
+
+// HandlerCommentHistory shows every revision of a comment with diffs between them
+func HandlerCommentHistory(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		commentID, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/comment_history/"))
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid comment ID.")
+			return
+		}
+
+		revisions, authorID, postID, err := utils.GetCommentRevisions(db, commentID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", "Comment not found.")
+				return
+			}
+			logging.FromContext(r.Context()).Error("failed to load comment revisions", "comment_id", commentID, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not load comment history.")
+			return
+		}
+
+		// Deleted comments, and comments of deleted posts, are only visible
+		// to moderators
+		user, _ := utils.GetUserFromSession(w, r, db)
+		var deleted bool
+		err = db.QueryRow(`
+			SELECT c.deleted_at IS NOT NULL OR p.deleted_at IS NOT NULL
+			FROM comments c JOIN posts p ON p.id = c.post_id
+			WHERE c.id = ?`, commentID).Scan(&deleted)
+		if err != nil && err != sql.ErrNoRows {
+			logging.FromContext(r.Context()).Error("failed to check comment", "comment_id", commentID, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not load comment history.")
+			return
+		}
+		if err == sql.ErrNoRows || (deleted && !utils.IsModerator(user)) {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", "Comment not found.")
+			return
+		}
+
+		mode := historyMode(r)
+
+		renderRevisionHistory(w, r, models.RevisionHistoryPageData{
+			Kind:        "comment",
+			TargetID:    commentID,
+			PostID:      postID,
+			Title:       fmt.Sprintf("Comment #%d", commentID),
+			AuthorID:    authorID,
+			Mode:        mode,
+			Revisions:   utils.BuildRevisionViews(revisions, mode),
+			CurrentUser: user,
+			CanRollback: utils.IsModerator(user),
+		})
+	}
+}
+
+// HandlerRollbackPost restores a post to one of its previous revisions (moderators only)
+func HandlerRollbackPost(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "The HTTP method is not supported.")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can roll back posts.")
+			return
+		}
+
+		postID, err1 := strconv.Atoi(r.FormValue("target_id"))
+		revisionID, err2 := strconv.Atoi(r.FormValue("revision_id"))
+		if err1 != nil || err2 != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid post or revision ID.")
+			return
+		}
+
+		// Rollbacks rewrite a closed thread too, so it has to be reopened first
+		if err := utils.CheckPostOpen(db, postID); err != nil {
+			renderPostClosed(w, err)
+			return
+		}
+
+		if err := utils.RollbackPostToRevision(r.Context(), db, postID, revisionID, user.ID); err != nil {
+			logging.FromContext(r.Context()).Error("post rollback failed", "post_id", postID, "revision_id", revisionID, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not roll back post.")
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/post_history/%d", postID), http.StatusSeeOther)
+	}
+}
+
+// HandlerRollbackComment restores a comment to one of its previous revisions (moderators only)
+func HandlerRollbackComment(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "The HTTP method is not supported.")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can roll back comments.")
+			return
+		}
+
+		commentID, err1 := strconv.Atoi(r.FormValue("target_id"))
+		revisionID, err2 := strconv.Atoi(r.FormValue("revision_id"))
+		if err1 != nil || err2 != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid comment or revision ID.")
+			return
+		}
+
+		// Rollbacks rewrite a closed thread too, so it has to be reopened first
+		if err := utils.CheckCommentOpen(db, commentID); err != nil {
+			renderPostClosed(w, err)
+			return
+		}
+
+		if err := utils.RollbackCommentToRevision(r.Context(), db, commentID, revisionID, user.ID); err != nil {
+			logging.FromContext(r.Context()).Error("comment rollback failed", "comment_id", commentID, "revision_id", revisionID, "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not roll back comment.")
+			return
+		}
+
+		http.Redirect(w, r, fmt.Sprintf("/comment_history/%d", commentID), http.StatusSeeOther)
+	}
+}
+
+// renderPostClosed renders an error from CheckPostOpen
+func renderPostClosed(w http.ResponseWriter, err error) {
+	if err == sql.ErrNoRows {
+		errors.RenderError(w, http.StatusNotFound, "Not Found", utils.PostClosedMessage(err))
+		return
+	}
+	errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.PostClosedMessage(err))
+}
+
+func historyMode(r *http.Request) string {
+	if r.URL.Query().Get("mode") == "words" {
+		return "words"
+	}
+	return "lines"
+}
+
+func renderRevisionHistory(w http.ResponseWriter, r *http.Request, data models.RevisionHistoryPageData) {
+
+	utils.Render(w, r, "revision_history", data)
+}
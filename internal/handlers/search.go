@@ -5,7 +5,6 @@ import (
 	"forum/internal"
 	"forum/internal/models"
 	"forum/internal/utils"
-	"html/template"
 	"net/http"
 	"strings"
 )
@@ -14,20 +13,11 @@ func HandlerSearch(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		query := strings.TrimSpace(r.URL.Query().Get("query"))
 
-		tmpl := template.Must(template.ParseFiles(
-			"templates/layout.html",
-			"templates/nav.html",
-			"templates/header.html",
-			"templates/search_results.html",
-			"templates/post_list_item.html",
-			"templates/notifications.html",
-		))
-
 		// 🧑 Get current user (if available)
 		user, _ := utils.GetUserFromSession(w, r, db) // ignore error for optional login
 
 		if query == "" {
-			tmpl.ExecuteTemplate(w, "layout", models.SearchPageData{
+			utils.Render(w, r, "search_results", models.SearchPageData{
 				Query:       "",
 				Results:     nil,
 				Message:     "Please enter a search query.",
@@ -47,7 +37,7 @@ func HandlerSearch(db *sql.DB) http.HandlerFunc {
 			message = "No results found for \"" + query + "\"."
 		}
 
-		tmpl.ExecuteTemplate(w, "layout", models.SearchPageData{
+		utils.Render(w, r, "search_results", models.SearchPageData{
 			Query:       query,
 			Results:     posts,
 			Message:     message,
@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"database/sql"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"strconv"
+	"time"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// AdminTrashPage lists soft-deleted posts and comments for moderators along
+// with when the retention period purges them
+func AdminTrashPage(db *sql.DB, retention time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can view the trash.")
+			return
+		}
+
+		items, err := utils.GetTrash(db, retention)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to load trash", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not load trash.")
+			return
+		}
+
+		data := models.TrashPageData{
+			Items:         items,
+			CurrentUser:   user,
+			RetentionDays: int(retention / (24 * time.Hour)),
+		}
+
+		utils.Render(w, r, "admin_trash", data)
+	}
+}
+
+// RestoreFromTrashHandler restores a soft-deleted post or comment
+func RestoreFromTrashHandler(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method not allowed", "Method not allowed")
+			return
+		}
+
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsModerator(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only moderators can restore content.")
+			return
+		}
+
+		id, err := strconv.Atoi(r.FormValue("id"))
+		if err != nil {
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid ID.")
+			return
+		}
+
+		switch r.FormValue("kind") {
+		case "post":
+			err = utils.RestorePost(r.Context(), db, id)
+		case "comment":
+			err = utils.RestoreComment(r.Context(), db, id)
+		default:
+			errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid content type.")
+			return
+		}
+
+		if err != nil {
+			if err == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", "Item is not in the trash.")
+				return
+			}
+			logging.FromContext(r.Context()).Error("restore failed", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Restore failed.")
+			return
+		}
+
+		http.Redirect(w, r, "/admin/trash", http.StatusSeeOther)
+	}
+}
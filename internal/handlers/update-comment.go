@@ -19,6 +19,7 @@ func UpdateCommentHandler(db *sql.DB) http.HandlerFunc {
 
 		commentIDStr := r.FormValue("commentId")
 		newContent := r.FormValue("commentContent")
+		reason := r.FormValue("editReason")
 
 		commentID, err := strconv.Atoi(commentIDStr)
 		if err != nil || newContent == "" {
@@ -33,7 +34,8 @@ func UpdateCommentHandler(db *sql.DB) http.HandlerFunc {
 		}
 
 		var authorID, postID int
-		err = db.QueryRow("SELECT user_id, post_id FROM comments WHERE id = ?", commentID).Scan(&authorID, &postID)
+		var oldContent string
+		err = db.QueryRow("SELECT user_id, post_id, content FROM comments WHERE id = ? AND deleted_at IS NULL", commentID).Scan(&authorID, &postID, &oldContent)
 		if err != nil {
 			errors.RenderError(w, http.StatusNotFound, "Not Found", "Comment not found.")
 			return
@@ -45,7 +47,24 @@ func UpdateCommentHandler(db *sql.DB) http.HandlerFunc {
 			return
 		}
 
-		_, err = db.Exec("UPDATE comments SET content = ? WHERE id = ?", newContent, commentID)
+		// Locked and archived threads are read-only for members
+		if err := utils.CheckPostWritable(db, user, postID); err != nil {
+			if err == sql.ErrNoRows {
+				errors.RenderError(w, http.StatusNotFound, "Not Found", utils.PostClosedMessage(err))
+				return
+			}
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.PostClosedMessage(err))
+			return
+		}
+
+		// Links already in the comment may stay; new ones need the trust level
+		if utils.CountLinks(newContent) > utils.CountLinks(oldContent) && !utils.CanUse(user, utils.CapLinks) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.CapabilityMessage(utils.CapLinks))
+			return
+		}
+
+		// Update the comment and keep the previous text in its revision history
+		err = utils.UpdateCommentContent(r.Context(), db, commentID, user.ID, newContent, reason)
 		if err != nil {
 			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not update comment.")
 			return
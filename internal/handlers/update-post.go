@@ -3,24 +3,26 @@ package handlers
 import (
 	"database/sql"
 	"forum/internal"
+	"forum/internal/logging"
 	"forum/internal/models"
 	"forum/internal/utils"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
-	"text/template"
 
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 )
 
-func EditPostHandler(db *sql.DB) http.HandlerFunc {
+// EditPostHandler shows and saves the edit form of a post; new attachments
+// must be one of attachmentTypes
+func EditPostHandler(db *sql.DB, attachmentTypes []models.AttachmentType) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		case http.MethodGet:
-			handleEditGet(w, r, db)
+			handleEditGet(w, r, db, attachmentTypes)
 		case http.MethodPost:
-			handleEditPost(w, r, db)
+			handleEditPost(w, r, db, attachmentTypes)
 		default:
 			errors.RenderError(w, http.StatusMethodNotAllowed, "Method Not Allowed", "The HTTP method is not supported.")
 		}
@@ -36,18 +38,7 @@ func contains(slice []int, val int) bool {
 	return false
 }
 
-func containsString(slice []string, val string) bool {
-	for _, item := range slice {
-		if item == val {
-			return true
-		}
-	}
-	return false
-}
-
-func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
-	log.Println("DEBUG: Starting handleEditGet")
-
+func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB, attachmentTypes []models.AttachmentType) {
 	// Parse post ID from URL
 	parts := strings.Split(r.URL.Path, "/")
 	if len(parts) < 3 || parts[2] == "" {
@@ -62,7 +53,6 @@ func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid post ID.")
 		return
 	}
-	log.Printf("DEBUG: Parsed post ID: %d", postID)
 
 	// Get categories
 	categories, err := utils.GetCategories(w, db)
@@ -71,7 +61,6 @@ func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Internal server error.")
 		return
 	}
-	log.Println("DEBUG: Fetched categories")
 
 	// Check user session
 	currentUser, err := utils.GetUserFromSession(w, r, db)
@@ -80,7 +69,6 @@ func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Session check error.")
 		return
 	}
-	log.Printf("DEBUG: Current user from session: %s", currentUser.Username)
 
 	// Get the post
 	post, err := utils.GetPostByID(db, postID)
@@ -94,7 +82,21 @@ func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		}
 		return
 	}
-	log.Printf("DEBUG: Fetched post: %+v", post)
+
+	if !utils.CanEditPost(currentUser, post) {
+		errors.RenderError(w, http.StatusForbidden, "Forbidden", "You don't have permission to edit this post.")
+		return
+	}
+
+	// Locked and archived threads are read-only for members
+	if err := utils.CheckPostWritable(db, currentUser, postID); err != nil {
+		if err == sql.ErrNoRows {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", utils.PostClosedMessage(err))
+			return
+		}
+		errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.PostClosedMessage(err))
+		return
+	}
 
 	// Get the post's current categories
 	postCategories, err := utils.GetPostCategories(db, postID)
@@ -103,14 +105,12 @@ func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Something went wrong categories.")
 		return
 	}
-	log.Printf("DEBUG: Fetched post categories: %+v", postCategories)
 
 	// Create selected categories map using simple map[int]bool
 	selectedCategories := make(map[int]bool)
 	for _, categoryID := range postCategories {
 		selectedCategories[categoryID] = true
 	}
-	log.Printf("DEBUG: Selected categories map: %+v", selectedCategories)
 
 	// Get the post's current tags
 	postTags, err := utils.GetPostTags(db, postID)
@@ -119,33 +119,10 @@ func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Something went wrong tags.")
 		return
 	}
-	log.Printf("DEBUG: Fetched post tags: %+v", postTags)
 
 	// Convert tags slice to comma-separated string for the form
 	tagsString := strings.Join(postTags, ", ")
 
-	// Parsing templates
-	tmpl := template.New("layout.html").Funcs(template.FuncMap{
-		"containsString": containsString,
-		"join":           strings.Join,
-	})
-
-	tmpl, err = tmpl.ParseFiles(
-		"templates/layout.html",
-		"templates/edit_post.html",
-		"templates/header.html",
-		"templates/nav.html",
-		"templates/images-post.html",
-		"templates/notifications.html",
-	)
-
-	if err != nil {
-		log.Printf("ERROR: Template parsing error: %v", err)
-		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Something went wrong Template.")
-		return
-	}
-	log.Println("DEBUG: Templates parsed successfully")
-
 	// Form data for the template
 	data := models.UpdatePostPageData{
 		Post:               post,
@@ -154,18 +131,15 @@ func handleEditGet(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		CurrentUser:        currentUser,
 		Tags:               tagsString,
 		CSRFToken:          "example-token",
+		AttachmentTypes:    attachmentTypes,
+		CanSetWiki:         utils.HasPermission(currentUser, post.UserID, "edit"),
 	}
 
 	// Execute the template
-	if err := tmpl.ExecuteTemplate(w, "layout", data); err != nil {
-		log.Printf("ERROR: Template execution error: %v", err)
-		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Something went wrong Template.")
-	} else {
-		log.Println("DEBUG: Template executed successfully")
-	}
+	utils.Render(w, r, "edit_post", data)
 }
 
-func handleEditPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
+func handleEditPost(w http.ResponseWriter, r *http.Request, db *sql.DB, attachmentTypes []models.AttachmentType) {
 	if err := r.ParseMultipartForm(10 << 20); err != nil {
 		errors.RenderError(w, http.StatusBadRequest, "Bad Request", "Invalid form data.")
 		return
@@ -175,6 +149,7 @@ func handleEditPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 	content := r.FormValue("content")
 	idStr := r.FormValue("id")
 	tags := r.FormValue("tags")
+	reason := r.FormValue("edit_reason")
 
 	postID, err := strconv.Atoi(idStr)
 	if err != nil {
@@ -200,12 +175,42 @@ func handleEditPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
-	// Check permissions (admin, moderator, or post author)
-	if !utils.HasPermission(currentUser, post.UserID, "edit") {
+	if post.IsDeleted {
+		errors.RenderError(w, http.StatusNotFound, "Not Found", "Post not found.")
+		return
+	}
+
+	// Check permissions (admin, moderator, post author, or a trusted member for wiki posts)
+	if !utils.CanEditPost(currentUser, post) {
 		errors.RenderError(w, http.StatusForbidden, "Forbidden", "You don't have permission to edit this post.")
 		return
 	}
 
+	// Locked and archived threads are read-only for members
+	if err := utils.CheckPostWritable(db, currentUser, postID); err != nil {
+		if err == sql.ErrNoRows {
+			errors.RenderError(w, http.StatusNotFound, "Not Found", utils.PostClosedMessage(err))
+			return
+		}
+		errors.RenderError(w, http.StatusForbidden, "Forbidden", utils.PostClosedMessage(err))
+		return
+	}
+
+	// Links already in the post may stay; new links, images and tags need
+	// the trust level that unlocks them
+	linkText := title + "\n" + content
+	if utils.CountLinks(linkText) <= utils.CountLinks(post.Title+"\n"+post.Content) {
+		linkText = ""
+	}
+	if msg, err := utils.CheckPostingCapabilities(r.Context(), db, currentUser.ID, linkText, len(r.MultipartForm.File["images[]"]), parseTags(tags)); err != nil {
+		logging.FromContext(r.Context()).Error("capability check failed", "err", err)
+		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to update post.")
+		return
+	} else if msg != "" {
+		errors.RenderError(w, http.StatusForbidden, "Forbidden", msg)
+		return
+	}
+
 	// === Categories ===
 	categories := r.Form["categories[]"]
 	if len(categories) == 0 {
@@ -243,11 +248,26 @@ func handleEditPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		removeImageIDs = append(removeImageIDs, id)
 	}
 
+	// === Processing attachments; new files must pass the scanner first ===
+	newAttachments, err := utils.ProcessUploadedAttachments(r, attachmentTypes)
+	if err != nil {
+		renderAttachmentError(w, r, err)
+		return
+	}
+	var removeAttachmentIDs []int
+	for _, idStr := range r.Form["remove_attachments[]"] {
+		if id, err := strconv.Atoi(idStr); err == nil {
+			removeAttachmentIDs = append(removeAttachmentIDs, id)
+		}
+	}
+
 	// === Post update ===
 	err = utils.UpdatePostFull(
 		r.Context(),
 		db,
 		postID,
+		currentUser.ID,
+		reason,
 		title,
 		content,
 		categories,
@@ -261,5 +281,24 @@ func handleEditPost(w http.ResponseWriter, r *http.Request, db *sql.DB) {
 		return
 	}
 
+	if err := utils.AddPostAttachments(r.Context(), db, int64(postID), currentUser.ID, newAttachments); err != nil {
+		logging.FromContext(r.Context()).Error("failed to add post attachments", "err", err)
+		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to attach files.")
+		return
+	}
+	if err := utils.RemovePostAttachments(r.Context(), db, postID, removeAttachmentIDs); err != nil {
+		logging.FromContext(r.Context()).Error("failed to remove post attachments", "err", err)
+		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to remove attachments.")
+		return
+	}
+	// Only the author and moderators turn wiki editing on or off
+	if utils.HasPermission(currentUser, post.UserID, "edit") {
+		if err := utils.SetPostWiki(r.Context(), db, postID, r.FormValue("wiki") != ""); err != nil {
+			logging.FromContext(r.Context()).Error("failed to set wiki flag", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Failed to update post.")
+			return
+		}
+	}
+
 	http.Redirect(w, r, "/user_page", http.StatusFound)
 }
@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"database/sql"
+	"forum/internal"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"forum/internal/utils"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ServeUploads serves files from the upload store under /static/uploads/.
+// Stores that can sign URLs (S3) get a redirect to an expiring URL; anything
+// else is streamed through the forum. Signed URLs are valid for urlExpiry.
+func ServeUploads(urlExpiry time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Attachments are only served as downloads through DownloadAttachment
+		key, err := utils.UploadKey(r.URL.Path)
+		if err != nil || strings.Contains(r.URL.Path, "..") || utils.IsAttachmentKey(key) {
+			http.NotFound(w, r)
+			return
+		}
+
+		store := utils.UploadStore()
+		if signer, ok := store.(storage.URLSigner); ok {
+			url, err := signer.SignedURL(key, urlExpiry)
+			if err != nil {
+				logging.FromContext(r.Context()).Error("failed to sign upload URL", "key", key, "err", err)
+				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+				return
+			}
+			// Let browsers reuse the redirect for part of the URL's lifetime
+			w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(urlExpiry.Seconds()/2)))
+			http.Redirect(w, r, url, http.StatusFound)
+			return
+		}
+
+		body, err := store.Get(r.Context(), key)
+		if err == storage.ErrNotFound {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			logging.FromContext(r.Context()).Error("failed to read upload", "key", key, "err", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		defer body.Close()
+
+		w.Header().Set("Content-Type", storage.ContentType(key))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+		if r.Method == http.MethodHead {
+			return
+		}
+		io.Copy(w, body)
+	}
+}
+
+// AdminUploadGCPage shows which uploaded files nothing references. GET is a
+// dry run; POST collects the garbage now instead of waiting for the next
+// scheduled run. Files must have been unreferenced for grace.
+func AdminUploadGCPage(db *sql.DB, grace time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := utils.GetUserFromSession(w, r, db)
+		if err != nil || !utils.IsAdmin(user) {
+			errors.RenderError(w, http.StatusForbidden, "Forbidden", "Only administrators can manage uploads.")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			errors.RenderError(w, http.StatusMethodNotAllowed, "Method not allowed", "Method not allowed")
+			return
+		}
+
+		dryRun := r.Method == http.MethodGet
+		report, err := utils.CollectUploadGarbage(r.Context(), db, utils.UploadStore(), grace, dryRun)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("upload GC failed", "err", err)
+			errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Could not scan uploads.")
+			return
+		}
+
+		data := models.UploadGCPageData{Report: report, GraceHours: int(grace.Hours()), CurrentUser: user}
+		utils.Render(w, r, "admin_uploads", data)
+	}
+}
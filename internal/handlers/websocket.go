@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"time"
 )
 
 var upgrader = websocket.Upgrader{
@@ -19,6 +20,7 @@ func NewHub() *Hub {
 		broadcast:  make(chan []byte),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
+		done:       make(chan struct{}),
 	}
 }
 
@@ -28,6 +30,8 @@ type Hub struct {
 	broadcast  chan []byte
 	register   chan *Client
 	unregister chan *Client
+	done       chan struct{}
+	closeOnce  sync.Once
 	mu         sync.Mutex
 }
 
@@ -98,10 +102,56 @@ func BroadcastNotification(userID int, notification map[string]interface{}) {
 	}
 }
 
-// Run starts the hub
+// ActiveConnections counts the open WebSocket connections: the hub's own
+// clients and the notification sockets opened through HandleWebSocket
+func (h *Hub) ActiveConnections() int {
+	h.mu.Lock()
+	n := len(h.clients)
+	h.mu.Unlock()
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	return n + len(clients)
+}
+
+// Close stops Run and sends every open WebSocket connection a "going away"
+// close frame before closing it. The HTTP server does not track hijacked
+// connections, so this is registered with RegisterOnShutdown.
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() {
+		close(h.done)
+
+		h.mu.Lock()
+		for client := range h.clients {
+			closeWebSocket(client.conn)
+			delete(h.clients, client)
+		}
+		h.mu.Unlock()
+
+		clientsMu.Lock()
+		for client := range clients {
+			closeWebSocket(client.conn)
+			delete(clients, client)
+		}
+		clientsMu.Unlock()
+	})
+}
+
+func closeWebSocket(conn *websocket.Conn) {
+	if conn == nil {
+		return
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second))
+	conn.Close()
+}
+
+// Run starts the hub; it returns after Close
 func (h *Hub) Run() {
 	for {
 		select {
+		case <-h.done:
+			return
 		case client := <-h.register:
 			h.mu.Lock()
 			h.clients[client] = true
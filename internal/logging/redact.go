@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces every value that must not be logged
+const Redacted = "[redacted]"
+
+// sensitiveKeys are matched against attribute keys, case-insensitively and
+// as substrings: "session_id", "reset_token" and "Set-Cookie" all match
+var sensitiveKeys = []string{"password", "passwd", "token", "secret", "cookie", "session", "authorization", "api_key", "apikey", "csrf"}
+
+// sensitiveText finds "key=value" and "key: value" pairs in free text, such
+// as messages written through the standard log package
+var sensitiveText = regexp.MustCompile(`(?i)\b((?:[a-z_-]*)(?:password|passwd|token|secret|cookie|session[_ -]?id|authorization|api[_-]?key)(?:\s+value)?)(\s*[=:]\s*)("[^"]*"|[^\s,;&]+)`)
+
+// IsSensitiveKey reports whether values under key are always redacted
+func IsSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, s := range sensitiveKeys {
+		if strings.Contains(key, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactString masks the values of sensitive key=value pairs in s
+func RedactString(s string) string {
+	return sensitiveText.ReplaceAllString(s, "${1}${2}"+Redacted)
+}
+
+// RedactHeader returns a copy of h with credentials masked
+func RedactHeader(h http.Header) http.Header {
+	out := make(http.Header, len(h))
+	for k, v := range h {
+		if IsSensitiveKey(k) {
+			out[k] = []string{Redacted}
+			continue
+		}
+		out[k] = v
+	}
+	return out
+}
+
+// redactHandler scrubs records before passing them on
+type redactHandler struct {
+	next slog.Handler
+}
+
+// NewRedactHandler wraps next so sensitive attributes and key=value pairs in
+// messages are masked
+func NewRedactHandler(next slog.Handler) slog.Handler {
+	return &redactHandler{next: next}
+}
+
+func (h *redactHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.next.Enabled(ctx, level)
+}
+
+func (h *redactHandler) Handle(ctx context.Context, r slog.Record) error {
+	clean := slog.NewRecord(r.Time, r.Level, RedactString(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		clean.AddAttrs(redactAttr(a))
+		return true
+	})
+	return h.next.Handle(ctx, clean)
+}
+
+func (h *redactHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clean := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		clean[i] = redactAttr(a)
+	}
+	return &redactHandler{next: h.next.WithAttrs(clean)}
+}
+
+func (h *redactHandler) WithGroup(name string) slog.Handler {
+	return &redactHandler{next: h.next.WithGroup(name)}
+}
+
+func redactAttr(a slog.Attr) slog.Attr {
+	if IsSensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	v := a.Value.Resolve()
+	switch v.Kind() {
+	case slog.KindGroup:
+		group := v.Group()
+		clean := make([]any, len(group))
+		for i, g := range group {
+			clean[i] = redactAttr(g)
+		}
+		return slog.Group(a.Key, clean...)
+	case slog.KindString:
+		return slog.String(a.Key, RedactString(v.String()))
+	case slog.KindAny:
+		switch x := v.Any().(type) {
+		case *http.Cookie, http.Cookie, []*http.Cookie:
+			return slog.String(a.Key, Redacted)
+		case http.Header:
+			return slog.Any(a.Key, RedactHeader(x))
+		case error:
+			return slog.String(a.Key, RedactString(x.Error()))
+		}
+	}
+	return slog.Attr{Key: a.Key, Value: v}
+}
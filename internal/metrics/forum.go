@@ -0,0 +1,49 @@
+package metrics
+
+// HTTP
+var (
+	HTTPRequests = Default.NewCounterVec("forum_http_requests_total",
+		"HTTP requests by route pattern, method and status code", "route", "method", "status")
+	HTTPDuration = Default.NewHistogramVec("forum_http_request_duration_seconds",
+		"Time to serve HTTP requests by route pattern and method", nil, "route", "method")
+	RateLimited = Default.NewCounter("forum_rate_limit_rejections_total",
+		"Requests rejected by the rate limiter")
+	IPBlocked = Default.NewCounterVec("forum_ip_block_rejections_total",
+		"Requests rejected by IP blocks, by scope (registration, posting, all)", "scope")
+	PoWChallenges = Default.NewCounterVec("forum_pow_challenges_total",
+		"Proof-of-work challenges by result (issued, solved, refused)", "result")
+	SpamVerdicts = Default.NewCounterVec("forum_spam_verdicts_total",
+		"New posts and comments by kind (post, comment) and spam filter verdict (allow, hold, reject)", "kind", "verdict")
+	SpamReviews = Default.NewCounterVec("forum_spam_reviews_total",
+		"Held content reviewed by moderators, by decision (ham, spam, reject)", "decision")
+)
+
+// WebSockets
+var WebSocketConnections = Default.NewGaugeFunc("forum_websocket_connections",
+	"Open WebSocket connections", nil)
+
+// Database
+var (
+	DBQueryDuration = Default.NewHistogramVec("forum_db_query_duration_seconds",
+		"Time until a database statement returns, by statement kind", nil, "operation")
+	DBOpenConnections = Default.NewGaugeFunc("forum_db_open_connections",
+		"Open database connections, in use or idle", nil)
+	DBInUseConnections = Default.NewGaugeFunc("forum_db_in_use_connections",
+		"Database connections currently in use", nil)
+)
+
+// Uploads
+var UploadBytes = Default.NewCounterVec("forum_upload_bytes_total",
+	"Bytes stored from uploads, by kind (image, avatar, attachment)", "kind")
+
+// Activity
+var (
+	PostsCreated = Default.NewCounter("forum_posts_created_total",
+		"Posts created")
+	CommentsCreated = Default.NewCounter("forum_comments_created_total",
+		"Comments created, replies included")
+	Registrations = Default.NewCounterVec("forum_registrations_total",
+		"Accounts registered, by method (password, google, github)", "method")
+	Logins = Default.NewCounterVec("forum_logins_total",
+		"Sign-in attempts by method (password, oauth) and result (success, failure)", "method", "result")
+)
@@ -0,0 +1,405 @@
+// Package metrics keeps counters, gauges and histograms in memory and serves
+// them in the Prometheus text exposition format.
+//
+// Metrics are declared once as package variables (see forum.go) and updated
+// from wherever the event happens. Label values should come from a small,
+// fixed set; routes are the mux patterns, never raw paths.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets suit request and query latencies in seconds
+var DefaultBuckets = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// collector is anything the registry can write out
+type collector interface {
+	name() string
+	write(w io.Writer)
+}
+
+// Registry holds the metrics of one process
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+	names      map[string]bool
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// Default is the registry the forum's metrics live in
+var Default = NewRegistry()
+
+func (r *Registry) register(c collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[c.name()] {
+		panic("metrics: " + c.name() + " registered twice")
+	}
+	r.names[c.name()] = true
+	r.collectors = append(r.collectors, c)
+}
+
+// Write writes every metric in the text exposition format, sorted by name
+func (r *Registry) Write(w io.Writer) {
+	r.mu.Lock()
+	collectors := append([]collector(nil), r.collectors...)
+	r.mu.Unlock()
+
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].name() < collectors[j].name() })
+	for _, c := range collectors {
+		c.write(w)
+	}
+}
+
+// desc is what every metric family has in common
+type desc struct {
+	fqName string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d *desc) name() string { return d.fqName }
+
+func (d *desc) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.fqName, escapeHelp(d.help), d.fqName, d.kind)
+}
+
+// key joins label values into a map key
+func (d *desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", d.fqName, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// series formats name{label="value",...} with extra appended to the labels
+func (d *desc) series(suffix string, values []string, extra ...string) string {
+	var b strings.Builder
+	b.WriteString(d.fqName)
+	b.WriteString(suffix)
+	if len(values) == 0 && len(extra) == 0 {
+		return b.String()
+	}
+	b.WriteByte('{')
+	for i, l := range d.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", l, values[i])
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		if len(values) > 0 || i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", extra[i], extra[i+1])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sortedKeys returns the series keys in a stable order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitKey turns a map key back into label values
+func splitKey(key string, n int) []string {
+	if n == 0 {
+		return nil
+	}
+	return strings.Split(key, "\xff")
+}
+
+// Counter only goes up
+type Counter struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Inc adds one
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add adds v, which must not be negative
+func (c *Counter) Add(v float64) {
+	if v < 0 {
+		panic("metrics: counters cannot decrease")
+	}
+	c.mu.Lock()
+	c.value += v
+	c.mu.Unlock()
+}
+
+// Value returns the current count
+func (c *Counter) Value() float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.value
+}
+
+// CounterVec is a family of counters told apart by labels
+type CounterVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*Counter
+}
+
+// NewCounterVec registers a counter family in r
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{desc: desc{name, help, "counter", labels}, series: make(map[string]*Counter)}
+	r.register(c)
+	return c
+}
+
+// NewCounter registers a counter without labels in r
+func (r *Registry) NewCounter(name, help string) *Counter {
+	return r.NewCounterVec(name, help).With()
+}
+
+// With returns the counter for the given label values, creating it at zero
+func (c *CounterVec) With(values ...string) *Counter {
+	key := c.key(values)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[key]
+	if !ok {
+		s = &Counter{}
+		c.series[key] = s
+	}
+	return s
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.header(w)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, key := range sortedKeys(c.series) {
+		fmt.Fprintf(w, "%s %s\n", c.desc.series("", splitKey(key, len(c.labels))), formatFloat(c.series[key].Value()))
+	}
+}
+
+// Gauge goes up and down
+type Gauge struct {
+	mu    sync.Mutex
+	value float64
+}
+
+// Set replaces the value
+func (g *Gauge) Set(v float64) {
+	g.mu.Lock()
+	g.value = v
+	g.mu.Unlock()
+}
+
+// Add changes the value by v, which may be negative
+func (g *Gauge) Add(v float64) {
+	g.mu.Lock()
+	g.value += v
+	g.mu.Unlock()
+}
+
+// Inc adds one
+func (g *Gauge) Inc() { g.Add(1) }
+
+// Dec subtracts one
+func (g *Gauge) Dec() { g.Add(-1) }
+
+// Value returns the current value
+func (g *Gauge) Value() float64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.value
+}
+
+// GaugeVec is a family of gauges told apart by labels
+type GaugeVec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*Gauge
+}
+
+// NewGaugeVec registers a gauge family in r
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{desc: desc{name, help, "gauge", labels}, series: make(map[string]*Gauge)}
+	r.register(g)
+	return g
+}
+
+// NewGauge registers a gauge without labels in r
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).With()
+}
+
+// With returns the gauge for the given label values, creating it at zero
+func (g *GaugeVec) With(values ...string) *Gauge {
+	key := g.key(values)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	s, ok := g.series[key]
+	if !ok {
+		s = &Gauge{}
+		g.series[key] = s
+	}
+	return s
+}
+
+func (g *GaugeVec) write(w io.Writer) {
+	g.header(w)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, key := range sortedKeys(g.series) {
+		fmt.Fprintf(w, "%s %s\n", g.desc.series("", splitKey(key, len(g.labels))), formatFloat(g.series[key].Value()))
+	}
+}
+
+// GaugeFunc is a gauge whose value is read when metrics are scraped
+type GaugeFunc struct {
+	desc
+	mu sync.Mutex
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge without labels whose value comes from fn.
+// fn may be nil until Set is called; the gauge reads 0 until then.
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{desc: desc{fqName: name, help: help, kind: "gauge"}, fn: fn}
+	r.register(g)
+	return g
+}
+
+// Set replaces the function the value is read from
+func (g *GaugeFunc) Set(fn func() float64) {
+	g.mu.Lock()
+	g.fn = fn
+	g.mu.Unlock()
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	g.mu.Lock()
+	fn := g.fn
+	g.mu.Unlock()
+	value := 0.0
+	if fn != nil {
+		value = fn()
+	}
+	g.header(w)
+	fmt.Fprintf(w, "%s %s\n", g.fqName, formatFloat(value))
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe records one value
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// ObserveDuration records the seconds since start
+func (h *Histogram) ObserveDuration(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+// HistogramVec is a family of histograms told apart by labels
+type HistogramVec struct {
+	desc
+	bounds []float64
+	mu     sync.Mutex
+	series map[string]*Histogram
+}
+
+// NewHistogramVec registers a histogram family in r. buckets are the upper
+// bounds in increasing order; DefaultBuckets is used when it is nil.
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	h := &HistogramVec{desc: desc{name, help, "histogram", labels}, bounds: buckets, series: make(map[string]*Histogram)}
+	r.register(h)
+	return h
+}
+
+// With returns the histogram for the given label values
+func (h *HistogramVec) With(values ...string) *Histogram {
+	key := h.key(values)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[key]
+	if !ok {
+		s = &Histogram{bounds: h.bounds, buckets: make([]uint64, len(h.bounds))}
+		h.series[key] = s
+	}
+	return s
+}
+
+func (h *HistogramVec) write(w io.Writer) {
+	h.header(w)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, key := range sortedKeys(h.series) {
+		values := splitKey(key, len(h.labels))
+		s := h.series[key]
+		s.mu.Lock()
+		for i, bound := range s.bounds {
+			fmt.Fprintf(w, "%s %d\n", h.desc.series("_bucket", values, "le", formatFloat(bound)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s %d\n", h.desc.series("_bucket", values, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s %s\n", h.desc.series("_sum", values), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s %d\n", h.desc.series("_count", values), s.count)
+		s.mu.Unlock()
+	}
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	driversMu sync.Mutex
+	drivers   = make(map[string]string)
+)
+
+// SQLDriver registers, once, a driver that times every statement run through
+// the driver registered as base, and returns its name for sql.Open
+func SQLDriver(base string) string {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	if name, ok := drivers[base]; ok {
+		return name
+	}
+	// sql.Open does not connect, it only looks the driver up
+	db, err := sql.Open(base, "")
+	if err != nil {
+		panic("metrics: " + err.Error())
+	}
+	name := base + "+metrics"
+	sql.Register(name, timedDriver{db.Driver()})
+	db.Close()
+	drivers[base] = name
+	return name
+}
+
+// WatchDB reports the connection pool of db in the database gauges
+func WatchDB(db *sql.DB) {
+	DBOpenConnections.Set(func() float64 { return float64(db.Stats().OpenConnections) })
+	DBInUseConnections.Set(func() float64 { return float64(db.Stats().InUse) })
+}
+
+// operation is the statement kind used as label: select, insert, update,
+// delete, or other for everything else (pragmas, DDL, transactions)
+func operation(query string) string {
+	query = strings.TrimSpace(query)
+	if i := strings.IndexAny(query, " \t\r\n("); i > 0 {
+		query = query[:i]
+	}
+	switch op := strings.ToLower(query); op {
+	case "select", "with":
+		return "select"
+	case "insert", "update", "delete":
+		return op
+	default:
+		return "other"
+	}
+}
+
+func observeQuery(query string, start time.Time) {
+	DBQueryDuration.With(operation(query)).ObserveDuration(start)
+}
+
+type timedDriver struct {
+	driver.Driver
+}
+
+func (d timedDriver) Open(dsn string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return &timedConn{conn}, nil
+}
+
+// timedConn passes everything to the wrapped connection and times
+// statements. Methods the wrapped connection lacks report driver.ErrSkip so
+// database/sql falls back the way it would without the wrapper.
+type timedConn struct {
+	driver.Conn
+}
+
+func (c *timedConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *timedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var (
+		stmt driver.Stmt
+		err  error
+	)
+	if p, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &timedStmt{Stmt: stmt, query: query}, nil
+}
+
+func (c *timedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if b, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return b.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *timedConn) Ping(ctx context.Context) error {
+	if p, ok := c.Conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	e, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observeQuery(query, time.Now())
+	return e.ExecContext(ctx, query, args)
+}
+
+func (c *timedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	q, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	defer observeQuery(query, time.Now())
+	return q.QueryContext(ctx, query, args)
+}
+
+type timedStmt struct {
+	driver.Stmt
+	query string
+}
+
+func (s *timedStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	defer observeQuery(s.query, time.Now())
+	if e, ok := s.Stmt.(driver.StmtExecContext); ok {
+		return e.ExecContext(ctx, args)
+	}
+	return s.Stmt.Exec(values(args))
+}
+
+func (s *timedStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	defer observeQuery(s.query, time.Now())
+	if q, ok := s.Stmt.(driver.StmtQueryContext); ok {
+		return q.QueryContext(ctx, args)
+	}
+	return s.Stmt.Query(values(args))
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	out := make([]driver.Value, len(args))
+	for i, a := range args {
+		out[i] = a.Value
+	}
+	return out
+}
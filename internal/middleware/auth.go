@@ -3,9 +3,9 @@ package middleware
 import (
 	"context"
 	"database/sql"
+	"forum/internal/logging"
 	"forum/internal/security"
 	"forum/internal/utils"
-	"log"
 	"net/http"
 	"time"
 )
@@ -13,7 +13,10 @@ import (
 func AuthMiddleware(db *sql.DB, next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		userID, err := security.ValidateSession(db, r)
-		log.Printf("User (ID: %d) successfully AuthMiddleware", userID)
+		if err != nil {
+			logging.FromContext(r.Context()).Debug("no valid session, continuing as guest", "reason", err)
+		}
+		logging.SetUserID(r.Context(), userID)
 
 		// Don't redirect if there is no session - this is the norm for guests
 
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	errors "forum/internal"
+	"forum/internal/logging"
+	"forum/internal/metrics"
+	"forum/internal/utils"
+	"net/http"
+	"strings"
+)
+
+// Routes the registration and posting scopes of IP blocks close; paths
+// ending in a slash are subtrees. Everything else is closed only by blocks
+// of all requests.
+var (
+	registrationRoutes = []string{"/register", "/register-submit"}
+	postingRoutes      = []string{
+		"/create", "/createin", "/create-comment", "/edit_post/", "/edit_comment/",
+		"/like", "/post_images/", "/upload_avatar", "/notifications/add_reply",
+	}
+	// Blocked clients still get styles for the error page, and probes from
+	// inside a blocked network keep working
+	unblockableRoutes = []string{"/static/", "/healthz", "/readyz"}
+)
+
+func onRoute(routes []string, path string) bool {
+	for _, route := range routes {
+		if path == route || strings.HasSuffix(route, "/") && strings.HasPrefix(path, route) {
+			return true
+		}
+	}
+	return false
+}
+
+// BlockScope is the scope of IP blocks that close the route of r
+func BlockScope(r *http.Request) string {
+	switch {
+	case onRoute(registrationRoutes, r.URL.Path):
+		return utils.BlockRegistration
+	case onRoute(postingRoutes, r.URL.Path):
+		return utils.BlockPosting
+	}
+	return utils.BlockAll
+}
+
+// Blocklist refuses requests from networks admins blocked, before sessions
+// are looked at. OAuth callbacks both sign in and sign up, so their
+// handlers check registration blocks themselves when creating an account.
+func Blocklist(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if onRoute(unblockableRoutes, r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		scope := BlockScope(r)
+		if !utils.IPBlocked(r, scope) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		metrics.IPBlocked.With(scope).Inc()
+		logging.FromContext(r.Context()).Warn("request from blocked network", "client_ip", ClientIP(r), "scope", scope)
+		const message = "Requests from your network have been blocked."
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			w.Header().Set("Content-Type", "application/json")
+			utils.RespondWithError(w, http.StatusForbidden, message)
+			return
+		}
+		errors.RenderError(w, http.StatusForbidden, "Forbidden", message)
+	})
+}
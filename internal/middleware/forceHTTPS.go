@@ -1,17 +1,66 @@
 package middleware
 
 import (
+	"fmt"
+	"net"
 	"net/http"
+	"time"
 )
 
 func ForceHTTPS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.TLS == nil {
+		if !IsHTTPS(r) {
 			// If the request is not via HTTPS — redirect to HTTPS
-			httpsURL := "https://" + r.Host + r.RequestURI
-			http.Redirect(w, r, httpsURL, http.StatusMovedPermanently)
+			http.Redirect(w, r, httpsURL(r, ""), http.StatusMovedPermanently)
 			return
 		}
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RedirectToHTTPS answers every request on the plain HTTP listener with a
+// permanent redirect to the same URL on httpsAddr, the HTTPS listen address
+func RedirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Only GET and HEAD are safe to repeat against another URL
+		code := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			code = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, httpsURL(r, port), code)
+	})
+}
+
+// httpsURL is the HTTPS form of r's URL; port replaces the request's port
+// unless it is empty or the default 443
+func httpsURL(r *http.Request, port string) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if port != "" && port != "443" {
+		host = net.JoinHostPort(host, port)
+	} else if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+		host = "[" + host + "]"
+	}
+	return "https://" + host + r.URL.RequestURI()
+}
+
+// HSTS tells browsers to use HTTPS only for maxAge. The header is only sent
+// on HTTPS responses, as browsers ignore it over plain HTTP.
+func HSTS(maxAge time.Duration, includeSubdomains bool, next http.Handler) http.Handler {
+	if maxAge <= 0 {
+		return next
+	}
+	value := fmt.Sprintf("max-age=%d", int64(maxAge.Seconds()))
+	if includeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if IsHTTPS(r) {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
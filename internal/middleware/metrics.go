@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"forum/internal/metrics"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Metrics counts requests and their latency per route. It must sit between
+// the ServeMux and anything that copies the request, because the route is
+// the pattern the mux records on the request it was given.
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		// Requests refused before routing have no pattern
+		route := r.Pattern
+		if route == "" {
+			route = "none"
+		}
+		method := metricMethod(r.Method)
+		metrics.HTTPRequests.With(route, method, strconv.Itoa(status)).Inc()
+		metrics.HTTPDuration.With(route, method).ObserveDuration(start)
+	})
+}
+
+// metricMethod keeps made-up methods from creating new series
+func metricMethod(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
@@ -0,0 +1,204 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"forum/internal/logging"
+	"forum/internal/metrics"
+	"forum/internal/security"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Headers carrying a proof-of-work solution, and the one telling a client
+// its submission needs one
+const (
+	PoWTokenHeader    = "X-PoW-Token"
+	PoWNonceHeader    = "X-PoW-Nonce"
+	PoWRequiredHeader = "X-PoW-Required"
+)
+
+// PoWPolicy decides when forms need a proof-of-work challenge and how hard
+// it is. A client that submitted protected forms Threshold times within
+// Window must solve one of Difficulty bits, one bit more for every further
+// submission up to MaxDifficulty. Each bit doubles the work.
+type PoWPolicy struct {
+	Enabled       bool
+	Threshold     int
+	Window        time.Duration
+	Difficulty    int
+	MaxDifficulty int
+	TTL           time.Duration
+}
+
+// difficulty is what a client with attempts recent submissions must solve,
+// 0 for none
+func (p PoWPolicy) difficulty(attempts int) int {
+	if !p.Enabled || attempts < p.Threshold {
+		return 0
+	}
+	return min(p.Difficulty+attempts-p.Threshold, p.MaxDifficulty)
+}
+
+// powForms are the routes protected by challenges, by form name
+var powForms = map[string]string{
+	"/register-submit":        "register",
+	"/login-submit":           "login",
+	"/forgot-password-submit": "reset",
+}
+
+var (
+	powMu     sync.Mutex
+	powPolicy = PoWPolicy{Enabled: true, Threshold: 3, Window: 15 * time.Minute, Difficulty: 16, MaxDifficulty: 22, TTL: 5 * time.Minute}
+	// powAttempts are the recent submissions of protected forms per client IP
+	powAttempts = map[string][]time.Time{}
+)
+
+// SetProofOfWork changes the challenge policy and forgets the submissions
+// counted under the previous one
+func SetProofOfWork(p PoWPolicy) {
+	powMu.Lock()
+	defer powMu.Unlock()
+	powPolicy = p
+	powAttempts = map[string][]time.Time{}
+}
+
+// recentAttempts counts the submissions of ip within the window at now,
+// forgetting older ones
+func recentAttempts(p PoWPolicy, ip string, now time.Time) int {
+	attempts := powAttempts[ip]
+	for len(attempts) > 0 && now.Sub(attempts[0]) >= p.Window {
+		attempts = attempts[1:]
+	}
+	if len(attempts) == 0 {
+		delete(powAttempts, ip)
+	} else {
+		powAttempts[ip] = attempts
+	}
+	return len(attempts)
+}
+
+// powState returns the policy and the difficulty ip must solve at now
+func powState(ip string, now time.Time) (PoWPolicy, int) {
+	powMu.Lock()
+	defer powMu.Unlock()
+	return powPolicy, powPolicy.difficulty(recentAttempts(powPolicy, ip, now))
+}
+
+// recordAttempts counts n submissions of ip at now
+func recordAttempts(ip string, n int, now time.Time) {
+	powMu.Lock()
+	defer powMu.Unlock()
+	for range n {
+		powAttempts[ip] = append(powAttempts[ip], now)
+	}
+}
+
+// expireAttempts forgets clients with no submissions in the window
+func expireAttempts(now time.Time) {
+	powMu.Lock()
+	defer powMu.Unlock()
+	for ip := range powAttempts {
+		recentAttempts(powPolicy, ip, now)
+	}
+}
+
+// PoWChallenge issues challenges as JSON for ?form=. Difficulty 0 means the
+// client may submit the form without solving anything for now.
+func PoWChallenge() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		form := r.URL.Query().Get("form")
+		known := false
+		for _, f := range powForms {
+			known = known || f == form
+		}
+		if !known {
+			http.Error(w, "Unknown form", http.StatusBadRequest)
+			return
+		}
+
+		now := time.Now()
+		policy, difficulty := powState(ClientIP(r), now)
+		challenge := security.NewChallenge(form, difficulty, policy.TTL, now)
+		if difficulty > 0 {
+			metrics.PoWChallenges.With("issued").Inc()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(struct {
+			Required bool `json:"required"`
+			security.Challenge
+		}{difficulty > 0, challenge})
+	}
+}
+
+// ProofOfWork makes clients that keep submitting the registration, sign-in
+// and password reset forms solve a challenge first. Submissions count
+// towards the threshold, failed ones twice. A solution is accepted once,
+// remembered in the rate limit store so instances sharing it agree.
+func ProofOfWork(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		form, ok := powForms[r.URL.Path]
+		if !ok || r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		now := time.Now()
+		ip := ClientIP(r)
+		policy, difficulty := powState(ip, now)
+		if difficulty > 0 {
+			if err := checkSolution(r, form, difficulty, policy, now); err != nil {
+				metrics.PoWChallenges.With("refused").Inc()
+				logging.FromContext(r.Context()).Info("proof-of-work refused", "client_ip", ip, "form", form, "difficulty", difficulty, "err", err)
+				recordAttempts(ip, 1, now)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set(PoWRequiredHeader, "1")
+				w.WriteHeader(http.StatusForbidden)
+				json.NewEncoder(w).Encode(map[string]string{"error": "Please wait while your browser completes a security check, then try again."})
+				return
+			}
+			metrics.PoWChallenges.With("solved").Inc()
+		}
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		attempts := 1
+		if rec.status >= http.StatusBadRequest {
+			attempts = 2
+		}
+		recordAttempts(ip, attempts, now)
+	})
+}
+
+var (
+	errChallengeWeak     = errors.New("challenge is easier than required")
+	errChallengeReplayed = errors.New("challenge was already used")
+)
+
+func checkSolution(r *http.Request, form string, difficulty int, policy PoWPolicy, now time.Time) error {
+	solved, err := security.VerifyChallenge(r.Header.Get(PoWTokenHeader), r.Header.Get(PoWNonceHeader), form, now)
+	if err != nil {
+		return err
+	}
+	if solved.Difficulty < difficulty {
+		return errChallengeWeak
+	}
+
+	mu.Lock()
+	store := limitStore
+	mu.Unlock()
+	// A bucket of one for the challenge's lifetime: the first use takes it
+	d, err := store.Take(r.Context(), "pow|"+solved.ID, Policy{Burst: 1, Interval: policy.TTL}, now)
+	if err != nil {
+		// Like the rate limiter, a failing store lets the solution through
+		logging.FromContext(r.Context()).Error("proof-of-work replay check failed", "err", err)
+		return nil
+	}
+	if !d.Allowed {
+		return errChallengeReplayed
+	}
+	return nil
+}
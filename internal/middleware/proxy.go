@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type originKey struct{}
+
+// origin is where a request really came from, as established by TrustProxies
+type origin struct {
+	ip    string
+	https bool
+}
+
+// TrustProxies believes X-Forwarded-For and X-Forwarded-Proto only when the
+// connection comes from one of the trusted prefixes. The client is the
+// rightmost forwarded address that is not itself a trusted proxy, so a
+// client cannot choose its IP by sending the header itself.
+func TrustProxies(trusted []netip.Prefix, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		o := origin{ip: remoteIP(r), https: r.TLS != nil}
+		if isTrusted(trusted, o.ip) {
+			if ip := forwardedClient(trusted, r.Header.Values("X-Forwarded-For")); ip != "" {
+				o.ip = ip
+			}
+			if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+				first, _, _ := strings.Cut(proto, ",")
+				o.https = strings.EqualFold(strings.TrimSpace(first), "https")
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), originKey{}, o)))
+	})
+}
+
+// ClientIP returns the IP of the client that made r, behind trusted proxies
+// the forwarded one
+func ClientIP(r *http.Request) string {
+	if o, ok := r.Context().Value(originKey{}).(origin); ok {
+		return o.ip
+	}
+	return remoteIP(r)
+}
+
+// IsHTTPS reports whether the client reached the forum over HTTPS, either
+// directly or through a trusted proxy
+func IsHTTPS(r *http.Request) bool {
+	if o, ok := r.Context().Value(originKey{}).(origin); ok {
+		return o.https
+	}
+	return r.TLS != nil
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func isTrusted(trusted []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedClient walks the X-Forwarded-For chain from the nearest hop
+func forwardedClient(trusted []netip.Prefix, headers []string) string {
+	var hops []string
+	for _, h := range headers {
+		for _, hop := range strings.Split(h, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(hops[i])
+		if err != nil {
+			// Garbage from an untrusted hop: stop at the last good one
+			break
+		}
+		if !isTrusted(trusted, addr.String()) {
+			return addr.Unmap().String()
+		}
+		if i == 0 {
+			return addr.Unmap().String()
+		}
+	}
+	return ""
+}
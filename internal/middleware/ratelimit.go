@@ -1,59 +1,211 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"forum/internal/metrics"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
-
-	"golang.org/x/time/rate"
 )
 
-type visitor struct {
-	limiter  *rate.Limiter
-	lastSeen time.Time
+// Policy lets a client make Burst requests at once, refilled one every
+// Interval
+type Policy struct {
+	Burst    int
+	Interval time.Duration
+	// PerUser counts signed-in users by account instead of by IP
+	PerUser bool
+}
+
+// window is how long an empty bucket takes to refill completely
+func (p Policy) window() time.Duration {
+	return time.Duration(p.Burst) * p.Interval
+}
+
+// Decision is the outcome of one request against a policy
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is when the client has its whole burst again
+	Reset time.Duration
+	// RetryAfter is when a denied client may make the next request
+	RetryAfter time.Duration
+}
+
+// take applies p to a request at now, with tat the key's theoretical arrival
+// time (GCRA): how far ahead of now the requests made so far have used the
+// bucket. It returns the new tat, which is unchanged when the request is
+// denied.
+func (p Policy) take(tat, now time.Time) (time.Time, Decision) {
+	if tat.Before(now) {
+		tat = now
+	}
+	next := tat.Add(p.Interval)
+	if next.Sub(now) > p.window() {
+		return tat, Decision{
+			Limit:      p.Burst,
+			Reset:      tat.Sub(now),
+			RetryAfter: next.Sub(now) - p.window(),
+		}
+	}
+	return next, p.admitted(next, now)
+}
+
+// admitted describes an allowed request that moved the tat to tat
+func (p Policy) admitted(tat, now time.Time) Decision {
+	return Decision{
+		Allowed:   true,
+		Limit:     p.Burst,
+		Remaining: int((p.window() - tat.Sub(now)) / p.Interval),
+		Reset:     tat.Sub(now),
+	}
+}
+
+// LimitStore keeps the state of rate limited keys. Instances sharing a store
+// share their limits.
+type LimitStore interface {
+	// Take counts a request for key under p at now
+	Take(ctx context.Context, key string, p Policy, now time.Time) (Decision, error)
+	// Expire forgets keys whose buckets are full again at now
+	Expire(ctx context.Context, now time.Time) error
 }
 
 var (
-	visitors = make(map[string]*visitor)
-	mu       sync.Mutex
+	mu sync.Mutex
+
+	// Every request is held to the default policy per client IP, and to the
+	// policy of its route on top
+	defaultPolicy            = Policy{Burst: 30, Interval: 50 * time.Millisecond}
+	routePolicies            = map[string]Policy{}
+	limitStore    LimitStore = NewMemoryLimitStore()
+	// limitUser returns the signed-in user of a request, or 0
+	limitUser = func(*http.Request) int { return 0 }
 )
 
-func init() {
-	go cleanupVisitors()
+// SetRateLimit changes the default policy
+func SetRateLimit(interval time.Duration, burst int) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultPolicy = Policy{Burst: burst, Interval: interval}
+}
+
+// SetRouteRateLimits replaces the route policies. Keys are paths or, ending
+// in a slash, subtrees as in http.ServeMux.
+func SetRouteRateLimits(policies map[string]Policy) {
+	mu.Lock()
+	defer mu.Unlock()
+	routePolicies = policies
+}
+
+// SetRateLimitStore changes where limits are kept
+func SetRateLimitStore(store LimitStore) {
+	mu.Lock()
+	defer mu.Unlock()
+	limitStore = store
+}
+
+// SetRateLimitUser sets how per-user policies find the user of a request;
+// user returns 0 for guests, who are limited by IP
+func SetRateLimitUser(user func(*http.Request) int) {
+	mu.Lock()
+	defer mu.Unlock()
+	limitUser = user
 }
 
-func cleanupVisitors() {
+// RunVisitorCleanup forgets clients whose limits are full again, and those
+// with no recent proof-of-work attempts, once a minute, until ctx is done
+func RunVisitorCleanup(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
 	for {
-		time.Sleep(time.Minute)
-		mu.Lock()
-		for ip, v := range visitors {
-			if time.Since(v.lastSeen) > 3*time.Minute {
-				delete(visitors, ip)
-			}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
 		}
+		mu.Lock()
+		store := limitStore
 		mu.Unlock()
+		if err := store.Expire(ctx, time.Now()); err != nil {
+			slog.Error("rate limit cleanup failed", "err", err)
+		}
+		expireAttempts(time.Now())
+	}
+}
+
+// routePolicy finds the policy of path: an exact match, or else the longest
+// subtree containing it
+func routePolicy(policies map[string]Policy, path string) (string, Policy, bool) {
+	if p, ok := policies[path]; ok {
+		return path, p, true
+	}
+	var best string
+	for route := range policies {
+		if strings.HasSuffix(route, "/") && strings.HasPrefix(path, route) && len(route) > len(best) {
+			best = route
+		}
 	}
+	if best == "" {
+		return "", Policy{}, false
+	}
+	return best, policies[best], true
 }
 
+// RateLimit holds clients to the default policy and their route's policy.
+// Clients are told their standing in RateLimit-* headers and, once limited,
+// when to come back in Retry-After. Should the store fail, requests are let
+// through rather than failing the forum.
 func RateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ip := r.RemoteAddr
-
 		mu.Lock()
-		v, exists := visitors[ip]
-		if !exists {
-			limiter := rate.NewLimiter(rate.Every(50*time.Millisecond), 10)
-			visitors[ip] = &visitor{limiter, time.Now()}
-			v = visitors[ip]
-		}
-		v.lastSeen = time.Now()
+		fallback, routes, store, user := defaultPolicy, routePolicies, limitStore, limitUser
 		mu.Unlock()
 
-		if !v.limiter.Allow() {
-			http.Error(w, "Забагато запитів", http.StatusTooManyRequests)
+		now := time.Now()
+		ip := "ip:" + ClientIP(r)
+		d, err := store.Take(r.Context(), "default|"+ip, fallback, now)
+		policy := fallback
+
+		if route, p, ok := routePolicy(routes, r.URL.Path); ok && err == nil && d.Allowed {
+			client := ip
+			if p.PerUser {
+				if id := user(r); id > 0 {
+					client = "user:" + strconv.Itoa(id)
+				}
+			}
+			d, err = store.Take(r.Context(), route+"|"+client, p, now)
+			policy = p
+		}
+		if err != nil {
+			slog.Error("rate limit store failed, letting the request through", "err", err)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", policy.Burst, seconds(policy.window())))
+		h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+		if !d.Allowed {
+			metrics.RateLimited.Inc()
+			h.Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+			http.Error(w, "Too many requests, please try again later", http.StatusTooManyRequests)
 			return
 		}
 
 		next.ServeHTTP(w, r)
 	})
 }
+
+// seconds rounds d up to whole seconds, as the headers count in seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
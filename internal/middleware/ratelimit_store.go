@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// MemoryLimitStore keeps limits in the process
+type MemoryLimitStore struct {
+	mu   sync.Mutex
+	tats map[string]time.Time
+}
+
+func NewMemoryLimitStore() *MemoryLimitStore {
+	return &MemoryLimitStore{tats: make(map[string]time.Time)}
+}
+
+func (s *MemoryLimitStore) Take(_ context.Context, key string, p Policy, now time.Time) (Decision, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tat, d := p.take(s.tats[key], now)
+	s.tats[key] = tat
+	return d, nil
+}
+
+func (s *MemoryLimitStore) Expire(_ context.Context, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, tat := range s.tats {
+		if !tat.After(now) {
+			delete(s.tats, key)
+		}
+	}
+	return nil
+}
+
+// SQLiteLimitStore keeps limits in the rate_limits table, so every instance
+// using the same database shares them
+type SQLiteLimitStore struct {
+	db *sql.DB
+}
+
+func NewSQLiteLimitStore(db *sql.DB) *SQLiteLimitStore {
+	return &SQLiteLimitStore{db: db}
+}
+
+// Take decides in one conditional upsert, so concurrent requests from any
+// instance cannot both use the last token
+func (s *SQLiteLimitStore) Take(ctx context.Context, key string, p Policy, now time.Time) (Decision, error) {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO rate_limits (key, tat) VALUES (?1, ?2 + ?3)
+		ON CONFLICT(key) DO UPDATE SET tat = MAX(tat, ?2) + ?3
+		WHERE MAX(tat, ?2) + ?3 - ?2 <= ?4`,
+		key, now.UnixNano(), int64(p.Interval), int64(p.window()))
+	if err != nil {
+		return Decision{}, err
+	}
+	allowed, err := res.RowsAffected()
+	if err != nil {
+		return Decision{}, err
+	}
+
+	var tat int64
+	if err := s.db.QueryRowContext(ctx, `SELECT tat FROM rate_limits WHERE key = ?`, key).Scan(&tat); err != nil {
+		return Decision{}, err
+	}
+	if allowed == 1 {
+		return p.admitted(time.Unix(0, tat), now), nil
+	}
+	_, d := p.take(time.Unix(0, tat), now)
+	d.Allowed = false
+	return d, nil
+}
+
+func (s *SQLiteLimitStore) Expire(ctx context.Context, now time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE tat <= ?`, now.UnixNano())
+	return err
+}
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Attachment is a non-image file attached to a post
+type Attachment struct {
+	ID            int
+	PostID        int
+	UserID        int
+	Path          string // upload path of the stored file
+	Name          string // file name shown to readers, as uploaded
+	ContentType   string
+	Size          int64
+	SizeLabel     string // Size in human readable form, e.g. "1.2 MB"
+	DownloadCount int
+	CreatedAt     time.Time
+}
+
+// AttachmentType is a file extension that may be attached and its size limit
+type AttachmentType struct {
+	Ext          string // with the leading dot, e.g. ".pdf"
+	ContentType  string
+	MaxSize      int64
+	MaxSizeLabel string
+}
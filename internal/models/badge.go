@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// Badge is a badge a member earned, with how many times for repeatable ones
+type Badge struct {
+	Key         string
+	Name        string
+	Icon        string
+	Description string
+	Repeatable  bool
+	Count       int
+	AwardedAt   time.Time // first time it was awarded
+}
+
+// BadgeHolder is a member holding a badge on the badges page
+type BadgeHolder struct {
+	UserID    int
+	Username  string
+	Count     int
+	AwardedAt time.Time // last time it was awarded
+}
+
+// BadgeSummary is a badge on the badges page with everyone holding it
+type BadgeSummary struct {
+	Key         string
+	Name        string
+	Icon        string
+	Description string
+	Repeatable  bool
+	Holders     []BadgeHolder
+}
+
+type BadgesPageData struct {
+	CurrentUser *User
+	Badges      []BadgeSummary
+}
@@ -5,6 +5,7 @@ type Comment struct {
 	PostID          int
 	UserID          int
 	UserName        string
+	UserTrustLevel  int
 	Content         string
 	Likes           int
 	Dislikes        int
@@ -12,4 +13,22 @@ type Comment struct {
 	ParentCommentID int
 	Username        string
 	PostTitle       string
+	IsEdited        bool
+	IsDeleted       bool
+}
+
+// CommentNode is a comment placed in its thread, with its replies as children
+type CommentNode struct {
+	Comment
+	Depth          int
+	Path           string
+	UserReaction   string
+	CanModify      bool
+	CanReact       bool
+	CanReply       bool
+	CanSplit       bool // moderators can select the comment to split it into a new post
+	Children       []*CommentNode
+	Descendants    int
+	ContinueThread bool // replies exist below the depth limit and are shown on the thread page
+	Collapsed      bool
 }
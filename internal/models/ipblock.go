@@ -0,0 +1,24 @@
+package models
+
+// IPBlock is an IP address or network admins have blocked
+type IPBlock struct {
+	ID            int
+	Network       string // CIDR notation; single addresses are /32 or /128
+	Scope         string // registration, posting or all
+	Reason        string
+	CreatedByName string
+	CreatedAt     string
+	ExpiresAt     string // empty for blocks that never expire
+	Expired       bool
+}
+
+type IPBlocklistPageData struct {
+	Blocks      []IPBlock
+	Scopes      []string
+	CurrentUser *User
+	CanManage   bool // admins add and remove blocks; moderators look them up
+	Error       string
+	// Network looked up, and the accounts registered or last signed in there
+	Network  string
+	Accounts []User
+}
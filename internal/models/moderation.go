@@ -0,0 +1,17 @@
+package models
+
+// ModerationLogEntry records a moderator action on a thread
+type ModerationLogEntry struct {
+	ID            int
+	ModeratorName string
+	Action        string
+	PostID        int
+	TargetPostID  int
+	Details       string
+	CreatedAt     string
+}
+
+type ModerationLogPageData struct {
+	Entries     []ModerationLogEntry
+	CurrentUser *User
+}
@@ -14,4 +14,5 @@ type Notification struct {
 	ActorName string    `json:"actor_name"`
 	IsRead    bool      `json:"is_read"`
 	CreatedAt time.Time `json:"created_at"`
+	Message   string    `json:"message"` // a moderator's reason for approved and rejected
 }
@@ -17,9 +17,10 @@ type Post struct {
 }
 
 type CreatePostPageData struct {
-	Categories  []Category
-	CurrentUser *User
-	CSRFToken   string
+	Categories      []Category
+	CurrentUser     *User
+	CSRFToken       string
+	AttachmentTypes []AttachmentType
 }
 type UpdatePostPageData struct {
 	Post               PostView
@@ -28,6 +29,8 @@ type UpdatePostPageData struct {
 	CSRFToken          string
 	Tags               string
 	SelectedCategories map[int]bool
+	AttachmentTypes    []AttachmentType
+	CanSetWiki         bool // the author and moderators decide whether it is a wiki post
 }
 type Category struct {
 	ID   int
@@ -35,48 +38,75 @@ type Category struct {
 }
 
 type PostPageData struct {
-	Post             PostView
-	Comments         []CommentWithReaction
-	CurrentUser      *User
-	UserReaction     string
-	CommentReactions map[int]string
-	Tags             []string
-	CanModifyPost    bool
-	CanModifyComment map[int]bool
-	Replies          map[int][]Comment
-}
-
-type CommentWithReaction struct {
-	Comment      // embedding your Comment
-	UserReaction string
+	Post          PostView
+	Comments      []*CommentNode
+	CurrentUser   *User
+	UserReaction  string
+	Tags          []string
+	CanModifyPost bool
+	CanEditWiki   bool // the viewer may edit this wiki post, but not delete it
+	CommentSort   string
+	ThreadRootID  int // non-zero when a single thread is shown via ?thread=
+	CanModerate   bool
+	CategoryPins  []CategoryPin
+	Categories    []CategoryOption // every category, for the move form
+	Notice        string           // shown above the post, e.g. after a comment was held for review
+	HeldComments  HeldList         // comments awaiting review the viewer may see
 }
 
 // Struct for Post view
 type PostView struct {
-	ID            int
-	UserID        int
-	CurrentUser   *User
-	UserName      string
-	Title         string
-	Content       string
-	Likes         int
-	Dislikes      int
-	IsLiked       bool
-	CommentsCount int
-	Comments      []Comment
-	CreatedAt     string
-	UpdatedAt     string
-	IsEdited      bool
-	Categories    []string
-	Category      string
-	Image         sql.NullString
-	ImagePaths    []Image
-	Tags          []string
+	ID             int
+	UserID         int
+	CurrentUser    *User
+	UserName       string
+	UserTrustLevel int
+	Title          string
+	Content        string
+	Likes          int
+	Dislikes       int
+	IsLiked        bool
+	CommentsCount  int
+	Comments       []Comment
+	CreatedAt      string
+	UpdatedAt      string
+	IsEdited       bool
+	Categories     []string
+	Category       string
+	Image          sql.NullString
+	ImagePaths     []Image
+	Attachments    []Attachment
+	Tags           []string
+	IsDeleted      bool
+	IsPinned       bool
+	IsLocked       bool
+	IsAnnouncement bool
+	IsArchived     bool
+	MergedInto     int
+	IsWiki         bool // other members with enough trust may edit it
+}
+
+// CategoryOption is a category in a picker, marked when the post already has it
+type CategoryOption struct {
+	ID       int
+	Name     string
+	Selected bool
+}
+
+// CategoryPin is one of a post's categories and whether the post is pinned in it
+type CategoryPin struct {
+	ID     int
+	Name   string
+	Pinned bool
 }
 
 type Image struct {
-	ID        int
-	Path      string
-	IsPrimary bool
-	Order     int
+	ID          int
+	Path        string
+	ThumbSmall  string // falls back to Path for images uploaded before thumbnails existed
+	ThumbMedium string
+	IsPrimary   bool
+	Order       int
+	Caption     string
+	AltText     string
 }
@@ -0,0 +1,24 @@
+package models
+
+// Reputation is what a member's reputation is made of and the trust level
+// it earns them
+type Reputation struct {
+	Score            int
+	Level            int
+	LevelName        string
+	LikesReceived    int // likes others gave the member's posts and comments
+	DislikesReceived int
+	Comments         int // published comments
+	MonthsMember     int
+	Approved         int // submissions moderators approved
+	Rejected         int // submissions moderators rejected
+	Removed          int // posts and comments moderators deleted
+	NextLevelAt      int // reputation the next level needs, 0 at the top
+}
+
+// Capability is something a trust level unlocks, with the level it needs
+type Capability struct {
+	Name    string
+	Level   int
+	Allowed bool
+}
@@ -0,0 +1,44 @@
+package models
+
+// Revision is one stored version of a post or comment
+type Revision struct {
+	ID         int
+	Number     int // 1-based position in the history
+	EditorID   int
+	EditorName string
+	EditorRole string
+	Title      string // empty for comments
+	Content    string
+	Tags       string
+	Categories string
+	Reason     string
+	CreatedAt  string
+	// Set when the editor is not the author (moderator/admin edit)
+	IsModeratorEdit bool
+}
+
+// DiffOp is a single chunk of a diff: "equal", "insert" or "delete"
+type DiffOp struct {
+	Type string
+	Text string
+}
+
+// RevisionView pairs a revision with the diff against the previous one
+type RevisionView struct {
+	Revision
+	TitleDiff   []DiffOp
+	ContentDiff []DiffOp
+	IsCurrent   bool
+}
+
+type RevisionHistoryPageData struct {
+	Kind        string // "post" or "comment"
+	TargetID    int
+	PostID      int
+	Title       string
+	AuthorID    int
+	Mode        string // "lines" or "words"
+	Revisions   []RevisionView
+	CurrentUser *User
+	CanRollback bool
+}
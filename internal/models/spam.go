@@ -0,0 +1,60 @@
+package models
+
+// HeldContent is a post or comment held back for a moderator to review
+// instead of being published
+type HeldContent struct {
+	ID              int
+	Kind            string // post or comment
+	UserID          int
+	UserName        string
+	PostID          int    // post a comment is for
+	PostTitle       string // title of that post
+	ParentCommentID int
+	Title           string
+	Content         string
+	Payload         HeldPostPayload
+	Reasons         []string
+	SpamScore       float64 // classifier's spam probability
+	Scored          bool    // whether the classifier had a say
+	Status          string
+	DecisionReason  string // what the moderator told the author
+	CreatedAt       string
+}
+
+// SpamPercent is SpamScore as a percentage
+func (h HeldContent) SpamPercent() float64 {
+	return h.SpamScore * 100
+}
+
+// HeldList is held content shown outside the review queue: to its author,
+// and to moderators next to the post a comment is for
+type HeldList struct {
+	Heading    string
+	Items      []HeldContent
+	ShowPost   bool // link the post each comment is for
+	ShowAuthor bool
+}
+
+// HeldPostPayload is what a held post needs besides its text to be
+// created as submitted
+type HeldPostPayload struct {
+	CategoryIDs  []int        `json:"category_ids,omitempty"`
+	Tags         []string     `json:"tags,omitempty"`
+	Images       []string     `json:"images,omitempty"`
+	PrimaryImage int          `json:"primary_image,omitempty"`
+	Attachments  []Attachment `json:"attachments,omitempty"`
+	Wiki         bool         `json:"wiki,omitempty"`
+}
+
+// SpamTraining is how many decisions the spam classifier learned from
+type SpamTraining struct {
+	Spam int
+	Ham  int
+}
+
+type ReviewQueuePageData struct {
+	Items       []HeldContent
+	CurrentUser *User
+	Training    SpamTraining
+	MinTraining int // decisions of each kind before the classifier is used
+}
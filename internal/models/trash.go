@@ -0,0 +1,28 @@
+package models
+
+// TrashItem is a soft-deleted post or comment waiting for restore or purge
+type TrashItem struct {
+	Kind          string // "post" or "comment"
+	ID            int
+	PostID        int
+	Title         string // post title, or title of the post the comment belongs to
+	Content       string
+	AuthorName    string
+	DeletedByName string
+	Reason        string
+	DeletedAt     string
+	PurgeAfter    string
+}
+
+type TrashPageData struct {
+	Items         []TrashItem
+	CurrentUser   *User
+	RetentionDays int
+}
+
+// PurgeReport summarises one run of the trash purge
+type PurgeReport struct {
+	Posts    int
+	Comments int
+	Files    int // released to the upload GC
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// UploadGCEntry is an unreferenced file found by the upload garbage collector
+type UploadGCEntry struct {
+	Key           string
+	Size          int64
+	OrphanedSince time.Time
+	Expired       bool // past the grace period: deleted, or would be on a dry run
+}
+
+// UploadGCReport summarises one run of the upload garbage collector
+type UploadGCReport struct {
+	DryRun     bool
+	Grace      time.Duration
+	Scanned    int
+	Referenced int
+	Orphans    []UploadGCEntry
+	Deleted    int // files removed; always 0 on a dry run
+	BytesFreed int64
+}
+
+type UploadGCPageData struct {
+	Report      UploadGCReport
+	GraceHours  int
+	CurrentUser *User
+}
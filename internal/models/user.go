@@ -1,5 +1,7 @@
 package models
 
+import "log/slog"
+
 type NewUser struct {
 	ID           int
 	Username     string
@@ -10,10 +12,18 @@ type NewUser struct {
 	Role         string // guest, user, moderator, admin
 }
 
+// LogValue keeps the password hash and email out of structured logs
+func (u NewUser) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("id", u.ID), slog.String("username", u.Username), slog.String("role", u.Role))
+}
+
 type UserPageData struct {
 	Posts       []PostView
 	CurrentUser *User
 	Categories  []Category
+	Notice      string   // shown above the posts, e.g. after a post was held for review
+	Held        HeldList // the user's submissions awaiting review or recently rejected
+	Badges      []Badge
 }
 
 type User struct {
@@ -30,6 +40,16 @@ type User struct {
 	Dislikes     int
 	Banned       bool
 	Role         string // guest, user, moderator, admin
+	// Where the account was registered and last signed in from, for moderators
+	RegistrationIP string
+	LastLoginIP    string
+	Reputation     int
+	TrustLevel     int
+}
+
+// LogValue keeps the password hash, email and post lists out of structured logs
+func (u User) LogValue() slog.Value {
+	return slog.GroupValue(slog.Int("id", u.ID), slog.String("username", u.Username), slog.String("role", u.Role))
 }
 
 type ProfilePageData struct {
@@ -39,6 +59,9 @@ type ProfilePageData struct {
 	RequestError     string // show error if any
 	Notifications    []Notification
 	PostsWithComment []PostView
+	Reputation       Reputation
+	Capabilities     []Capability // what the member's trust level unlocks
+	Badges           []Badge
 }
 
 type LikedPosts struct {
@@ -0,0 +1,162 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is how much of the file is sent per INSTREAM chunk
+const clamdChunkSize = 64 << 10
+
+// Clamd scans files with a ClamAV daemon using the INSTREAM command, so the
+// daemon does not need access to the forum's files
+type Clamd struct {
+	Network string // "tcp" or "unix"
+	Address string
+	Timeout time.Duration
+}
+
+// NewClamd parses an address of the form tcp://host:port, unix:///path,
+// host:port or /path
+func NewClamd(address string, timeout time.Duration) (*Clamd, error) {
+	c := &Clamd{Network: "tcp", Address: address, Timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "tcp://"):
+		c.Address = strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "unix://"):
+		c.Network, c.Address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "/"):
+		c.Network = "unix"
+	}
+	if c.Address == "" {
+		return nil, fmt.Errorf("clamd address is empty")
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = DefaultTimeout
+	}
+	return c, nil
+}
+
+func (c *Clamd) dial(ctx context.Context) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, c.Network, c.Address)
+	if err != nil {
+		return nil, fmt.Errorf("connect to clamd: %w", err)
+	}
+	deadline := time.Now().Add(c.Timeout)
+	if dl, ok := ctx.Deadline(); ok && dl.Before(deadline) {
+		deadline = dl
+	}
+	conn.SetDeadline(deadline)
+	return conn, nil
+}
+
+// Ping checks that the daemon is reachable
+func (c *Clamd) Ping(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("send PING: %w", err)
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply %q", reply)
+	}
+	return nil
+}
+
+// Scan implements Scanner. The file is streamed to the daemon in chunks
+// followed by a zero length chunk.
+func (c *Clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return Result{}, err
+	}
+	defer conn.Close()
+
+	if err := sendStream(conn, r); err != nil {
+		// clamd closes the connection when the stream exceeds its size
+		// limit; its reply explains why
+		if reply, rerr := readReply(conn); rerr == nil && reply != "" {
+			return parseReply(reply)
+		}
+		return Result{}, err
+	}
+	reply, err := readReply(conn)
+	if err != nil {
+		return Result{}, err
+	}
+	return parseReply(reply)
+}
+
+func sendStream(conn net.Conn, r io.Reader) error {
+	w := bufio.NewWriter(conn)
+	if _, err := w.WriteString("zINSTREAM\x00"); err != nil {
+		return fmt.Errorf("send INSTREAM: %w", err)
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	var size [4]byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size[:], uint32(n))
+			w.Write(size[:])
+			if _, werr := w.Write(buf[:n]); werr != nil {
+				return fmt.Errorf("send chunk: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read file: %w", err)
+		}
+	}
+
+	binary.BigEndian.PutUint32(size[:], 0)
+	w.Write(size[:])
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("send stream: %w", err)
+	}
+	return nil
+}
+
+// readReply reads a NUL terminated reply, as requested by the z prefix
+func readReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("read clamd reply: %w", err)
+	}
+	return string(bytes.TrimSpace(bytes.TrimRight(reply, "\x00"))), nil
+}
+
+// parseReply interprets replies such as "stream: OK" and
+// "stream: Eicar-Signature FOUND"
+func parseReply(reply string) (Result, error) {
+	status := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case status == "OK":
+		return Result{Clean: true}, nil
+	case strings.HasSuffix(status, " FOUND"):
+		return Result{Signature: strings.TrimSuffix(status, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,49 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"forum/internal/config"
+	"io"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds a single scan when no timeout is configured
+const DefaultTimeout = 30 * time.Second
+
+// Result is the verdict on one file
+type Result struct {
+	Clean     bool
+	Signature string // what was detected when the file is not clean
+}
+
+// Scanner checks uploaded files for malware. An error means the file could
+// not be checked, which is not the same as it being clean.
+type Scanner interface {
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// Nop accepts every file. It is used when no scanner is configured.
+type Nop struct{}
+
+// Scan implements Scanner
+func (Nop) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	return Result{Clean: true}, nil
+}
+
+// Open builds the scanner selected by cfg.Backend ("none" or "clamd")
+func Open(cfg config.Scanner) (Scanner, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", "none":
+		return Nop{}, nil
+	case "clamd":
+		timeout := cfg.ClamdTimeout
+		if timeout <= 0 {
+			timeout = DefaultTimeout
+		}
+		return NewClamd(cfg.ClamdAddress, timeout)
+	default:
+		return nil, fmt.Errorf("unknown scanner backend %q", cfg.Backend)
+	}
+}
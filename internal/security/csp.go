@@ -0,0 +1,29 @@
+package security
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+)
+
+type nonceKey struct{}
+
+// NewNonce returns a fresh random value for a Content-Security-Policy nonce,
+// in URL-safe base64 so html/template leaves it unescaped in attributes
+func NewNonce() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// WithCSPNonce returns a context carrying the nonce of the request's policy
+func WithCSPNonce(ctx context.Context, nonce string) context.Context {
+	return context.WithValue(ctx, nonceKey{}, nonce)
+}
+
+// CSPNonce is the nonce scripts and styles of the page rendered for ctx must
+// carry, or "" outside the security headers middleware
+func CSPNonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceKey{}).(string)
+	return nonce
+}
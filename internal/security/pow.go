@@ -0,0 +1,121 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"math/bits"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Reasons a proof-of-work solution is refused
+var (
+	ErrChallengeInvalid  = errors.New("challenge is not one the forum issued")
+	ErrChallengeExpired  = errors.New("challenge has expired")
+	ErrChallengeUnsolved = errors.New("challenge is not solved")
+)
+
+// Challenge is a proof-of-work puzzle: a client solves it by finding a nonce
+// for which SHA-256 of "token:nonce" starts with Difficulty zero bits. The
+// token carries the parameters, signed, so the forum keeps no state until a
+// solution comes back.
+type Challenge struct {
+	Token      string    `json:"token"`
+	Difficulty int       `json:"difficulty"`
+	Expires    time.Time `json:"expires"`
+}
+
+// SolvedChallenge is what a valid solution proves
+type SolvedChallenge struct {
+	// ID is unique per challenge, for refusing a solution used twice
+	ID         string
+	Form       string
+	Difficulty int
+	Expires    time.Time
+}
+
+// powKey signs challenges. It is derived from the session secret so the
+// two signatures cannot be swapped for one another.
+func powKey() []byte {
+	h := hmac.New(sha256.New, hmacSecret)
+	h.Write([]byte("proof-of-work"))
+	return h.Sum(nil)
+}
+
+func signChallenge(payload string) string {
+	h := hmac.New(sha256.New, powKey())
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// NewChallenge issues a challenge for form, solvable until now+ttl
+func NewChallenge(form string, difficulty int, ttl time.Duration, now time.Time) Challenge {
+	id := make([]byte, 16)
+	rand.Read(id)
+	expires := now.Add(ttl).Truncate(time.Second)
+	payload := strings.Join([]string{
+		hex.EncodeToString(id), form, strconv.Itoa(difficulty), strconv.FormatInt(expires.Unix(), 10),
+	}, "|")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return Challenge{
+		Token:      encoded + "." + signChallenge(encoded),
+		Difficulty: difficulty,
+		Expires:    expires,
+	}
+}
+
+// VerifyChallenge checks that nonce solves token, a challenge issued for form
+// that has not expired at now. It does not know whether the solution was
+// used before; callers remember SolvedChallenge.ID for that.
+func VerifyChallenge(token, nonce, form string, now time.Time) (SolvedChallenge, error) {
+	encoded, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(signChallenge(encoded))) {
+		return SolvedChallenge{}, ErrChallengeInvalid
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return SolvedChallenge{}, ErrChallengeInvalid
+	}
+	fields := strings.Split(string(payload), "|")
+	if len(fields) != 4 || fields[1] != form {
+		return SolvedChallenge{}, ErrChallengeInvalid
+	}
+	difficulty, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return SolvedChallenge{}, ErrChallengeInvalid
+	}
+	expiresUnix, err := strconv.ParseInt(fields[3], 10, 64)
+	if err != nil {
+		return SolvedChallenge{}, ErrChallengeInvalid
+	}
+	solved := SolvedChallenge{ID: fields[0], Form: form, Difficulty: difficulty, Expires: time.Unix(expiresUnix, 0)}
+
+	if !now.Before(solved.Expires) {
+		return SolvedChallenge{}, ErrChallengeExpired
+	}
+	if nonce == "" || len(nonce) > 20 {
+		return SolvedChallenge{}, ErrChallengeUnsolved
+	}
+	sum := sha256.Sum256([]byte(token + ":" + nonce))
+	if LeadingZeroBits(sum[:]) < difficulty {
+		return SolvedChallenge{}, ErrChallengeUnsolved
+	}
+	return solved, nil
+}
+
+// LeadingZeroBits counts the zero bits b starts with
+func LeadingZeroBits(b []byte) int {
+	n := 0
+	for _, c := range b {
+		if c != 0 {
+			return n + bits.LeadingZeros8(c)
+		}
+		n += 8
+	}
+	return n
+}
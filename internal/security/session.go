@@ -4,17 +4,27 @@ import (
 	"database/sql"
 	"fmt"
 	"github.com/google/uuid"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 )
 
-var isProduction = os.Getenv("APP_ENV") == "production" // automatic environment detection
+// secureCookies marks cookies Secure so browsers only send them over HTTPS
+var secureCookies bool
+
+// SetSecureCookies is called at startup; production sets it
+func SetSecureCookies(secure bool) {
+	secureCookies = secure
+}
+
+// SecureCookies reports whether cookies are marked Secure
+func SecureCookies() bool {
+	return secureCookies
+}
 
 func CreateSession(w http.ResponseWriter, userID int, db *sql.DB) error {
-	log.Printf("Processing CreateSession, userID: %d:", userID)
+	slog.Debug("creating session", "user_id", userID)
 	const maxRetries = 3
 	var lastErr error
 
@@ -65,19 +75,13 @@ func tryCreateSession(w http.ResponseWriter, userID int, db *sql.DB) error {
 		return fmt.Errorf("failed to commit transaction: %v", err)
 	}
 
-	SetSessionCookie(w, sessionID, expiresAt, isProduction)
+	SetSessionCookie(w, sessionID, expiresAt, secureCookies)
 	return nil
 }
 
 func ValidateSession(db *sql.DB, r *http.Request) (int, error) {
 	cookie, err := r.Cookie("session_id")
 
-	if err != nil {
-		log.Printf("ValidateSession: no session cookie found")
-		return 0, fmt.Errorf("session cookie not found")
-	}
-	log.Printf("ValidateSession: cookie value: %s", cookie.Value)
-
 	if err != nil {
 		return 0, fmt.Errorf("session cookie not found")
 	}
@@ -86,7 +90,6 @@ func ValidateSession(db *sql.DB, r *http.Request) (int, error) {
 	if !ok {
 		return 0, fmt.Errorf("invalid cookie signature")
 	}
-	log.Printf("Processing ValidateSession, session_id: %s,", sessionID)
 	var userID int
 	var expiresAt time.Time
 	err = db.QueryRow(
@@ -132,7 +135,7 @@ func DestroySession(w http.ResponseWriter, r *http.Request, db *sql.DB) error {
 		Path:     "/",
 		MaxAge:   -1,
 		HttpOnly: true,
-		Secure:   isProduction,
+		Secure:   secureCookies,
 		SameSite: http.SameSiteStrictMode,
 	})
 
@@ -6,17 +6,16 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"log"
-	"os"
 	"strings"
 )
 
 var hmacSecret []byte
 
-// InitHMACSecret must be called during app startup
-func InitHMACSecret() {
-	secret := os.Getenv("SESSION_HMAC_SECRET")
+// InitHMACSecret must be called during app startup with the key session IDs
+// are signed with
+func InitHMACSecret(secret string) {
 	if secret == "" {
-		log.Fatal("❌ SESSION_HMAC_SECRET is not set")
+		log.Fatal("❌ session HMAC secret is empty")
 	}
 	hmacSecret = []byte(secret)
 }
@@ -1,19 +1,24 @@
 package security
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
+	"forum/internal/config"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
 	"golang.org/x/crypto/acme/autocert"
 )
 
-func SetupTLS(domain string) *tls.Config {
-	certManager := autocert.Manager{
-		Prompt:     autocert.AcceptTOS,
-		HostPolicy: autocert.HostWhitelist(domain),
-		Cache:      autocert.DirCache("certs"),
-	}
-
+// TLSConfig returns the server TLS settings shared by every mode, with
+// certificates taken from getCertificate
+func TLSConfig(getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error)) *tls.Config {
 	return &tls.Config{
-		GetCertificate: certManager.GetCertificate,
+		GetCertificate: getCertificate,
 		MinVersion:     tls.VersionTLS12,
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
@@ -23,5 +28,115 @@ func SetupTLS(domain string) *tls.Config {
 			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
 		},
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+}
+
+// NewAutocertManager returns the ACME certificate manager for cfg. Setting
+// ACMEDirectory points it at another CA, such as a local Pebble instance.
+func NewAutocertManager(cfg config.TLS) *autocert.Manager {
+	m := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(cfg.DomainList()...),
+		Cache:      autocert.DirCache(cfg.CacheDir),
+		Email:      cfg.ACMEEmail,
+	}
+	if cfg.ACMEDirectory != "" {
+		m.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectory}
+	}
+	return m
+}
+
+// AutocertTLSConfig is TLSConfig for an autocert manager; it also answers
+// tls-alpn-01 challenges, so no plain HTTP listener is strictly needed
+func AutocertTLSConfig(m *autocert.Manager) *tls.Config {
+	cfg := TLSConfig(m.GetCertificate)
+	cfg.NextProtos = append(cfg.NextProtos, acme.ALPNProto)
+	return cfg
+}
+
+// CertReloader serves a certificate from files and picks up replacements,
+// e.g. from certbot, without a restart
+type CertReloader struct {
+	certFile, keyFile string
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// NewCertReloader loads the key pair once; it fails when the files are
+// missing or do not match
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate is used as tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload loads the files again when either changed since the last load. A
+// broken replacement is reported and the current certificate kept.
+func (r *CertReloader) Reload() (bool, error) {
+	modified, err := latestModTime(r.certFile, r.keyFile)
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modified.Equal(r.modified)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("load TLS key pair: %w", err)
+	}
+	r.mu.Lock()
+	r.cert, r.modified = &cert, modified
+	r.mu.Unlock()
+	return true, nil
+}
+
+// Run checks the files every interval until ctx is done
+func (r *CertReloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, err := r.Reload()
+		switch {
+		case err != nil:
+			slog.Error("TLS certificate reload failed, keeping the current one", "err", err)
+		case changed:
+			slog.Info("TLS certificate reloaded", "cert", r.certFile)
+		}
+	}
+}
+
+func latestModTime(paths ...string) (time.Time, error) {
+	var latest time.Time
+	for _, p := range paths {
+		info, err := os.Stat(p)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
 	}
+	return latest, nil
 }
@@ -0,0 +1,155 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"forum/internal/config"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrNotFound is returned when a blob does not exist in the store
+var ErrNotFound = errors.New("blob not found")
+
+// BlobInfo describes a stored blob
+type BlobInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// BlobStore keeps uploaded files. Keys are slash separated and relative to
+// the store root, e.g. "1700000000.jpg".
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	List(ctx context.Context, prefix string) ([]BlobInfo, error)
+}
+
+// URLSigner is implemented by stores whose blobs are not served by the forum
+// itself; clients are redirected to a URL that expires after the given time.
+type URLSigner interface {
+	SignedURL(key string, expiry time.Duration) (string, error)
+}
+
+// CleanKey validates a key and returns it in canonical form. Keys may not
+// escape the store root.
+func CleanKey(key string) (string, error) {
+	key = strings.TrimPrefix(path.Clean("/"+strings.ReplaceAll(key, "\\", "/")), "/")
+	if key == "" || key == "." {
+		return "", fmt.Errorf("invalid blob key")
+	}
+	return key, nil
+}
+
+// Open builds the store selected by cfg.Backend ("local" or "s3"). The
+// local store defaults to static/uploads next to the executable; S3 needs
+// an endpoint, bucket and credentials.
+func Open(cfg config.Storage) (BlobStore, error) {
+	switch strings.ToLower(strings.TrimSpace(cfg.Backend)) {
+	case "", "local":
+		root := cfg.LocalDir
+		if root == "" {
+			root = DefaultLocalRoot()
+		}
+		return NewLocalStore(root)
+	case "s3":
+		return NewS3Store(S3Config{
+			Endpoint:  cfg.S3Endpoint,
+			Bucket:    cfg.S3Bucket,
+			Region:    cfg.S3Region,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey.Value(),
+			PathStyle: cfg.S3PathStyle,
+		})
+	default:
+		return nil, fmt.Errorf("unknown blob backend %q", cfg.Backend)
+	}
+}
+
+// DefaultLocalRoot is the directory the local store writes to when no
+// directory is configured
+func DefaultLocalRoot() string {
+	exe, _ := os.Executable()
+	return filepath.Join(filepath.Dir(exe), "static", "uploads")
+}
+
+// CopyResult counts what Copy did
+type CopyResult struct {
+	Copied  int
+	Skipped int
+}
+
+// Copy transfers every blob from src to dst. Blobs that already exist in dst
+// are skipped unless overwrite is set. With dryRun nothing is written.
+func Copy(ctx context.Context, src, dst BlobStore, overwrite, dryRun bool, logf func(format string, args ...any)) (CopyResult, error) {
+	var res CopyResult
+	blobs, err := src.List(ctx, "")
+	if err != nil {
+		return res, fmt.Errorf("list source: %w", err)
+	}
+
+	for _, blob := range blobs {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+		if !overwrite {
+			exists, err := dst.Exists(ctx, blob.Key)
+			if err != nil {
+				return res, fmt.Errorf("check %s: %w", blob.Key, err)
+			}
+			if exists {
+				res.Skipped++
+				continue
+			}
+		}
+		if logf != nil {
+			logf("copy %s (%d bytes)", blob.Key, blob.Size)
+		}
+		if dryRun {
+			res.Copied++
+			continue
+		}
+		if err := copyBlob(ctx, src, dst, blob); err != nil {
+			return res, fmt.Errorf("copy %s: %w", blob.Key, err)
+		}
+		res.Copied++
+	}
+	return res, nil
+}
+
+func copyBlob(ctx context.Context, src, dst BlobStore, blob BlobInfo) error {
+	r, err := src.Get(ctx, blob.Key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return dst.Put(ctx, blob.Key, r, blob.Size, ContentType(blob.Key))
+}
+
+// ContentType guesses the MIME type of an upload from its key
+func ContentType(key string) string {
+	switch strings.ToLower(path.Ext(key)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".gif":
+		return "image/gif"
+	case ".webp":
+		return "image/webp"
+	case ".svg":
+		return "image/svg+xml"
+	case ".pdf":
+		return "application/pdf"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,267 @@
+package test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	forumerrors "forum/internal"
+	"forum/internal/config"
+	"forum/internal/handlers"
+	"forum/internal/models"
+	"forum/internal/scanner"
+	"forum/internal/utils"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+// fakeClamd speaks enough of the clamd protocol for PING and INSTREAM and
+// reports the EICAR test string as infected
+func fakeClamd(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				cmd, err := r.ReadString(0)
+				if err != nil {
+					return
+				}
+				switch cmd {
+				case "zPING\x00":
+					conn.Write([]byte("PONG\x00"))
+				case "zINSTREAM\x00":
+					var data []byte
+					for {
+						var size uint32
+						if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+							return
+						}
+						if size == 0 {
+							break
+						}
+						chunk := make([]byte, size)
+						if _, err := io.ReadFull(r, chunk); err != nil {
+							return
+						}
+						data = append(data, chunk...)
+					}
+					if bytes.Contains(data, []byte("EICAR-STANDARD-ANTIVIRUS-TEST-FILE")) {
+						conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					} else {
+						conn.Write([]byte("stream: OK\x00"))
+					}
+				default:
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+				}
+			}(conn)
+		}
+	}()
+	return "tcp://" + ln.Addr().String()
+}
+
+func TestClamdScanner(t *testing.T) {
+	clamd, err := scanner.NewClamd(fakeClamd(t), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	if err := clamd.Ping(ctx); err != nil {
+		t.Fatalf("Ping returned error: %v", err)
+	}
+
+	// Larger than one chunk, to exercise the chunked stream
+	clean, err := clamd.Scan(ctx, strings.NewReader(strings.Repeat("hello world\n", 20000)))
+	if err != nil || !clean.Clean {
+		t.Errorf("expected a clean result, got %+v, %v", clean, err)
+	}
+
+	infected, err := clamd.Scan(ctx, strings.NewReader(eicar))
+	if err != nil || infected.Clean || infected.Signature != "Eicar-Test-Signature" {
+		t.Errorf("expected the EICAR signature, got %+v, %v", infected, err)
+	}
+
+	unreachable, _ := scanner.NewClamd("127.0.0.1:1", time.Second)
+	if _, err := unreachable.Scan(ctx, strings.NewReader("x")); err == nil {
+		t.Error("expected an error when clamd is not reachable")
+	}
+}
+
+// useFakeClamd makes attachments go through a fake clamd for one test
+func useFakeClamd(t *testing.T) {
+	clamd, err := scanner.NewClamd(fakeClamd(t), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.SetAttachmentScanner(clamd)
+	t.Cleanup(func() { utils.SetAttachmentScanner(nil) })
+}
+
+// testAttachmentTypes parses spec or fails the test
+func testAttachmentTypes(t *testing.T, spec string) []models.AttachmentType {
+	types, err := utils.ParseAttachmentTypes(spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return types
+}
+
+func saveTestAttachment(t *testing.T, types []models.AttachmentType, name string, content []byte) (models.Attachment, error) {
+	file, header := createTestFile(t, name, "application/octet-stream", content)
+	defer file.Close()
+	return utils.SaveAttachment(context.Background(), file, header, types)
+}
+
+func TestSaveAttachment(t *testing.T) {
+	store := useTempUploadStore(t)
+	useFakeClamd(t)
+	types := testAttachmentTypes(t, "txt:0.001,pdf:1")
+
+	a, err := saveTestAttachment(t, types, `C:\logs\build "v2".txt`, []byte("build failed\n"))
+	if err != nil {
+		t.Fatalf("SaveAttachment returned error: %v", err)
+	}
+	if a.Name != "build v2.txt" || a.ContentType != "text/plain; charset=utf-8" || a.Size != 13 {
+		t.Errorf("unexpected attachment %+v", a)
+	}
+	if !strings.HasPrefix(a.Path, "/static/uploads/attachments/") || !uploadExists(t, store, a.Path) {
+		t.Errorf("attachment not stored: %s", a.Path)
+	}
+
+	rejected := map[string][]byte{
+		"setup.exe":    []byte("MZ"),                    // not allowed, and the forum could not check it
+		"notes.docx":   []byte("PK\x03\x04"),            // not allowed
+		"big.txt":      bytes.Repeat([]byte("a"), 1100), // over the 0.001 MB limit
+		"fake.pdf":     []byte("<html>not a pdf</html>"),
+		"binary.txt":   {0x00, 0x01, 0x02},
+		"infected.txt": []byte(eicar),
+	}
+	for name, content := range rejected {
+		if _, err := saveTestAttachment(t, types, name, content); !errors.Is(err, utils.ErrAttachmentRejected) {
+			t.Errorf("%s: expected ErrAttachmentRejected, got %v", name, err)
+		}
+	}
+
+	// A scanner that cannot be reached refuses the file without blaming it
+	unreachable, _ := scanner.NewClamd("127.0.0.1:1", time.Second)
+	utils.SetAttachmentScanner(unreachable)
+	_, err = saveTestAttachment(t, types, "other.txt", []byte("hi"))
+	if err == nil || errors.Is(err, utils.ErrAttachmentRejected) {
+		t.Errorf("expected a scan error, got %v", err)
+	}
+}
+
+func TestParseAttachmentTypes(t *testing.T) {
+	types, err := utils.ParseAttachmentTypes("PDF:2, .zip:0.5,")
+	if err != nil || len(types) != 2 || types[0].Ext != ".pdf" || types[0].MaxSize != 2<<20 || types[1].Ext != ".zip" || types[1].MaxSizeLabel != "512.0 KB" {
+		t.Errorf("unexpected attachment types %+v, %v", types, err)
+	}
+
+	// Mistakes are reported instead of silently dropping the entry
+	for _, spec := range []string{"docx:1", "txt:abc", "txt:0", "txt", "pdf:2,pdf:9"} {
+		if _, err := utils.ParseAttachmentTypes(spec); err == nil {
+			t.Errorf("%q: expected an error", spec)
+		}
+	}
+}
+
+func TestDownloadAttachment(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	store := useTempUploadStore(t)
+	ctx := context.Background()
+	forumerrors.Init(getTemplatePath())
+
+	a, err := saveTestAttachment(t, testAttachmentTypes(t, config.Defaults().Uploads.AttachmentTypes), "отчёт.txt", []byte("all good\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.AddPostAttachments(ctx, db, 1, 1, []models.Attachment{a}); err != nil {
+		t.Fatal(err)
+	}
+	attachments, err := utils.GetPostAttachments(db, 1)
+	if err != nil || len(attachments) != 1 {
+		t.Fatalf("expected one attachment, got %+v, %v", attachments, err)
+	}
+	url := "/attachments/" + strconv.Itoa(attachments[0].ID)
+
+	download := func(method string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handlers.DownloadAttachment(db)(rr, httptest.NewRequest(method, url, nil))
+		return rr
+	}
+
+	rr := download(http.MethodHead)
+	if rr.Code != http.StatusOK || rr.Body.Len() != 0 {
+		t.Errorf("unexpected HEAD response %d", rr.Code)
+	}
+	rr = download(http.MethodGet)
+	if rr.Code != http.StatusOK || rr.Body.String() != "all good\n" {
+		t.Fatalf("unexpected response %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Disposition"); got != "attachment; filename*=utf-8''%D0%BE%D1%82%D1%87%D1%91%D1%82.txt" {
+		t.Errorf("unexpected Content-Disposition %q", got)
+	}
+	if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+		t.Error("expected nosniff")
+	}
+	a, _ = utils.GetAttachment(db, attachments[0].ID)
+	if a.DownloadCount != 1 {
+		t.Errorf("expected 1 download (HEAD does not count), got %d", a.DownloadCount)
+	}
+
+	// The file cannot be opened inline through the uploads handler
+	rr = httptest.NewRecorder()
+	handlers.ServeUploads(time.Minute)(rr, httptest.NewRequest(http.MethodGet, a.Path, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected attachments to be hidden from /static/uploads/, got %d", rr.Code)
+	}
+
+	// Attachments of deleted posts are hidden from everyone but moderators
+	db.Exec("UPDATE posts SET deleted_at = CURRENT_TIMESTAMP WHERE id = 1")
+	if rr := download(http.MethodGet); rr.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for a deleted post, got %d", rr.Code)
+	}
+	db.Exec("UPDATE posts SET deleted_at = NULL WHERE id = 1")
+
+	// Attachments count as references until they are removed
+	report, err := utils.CollectUploadGarbage(ctx, db, store, 0, true)
+	if err != nil || len(report.Orphans) != 0 {
+		t.Errorf("attachment reported as orphan: %+v, %v", report.Orphans, err)
+	}
+	if err := utils.RemovePostAttachments(ctx, db, 2, []int{a.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if !uploadExists(t, store, a.Path) {
+		t.Error("an attachment of another post must not be removed")
+	}
+	if err := utils.RemovePostAttachments(ctx, db, 1, []int{a.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.CollectUploadGarbage(ctx, db, store, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if uploadExists(t, store, a.Path) {
+		t.Error("expected the file to be released with its last attachment")
+	}
+}
@@ -0,0 +1,216 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"forum/internal/handlers"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stripedPNG is a wide image with red, green and blue vertical thirds
+func stripedPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= w/3 {
+				c = color.RGBA{0, 255, 0, 255}
+			}
+			if x >= 2*w/3 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func twoFrameGIF(t *testing.T) []byte {
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{Delay: []int{10, 10}}
+	for i := 0; i < 2; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 60, 40), pal)
+		for p := range frame.Pix {
+			frame.Pix[p] = uint8(i)
+		}
+		g.Image = append(g.Image, frame)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessAvatar_CropsAndResizes(t *testing.T) {
+	avatar, err := utils.ProcessAvatar(bytes.NewReader(stripedPNG(t, 300, 100)), false)
+	if err != nil {
+		t.Fatalf("ProcessAvatar returned error: %v", err)
+	}
+	if len(avatar.Sizes) != len(utils.AvatarSizes) {
+		t.Fatalf("expected %d sizes, got %d", len(utils.AvatarSizes), len(avatar.Sizes))
+	}
+	for _, size := range utils.AvatarSizes {
+		img, err := png.Decode(bytes.NewReader(avatar.Sizes[size]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if img.Bounds().Dx() != size || img.Bounds().Dy() != size {
+			t.Errorf("expected %dx%d, got %v", size, size, img.Bounds())
+		}
+		// The centered square only covers the green middle third
+		for _, x := range []int{0, size / 2, size - 1} {
+			r, g, b, _ := img.At(x, size/2).RGBA()
+			if r != 0 || b != 0 || g == 0 {
+				t.Errorf("size %d: expected green at x=%d, got %d,%d,%d", size, x, r>>8, g>>8, b>>8)
+			}
+		}
+	}
+}
+
+func TestProcessAvatar_AnimatedGIF(t *testing.T) {
+	data := twoFrameGIF(t)
+
+	flat, err := utils.ProcessAvatar(bytes.NewReader(data), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if flat.Format != "png" {
+		t.Errorf("expected a flattened PNG, got %s", flat.Format)
+	}
+
+	anim, err := utils.ProcessAvatar(bytes.NewReader(data), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(anim.Sizes[64]))
+	if err != nil {
+		t.Fatalf("expected an animated GIF: %v", err)
+	}
+	if len(g.Image) != 2 || g.Config.Width != 64 || g.Config.Height != 64 {
+		t.Errorf("expected 2 frames of 64x64, got %d frames of %dx%d", len(g.Image), g.Config.Width, g.Config.Height)
+	}
+}
+
+func TestProcessAvatar_LongGIFIsFlattened(t *testing.T) {
+	avatar, err := utils.ProcessAvatar(bytes.NewReader(gifWithFrames(t, utils.MaxAvatarFrames+1)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if avatar.Format != "png" {
+		t.Errorf("expected an animation over the frame limit to be flattened, got %s", avatar.Format)
+	}
+}
+
+func TestIdenticon(t *testing.T) {
+	a1, err := utils.Identicon("user:1", 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a2, _ := utils.Identicon("user:1", 64)
+	b, _ := utils.Identicon("user:2", 64)
+	if !bytes.Equal(a1, a2) {
+		t.Error("identicons must be deterministic")
+	}
+	if bytes.Equal(a1, b) {
+		t.Error("different users should get different identicons")
+	}
+
+	// Requested sizes are rounded up to a stored avatar size
+	cfg, err := png.DecodeConfig(bytes.NewReader(mustIdenticon(t, "user:1", 50)))
+	if err != nil || cfg.Width != 64 || cfg.Height != 64 {
+		t.Errorf("expected a 64x64 PNG, got %+v, %v", cfg, err)
+	}
+
+	rr := httptest.NewRecorder()
+	handlers.IdenticonHandler()(rr, httptest.NewRequest(http.MethodGet, "/identicon/1.png?size=64", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" || !bytes.Equal(rr.Body.Bytes(), a1) {
+		t.Errorf("unexpected identicon response %d %s", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func mustIdenticon(t *testing.T, seed string, size int) []byte {
+	data, err := utils.Identicon(seed, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAvatarURL(t *testing.T) {
+	tests := []struct {
+		path string
+		size int
+		want string
+	}{
+		{"", 64, "/identicon/7.png?size=64"},
+		{utils.DefaultAvatarPath, 20, "/identicon/7.png?size=32"},
+		{"/static/uploads/avatars/abc.png", 48, "/static/uploads/avatars/abc_64.png"},
+		{"/static/uploads/avatars/abc.png", 500, "/static/uploads/avatars/abc.png"},
+		{"/static/uploads/legacy.jpg", 64, "/static/uploads/legacy.jpg"},
+		{"https://avatars.example.com/u/7", 64, "https://avatars.example.com/u/7"},
+	}
+	for _, tt := range tests {
+		if got := utils.AvatarURL(models.User{ID: 7, AvatarPath: tt.path}, tt.size); got != tt.want {
+			t.Errorf("AvatarURL(%q, %d) = %q, want %q", tt.path, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestSaveAvatar_StoresEverySize(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	store := useTempUploadStore(t)
+	ctx := context.Background()
+
+	file, header := createTestFile(t, "me.png", "image/png", stripedPNG(t, 90, 60))
+	defer file.Close()
+	path, err := utils.SaveAvatar(context.Background(), file, header, false)
+	if err != nil {
+		t.Fatalf("SaveAvatar returned error: %v", err)
+	}
+	if !strings.HasPrefix(path, "/static/uploads/avatars/") {
+		t.Fatalf("unexpected avatar path %s", path)
+	}
+	for _, size := range utils.AvatarSizes {
+		if !uploadExists(t, store, utils.AvatarSizePath(path, size)) {
+			t.Errorf("missing %dpx avatar", size)
+		}
+	}
+
+	// Every size counts as referenced while the user has the avatar
+	db.Exec("UPDATE users SET avatar_url = ? WHERE id = 1", path)
+	report, err := utils.CollectUploadGarbage(ctx, db, store, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 0 {
+		t.Errorf("avatar sizes reported as orphans: %+v", report.Orphans)
+	}
+
+	// Replacing the avatar releases every size of the old one
+	db.Exec("UPDATE users SET avatar_url = NULL WHERE id = 1")
+	if removed, err := utils.ReleaseUpload(ctx, db, path); err != nil || !removed {
+		t.Fatalf("expected the avatar to be released, got %v, %v", removed, err)
+	}
+	if _, err := utils.CollectUploadGarbage(ctx, db, store, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	blobs, _ := store.List(ctx, "")
+	if len(blobs) != 0 {
+		t.Errorf("expected every size to be removed, %d files left", len(blobs))
+	}
+}
@@ -0,0 +1,107 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"forum/internal/handlers"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAwardBadges(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Both seeded members have published a post and a comment before there
+	// were badges: the first run awards them without notifying anyone
+	n, err := utils.AwardBadges(ctx, db)
+	if err != nil || n != 4 {
+		t.Fatalf("expected 4 badges, got %d, %v", n, err)
+	}
+	badgeNotifications := func(userID int, message string) int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM notifications WHERE type = 'badge' AND user_id = ? AND message LIKE ?", userID, message).Scan(&n)
+		return n
+	}
+	if n := badgeNotifications(1, "%"); n != 0 {
+		t.Errorf("expected backfilled badges not to be notified, got %d", n)
+	}
+	// Badges earned once are not awarded again
+	if n, _ := utils.AwardBadges(ctx, db); n != 0 {
+		t.Errorf("expected nothing new, got %d", n)
+	}
+
+	// Repeatable badges are awarded for every comment and year that earns them
+	for i := 3; i <= 7; i++ {
+		db.Exec("INSERT INTO users (id, username, email, password) VALUES (?, ?, ?, 'password')", i, fmt.Sprintf("fan%d", i), fmt.Sprintf("fan%d@example.com", i))
+		db.Exec("INSERT INTO likes (user_id, comment_id, reaction) VALUES (?, 1, 'Like'), (?, 2, 'Like')", i, i)
+	}
+	db.Exec("UPDATE users SET created_at = DATETIME('now', '-2 years', '-1 day') WHERE id = 1")
+	db.Exec("UPDATE users SET banned = 1 WHERE id = 2")
+	db.Exec("INSERT INTO posts (user_id, title, content) VALUES (3, 'Hi', 'New here')")
+	if _, err := utils.AwardBadges(ctx, db); err != nil {
+		t.Fatal(err)
+	}
+	// New badges are notified, once per badge however many times it was awarded
+	if badgeNotifications(3, "First Post") != 1 {
+		t.Error("expected a new member to be notified of their first post")
+	}
+	if badgeNotifications(1, "Anniversary") != 1 || badgeNotifications(1, "Helpful Commenter") != 1 {
+		t.Errorf("expected alice to be notified once per new badge, got %d", badgeNotifications(1, "%"))
+	}
+
+	badges, err := utils.GetUserBadges(ctx, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := make(map[string]int)
+	for _, b := range badges {
+		counts[b.Key] = b.Count
+	}
+	if counts["helpful_commenter"] != 1 || counts["anniversary"] != 2 || counts["first_post"] != 1 {
+		t.Errorf("unexpected badges of alice %v", counts)
+	}
+	// Bob is banned, so his liked comment earns him nothing
+	if badges, _ := utils.GetUserBadges(ctx, db, 2); len(badges) != 2 {
+		t.Errorf("expected a banned member to earn nothing new, got %+v", badges)
+	}
+
+	summaries, err := utils.GetBadgeSummaries(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range summaries {
+		if s.Key == "anniversary" && (len(s.Holders) != 1 || s.Holders[0].Username != "alice" || s.Holders[0].Count != 2) {
+			t.Errorf("unexpected anniversary holders %+v", s.Holders)
+		}
+		if s.Key == "first_comment" && len(s.Holders) != 2 {
+			t.Errorf("expected both members to hold first comment, got %+v", s.Holders)
+		}
+	}
+}
+
+func TestBadgesPage(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	if err := utils.InitTemplates(filepath.Dir(getTemplatePath()), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := utils.AwardBadges(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handlers.BadgesHandler(db)(rr, httptest.NewRequest(http.MethodGet, "/badges", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected the badges page, got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, "Helpful Commenter") || !strings.Contains(body, "alice") {
+		t.Errorf("expected every badge and its holders to be listed:\n%s", body)
+	}
+}
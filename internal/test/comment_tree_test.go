@@ -0,0 +1,111 @@
+package test
+
+import (
+	"forum/internal/utils"
+	"testing"
+)
+
+func TestCommentTreeDepthAndContinueThread(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+
+	// Build a chain of replies under comment 1 that is deeper than the limit
+	parent := 1
+	chain := []int{1}
+	for i := 0; i < utils.MaxThreadDepth+2; i++ {
+		id, err := utils.AddComment(db, 1, 2, parent, "reply")
+		if err != nil {
+			t.Fatalf("AddComment returned error: %v", err)
+		}
+		chain = append(chain, id)
+		parent = id
+	}
+
+	roots, err := utils.GetCommentTree(db, 1, 0, utils.CommentSortOldest, utils.MaxThreadDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(roots) != 2 || roots[0].ID != 1 || roots[1].ID != 2 {
+		t.Fatalf("expected the two seeded comments as roots, got %+v", roots)
+	}
+
+	node := roots[0]
+	for node.Depth < utils.MaxThreadDepth-1 {
+		if len(node.Children) != 1 {
+			t.Fatalf("comment %d at depth %d should have one reply, got %d", node.ID, node.Depth, len(node.Children))
+		}
+		node = node.Children[0]
+	}
+	if len(node.Children) != 0 || !node.ContinueThread {
+		t.Errorf("deepest rendered comment %d should link to its thread instead of showing children", node.ID)
+	}
+	if roots[0].Descendants != utils.MaxThreadDepth-1 {
+		t.Errorf("expected %d loaded descendants, got %d", utils.MaxThreadDepth-1, roots[0].Descendants)
+	}
+
+	// The thread view starts at the cut-off comment and shows the rest of the chain
+	thread, err := utils.GetCommentTree(db, 1, node.ID, utils.CommentSortOldest, utils.MaxThreadDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(thread) != 1 || thread[0].ID != node.ID || thread[0].Depth != 0 {
+		t.Fatalf("expected thread rooted at %d, got %+v", node.ID, thread)
+	}
+	if want := len(chain) - utils.MaxThreadDepth; thread[0].Descendants != want {
+		t.Errorf("expected %d replies in the thread, got %d", want, thread[0].Descendants)
+	}
+}
+
+func TestCommentTreeSort(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+
+	first, _ := utils.AddComment(db, 1, 1, 1, "first")
+	second, _ := utils.AddComment(db, 1, 1, 1, "second")
+	if _, err := db.Exec("INSERT INTO likes (user_id, comment_id, reaction) VALUES (2, ?, 'Like')", first); err != nil {
+		t.Fatal(err)
+	}
+
+	newest, err := utils.GetCommentTree(db, 1, 0, utils.CommentSortNewest, utils.MaxThreadDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newest[0].ID != 2 || newest[1].Children[0].ID != second {
+		t.Errorf("newest sort should put later comments first at every level")
+	}
+
+	top, err := utils.GetCommentTree(db, 1, 0, utils.CommentSortTop, utils.MaxThreadDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var replies []int
+	for _, r := range top {
+		if r.ID == 1 {
+			for _, c := range r.Children {
+				replies = append(replies, c.ID)
+			}
+		}
+	}
+	if len(replies) != 2 || replies[0] != first {
+		t.Errorf("top sort should put the liked reply first, got %v", replies)
+	}
+}
+
+func TestAddCommentRejectsParentFromOtherPost(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+
+	if _, err := utils.AddComment(db, 2, 1, 1, "wrong thread"); err == nil {
+		t.Error("expected error when replying to a comment of another post")
+	}
+
+	id, err := utils.AddComment(db, 2, 1, 0, "top level")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var isNull bool
+	db.QueryRow("SELECT parent_comment_id IS NULL FROM comments WHERE id = ?", id).Scan(&isNull)
+	if !isNull {
+		t.Error("top-level comments should have a NULL parent")
+	}
+}
@@ -0,0 +1,149 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"forum/internal/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeEnv returns a lookup function over a fixed environment
+func fakeEnv(vars map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := vars[key]
+		return v, ok
+	}
+}
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "forum.env")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigLayering(t *testing.T) {
+	file := writeConfigFile(t, "DB_PATH=from-file.db\nTRASH_RETENTION_DAYS=7\nADDR=:9000\nGOOGLE_CLIENT_ID=file-id\n")
+	env := fakeEnv(map[string]string{
+		"CONFIG_FILE": file,
+		"DB_PATH":     "from-env.db",
+		"ADDR":        ":9001",
+	})
+
+	cfg, err := config.LoadFrom([]string{"-db-path", "from-flag.db", "-rate-limit-interval-ms=100", "-upload-gc-grace-hours", "90m"}, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.File != file {
+		t.Errorf("expected the file from CONFIG_FILE, got %q", cfg.File)
+	}
+	if cfg.Database.Path != "from-flag.db" || cfg.Server.Addr != ":9001" || cfg.Google.ClientID != "file-id" {
+		t.Errorf("flags, environment and file were not layered: %+v", cfg)
+	}
+	if cfg.Content.TrashRetention != 7*24*time.Hour || cfg.RateLimit.Interval != 100*time.Millisecond || cfg.Uploads.GCGrace != 90*time.Minute {
+		t.Errorf("durations not parsed in their units: %v %v %v", cfg.Content.TrashRetention, cfg.RateLimit.Interval, cfg.Uploads.GCGrace)
+	}
+	if cfg.Content.ArchiveAfter != config.Defaults().Content.ArchiveAfter {
+		t.Errorf("unset settings must keep their default, got %v", cfg.Content.ArchiveAfter)
+	}
+}
+
+func TestConfigLoadErrors(t *testing.T) {
+	// Every bad value is reported, with where it came from
+	file := writeConfigFile(t, "TRASH_RETENTION_DAYS=soon\n")
+	_, err := config.LoadFrom([]string{"-config", file, "-rate-limit-burst", "many"}, fakeEnv(map[string]string{"AVATAR_ANIMATED": "maybe"}))
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"TRASH_RETENTION_DAYS from " + file, "AVATAR_ANIMATED from environment", "RATE_LIMIT_BURST from -rate-limit-burst"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+
+	// A config file that was asked for must exist
+	if _, err := config.LoadFrom([]string{"-config", filepath.Join(t.TempDir(), "missing.env")}, fakeEnv(nil)); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+	if _, err := config.LoadFrom([]string{"-no-such-flag"}, fakeEnv(nil)); err == nil {
+		t.Error("expected an error for an unknown flag")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Database.EncryptionKey = "key"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults with a database key should be valid: %v", err)
+	}
+
+	cfg = config.Defaults()
+	cfg.Env = config.Production
+	cfg.Storage.Backend = "s3"
+	cfg.Scanner.Backend = "clamav"
+	cfg.GitHub.ClientID = "id"
+	cfg.Content.TrashRetention = 0
+	cfg.Trust.Levels = "50,10,150"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	// All problems are reported at once
+	for _, want := range []string{"DB_ENCRYPTION_KEY", "SESSION_HMAC_SECRET", "S3_BUCKET", "SCANNER_BACKEND", "GITHUB_CLIENT_SECRET", "TRASH_RETENTION_DAYS", "TRUST_LEVEL_REPUTATION"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+}
+
+func TestConfigRedactsSecrets(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Database.EncryptionKey = "db-key-123"
+	cfg.Session.HMACSecret = "hmac-456"
+	cfg.Google.ClientSecret = "google-789"
+	cfg.Storage.S3SecretKey = "s3-000"
+
+	encoded, _ := json.Marshal(cfg)
+	outputs := map[string]string{
+		"Dump": cfg.Dump(),
+		"%v":   fmt.Sprintf("%v", cfg),
+		"%+v":  fmt.Sprintf("%+v", cfg),
+		"%#v":  fmt.Sprintf("%#v", cfg),
+		"json": string(encoded),
+	}
+	for name, out := range outputs {
+		for _, secret := range []string{"db-key-123", "hmac-456", "google-789", "s3-000"} {
+			if strings.Contains(out, secret) {
+				t.Errorf("%s leaks %s", name, secret)
+			}
+		}
+	}
+	if !strings.Contains(cfg.Dump(), "DB_ENCRYPTION_KEY=[redacted]\n") || !strings.Contains(cfg.Dump(), "SENDGRID_API_KEY=\n") {
+		t.Errorf("Dump should show which secrets are set:\n%s", cfg.Dump())
+	}
+	if cfg.Database.EncryptionKey.Value() != "db-key-123" {
+		t.Error("Value must return the secret itself")
+	}
+}
+
+func TestConfigDumpRoundTrip(t *testing.T) {
+	cfg, err := config.LoadFrom([]string{"-trash-retention-days", "1.5", "-attachment-types", "pdf:1,txt:2"}, fakeEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := config.LoadFrom([]string{"-config", writeConfigFile(t, cfg.Dump())}, fakeEnv(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded.File = cfg.File
+	if !reflect.DeepEqual(cfg, reloaded) {
+		t.Errorf("dump does not load back to the same configuration:\n%+v\n%+v", cfg, reloaded)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"forum/internal"
 	"forum/internal/handlers"
+	"forum/internal/utils"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -62,11 +63,8 @@ func TestHandlerCreateComment_Success(t *testing.T) {
 	req := httptest.NewRequest(http.MethodPost, "/comment", strings.NewReader(form.Encode()))
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 
-	type contextKey string
-	const userIDKey contextKey = "userID"
-
 	// Додаємо userID у контекст
-	ctx := context.WithValue(req.Context(), userIDKey, 1)
+	ctx := context.WithValue(req.Context(), utils.UserIDKey, 1)
 	req = req.WithContext(ctx)
 
 	rr := httptest.NewRecorder()
@@ -24,7 +24,7 @@ func TestCreatePost_Success(t *testing.T) {
 	imagePath := []string{"test1.jpg", "test2.jpg"}
 	primaryImageIndex := 1
 
-	err := handlers.CreatePost(db, userID, title, content, createdAt, categoryIDs, tags, imagePath, primaryImageIndex)
+	err := handlers.CreatePost(db, userID, title, content, createdAt, categoryIDs, tags, imagePath, primaryImageIndex, nil)
 	if err != nil {
 		t.Fatalf("expected no error, got %v", err)
 	}
@@ -57,6 +57,7 @@ func TestCreatePost_TooManyCategories(t *testing.T) {
 		[]string{},
 		[]string{},
 		1,
+		nil,
 	)
 
 	if err == nil || err.Error() != "can select up to 3 categories only" {
@@ -78,6 +79,7 @@ func TestCreatePost_NonExistentCategory(t *testing.T) {
 		[]string{},
 		[]string{},
 		1,
+		nil,
 	)
 	if err == nil || err.Error() != "one or more categories do not exist" {
 		t.Errorf("expected error about non-existent category, got: %v", err)
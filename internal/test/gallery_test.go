@@ -0,0 +1,171 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"forum/internal/handlers"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// addGalleryImages gives post 1 three more images after its fixture image
+func addGalleryImages(t *testing.T, db *sql.DB) []int {
+	for i, path := range []string{"/images/a.png", "/images/b.png", "/images/c.png"} {
+		if _, err := db.Exec("INSERT INTO post_images (post_id, image_path, order_index) VALUES (1, ?, ?)", path, i+1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	images, err := utils.GetPostImages(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int, len(images))
+	for i, img := range images {
+		ids[i] = img.ID
+	}
+	return ids
+}
+
+func TestReorderPostImages(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+	ids := addGalleryImages(t, db)
+
+	reversed := []int{ids[3], ids[2], ids[1], ids[0]}
+	if err := utils.ReorderPostImages(ctx, db, 1, reversed); err != nil {
+		t.Fatalf("ReorderPostImages returned error: %v", err)
+	}
+	images, _ := utils.GetPostImages(db, 1)
+	for i, img := range images {
+		if img.ID != reversed[i] || img.Order != i {
+			t.Errorf("position %d: expected image %d, got %+v", i, reversed[i], img)
+		}
+	}
+
+	var post2Image int
+	db.QueryRow("SELECT id FROM post_images WHERE post_id = 2").Scan(&post2Image)
+	invalid := map[string][]int{
+		"missing image":    {ids[0], ids[1], ids[2]},
+		"duplicate image":  {ids[0], ids[0], ids[1], ids[2]},
+		"other post image": {ids[0], ids[1], ids[2], post2Image},
+	}
+	for name, order := range invalid {
+		if err := utils.ReorderPostImages(ctx, db, 1, order); !errors.Is(err, utils.ErrInvalidGallery) {
+			t.Errorf("%s: expected ErrInvalidGallery, got %v", name, err)
+		}
+	}
+
+	// A rejected order leaves the gallery untouched
+	images, _ = utils.GetPostImages(db, 1)
+	if images[0].ID != reversed[0] {
+		t.Errorf("failed reorder changed the gallery: %+v", images)
+	}
+}
+
+func TestSetPrimaryAndImageText(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+	ids := addGalleryImages(t, db)
+
+	if err := utils.SetPrimaryPostImage(ctx, db, 1, ids[2]); err != nil {
+		t.Fatalf("SetPrimaryPostImage returned error: %v", err)
+	}
+	images, _ := utils.GetPostImages(db, 1)
+	for _, img := range images {
+		if img.IsPrimary != (img.ID == ids[2]) {
+			t.Errorf("image %d: unexpected primary flag %v", img.ID, img.IsPrimary)
+		}
+	}
+
+	if err := utils.UpdatePostImageText(ctx, db, 1, ids[1], "  Sunset over the bay ", "Orange sky above water"); err != nil {
+		t.Fatalf("UpdatePostImageText returned error: %v", err)
+	}
+	images, _ = utils.GetPostImages(db, 1)
+	if images[1].Caption != "Sunset over the bay" || images[1].AltText != "Orange sky above water" {
+		t.Errorf("unexpected caption/alt text: %+v", images[1])
+	}
+
+	long := strings.Repeat("x", utils.MaxImageCaptionLength+1)
+	if err := utils.UpdatePostImageText(ctx, db, 1, ids[1], long, ""); !errors.Is(err, utils.ErrInvalidGallery) {
+		t.Errorf("expected a too-long caption to be rejected, got %v", err)
+	}
+	if err := utils.SetPrimaryPostImage(ctx, db, 2, ids[1]); !errors.Is(err, utils.ErrInvalidGallery) {
+		t.Errorf("expected an image of another post to be rejected, got %v", err)
+	}
+}
+
+func TestUpdatePostFull_AppendsImagesAndKeepsPrimary(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+	ids := addGalleryImages(t, db)
+
+	// Remove the primary fixture image and add two new ones
+	err := utils.UpdatePostFull(ctx, db, 1, 1, "", "Hello World", "This is a test post", []string{"1"}, "",
+		[]string{"/images/new1.png", "/images/new2.png"}, []int{ids[0]})
+	if err != nil {
+		t.Fatalf("UpdatePostFull returned error: %v", err)
+	}
+
+	images, _ := utils.GetPostImages(db, 1)
+	var paths []string
+	for i, img := range images {
+		paths = append(paths, img.Path)
+		if img.Order != i {
+			t.Errorf("expected order %d, got %d", i, img.Order)
+		}
+	}
+	want := "/images/a.png,/images/b.png,/images/c.png,/images/new1.png,/images/new2.png"
+	if strings.Join(paths, ",") != want {
+		t.Errorf("expected new images after existing ones, got %v", paths)
+	}
+	if !images[0].IsPrimary {
+		t.Error("the first remaining image should become primary")
+	}
+}
+
+func TestGalleryHandlers(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ids := addGalleryImages(t, db)
+
+	do := func(h http.HandlerFunc, userID int, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/post_images/text", strings.NewReader(body))
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+
+	// alice owns post 1
+	rr := do(handlers.HandlerUpdateImageText(db), 1, `{"post_id":1,"image_id":`+strconv.Itoa(ids[1])+`,"caption":"Hi","alt_text":"A cat"}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var images []models.Image
+	if err := json.Unmarshal(rr.Body.Bytes(), &images); err != nil || len(images) != 4 || images[1].AltText != "A cat" {
+		t.Errorf("expected the updated gallery in the response, got %s", rr.Body.String())
+	}
+
+	// alice cannot edit bob's post 2
+	var post2Image int
+	db.QueryRow("SELECT id FROM post_images WHERE post_id = 2").Scan(&post2Image)
+	rr = do(handlers.HandlerSetPrimaryImage(db), 1, `{"post_id":2,"image_id":`+strconv.Itoa(post2Image)+`}`)
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected 403 for another user's post, got %d", rr.Code)
+	}
+
+	rr = do(handlers.HandlerReorderImages(db), 1, `{"post_id":1,"image_ids":[`+strconv.Itoa(ids[0])+`]}`)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for an incomplete order, got %d", rr.Code)
+	}
+}
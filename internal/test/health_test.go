@@ -0,0 +1,226 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"forum/database"
+	"forum/internal/handlers"
+	"forum/internal/middleware"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHealthz(t *testing.T) {
+	rr := httptest.NewRecorder()
+	handlers.Healthz()(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "ok\n" {
+		t.Errorf("expected 200 ok, got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	ready := handlers.ReadinessCheck{Name: "database", Check: func(context.Context) error { return nil }}
+	broken := handlers.ReadinessCheck{Name: "migrations", Check: func(context.Context) error { return fmt.Errorf("pending: posts.deleted_at") }}
+
+	var body struct {
+		Status string            `json:"status"`
+		Checks map[string]string `json:"checks"`
+	}
+
+	rr := httptest.NewRecorder()
+	handlers.Readyz(ready)(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("expected 200, got %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	handlers.Readyz(ready, broken)(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected 503, got %d", rr.Code)
+	}
+	if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Status != "unavailable" || body.Checks["database"] != "ok" || body.Checks["migrations"] != "pending: posts.deleted_at" {
+		t.Errorf("unexpected body %+v", body)
+	}
+}
+
+func TestPendingColumnMigrations(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	if _, err := conn.Exec(`CREATE TABLE posts (id INTEGER); CREATE TABLE comments (id INTEGER); CREATE TABLE post_images (id INTEGER); CREATE TABLE users (id INTEGER); CREATE TABLE held_content (id INTEGER); CREATE TABLE notifications (id INTEGER)`); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := db.PendingColumnMigrations(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) == 0 || pending[0] != "posts.deleted_at" {
+		t.Errorf("expected the missing columns to be pending, got %v", pending)
+	}
+
+	if err := db.ApplyColumnMigrations(conn); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := db.PendingColumnMigrations(conn); err != nil || len(pending) != 0 {
+		t.Errorf("expected nothing pending after migrating, got %v (%v)", pending, err)
+	}
+}
+
+func TestApplyNotificationTypes(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	// A database from before moderation decisions were notified
+	if _, err := conn.Exec(`CREATE TABLE posts (id INTEGER); CREATE TABLE comments (id INTEGER); CREATE TABLE post_images (id INTEGER); CREATE TABLE users (id INTEGER); CREATE TABLE held_content (id INTEGER);
+		CREATE TABLE notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment', 'reply')),
+			post_id INTEGER,
+			comment_id INTEGER,
+			actor_id INTEGER,
+			is_read BOOLEAN DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO notifications (user_id, type, post_id, actor_id) VALUES (1, 'like', 1, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ApplyColumnMigrations(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ApplyNotificationTypes(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := conn.Exec("INSERT INTO notifications (user_id, type, actor_id, message) VALUES (1, 'rejected', 2, 'off topic')"); err != nil {
+		t.Fatalf("expected the new type to be allowed: %v", err)
+	}
+	var n int
+	conn.QueryRow("SELECT COUNT(*) FROM notifications WHERE type = 'like' AND post_id = 1").Scan(&n)
+	if n != 1 {
+		t.Error("expected the existing notification to be kept")
+	}
+	// Running it again changes nothing
+	if err := db.ApplyNotificationTypes(conn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApplyNotificationTypesKeepsOrphans(t *testing.T) {
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+	// A notification about a post that was removed without cascading
+	if _, err := conn.Exec(`PRAGMA foreign_keys = ON;
+		CREATE TABLE posts (id INTEGER PRIMARY KEY); CREATE TABLE comments (id INTEGER PRIMARY KEY); CREATE TABLE post_images (id INTEGER); CREATE TABLE users (id INTEGER PRIMARY KEY); CREATE TABLE held_content (id INTEGER);
+		INSERT INTO users (id) VALUES (1), (2);
+		CREATE TABLE notifications (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment', 'reply')),
+			post_id INTEGER,
+			comment_id INTEGER,
+			actor_id INTEGER,
+			is_read BOOLEAN DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+		INSERT INTO notifications (user_id, type, post_id, actor_id) VALUES (1, 'like', 99, 2)`); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ApplyColumnMigrations(conn); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.ApplyNotificationTypes(conn); err != nil {
+		t.Fatalf("expected the rebuild to copy notifications of removed posts: %v", err)
+	}
+
+	var n int
+	var foreignKeys bool
+	conn.QueryRow("SELECT COUNT(*) FROM notifications WHERE post_id = 99").Scan(&n)
+	conn.QueryRow("PRAGMA foreign_keys").Scan(&foreignKeys)
+	if n != 1 || !foreignKeys {
+		t.Errorf("expected the notification kept and foreign keys back on, got %d and %t", n, foreignKeys)
+	}
+}
+
+func TestHubCloseSendsCloseFrame(t *testing.T) {
+	hub := handlers.NewHub()
+	done := make(chan struct{})
+	go func() {
+		hub.Run()
+		close(done)
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), utils.UserIDKey, 1)
+		handlers.HandleWebSocket(w, r.WithContext(ctx))
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// The connection is registered once the upgrade has completed
+	deadline := time.Now().Add(time.Second)
+	for hub.ActiveConnections() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hub.ActiveConnections() != 1 {
+		t.Fatalf("expected one connection, got %d", hub.ActiveConnections())
+	}
+
+	hub.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("expected a going away close frame, got %v", err)
+	}
+	if hub.ActiveConnections() != 0 {
+		t.Errorf("expected no connections after Close, got %d", hub.ActiveConnections())
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("Run did not return after Close")
+	}
+}
+
+func TestRunVisitorCleanupStops(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		middleware.RunVisitorCleanup(ctx)
+		close(done)
+	}()
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("RunVisitorCleanup did not return after its context was cancelled")
+	}
+}
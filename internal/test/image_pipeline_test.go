@@ -0,0 +1,152 @@
+package test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"forum/internal/utils"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func encodeTestPNG(t *testing.T, w, h int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// jpegWithOrientation builds a JPEG carrying an EXIF block with the given orientation
+func jpegWithOrientation(t *testing.T, w, h int, orientation uint16) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, w, h)), nil); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08\x00\x01")
+	entry := make([]byte, 12)
+	binary.BigEndian.PutUint16(entry[0:], 0x0112)
+	binary.BigEndian.PutUint16(entry[2:], 3)
+	binary.BigEndian.PutUint32(entry[4:], 1)
+	binary.BigEndian.PutUint16(entry[8:], orientation)
+	tiff = append(tiff, entry...)
+	tiff = append(tiff, 0, 0, 0, 0)
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	segment := []byte{0xFF, 0xE1, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	segment = append(segment, payload...)
+
+	out := append([]byte{}, data[:2]...)
+	out = append(out, segment...)
+	return append(out, data[2:]...)
+}
+
+func TestProcessImageAppliesOrientationAndStripsExif(t *testing.T) {
+	img, err := utils.ProcessImage(bytes.NewReader(jpegWithOrientation(t, 40, 20, 6)))
+	if err != nil {
+		t.Fatalf("ProcessImage returned error: %v", err)
+	}
+	if img.Format != "jpeg" || img.Width != 20 || img.Height != 40 {
+		t.Errorf("expected rotated 20x40 jpeg, got %s %dx%d", img.Format, img.Width, img.Height)
+	}
+	if bytes.Contains(img.Data, []byte("Exif")) {
+		t.Error("re-encoded image still contains EXIF data")
+	}
+}
+
+func TestProcessImageLimitsDimensions(t *testing.T) {
+	img, err := utils.ProcessImage(bytes.NewReader(encodeTestPNG(t, utils.MaxImageDimension+400, 100)))
+	if err != nil {
+		t.Fatalf("ProcessImage returned error: %v", err)
+	}
+	if img.Width != utils.MaxImageDimension {
+		t.Errorf("expected width %d, got %d", utils.MaxImageDimension, img.Width)
+	}
+
+	small, err := png.DecodeConfig(bytes.NewReader(img.Thumbnails["sm"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if small.Width != utils.ThumbnailSizes[0].MaxDim {
+		t.Errorf("expected small thumbnail width %d, got %d", utils.ThumbnailSizes[0].MaxDim, small.Width)
+	}
+}
+
+// gifWithFrames builds a tiny GIF with n frames
+func gifWithFrames(t *testing.T, n int) []byte {
+	pal := color.Palette{color.Black, color.White}
+	g := &gif.GIF{}
+	for i := 0; i < n; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 4, 4), pal))
+		g.Delay = append(g.Delay, 1)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestProcessImageLimitsGIFFrames(t *testing.T) {
+	img, err := utils.ProcessImage(bytes.NewReader(gifWithFrames(t, 3)))
+	if err != nil || img.Format != "gif" {
+		t.Fatalf("expected a small animation to be accepted, got %v", err)
+	}
+
+	if _, err := utils.ProcessImage(bytes.NewReader(gifWithFrames(t, utils.MaxGIFFrames+1))); err != utils.ErrAnimationTooLong {
+		t.Errorf("expected ErrAnimationTooLong, got %v", err)
+	}
+
+	// Every frame may cover the whole logical screen, so a large screen
+	// makes a few frames too many pixels together
+	data := gifWithFrames(t, 10)
+	binary.LittleEndian.PutUint16(data[6:], utils.MaxImageDimension)
+	binary.LittleEndian.PutUint16(data[8:], utils.MaxImageDimension)
+	if _, err := utils.ProcessImage(bytes.NewReader(data)); err != utils.ErrImageTooLarge {
+		t.Errorf("expected ErrImageTooLarge, got %v", err)
+	}
+}
+
+func TestSaveUploadedFile_DetectsRealFormat(t *testing.T) {
+	// A PNG uploaded with a .jpg name and a generic content type is stored as PNG
+	file, header := createTestFile(t, "holiday.jpg", "application/octet-stream", encodeTestPNG(t, 50, 50))
+	defer file.Close()
+
+	path, err := utils.SaveUploadedFile(file, header)
+	if err != nil {
+		t.Fatalf("SaveUploadedFile returned error: %v", err)
+	}
+	defer utils.RemoveUploadedFile(path)
+
+	if !strings.HasSuffix(path, ".png") {
+		t.Errorf("expected a .png path, got %s", path)
+	}
+	for _, size := range utils.ThumbnailSizes {
+		thumb := filepath.Join(utils.UploadsDir(), filepath.Base(utils.ThumbnailPath(path, size.Name)))
+		if _, err := os.Stat(thumb); err != nil {
+			t.Errorf("missing %s thumbnail: %v", size.Name, err)
+		}
+	}
+
+	// Text renamed to .jpg is not an image
+	fake, fakeHeader := createTestFile(t, "fake.jpg", "image/jpeg", []byte("definitely not a jpeg"))
+	defer fake.Close()
+	if _, err := utils.SaveUploadedFile(fake, fakeHeader); err == nil || err.Error() != "unsupported file type" {
+		t.Errorf("expected unsupported file type, got %v", err)
+	}
+}
@@ -0,0 +1,175 @@
+package test
+
+import (
+	"context"
+	"forum/internal"
+	"forum/internal/handlers"
+	"forum/internal/middleware"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseBlockedNetwork(t *testing.T) {
+	for in, want := range map[string]string{
+		"203.0.113.7":        "203.0.113.7/32",
+		" 203.0.113.77/24 ":  "203.0.113.0/24",
+		"2001:db8::1":        "2001:db8::1/128",
+		"2001:db8:abcd::/32": "2001:db8::/32",
+		"::ffff:192.0.2.1":   "192.0.2.1/32",
+	} {
+		got, err := utils.ParseBlockedNetwork(in)
+		if err != nil || got.String() != want {
+			t.Errorf("ParseBlockedNetwork(%q) = %v, %v; want %s", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "203.0.113", "203.0.113.0/33", "example.com"} {
+		if _, err := utils.ParseBlockedNetwork(bad); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
+
+func TestIPBlocklist(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+
+	list := utils.NewIPBlocklist(db, time.Hour)
+	utils.IPBlocks = list
+	t.Cleanup(func() { utils.IPBlocks = nil })
+
+	for _, b := range []struct {
+		network, scope string
+		expires        time.Time
+	}{
+		{"203.0.113.0/24", utils.BlockPosting, time.Time{}},
+		{"2001:db8::/32", utils.BlockAll, time.Time{}},
+		{"198.51.100.7", utils.BlockRegistration, time.Now().Add(-time.Minute)},
+	} {
+		if err := utils.AddIPBlock(db, b.network, b.scope, "spam", 1, b.expires); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := utils.AddIPBlock(db, "192.0.2.1", "everything", "", 1, time.Time{}); err == nil {
+		t.Error("expected an unknown scope to be rejected")
+	}
+
+	now := time.Now()
+	for _, c := range []struct {
+		ip, scope string
+		want      bool
+	}{
+		{"203.0.113.9", utils.BlockPosting, true},
+		{"203.0.113.9", utils.BlockRegistration, false},
+		{"203.0.113.9", utils.BlockAll, false},
+		{"203.0.114.9", utils.BlockPosting, false},
+		{"2001:db8:1::5", utils.BlockRegistration, true},
+		{"2001:db9::5", utils.BlockAll, false},
+		// Expired blocks no longer apply
+		{"198.51.100.7", utils.BlockRegistration, false},
+		{"not an ip", utils.BlockAll, false},
+	} {
+		got, err := list.Blocked(context.Background(), c.ip, c.scope, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != c.want {
+			t.Errorf("Blocked(%s, %s) = %v, want %v", c.ip, c.scope, got, c.want)
+		}
+	}
+
+	blocks, err := utils.GetIPBlocks(db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 3 || !blocks[0].Expired || blocks[0].Network != "198.51.100.7/32" {
+		t.Fatalf("unexpected blocks: %+v", blocks)
+	}
+
+	// Lifting a block applies at once
+	if err := utils.RemoveIPBlock(db, blocks[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := list.Blocked(context.Background(), "203.0.113.9", utils.BlockPosting, now); got {
+		t.Error("expected the lifted block to stop applying")
+	}
+}
+
+func TestBlocklistMiddleware(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	errors.Init(getTemplatePath())
+
+	utils.IPBlocks = utils.NewIPBlocklist(db, time.Hour)
+	t.Cleanup(func() { utils.IPBlocks = nil })
+	utils.AddIPBlock(db, "203.0.113.0/24", utils.BlockPosting, "", 1, time.Time{})
+	utils.AddIPBlock(db, "198.51.100.0/24", utils.BlockRegistration, "", 1, time.Time{})
+	utils.AddIPBlock(db, "192.0.2.66", utils.BlockAll, "", 1, time.Time{})
+
+	handler := middleware.TrustProxies(nil, middleware.Blocklist(http.HandlerFunc(testHandler)))
+	do := func(path, ip, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = ip + ":4000"
+		req.Header.Set("Accept", accept)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, c := range []struct {
+		path, ip string
+		want     int
+	}{
+		{"/createin", "203.0.113.5", http.StatusForbidden},
+		{"/edit_comment/12", "203.0.113.5", http.StatusForbidden},
+		{"/register-submit", "203.0.113.5", http.StatusOK},
+		{"/", "203.0.113.5", http.StatusOK},
+		{"/register-submit", "198.51.100.5", http.StatusForbidden},
+		{"/like", "198.51.100.5", http.StatusOK},
+		{"/", "192.0.2.66", http.StatusForbidden},
+		{"/login-submit", "192.0.2.66", http.StatusForbidden},
+		// The error page still gets its styles
+		{"/static/css/style.css", "192.0.2.66", http.StatusOK},
+	} {
+		if rr := do(c.path, c.ip, "text/html"); rr.Code != c.want {
+			t.Errorf("%s from %s: got %d, want %d", c.path, c.ip, rr.Code, c.want)
+		}
+	}
+
+	// Scripts calling JSON endpoints get an error they can show
+	rr := do("/register-submit", "198.51.100.5", "application/json")
+	if rr.Code != http.StatusForbidden || rr.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON 403, got %d %q", rr.Code, rr.Header().Get("Content-Type"))
+	}
+}
+
+func TestAccountIPsRecorded(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+
+	if err := handlers.СreateRegularUser(db, "from-v4", "v4@example.com", "hash", "203.0.113.5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := handlers.СreateOAuthUser(db, "from-v6", "v6@example.com", "github", "42", "", "2001:db8::7"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE users SET last_login_ip = '203.0.113.200' WHERE username = 'from-v6'`); err != nil {
+		t.Fatal(err)
+	}
+
+	network, _ := utils.ParseBlockedNetwork("203.0.113.0/24")
+	accounts, err := utils.GetAccountsInNetwork(db, network)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(accounts) != 2 {
+		t.Fatalf("expected both accounts in the network, got %+v", accounts)
+	}
+	network, _ = utils.ParseBlockedNetwork("2001:db8::/32")
+	accounts, _ = utils.GetAccountsInNetwork(db, network)
+	if len(accounts) != 1 || accounts[0].Username != "from-v6" || accounts[0].RegistrationIP != "2001:db8::7" {
+		t.Errorf("expected only the IPv6 registration, got %+v", accounts)
+	}
+}
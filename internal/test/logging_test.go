@@ -0,0 +1,138 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"forum/internal/config"
+	"forum/internal/logging"
+	"forum/internal/middleware"
+	"forum/internal/models"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// captureLogs makes a logger writing to the returned buffer the default for
+// the rest of the test
+func captureLogs(t *testing.T, format string) *bytes.Buffer {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, config.Logging{Level: "debug", Format: format})
+	if err != nil {
+		t.Fatal(err)
+	}
+	previous := slog.Default()
+	slog.SetDefault(logger)
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func TestLoggingRedactsSecrets(t *testing.T) {
+	buf := captureLogs(t, "text")
+
+	header := http.Header{"Cookie": {"session_id=abc123"}, "Accept": {"text/html"}}
+	slog.Info("login password=hunter2 for bob",
+		"session_token", "tok-456",
+		"cookie", &http.Cookie{Name: "session_id", Value: "cookie-789"},
+		"header", header,
+		"err", errors.New("bad token: secret-000"),
+		slog.Group("request", "authorization", "Bearer xyz"),
+		"user", models.User{ID: 7, Username: "bob", PasswordHash: "hash-111", Email: "bob@example.com"},
+	)
+
+	out := buf.String()
+	for _, secret := range []string{"hunter2", "tok-456", "cookie-789", "abc123", "secret-000", "Bearer xyz", "hash-111", "bob@example.com"} {
+		if strings.Contains(out, secret) {
+			t.Errorf("log output leaks %q:\n%s", secret, out)
+		}
+	}
+	for _, want := range []string{"for bob", "text/html", "user.id=7", "user.username=bob"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("log output lost %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestLoggingLevelAndFormat(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := logging.New(&buf, config.Logging{Level: "warn", Format: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger.Info("hidden")
+	logger.Warn("shown", "password", "p")
+
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatalf("expected a single JSON record, got %q: %v", buf.String(), err)
+	}
+	if record["msg"] != "shown" || record["password"] != logging.Redacted {
+		t.Errorf("unexpected record %v", record)
+	}
+
+	if _, err := logging.New(&buf, config.Logging{Level: "loud", Format: "text"}); err == nil {
+		t.Error("expected an error for an unknown level")
+	}
+	if _, err := logging.New(&buf, config.Logging{Level: "info", Format: "xml"}); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	buf := captureLogs(t, "json")
+
+	var handlerRequestID string
+	handler := middleware.RequestLogger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerRequestID = logging.RequestID(r.Context())
+		logging.SetUserID(r.Context(), 42)
+		logging.FromContext(r.Context()).Info("inside handler")
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/reset-password?token=abc", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	requestID := rr.Header().Get(middleware.RequestIDHeader)
+	if requestID == "" || requestID != handlerRequestID {
+		t.Fatalf("response ID %q does not match the handler's %q", requestID, handlerRequestID)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a handler line and an access line, got:\n%s", buf.String())
+	}
+	var inside, access map[string]any
+	json.Unmarshal([]byte(lines[0]), &inside)
+	json.Unmarshal([]byte(lines[1]), &access)
+
+	if inside["request_id"] != requestID || inside["user_id"] != float64(42) {
+		t.Errorf("handler logger lacks request context: %v", inside)
+	}
+	if access["msg"] != "request" || access["method"] != "GET" || access["path"] != "/reset-password" ||
+		access["status"] != float64(http.StatusTeapot) || access["user_id"] != float64(42) || access["latency"] == nil {
+		t.Errorf("unexpected access line: %v", access)
+	}
+	if strings.Contains(buf.String(), "abc") {
+		t.Errorf("the query string must not be logged:\n%s", buf.String())
+	}
+
+	// A well-formed ID from a proxy is kept, anything else is replaced
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(middleware.RequestIDHeader, "edge-1234")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get(middleware.RequestIDHeader); got != "edge-1234" {
+		t.Errorf("expected the incoming request ID to be kept, got %q", got)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(middleware.RequestIDHeader, "bad id\nwith newline")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get(middleware.RequestIDHeader); got == "" || strings.Contains(got, " ") {
+		t.Errorf("expected a malformed request ID to be replaced, got %q", got)
+	}
+}
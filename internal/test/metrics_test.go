@@ -0,0 +1,150 @@
+package test
+
+import (
+	"database/sql"
+	"forum/internal/metrics"
+	"forum/internal/middleware"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	r := metrics.NewRegistry()
+	requests := r.NewCounterVec("test_requests_total", "Requests served", "route", "status")
+	requests.With("/post_page/", "200").Add(3)
+	requests.With("/login", "401").Inc()
+	r.NewGauge("test_connections", "Open connections").Set(2)
+	latency := r.NewHistogramVec("test_latency_seconds", "Latency", []float64{0.1, 1})
+	latency.With().Observe(0.05)
+	latency.With().Observe(0.5)
+	latency.With().Observe(5)
+
+	var b strings.Builder
+	r.Write(&b)
+	out := b.String()
+
+	for _, want := range []string{
+		"# HELP test_requests_total Requests served\n# TYPE test_requests_total counter\n",
+		`test_requests_total{route="/login",status="401"} 1` + "\n",
+		`test_requests_total{route="/post_page/",status="200"} 3` + "\n",
+		"# TYPE test_connections gauge\ntest_connections 2\n",
+		`test_latency_seconds_bucket{le="0.1"} 1` + "\n",
+		`test_latency_seconds_bucket{le="1"} 2` + "\n",
+		`test_latency_seconds_bucket{le="+Inf"} 3` + "\n",
+		"test_latency_seconds_sum 5.55\ntest_latency_seconds_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("exposition lacks %q:\n%s", want, out)
+		}
+	}
+	// Families are written in name order
+	if strings.Index(out, "test_connections") > strings.Index(out, "test_requests_total") {
+		t.Errorf("metrics are not sorted by name:\n%s", out)
+	}
+}
+
+func TestMetricsHandlerToken(t *testing.T) {
+	r := metrics.NewRegistry()
+	r.NewCounter("test_total", "Test").Inc()
+	handler := metrics.Handler(r, "scrape-token")
+
+	for _, tc := range []struct {
+		auth string
+		want int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"scrape-token", http.StatusUnauthorized},
+		{"Bearer scrape-token", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if tc.auth != "" {
+			req.Header.Set("Authorization", tc.auth)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Errorf("Authorization %q: expected %d, got %d", tc.auth, tc.want, rr.Code)
+		}
+		if tc.want == http.StatusOK && !strings.Contains(rr.Body.String(), "test_total 1") {
+			t.Errorf("unexpected body %q", rr.Body.String())
+		}
+	}
+}
+
+func TestMetricsMiddlewareUsesRoutePattern(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/post_page/", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	})
+	handler := middleware.Metrics(mux)
+
+	counter := metrics.HTTPRequests.With("/post_page/", "GET", "404")
+	before := counter.Value()
+	for _, path := range []string{"/post_page/1", "/post_page/2", "/post_page/3"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	if got := counter.Value() - before; got != 3 {
+		t.Errorf("expected 3 requests counted under the pattern, got %v", got)
+	}
+	if metrics.HTTPDuration.With("/post_page/", "GET").Count() == 0 {
+		t.Error("expected the latency to be observed")
+	}
+
+	// Made-up methods share one series
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("BREW", "/post_page/1", nil))
+	if metrics.HTTPRequests.With("/post_page/", "OTHER", "404").Value() == 0 {
+		t.Error("expected an unknown method to be counted as OTHER")
+	}
+}
+
+func TestMetricsSQLDriver(t *testing.T) {
+	db, err := sql.Open(metrics.SQLDriver("sqlite3"), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	metrics.WatchDB(db)
+
+	selects := metrics.DBQueryDuration.With("select")
+	inserts := metrics.DBQueryDuration.With("insert")
+	beforeSelects, beforeInserts := selects.Count(), inserts.Count()
+
+	if _, err := db.Exec("CREATE TABLE t (v INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO t (v) VALUES (?)", 1); err != nil {
+		t.Fatal(err)
+	}
+	stmt, err := db.Prepare("INSERT INTO t (v) VALUES (?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Exec(2); err != nil {
+		t.Fatal(err)
+	}
+	stmt.Close()
+	var sum int
+	if err := db.QueryRow("SELECT SUM(v) FROM t").Scan(&sum); err != nil || sum != 3 {
+		t.Fatalf("expected 3, got %d (%v)", sum, err)
+	}
+
+	if got := inserts.Count() - beforeInserts; got != 2 {
+		t.Errorf("expected 2 timed inserts, got %d", got)
+	}
+	if got := selects.Count() - beforeSelects; got != 1 {
+		t.Errorf("expected 1 timed select, got %d", got)
+	}
+
+	// The pool gauges follow the database WatchDB was given
+	rec := httptest.NewRecorder()
+	metrics.Handler(metrics.Default, "").ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	if !strings.Contains(string(body), "forum_db_open_connections 1\n") {
+		t.Errorf("expected one open connection in:\n%s", body)
+	}
+}
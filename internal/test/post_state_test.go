@@ -0,0 +1,175 @@
+package test
+
+import (
+	"context"
+	"forum/internal"
+	"forum/internal/handlers"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockedPostRejectsComments(t *testing.T) {
+	errors.Init(getTemplatePath())
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	if err := utils.SetPostLocked(ctx, db, 1, 2, true); err != nil {
+		t.Fatalf("SetPostLocked returned error: %v", err)
+	}
+	if err := utils.CheckPostOpen(db, 1); err != utils.ErrPostLocked {
+		t.Fatalf("expected ErrPostLocked, got %v", err)
+	}
+	if err := utils.CheckCommentOpen(db, 1); err != utils.ErrPostLocked {
+		t.Errorf("comments of a locked post should be closed too, got %v", err)
+	}
+
+	form := url.Values{"postId": {"1"}, "content": {"late reply"}}
+	req := httptest.NewRequest(http.MethodPost, "/create-comment", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, 1))
+	rr := httptest.NewRecorder()
+	handlers.CreateCommentHandler(db)(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected status %d for locked post, got %d", http.StatusForbidden, rr.Code)
+	}
+
+	if err := utils.SetPostLocked(ctx, db, 1, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.CheckPostOpen(db, 1); err != nil {
+		t.Errorf("unlocked post should be open, got %v", err)
+	}
+}
+
+func TestArchiveInactivePosts(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	if _, err := db.Exec("UPDATE posts SET created_at = datetime('now', '-400 days')"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE comments SET created_at = datetime('now', '-400 days')"); err != nil {
+		t.Fatal(err)
+	}
+	// Pinned posts are never archived automatically
+	if err := utils.SetPostPinned(ctx, db, 2, 0, 2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := utils.ArchiveInactivePosts(ctx, db, 180*24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("expected 1 archived post, got %d", n)
+	}
+	if err := utils.CheckPostOpen(db, 1); err != utils.ErrPostArchived {
+		t.Errorf("expected post 1 to be archived, got %v", err)
+	}
+
+	// Unarchiving counts as activity, so the next run leaves the post alone
+	if err := utils.SetPostArchived(ctx, db, 1, false); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := utils.ArchiveInactivePosts(ctx, db, 180*24*time.Hour); n != 0 {
+		t.Errorf("unarchived post should not be archived again right away, got %d", n)
+	}
+}
+
+func TestPinnedAndAnnouncementOrdering(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	if _, err := db.Exec("UPDATE posts SET created_at = datetime('now', '-' || id || ' days')"); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.SetPostPinned(ctx, db, 2, 0, 2, true); err != nil {
+		t.Fatal(err)
+	}
+
+	posts, err := handlers.GetPosts(db, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || posts[0].ID != 2 || !posts[0].IsPinned {
+		t.Fatalf("expected pinned post first, got %+v", posts)
+	}
+
+	if err := utils.SetPostAnnouncement(ctx, db, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	posts, _ = handlers.GetPosts(db, nil)
+	if posts[0].ID != 1 || !posts[0].IsAnnouncement {
+		t.Errorf("expected announcement above pinned posts, got post %d first", posts[0].ID)
+	}
+}
+
+func TestClosedPostIsReadOnly(t *testing.T) {
+	errors.Init(getTemplatePath())
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	if err := utils.SetPostArchived(ctx, db, 1, true); err != nil {
+		t.Fatal(err)
+	}
+	do := func(h http.HandlerFunc, path string, userID int, form url.Values) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr.Code
+	}
+
+	// Alice wrote comment 2, but the thread is archived
+	edit := url.Values{"commentId": {"2"}, "commentContent": {"changed"}}
+	if code := do(handlers.UpdateCommentHandler(db), "/edit_comment", 1, edit); code != http.StatusForbidden {
+		t.Errorf("expected a member's edit in an archived thread to be refused, got %d", code)
+	}
+	if code := do(handlers.HandlerDeleteComment(db), "/delete_comment/2", 1, nil); code != http.StatusForbidden {
+		t.Errorf("expected a member's delete in an archived thread to be refused, got %d", code)
+	}
+	var content string
+	db.QueryRow("SELECT content FROM comments WHERE id = 2").Scan(&content)
+	if content == "changed" {
+		t.Error("expected the comment to be left alone")
+	}
+
+	// Moderators still clean up closed threads, but rollbacks need it reopened
+	if code := do(handlers.HandlerDeleteComment(db), "/delete_comment/2", 2, nil); code != http.StatusSeeOther {
+		t.Errorf("expected a moderator to delete in an archived thread, got %d", code)
+	}
+	rollback := url.Values{"target_id": {"1"}, "revision_id": {"1"}}
+	if code := do(handlers.HandlerRollbackPost(db), "/rollback_post", 2, rollback); code != http.StatusForbidden {
+		t.Errorf("expected a rollback in an archived thread to be refused, got %d", code)
+	}
+}
+
+func TestPinInOwnCategoryOnly(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	// Post 1 is in category 1 only
+	if err := utils.SetPostPinned(ctx, db, 1, 2, 2, true); err != utils.ErrPostNotInCategory {
+		t.Errorf("expected ErrPostNotInCategory, got %v", err)
+	}
+	var pins int
+	db.QueryRow("SELECT COUNT(*) FROM post_pins WHERE post_id = 1").Scan(&pins)
+	if pins != 0 {
+		t.Errorf("expected no pin in an unrelated category, got %d", pins)
+	}
+	if err := utils.SetPostPinned(ctx, db, 1, 1, 2, true); err != nil {
+		t.Errorf("expected a pin in the post's category, got %v", err)
+	}
+}
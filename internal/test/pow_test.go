@@ -0,0 +1,157 @@
+package test
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"forum/internal/middleware"
+	"forum/internal/security"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// solveChallenge brute-forces a nonce the way the forms' script does
+func solveChallenge(t *testing.T, token string, difficulty int) string {
+	t.Helper()
+	for nonce := 0; nonce < 1<<24; nonce++ {
+		sum := sha256.Sum256([]byte(token + ":" + strconv.Itoa(nonce)))
+		if security.LeadingZeroBits(sum[:]) >= difficulty {
+			return strconv.Itoa(nonce)
+		}
+	}
+	t.Fatalf("no nonce found for difficulty %d", difficulty)
+	return ""
+}
+
+func TestVerifyChallenge(t *testing.T) {
+	security.InitHMACSecret("test-secret")
+	now := time.Now()
+	c := security.NewChallenge("register", 8, time.Minute, now)
+	nonce := solveChallenge(t, c.Token, c.Difficulty)
+
+	solved, err := security.VerifyChallenge(c.Token, nonce, "register", now)
+	if err != nil || solved.Difficulty != 8 || solved.ID == "" {
+		t.Fatalf("expected the solution to verify, got %+v, %v", solved, err)
+	}
+
+	// Lowering the difficulty in the token breaks its signature
+	encoded, sig, _ := strings.Cut(c.Token, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(encoded)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(payload), "|8|", "|0|", 1))) + "." + sig
+
+	for name, tc := range map[string]struct {
+		token, nonce, form string
+		at                 time.Time
+		want               error
+	}{
+		"other form": {c.Token, nonce, "login", now, security.ErrChallengeInvalid},
+		"forged":     {forged, nonce, "register", now, security.ErrChallengeInvalid},
+		"garbage":    {"not-a-token", nonce, "register", now, security.ErrChallengeInvalid},
+		"expired":    {c.Token, nonce, "register", now.Add(2 * time.Minute), security.ErrChallengeExpired},
+		"no nonce":   {c.Token, "", "register", now, security.ErrChallengeUnsolved},
+	} {
+		if _, err := security.VerifyChallenge(tc.token, tc.nonce, tc.form, tc.at); err != tc.want {
+			t.Errorf("%s: got %v, want %v", name, err, tc.want)
+		}
+	}
+
+	// A nonce that does not reach the difficulty is refused
+	for n := 0; ; n++ {
+		sum := sha256.Sum256([]byte(c.Token + ":" + strconv.Itoa(n)))
+		if security.LeadingZeroBits(sum[:]) < 8 {
+			if _, err := security.VerifyChallenge(c.Token, strconv.Itoa(n), "register", now); err != security.ErrChallengeUnsolved {
+				t.Errorf("expected an insufficient nonce to be refused, got %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestProofOfWorkMiddleware(t *testing.T) {
+	security.InitHMACSecret("test-secret")
+	limitRoutes(t, nil)
+	middleware.SetProofOfWork(middleware.PoWPolicy{Enabled: true, Threshold: 2, Window: time.Minute, Difficulty: 4, MaxDifficulty: 5, TTL: time.Minute})
+	t.Cleanup(func() {
+		middleware.SetProofOfWork(middleware.PoWPolicy{Enabled: true, Threshold: 3, Window: 15 * time.Minute, Difficulty: 16, MaxDifficulty: 22, TTL: 5 * time.Minute})
+	})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pow/challenge", middleware.PoWChallenge())
+	mux.HandleFunc("/register-submit", testHandler)
+	mux.HandleFunc("/login-submit", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Invalid email or password", http.StatusUnauthorized)
+	})
+	handler := middleware.TrustProxies(nil, middleware.ProofOfWork(mux))
+
+	submit := func(path, ip, token, nonce string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = ip + ":4000"
+		if token != "" {
+			req.Header.Set(middleware.PoWTokenHeader, token)
+			req.Header.Set(middleware.PoWNonceHeader, nonce)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	challenge := func(form, ip string) (required bool, c security.Challenge) {
+		req := httptest.NewRequest(http.MethodGet, "/pow/challenge?form="+form, nil)
+		req.RemoteAddr = ip + ":4000"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var body struct {
+			Required bool `json:"required"`
+			security.Challenge
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&body); err != nil {
+			t.Fatalf("challenge for %s: %v", form, err)
+		}
+		return body.Required, body.Challenge
+	}
+
+	// The first submissions need nothing
+	const ip = "203.0.113.40"
+	if required, _ := challenge("register", ip); required {
+		t.Fatal("expected no challenge before any submission")
+	}
+	for i := 0; i < 2; i++ {
+		if rr := submit("/register-submit", ip, "", ""); rr.Code != http.StatusOK {
+			t.Fatalf("submission %d: got %d", i, rr.Code)
+		}
+	}
+
+	// Then a solution is required
+	rr := submit("/register-submit", ip, "", "")
+	if rr.Code != http.StatusForbidden || rr.Header().Get(middleware.PoWRequiredHeader) == "" {
+		t.Fatalf("expected a challenge to be demanded, got %d %v", rr.Code, rr.Header())
+	}
+	required, c := challenge("register", ip)
+	if !required || c.Difficulty != 5 {
+		t.Fatalf("expected a challenge of 5 bits after three submissions, got %v %+v", required, c)
+	}
+	nonce := solveChallenge(t, c.Token, c.Difficulty)
+	if rr := submit("/register-submit", ip, c.Token, nonce); rr.Code != http.StatusOK {
+		t.Fatalf("expected the solved submission to pass, got %d", rr.Code)
+	}
+	// Once
+	if rr := submit("/register-submit", ip, c.Token, nonce); rr.Code != http.StatusForbidden {
+		t.Errorf("expected a replayed solution to be refused, got %d", rr.Code)
+	}
+	// Nor for another form
+	if rr := submit("/login-submit", ip, c.Token, nonce); rr.Code != http.StatusForbidden || rr.Header().Get(middleware.PoWRequiredHeader) == "" {
+		t.Errorf("expected a solution for another form to be refused, got %d", rr.Code)
+	}
+
+	// A failed sign-in counts twice, so one is enough to need a challenge
+	const other = "198.51.100.40"
+	if rr := submit("/login-submit", other, "", ""); rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected the handler's answer, got %d", rr.Code)
+	}
+	if required, c := challenge("login", other); !required || c.Difficulty != 4 {
+		t.Errorf("expected a 4 bit challenge after a failed sign-in, got %v %+v", required, c)
+	}
+}
@@ -0,0 +1,108 @@
+package test
+
+import (
+	"context"
+	"forum/internal"
+	"forum/internal/handlers"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPreModerationCheck(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	now := time.Now()
+
+	// Alice has published one post and one comment
+	comment := utils.Submission{Kind: "comment", UserID: 1, Content: "Another comment"}
+	if f, err := (utils.PreModeration{FirstPosts: 3}).Check(ctx, db, comment, now); err != nil || f.Verdict != utils.SpamHold {
+		t.Errorf("expected a new member's third submission to be held, got %+v, %v", f, err)
+	}
+	if f, _ := (utils.PreModeration{FirstPosts: 2}).Check(ctx, db, comment, now); f.Verdict != utils.SpamAllow {
+		t.Errorf("expected the third submission to pass once two are published, got %v", f.Verdict)
+	}
+	comment.AuthorTrusted = true
+	if f, _ := (utils.PreModeration{FirstPosts: 3}).Check(ctx, db, comment, now); f.Verdict != utils.SpamAllow {
+		t.Errorf("expected trusted members to skip pre-moderation, got %v", f.Verdict)
+	}
+
+	categories := utils.PreModeration{Categories: []string{"science"}}
+	post := utils.Submission{Kind: "post", UserID: 1, Title: "Black holes", CategoryIDs: []int{1, 2}}
+	f, err := categories.Check(ctx, db, post, now)
+	if err != nil || f.Verdict != utils.SpamHold || !strings.Contains(f.Reason, "Science") {
+		t.Errorf("expected a post in Science to be held, got %+v, %v", f, err)
+	}
+	post.CategoryIDs = []int{1}
+	if f, _ := categories.Check(ctx, db, post, now); f.Verdict != utils.SpamAllow {
+		t.Errorf("expected a post in Technology to pass, got %v", f.Verdict)
+	}
+}
+
+func TestPreModerationReview(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	errors.Init(getTemplatePath())
+	ctx := context.Background()
+	utils.SetSpamFilter(utils.NewSpamFilter(utils.PreModeration{FirstPosts: 10}))
+	t.Cleanup(func() { utils.SetSpamFilter(nil) })
+
+	post := func(h http.HandlerFunc, userID int, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+	notification := func(notifType string) (message string, commentID int) {
+		db.QueryRow("SELECT COALESCE(message, ''), COALESCE(comment_id, 0) FROM notifications WHERE user_id = 1 AND type = ?", notifType).Scan(&message, &commentID)
+		return
+	}
+
+	post(handlers.CreateCommentHandler(db), 1, url.Values{"postId": {"1"}, "content": {"First question"}})
+	post(handlers.CreateCommentHandler(db), 1, url.Values{"postId": {"1"}, "content": {"Second question"}})
+
+	// Only the author and moderators see the pending comments
+	if held, _ := utils.GetHeldComments(ctx, db, 1, 1); len(held) != 2 {
+		t.Fatalf("expected the author to see both comments, got %d", len(held))
+	}
+	if held, _ := utils.GetHeldComments(ctx, db, 1, 2); len(held) != 0 {
+		t.Errorf("expected another member to see none, got %d", len(held))
+	}
+	if held, _ := utils.GetHeldComments(ctx, db, 1, 0); len(held) != 2 {
+		t.Errorf("expected moderators to see both, got %d", len(held))
+	}
+
+	review := handlers.ReviewHeldContentHandler(db, 1)
+	rr := post(review, 2, url.Values{"id": {"1"}, "decision": {"reject"}, "reason": {"Please search first"}})
+	if rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the comment to be rejected, got %d", rr.Code)
+	}
+	if message, _ := notification("rejected"); message != "Please search first" {
+		t.Errorf("expected the author to be told why, got %q", message)
+	}
+	mine, _ := utils.GetHeldContentByUser(ctx, db, 1)
+	if len(mine) != 2 || mine[1].Status != utils.HeldRejected || mine[1].DecisionReason != "Please search first" {
+		t.Errorf("unexpected submissions on the author's page %+v", mine)
+	}
+	if training, _ := utils.GetSpamTraining(ctx, db); training.Spam+training.Ham != 0 {
+		t.Errorf("expected a rejection that is not spam not to train the classifier, got %+v", training)
+	}
+
+	// One approval is enough to be trusted here
+	if rr := post(review, 2, url.Values{"id": {"2"}, "decision": {"ham"}}); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the comment to be approved, got %d", rr.Code)
+	}
+	if _, commentID := notification("approved"); commentID == 0 {
+		t.Error("expected the approval notification to point at the published comment")
+	}
+	if result := utils.CheckSpam(ctx, db, utils.Submission{Kind: "comment", UserID: 1, Content: "Third question"}); result.Verdict != utils.SpamAllow {
+		t.Errorf("expected a trusted member to skip pre-moderation, got %v", result.Verdict)
+	}
+}
@@ -0,0 +1,171 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"forum/internal/config"
+	"forum/internal/middleware"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newLimitDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`CREATE TABLE rate_limits (key TEXT PRIMARY KEY, tat INTEGER NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestLimitStores(t *testing.T) {
+	db := newLimitDB(t)
+	for name, store := range map[string]middleware.LimitStore{
+		"memory": middleware.NewMemoryLimitStore(),
+		"sqlite": middleware.NewSQLiteLimitStore(db),
+	} {
+		policy := middleware.Policy{Burst: 3, Interval: time.Second}
+		now := time.Unix(1700000000, 0)
+		take := func(at time.Time) middleware.Decision {
+			d, err := store.Take(context.Background(), "client", policy, at)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			return d
+		}
+
+		for want := 2; want >= 0; want-- {
+			if d := take(now); !d.Allowed || d.Remaining != want {
+				t.Errorf("%s: expected an allowed request with %d remaining, got %+v", name, want, d)
+			}
+		}
+		d := take(now)
+		if d.Allowed || d.RetryAfter != time.Second || d.Reset != 3*time.Second {
+			t.Errorf("%s: expected a denial for a second, got %+v", name, d)
+		}
+		if d := take(now.Add(time.Second)); !d.Allowed || d.Remaining != 0 {
+			t.Errorf("%s: expected one request after a second, got %+v", name, d)
+		}
+
+		// Full buckets are forgotten
+		if err := store.Expire(context.Background(), now.Add(time.Minute)); err != nil {
+			t.Fatal(err)
+		}
+		if d := take(now.Add(time.Minute)); d.Remaining != 2 {
+			t.Errorf("%s: expected a fresh bucket after expiry, got %+v", name, d)
+		}
+	}
+
+	// Instances sharing the database share the limit
+	other := middleware.NewSQLiteLimitStore(db)
+	d, _ := other.Take(context.Background(), "client", middleware.Policy{Burst: 3, Interval: time.Second}, time.Unix(1700000060, 0))
+	if d.Remaining != 1 {
+		t.Errorf("expected the second store to see the first one's request, got %+v", d)
+	}
+}
+
+// limitRoutes installs route policies on a fresh store for one test
+func limitRoutes(t *testing.T, routes map[string]middleware.Policy) {
+	t.Helper()
+	middleware.SetRateLimitStore(middleware.NewMemoryLimitStore())
+	middleware.SetRouteRateLimits(routes)
+	middleware.SetRateLimitUser(func(r *http.Request) int {
+		id, _ := strconv.Atoi(r.Header.Get("X-Test-User"))
+		return id
+	})
+	t.Cleanup(func() {
+		middleware.SetRateLimitStore(middleware.NewMemoryLimitStore())
+		middleware.SetRouteRateLimits(nil)
+		middleware.SetRateLimitUser(func(*http.Request) int { return 0 })
+	})
+}
+
+func TestRateLimitRoutes(t *testing.T) {
+	limitRoutes(t, map[string]middleware.Policy{
+		"/login-submit": {Burst: 2, Interval: 30 * time.Second},
+		"/like":         {Burst: 1, Interval: time.Minute, PerUser: true},
+	})
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	handler := middleware.TrustProxies(trusted, middleware.RateLimit(http.HandlerFunc(testHandler)))
+
+	do := func(path, remote, xff, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, nil)
+		req.RemoteAddr = remote
+		if xff != "" {
+			req.Header.Set("X-Forwarded-For", xff)
+		}
+		if user != "" {
+			req.Header.Set("X-Test-User", user)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// Ports do not give a client a fresh bucket
+	do("/login-submit", "203.0.113.5:4000", "", "")
+	rr := do("/login-submit", "203.0.113.5:4001", "", "")
+	if rr.Code != http.StatusOK || rr.Header().Get("RateLimit-Remaining") != "0" || rr.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Errorf("unexpected second login: %d %v", rr.Code, rr.Header())
+	}
+	rr = do("/login-submit", "203.0.113.5:4002", "", "")
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") != "30" {
+		t.Errorf("expected 429 with Retry-After 30, got %d %q", rr.Code, rr.Header().Get("Retry-After"))
+	}
+	// Other routes only have the default policy
+	if rr := do("/", "203.0.113.5:4003", "", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected other routes to be allowed, got %d", rr.Code)
+	}
+
+	// Behind a trusted proxy clients are told apart by X-Forwarded-For, and
+	// a forged header from an untrusted peer changes nothing
+	if rr := do("/login-submit", "10.0.0.2:5000", "198.51.100.7", ""); rr.Code != http.StatusOK {
+		t.Errorf("expected a proxied client to have its own limit, got %d", rr.Code)
+	}
+	if rr := do("/login-submit", "203.0.113.5:4004", "198.51.100.8", ""); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected a forged X-Forwarded-For to be ignored, got %d", rr.Code)
+	}
+
+	// Per-user policies count accounts, even sharing an IP
+	if rr := do("/like", "10.0.0.2:5000", "198.51.100.9", "1"); rr.Code != http.StatusOK {
+		t.Errorf("expected the first like of user 1 to pass, got %d", rr.Code)
+	}
+	if rr := do("/like", "10.0.0.2:5000", "198.51.100.9", "2"); rr.Code != http.StatusOK {
+		t.Errorf("expected user 2 to have their own limit, got %d", rr.Code)
+	}
+	if rr := do("/like", "10.0.0.2:5000", "198.51.100.10", "1"); rr.Code != http.StatusTooManyRequests {
+		t.Errorf("expected user 1 to be limited from another IP, got %d", rr.Code)
+	}
+}
+
+func TestRateLimitRoutePolicies(t *testing.T) {
+	cfg := config.Defaults().RateLimit
+	policies, err := cfg.RoutePolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := config.RoutePolicy{Path: "/createin", Requests: 10, Period: time.Minute, PerUser: true}
+	found := false
+	for _, p := range policies {
+		found = found || p == want
+	}
+	if !found {
+		t.Errorf("expected %+v among the defaults, got %+v", want, policies)
+	}
+
+	for _, bad := range []string{"login-submit=5/1m", "/login-submit=five/1m", "/login-submit=5/soon", "/login-submit"} {
+		cfg.Routes = bad
+		if _, err := cfg.RoutePolicies(); err == nil {
+			t.Errorf("expected %q to be rejected", bad)
+		}
+	}
+}
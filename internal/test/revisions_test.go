@@ -0,0 +1,231 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"forum/internal"
+	"forum/internal/handlers"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDiffWords(t *testing.T) {
+	got := utils.DiffWords("the quick fox", "the slow fox")
+	want := []models.DiffOp{
+		{Type: "equal", Text: "the "},
+		{Type: "delete", Text: "quick"},
+		{Type: "insert", Text: "slow"},
+		{Type: "equal", Text: " fox"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected word diff: %+v", got)
+	}
+}
+
+func TestDiffLarge(t *testing.T) {
+	// 50k words, and then 5k lines, on each side, all of them changed
+	var oldWords, newWords, oldLines, newLines strings.Builder
+	for i := 0; i < 50000; i++ {
+		fmt.Fprintf(&oldWords, "old%d ", i)
+		fmt.Fprintf(&newWords, "new%d ", i)
+	}
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(&oldLines, "old line %d\n", i)
+		fmt.Fprintf(&newLines, "new line %d\n", i)
+	}
+	common := "kept at the start\n"
+
+	for _, c := range []struct{ old, new string }{
+		{oldWords.String(), newWords.String()},
+		{common + oldLines.String() + common, common + newLines.String() + common},
+	} {
+		// Too large to compare token by token, so the changed middle is
+		// replaced as a whole
+		var before, after strings.Builder
+		for _, op := range utils.DiffWords(c.old, c.new) {
+			if op.Type != "insert" {
+				before.WriteString(op.Text)
+			}
+			if op.Type != "delete" {
+				after.WriteString(op.Text)
+			}
+		}
+		if before.String() != c.old || after.String() != c.new {
+			t.Error("expected the diff to keep both texts")
+		}
+	}
+	got := utils.DiffLines(common+oldLines.String(), common+newLines.String())
+	if len(got) != 3 || got[0].Text != common {
+		t.Errorf("expected the common start to stay equal, got %d ops", len(got))
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	got := utils.DiffLines("a\nb\nc\n", "a\nc\nd\n")
+	want := []models.DiffOp{
+		{Type: "equal", Text: "a\n"},
+		{Type: "delete", Text: "b\n"},
+		{Type: "equal", Text: "c\n"},
+		{Type: "insert", Text: "d\n"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected line diff: %+v", got)
+	}
+}
+
+func TestPostRevisionsAndRollback(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	// Moderator bob (ID 2) edits alice's post (ID 1)
+	err := utils.UpdatePostFull(ctx, db, 1, 2, "spam link removed", "Hello World", "Cleaned up content",
+		[]string{"1"}, "Go", nil, nil)
+	if err != nil {
+		t.Fatalf("UpdatePostFull returned error: %v", err)
+	}
+
+	revisions, authorID, err := utils.GetPostRevisions(db, 1)
+	if err != nil {
+		t.Fatalf("GetPostRevisions returned error: %v", err)
+	}
+	if authorID != 1 {
+		t.Errorf("expected author 1, got %d", authorID)
+	}
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions (original + edit), got %d", len(revisions))
+	}
+	if revisions[0].Content != "This is a test post" || revisions[0].IsModeratorEdit {
+		t.Errorf("first revision should be the author's original, got %+v", revisions[0])
+	}
+	if !revisions[1].IsModeratorEdit || revisions[1].Reason != "spam link removed" {
+		t.Errorf("second revision should be attributed to the moderator, got %+v", revisions[1])
+	}
+
+	if err := utils.RollbackPostToRevision(ctx, db, 1, revisions[0].ID, 2); err != nil {
+		t.Fatalf("RollbackPostToRevision returned error: %v", err)
+	}
+
+	var content string
+	if err := db.QueryRow("SELECT content FROM posts WHERE id = 1").Scan(&content); err != nil {
+		t.Fatal(err)
+	}
+	if content != "This is a test post" {
+		t.Errorf("expected original content after rollback, got %q", content)
+	}
+
+	revisions, _, _ = utils.GetPostRevisions(db, 1)
+	if len(revisions) != 3 || revisions[2].Reason != "Rolled back to revision #1" {
+		t.Errorf("rollback should be recorded as a new revision, got %+v", revisions)
+	}
+}
+
+func TestUpdatePostDropsRemovedTags(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	// Alice's post (ID 1) is tagged Go; the edit replaces it with two others
+	err := utils.UpdatePostFull(ctx, db, 1, 1, "", "Hello World", "This is a test post",
+		[]string{"1"}, "Rust, Web", nil, nil)
+	if err != nil {
+		t.Fatalf("UpdatePostFull returned error: %v", err)
+	}
+
+	tags, err := utils.GetPostTags(db, 1)
+	if err != nil {
+		t.Fatalf("GetPostTags returned error: %v", err)
+	}
+	sort.Strings(tags)
+	if !reflect.DeepEqual(tags, []string{"Rust", "Web"}) {
+		t.Errorf("expected only the tags of the edit, got %v", tags)
+	}
+}
+
+func TestCommentRevisionsAndRollback(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	// Comment 2 belongs to alice (ID 1)
+	if err := utils.UpdateCommentContent(ctx, db, 2, 1, "Thanks a lot!", ""); err != nil {
+		t.Fatalf("UpdateCommentContent returned error: %v", err)
+	}
+
+	revisions, authorID, postID, err := utils.GetCommentRevisions(db, 2)
+	if err != nil {
+		t.Fatalf("GetCommentRevisions returned error: %v", err)
+	}
+	if authorID != 1 || postID != 1 {
+		t.Errorf("unexpected author/post: %d/%d", authorID, postID)
+	}
+	if len(revisions) != 2 || revisions[1].IsModeratorEdit {
+		t.Fatalf("expected original + author edit, got %+v", revisions)
+	}
+
+	if err := utils.RollbackCommentToRevision(ctx, db, 2, revisions[0].ID, 2); err != nil {
+		t.Fatalf("RollbackCommentToRevision returned error: %v", err)
+	}
+
+	var content string
+	if err := db.QueryRow("SELECT content FROM comments WHERE id = 2").Scan(&content); err != nil {
+		t.Fatal(err)
+	}
+	if content != "Thank you!" {
+		t.Errorf("expected original comment after rollback, got %q", content)
+	}
+}
+
+func TestDeletedContentIsClosed(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	errors.Init(getTemplatePath())
+	if err := utils.InitTemplates(filepath.Dir(getTemplatePath()), false); err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE posts SET deleted_at = CURRENT_TIMESTAMP WHERE id = 2")
+	db.Exec("UPDATE comments SET deleted_at = CURRENT_TIMESTAMP WHERE id = 1")
+
+	request := func(h http.HandlerFunc, method, path string, userID int, form url.Values) int {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr.Code
+	}
+
+	// Only moderators see the history of deleted content
+	if code := request(handlers.HandlerPostHistory(db), http.MethodGet, "/post_history/2", 1, nil); code != http.StatusNotFound {
+		t.Errorf("expected the history of a deleted post to be hidden, got %d", code)
+	}
+	if code := request(handlers.HandlerCommentHistory(db), http.MethodGet, "/comment_history/1", 1, nil); code != http.StatusNotFound {
+		t.Errorf("expected the history of a deleted comment to be hidden, got %d", code)
+	}
+	if code := request(handlers.HandlerPostHistory(db), http.MethodGet, "/post_history/2", 2, nil); code != http.StatusOK {
+		t.Errorf("expected moderators to see the history of a deleted post, got %d", code)
+	}
+
+	// Deleted comments can be neither edited nor replied to
+	bob := url.Values{"commentId": {"1"}, "commentContent": {"Edited"}}
+	if code := request(handlers.UpdateCommentHandler(db), http.MethodPost, "/edit_comment", 2, bob); code != http.StatusNotFound {
+		t.Errorf("expected editing a deleted comment to fail, got %d", code)
+	}
+	reply := url.Values{"post_id": {"1"}, "parent_comment_id": {"1"}, "reply_content": {"Replying"}}
+	if code := request(handlers.HandlerAddReply(db, handlers.NewHub()), http.MethodPost, "/add-reply", 1, reply); code != http.StatusNotFound {
+		t.Errorf("expected replying to a deleted comment to fail, got %d", code)
+	}
+	var replies int
+	db.QueryRow("SELECT COUNT(*) FROM comments WHERE parent_comment_id = 1").Scan(&replies)
+	if replies != 0 {
+		t.Errorf("expected no reply to be saved, got %d", replies)
+	}
+}
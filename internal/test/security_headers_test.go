@@ -0,0 +1,101 @@
+package test
+
+import (
+	"forum/internal/middleware"
+	"forum/internal/security"
+	"forum/internal/utils"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	var nonces []string
+	handler := middleware.SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonces = append(nonces, security.CSPNonce(r.Context()))
+	}))
+
+	var policies []string
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		for header, want := range map[string]string{
+			"X-Content-Type-Options": "nosniff",
+			"X-Frame-Options":        "DENY",
+			"Referrer-Policy":        "strict-origin-when-cross-origin",
+		} {
+			if got := rr.Header().Get(header); got != want {
+				t.Errorf("%s: expected %q, got %q", header, want, got)
+			}
+		}
+		if !strings.Contains(rr.Header().Get("Permissions-Policy"), "camera=()") {
+			t.Errorf("unexpected Permissions-Policy %q", rr.Header().Get("Permissions-Policy"))
+		}
+		policies = append(policies, rr.Header().Get("Content-Security-Policy"))
+	}
+
+	if nonces[0] == "" || nonces[0] == nonces[1] {
+		t.Fatalf("expected a fresh nonce per request, got %q", nonces)
+	}
+	for i, policy := range policies {
+		for _, want := range []string{"script-src 'self' 'nonce-" + nonces[i] + "'", "object-src 'none'", "frame-ancestors 'none'"} {
+			if !strings.Contains(policy, want) {
+				t.Errorf("policy lacks %q: %s", want, policy)
+			}
+		}
+		if strings.Contains(policy, "'unsafe-inline'") && !strings.Contains(policy, "style-src-attr 'unsafe-inline'") {
+			t.Errorf("inline scripts must not be allowed: %s", policy)
+		}
+	}
+}
+
+func TestPageCarriesNonce(t *testing.T) {
+	registry, err := utils.NewTemplateRegistry(filepath.Dir(getTemplatePath()), utils.Layouts, utils.Pages, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := middleware.SecurityHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := registry.Render(w, r, "login", struct{ Error string }{}); err != nil {
+			t.Fatal(err)
+		}
+	}))
+	// The shared template carries each request's own nonce
+	for i := 0; i < 2; i++ {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/login", nil))
+
+		policy := rr.Header().Get("Content-Security-Policy")
+		start := strings.Index(policy, "'nonce-") + len("'nonce-")
+		nonce := policy[start : start+strings.Index(policy[start:], "'")]
+		if !strings.Contains(rr.Body.String(), `<script nonce="`+nonce+`" src="/static/js/actions.js">`) {
+			t.Errorf("expected the layout scripts to carry nonce %s:\n%s", nonce, rr.Body.String())
+		}
+	}
+}
+
+// Pages must never be rendered by text/template, which escapes nothing
+func TestNoTextTemplateImports(t *testing.T) {
+	root := filepath.Join(filepath.Dir(getTemplatePath()), "..")
+	fset := token.NewFileSet()
+	filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".go") || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		f, err := parser.ParseFile(fset, path, nil, parser.ImportsOnly)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, imp := range f.Imports {
+			if p, _ := strconv.Unquote(imp.Path.Value); p == "text/template" {
+				t.Errorf("%s imports text/template", path)
+			}
+		}
+		return nil
+	})
+}
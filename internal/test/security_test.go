@@ -6,7 +6,6 @@ import (
 	"github.com/google/uuid"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 )
 
@@ -85,8 +84,7 @@ func TestRateLimit(t *testing.T) {
 
 func TestSignAndVerifySessionID(t *testing.T) {
 	// Initialize the secret (for testing)
-	os.Setenv("SESSION_HMAC_SECRET", "test-secret-key")
-	security.InitHMACSecret()
+	security.InitHMACSecret("test-secret-key")
 
 	testSessionID := "session123"
 
@@ -107,8 +105,7 @@ func TestSignAndVerifySessionID(t *testing.T) {
 }
 
 func TestVerifySignedSessionID_Invalid(t *testing.T) {
-	os.Setenv("SESSION_HMAC_SECRET", "test-secret-key")
-	security.InitHMACSecret()
+	security.InitHMACSecret("test-secret-key")
 
 	// Incorrect format (no '|')
 	invalid := "invalidsignaturestring"
@@ -3,6 +3,7 @@ package test
 import (
 	"database/sql"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -10,7 +11,10 @@ import (
 func SetupTestDB(t *testing.T) (*sql.DB, func()) {
 	t.Helper()
 
-	db, err := sql.Open("sqlite3", "file::memory:?cache=shared")
+	// Each test gets its own named in-memory DB so data and table locks
+	// left behind by one test can't leak into the next
+	dsn := "file:" + strings.ReplaceAll(t.Name(), "/", "_") + "?mode=memory&cache=shared"
+	db, err := sql.Open("sqlite3", dsn)
 	if err != nil {
 		t.Fatalf("failed to open in-memory DB: %v", err)
 	}
@@ -27,7 +31,13 @@ func SetupTestDB(t *testing.T) (*sql.DB, func()) {
 		avatar_url TEXT DEFAULT NULL,
 		banned BOOLEAN DEFAULT FALSE,
 		provider TEXT DEFAULT '',
-		provider_id TEXT DEFAULT ''
+		provider_id TEXT DEFAULT '',
+		registration_ip TEXT DEFAULT '',
+		last_login_ip TEXT DEFAULT '',
+		trusted_at DATETIME,
+		reputation INTEGER DEFAULT 0,
+		trust_level INTEGER DEFAULT 0,
+		reputation_updated_at DATETIME
 	);
 
 	CREATE TABLE IF NOT EXISTS categories (
@@ -43,6 +53,16 @@ func SetupTestDB(t *testing.T) (*sql.DB, func()) {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		updated_at DATETIME,
 		image_path TEXT,
+		deleted_at DATETIME,
+		deleted_by INTEGER,
+		delete_reason TEXT DEFAULT '',
+		locked_at DATETIME,
+		locked_by INTEGER,
+		is_announcement BOOLEAN DEFAULT 0,
+		archived_at DATETIME,
+		state_changed_at DATETIME,
+		merged_into INTEGER,
+		is_wiki BOOLEAN DEFAULT 0,
 		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
 	);
 
@@ -53,6 +73,10 @@ func SetupTestDB(t *testing.T) (*sql.DB, func()) {
 		is_primary BOOLEAN DEFAULT FALSE,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		order_index INTEGER DEFAULT 0,
+		thumb_small TEXT,
+		thumb_medium TEXT,
+		caption TEXT DEFAULT '',
+		alt_text TEXT DEFAULT '',
 		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE
 	);
 
@@ -63,6 +87,9 @@ func SetupTestDB(t *testing.T) (*sql.DB, func()) {
 		content TEXT NOT NULL,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 		parent_comment_id INTEGER,
+		deleted_at DATETIME,
+		deleted_by INTEGER,
+		delete_reason TEXT DEFAULT '',
 		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
 		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
 		FOREIGN KEY (parent_comment_id) REFERENCES comments(id) ON DELETE CASCADE
@@ -127,12 +154,13 @@ func SetupTestDB(t *testing.T) (*sql.DB, func()) {
 	CREATE TABLE IF NOT EXISTS notifications (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		user_id INTEGER NOT NULL,
-		type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment', 'reply')),
+		type TEXT NOT NULL CHECK (type IN ('like', 'dislike', 'comment', 'reply', 'approved', 'rejected', 'badge')),
 		post_id INTEGER,
 		comment_id INTEGER,
 		actor_id INTEGER,
 		is_read BOOLEAN DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		message TEXT DEFAULT '',
 		FOREIGN KEY (user_id) REFERENCES users(id),
 		FOREIGN KEY (post_id) REFERENCES posts(id),
 		FOREIGN KEY (comment_id) REFERENCES comments(id),
@@ -148,6 +176,130 @@ func SetupTestDB(t *testing.T) (*sql.DB, func()) {
 		expires_at DATETIME NOT NULL,
 		FOREIGN KEY (user_id) REFERENCES users(id)
 	);
+
+	CREATE TABLE IF NOT EXISTS post_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		editor_id INTEGER NOT NULL,
+		title TEXT NOT NULL,
+		content TEXT NOT NULL,
+		tags TEXT DEFAULT '',
+		categories TEXT DEFAULT '',
+		reason TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+		FOREIGN KEY (editor_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS comment_revisions (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		comment_id INTEGER NOT NULL,
+		editor_id INTEGER NOT NULL,
+		content TEXT NOT NULL,
+		reason TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (comment_id) REFERENCES comments(id) ON DELETE CASCADE,
+		FOREIGN KEY (editor_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS post_pins (
+		post_id INTEGER NOT NULL,
+		category_id INTEGER NOT NULL DEFAULT 0,
+		pinned_by INTEGER NOT NULL,
+		pinned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		PRIMARY KEY (post_id, category_id),
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+		FOREIGN KEY (pinned_by) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS moderation_log (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		moderator_id INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		post_id INTEGER NOT NULL,
+		target_post_id INTEGER,
+		details TEXT DEFAULT '',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (moderator_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS post_attachments (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		post_id INTEGER NOT NULL,
+		user_id INTEGER NOT NULL,
+		file_path TEXT NOT NULL,
+		original_name TEXT NOT NULL,
+		content_type TEXT NOT NULL,
+		size INTEGER NOT NULL,
+		download_count INTEGER NOT NULL DEFAULT 0,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		FOREIGN KEY (post_id) REFERENCES posts(id) ON DELETE CASCADE,
+		FOREIGN KEY (user_id) REFERENCES users(id)
+	);
+
+	CREATE TABLE IF NOT EXISTS upload_orphans (
+		key TEXT PRIMARY KEY,
+		first_seen DATETIME NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS rate_limits (
+		key TEXT PRIMARY KEY,
+		tat INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS ip_blocks (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		cidr TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		reason TEXT NOT NULL DEFAULT '',
+		created_by INTEGER REFERENCES users(id),
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		expires_at DATETIME
+	);
+
+	CREATE TABLE IF NOT EXISTS held_content (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		kind TEXT NOT NULL,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		post_id INTEGER,
+		parent_comment_id INTEGER,
+		title TEXT NOT NULL DEFAULT '',
+		content TEXT NOT NULL,
+		payload TEXT NOT NULL DEFAULT '{}',
+		reasons TEXT NOT NULL DEFAULT '',
+		spam_score REAL,
+		status TEXT NOT NULL DEFAULT 'pending',
+		reviewed_by INTEGER REFERENCES users(id),
+		reviewed_at DATETIME,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		decision_reason TEXT DEFAULT ''
+	);
+
+	CREATE TABLE IF NOT EXISTS spam_tokens (
+		token TEXT PRIMARY KEY,
+		spam INTEGER NOT NULL DEFAULT 0,
+		ham INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS spam_training (
+		class TEXT PRIMARY KEY,
+		documents INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS user_badges (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+		badge TEXT NOT NULL,
+		ref_id INTEGER NOT NULL DEFAULT 0,
+		awarded_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		notified BOOLEAN NOT NULL DEFAULT 0,
+		UNIQUE(user_id, badge, ref_id)
+	);
+
+	CREATE TABLE IF NOT EXISTS badge_rules (
+		badge TEXT PRIMARY KEY,
+		backfilled_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
 	`
 
 	_, err = db.Exec(schema)
@@ -0,0 +1,284 @@
+package test
+
+import (
+	"context"
+	"forum/internal"
+	"forum/internal/handlers"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSpamChecks(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	now := time.Now()
+
+	links := utils.LinkLimit{NewAccountAge: 7 * 24 * time.Hour, MaxLinks: 2}
+	spammy := utils.Submission{Kind: "post", Content: "https://a.example http://b.example www.c.example", AuthorSince: now.Add(-time.Hour)}
+	if f, _ := links.Check(ctx, db, spammy, now); f.Verdict != utils.SpamHold {
+		t.Errorf("expected three links from a new account to be held, got %v", f.Verdict)
+	}
+	spammy.AuthorSince = now.Add(-30 * 24 * time.Hour)
+	if f, _ := links.Check(ctx, db, spammy, now); f.Verdict != utils.SpamAllow {
+		t.Errorf("expected an older account to post links freely, got %v", f.Verdict)
+	}
+
+	// The seeded post is repeated with different case and punctuation
+	dup := utils.DuplicateContent{Window: time.Hour, MinLength: 10}
+	f, err := dup.Check(ctx, db, utils.Submission{Kind: "comment", Content: "this is a TEST post!!"}, now)
+	if err != nil || f.Verdict != utils.SpamHold {
+		t.Errorf("expected a repeated post to be held, got %v, %v", f.Verdict, err)
+	}
+	if f, _ := dup.Check(ctx, db, utils.Submission{Kind: "comment", Content: "Nice post!"}, now); f.Verdict != utils.SpamAllow {
+		t.Errorf("expected short replies to be left alone, got %v", f.Verdict)
+	}
+	if f, _ := dup.Check(ctx, db, utils.Submission{Kind: "comment", Content: "this is a test post"}, now.Add(2*time.Hour)); f.Verdict != utils.SpamAllow {
+		t.Errorf("expected content older than the window not to count, got %v", f.Verdict)
+	}
+
+	banned := utils.NewBannedContent([]string{"casino", "free money"}, []*regexp.Regexp{regexp.MustCompile(`(?i)v[i1]agra`)}, utils.SpamReject)
+	for text, want := range map[string]utils.SpamVerdict{
+		"Best CASINO bonus":    utils.SpamReject,
+		"get free money today": utils.SpamReject,
+		"cheap V1agra":         utils.SpamReject,
+		"casinos of Monaco":    utils.SpamAllow,
+		"a free moneybox":      utils.SpamAllow,
+	} {
+		if f, _ := banned.Check(ctx, db, utils.Submission{Content: text}, now); f.Verdict != want {
+			t.Errorf("%q: got %v, want %v", text, f.Verdict, want)
+		}
+	}
+
+	// The strictest verdict wins and every reason is kept
+	result := utils.NewSpamFilter(links, banned).Check(ctx, db, utils.Submission{
+		Content:     "casino https://a.example https://b.example https://c.example",
+		AuthorSince: now,
+	}, now)
+	if result.Verdict != utils.SpamReject || len(result.Reasons) != 2 {
+		t.Errorf("unexpected result %+v", result)
+	}
+}
+
+func TestSpamClassifier(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	classifier := utils.SpamClassifier{MinTraining: 2, Hold: 0.9, Reject: 0.99}
+	sub := utils.Submission{Content: "Cheap pills, buy now at https://pills.example"}
+	if f, _ := classifier.Check(ctx, db, sub, time.Now()); f.Scored {
+		t.Fatal("expected an untrained classifier to stay silent")
+	}
+
+	for _, text := range []string{
+		"Buy cheap pills now at https://pills.example",
+		"Cheap pills and discount offers, buy now",
+		"Limited offer: buy cheap watches now",
+	} {
+		if err := utils.TrainSpam(ctx, db, text, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, text := range []string{
+		"How do I profile a Go program?",
+		"The new release fixed the race in the scheduler",
+		"Thanks, the profiler output helped me find the leak",
+	} {
+		if err := utils.TrainSpam(ctx, db, text, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	training, _ := utils.GetSpamTraining(ctx, db)
+	if training.Spam != 3 || training.Ham != 3 {
+		t.Fatalf("unexpected training counts %+v", training)
+	}
+
+	f, err := classifier.Check(ctx, db, sub, time.Now())
+	if err != nil || !f.Scored || f.Verdict == utils.SpamAllow {
+		t.Errorf("expected spam to be caught, got %+v, %v", f, err)
+	}
+	f, _ = classifier.Check(ctx, db, utils.Submission{Content: "Which profiler works for the Go scheduler?"}, time.Now())
+	if !f.Scored || f.Verdict != utils.SpamAllow || f.Score > 0.5 {
+		t.Errorf("expected a legitimate question to pass, got %+v", f)
+	}
+
+	tokens := utils.SpamTokens("Visit WWW.Pills.example/buy now now")
+	if tokens[0] != "link:pills.example" || strings.Count(strings.Join(tokens, " "), "now") != 1 {
+		t.Errorf("unexpected tokens %v", tokens)
+	}
+}
+
+func TestHeldCommentReview(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	errors.Init(getTemplatePath())
+	utils.SetSpamFilter(utils.NewSpamFilter(utils.NewBannedContent([]string{"casino"}, nil, utils.SpamHold)))
+	t.Cleanup(func() { utils.SetSpamFilter(nil) })
+
+	post := func(h http.HandlerFunc, userID int, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+	countComments := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM comments").Scan(&n)
+		return n
+	}
+
+	// Alice's comment is held instead of published
+	before := countComments()
+	rr := post(handlers.CreateCommentHandler(db), 1, url.Values{"postId": {"1"}, "content": {"My casino review"}})
+	if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/post_page/1?held=1" {
+		t.Fatalf("expected a redirect to the held notice, got %d %s", rr.Code, rr.Header().Get("Location"))
+	}
+	if countComments() != before {
+		t.Fatal("expected the held comment not to be published")
+	}
+	// The same from a moderator is published
+	post(handlers.CreateCommentHandler(db), 2, url.Values{"postId": {"1"}, "content": {"Casino spam is removed here"}})
+	if countComments() != before+1 {
+		t.Fatal("expected a moderator's comment to bypass the filter")
+	}
+
+	items, err := utils.GetHeldContent(context.Background(), db)
+	if err != nil || len(items) != 1 || items[0].UserName != "alice" || items[0].PostTitle != "Hello World" {
+		t.Fatalf("unexpected queue %+v, %v", items, err)
+	}
+
+	// Only moderators decide
+	decide := url.Values{"id": {"1"}, "decision": {"ham"}}
+	if rr := post(handlers.ReviewHeldContentHandler(db, 0), 1, decide); rr.Code != http.StatusForbidden {
+		t.Errorf("expected the author to be refused, got %d", rr.Code)
+	}
+	if rr := post(handlers.ReviewHeldContentHandler(db, 0), 2, decide); rr.Code != http.StatusSeeOther {
+		t.Fatalf("expected the comment to be approved, got %d", rr.Code)
+	}
+	if countComments() != before+2 {
+		t.Error("expected the approved comment to be published")
+	}
+	// Once
+	if rr := post(handlers.ReviewHeldContentHandler(db, 0), 2, decide); rr.Code != http.StatusNotFound {
+		t.Errorf("expected a second decision to be refused, got %d", rr.Code)
+	}
+	if training, _ := utils.GetSpamTraining(context.Background(), db); training.Ham != 1 || training.Spam != 0 {
+		t.Errorf("expected the decision to train the classifier, got %+v", training)
+	}
+}
+
+func TestHeldCommentInClosedThread(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	errors.Init(getTemplatePath())
+	utils.SetSpamFilter(utils.NewSpamFilter(utils.NewBannedContent([]string{"casino"}, nil, utils.SpamHold)))
+	t.Cleanup(func() { utils.SetSpamFilter(nil) })
+	ctx := context.Background()
+
+	post := func(h http.HandlerFunc, userID int, form url.Values) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, userID))
+		rr := httptest.NewRecorder()
+		h(rr, req)
+		return rr
+	}
+	post(handlers.CreateCommentHandler(db), 1, url.Values{"postId": {"1"}, "content": {"My casino review"}})
+
+	// The thread was locked while the comment waited
+	if err := utils.SetPostLocked(ctx, db, 1, 2, true); err != nil {
+		t.Fatal(err)
+	}
+	decide := url.Values{"id": {"1"}, "decision": {"ham"}}
+	if rr := post(handlers.ReviewHeldContentHandler(db, 0), 2, decide); rr.Code != http.StatusConflict {
+		t.Fatalf("expected approval into a locked thread to conflict, got %d", rr.Code)
+	}
+	if items, _ := utils.GetHeldContent(ctx, db); len(items) != 1 {
+		t.Fatalf("expected the comment back in the queue, got %+v", items)
+	}
+
+	// Once the thread is reopened it can be approved
+	if err := utils.SetPostLocked(ctx, db, 1, 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if rr := post(handlers.ReviewHeldContentHandler(db, 0), 2, decide); rr.Code != http.StatusSeeOther {
+		t.Errorf("expected the comment to be approved, got %d", rr.Code)
+	}
+}
+
+func TestHeldContentPublishFailures(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	errors.Init(getTemplatePath())
+	ctx := context.Background()
+
+	decide := func(id int) int {
+		form := url.Values{"id": {strconv.Itoa(id)}, "decision": {"ham"}}
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, 2))
+		rr := httptest.NewRecorder()
+		handlers.ReviewHeldContentHandler(db, 0)(rr, req)
+		return rr.Code
+	}
+	pending := func() int {
+		items, _ := utils.GetHeldContent(ctx, db)
+		return len(items)
+	}
+
+	// A reply to a comment deleted while it waited
+	reply := utils.Submission{Kind: "comment", UserID: 1, PostID: 1, ParentCommentID: 1, Content: "Agreed"}
+	replyID, err := utils.HoldContent(ctx, db, reply, models.HeldPostPayload{}, utils.SpamResult{Verdict: utils.SpamHold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("UPDATE comments SET deleted_at = CURRENT_TIMESTAMP WHERE id = 1")
+	if code := decide(replyID); code != http.StatusConflict || pending() != 1 {
+		t.Errorf("expected the reply to be refused and kept in the queue, got %d with %d pending", code, pending())
+	}
+	var replies int
+	db.QueryRow("SELECT COUNT(*) FROM comments WHERE content = 'Agreed'").Scan(&replies)
+	if replies != 0 {
+		t.Error("expected the reply to a deleted comment not to be published")
+	}
+
+	// A post whose link from the held item cannot be written is not created
+	post := utils.Submission{Kind: "post", UserID: 1, Title: "Held post", Content: "Waiting"}
+	postID, err := utils.HoldContent(ctx, db, post, models.HeldPostPayload{Wiki: true}, utils.SpamResult{Verdict: utils.SpamHold})
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec(`CREATE TRIGGER fail_held_link BEFORE UPDATE OF post_id ON held_content
+		BEGIN SELECT RAISE(ABORT, 'link failed'); END`)
+	if code := decide(postID); code != http.StatusConflict {
+		t.Errorf("expected the approval to fail, got %d", code)
+	}
+	var posts int
+	db.QueryRow("SELECT COUNT(*) FROM posts WHERE title = 'Held post'").Scan(&posts)
+	if posts != 0 || pending() != 2 {
+		t.Fatalf("expected no post and the item back in the queue, got %d posts and %d pending", posts, pending())
+	}
+
+	// Approved again once the failure is gone, the post is created once
+	db.Exec("DROP TRIGGER fail_held_link")
+	if code := decide(postID); code != http.StatusSeeOther {
+		t.Fatalf("expected the post to be approved, got %d", code)
+	}
+	var wiki bool
+	db.QueryRow("SELECT COUNT(*), MAX(is_wiki) FROM posts WHERE title = 'Held post'").Scan(&posts, &wiki)
+	if posts != 1 || !wiki {
+		t.Errorf("expected one wiki post, got %d (wiki %t)", posts, wiki)
+	}
+}
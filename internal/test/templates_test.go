@@ -0,0 +1,144 @@
+package test
+
+import (
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeTemplates writes files into a new directory and returns it
+func writeTemplates(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+var testLayouts = map[string][]string{"main": {"layout.html"}}
+
+var testPages = map[string]utils.PageSpec{"hello": {Layout: "main", Files: []string{"hello.html"}}}
+
+func TestTemplateRegistryLoadsForumPages(t *testing.T) {
+	dir := filepath.Dir(getTemplatePath())
+	if _, err := utils.NewTemplateRegistry(dir, utils.Layouts, utils.Pages, false); err != nil {
+		t.Fatalf("the forum's pages must load: %v", err)
+	}
+
+	// Every template belongs to a page; error.html is rendered on its own
+	used := map[string]bool{"error.html": true}
+	for _, files := range utils.Layouts {
+		for _, f := range files {
+			used[f] = true
+		}
+	}
+	for _, spec := range utils.Pages {
+		for _, f := range spec.Files {
+			used[f] = true
+		}
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.html"))
+	for _, f := range files {
+		if !used[filepath.Base(f)] {
+			t.Errorf("%s is not part of any page", filepath.Base(f))
+		}
+	}
+}
+
+func TestTemplateRegistryFailsFast(t *testing.T) {
+	layout := `{{define "layout"}}<main>{{template "content" .}}</main>{{end}}`
+
+	missing := writeTemplates(t, map[string]string{"layout.html": layout})
+	if _, err := utils.NewTemplateRegistry(missing, testLayouts, testPages, false); err == nil || !strings.Contains(err.Error(), "hello.html") {
+		t.Errorf("expected the missing file to be reported, got %v", err)
+	}
+
+	// Markup whose context depends on a branch cannot be escaped
+	unescapable := writeTemplates(t, map[string]string{
+		"layout.html": layout,
+		"hello.html":  `{{define "content"}}<a href="/user/{{.Name}}{{if .Admin}}" class="admin{{end}}">x</a>{{end}}`,
+	})
+	if _, err := utils.NewTemplateRegistry(unescapable, testLayouts, testPages, false); err == nil || !strings.Contains(err.Error(), "page hello") {
+		t.Errorf("expected the page to be rejected, got %v", err)
+	}
+}
+
+func TestTemplateRegistryRender(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"layout.html": `{{define "layout"}}<main>{{template "content" .}}</main>{{end}}`,
+		"hello.html":  `{{define "content"}}Hello {{.Name}}, {{.Posts.Count}}{{end}}`,
+	})
+	registry, err := utils.NewTemplateRegistry(dir, testLayouts, testPages, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	type posts struct{ Count int }
+	if err := registry.Render(rr, req, "hello", struct {
+		Name  string
+		Posts posts
+	}{"<b>ann</b>", posts{3}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := rr.Body.String(); got != "<main>Hello &lt;b&gt;ann&lt;/b&gt;, 3</main>" {
+		t.Errorf("unexpected page %q", got)
+	}
+
+	// A page failing halfway writes nothing, leaving room for an error page
+	rr = httptest.NewRecorder()
+	if err := registry.Render(rr, req, "hello", struct{ Name string }{"ann"}); err == nil {
+		t.Error("expected an execution error")
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("expected no output from a failed page, got %q", rr.Body.String())
+	}
+
+	if err := registry.Render(httptest.NewRecorder(), req, "missing", nil); err == nil {
+		t.Error("expected an error for an unknown page")
+	}
+}
+
+func TestTemplateRegistryReload(t *testing.T) {
+	dir := writeTemplates(t, map[string]string{
+		"layout.html": `{{define "layout"}}{{template "content" .}}{{end}}`,
+		"hello.html":  `{{define "content"}}first{{end}}`,
+	})
+	registry, err := utils.NewTemplateRegistry(dir, testLayouts, testPages, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func() string {
+		rr := httptest.NewRecorder()
+		if err := registry.Render(rr, httptest.NewRequest(http.MethodGet, "/", nil), "hello", nil); err != nil {
+			t.Fatal(err)
+		}
+		return rr.Body.String()
+	}
+	change := func(content string, at time.Time) {
+		path := filepath.Join(dir, "hello.html")
+		os.WriteFile(path, []byte(content), 0600)
+		os.Chtimes(path, at, at)
+	}
+
+	later := time.Now().Add(time.Minute)
+	change(`{{define "content"}}second{{end}}`, later)
+	if got := render(); got != "second" {
+		t.Errorf("expected the edited page, got %q", got)
+	}
+
+	// A broken edit keeps the page that worked
+	change(`{{define "content"}}{{if}}{{end}}`, later.Add(time.Minute))
+	if got := render(); got != "second" {
+		t.Errorf("expected the previous page to be kept, got %q", got)
+	}
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"forum/internal/utils"
+	"strings"
+	"testing"
+)
+
+func TestMovePost(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	if err := utils.SetPostPinned(ctx, db, 1, 1, 2, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := utils.MovePost(ctx, db, 1, 2, []int{2}); err != nil {
+		t.Fatalf("MovePost returned error: %v", err)
+	}
+
+	var category, pins int
+	db.QueryRow("SELECT category_id FROM post_categories WHERE post_id = 1").Scan(&category)
+	db.QueryRow("SELECT COUNT(*) FROM post_pins WHERE post_id = 1").Scan(&pins)
+	if category != 2 || pins != 0 {
+		t.Errorf("expected post in category 2 without stale pins, got category %d and %d pins", category, pins)
+	}
+
+	entries, err := utils.GetModerationLog(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Action != "move" || entries[0].ModeratorName != "bob" {
+		t.Errorf("unexpected moderation log: %+v", entries)
+	}
+
+	if err := utils.MovePost(ctx, db, 1, 2, []int{99}); err != utils.ErrInvalidThreadAction {
+		t.Errorf("moving to a missing category should fail, got %v", err)
+	}
+}
+
+func TestMergePost(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	// bob already liked post 2, so his like on post 1 is dropped; alice's moves over
+	if _, err := db.Exec("INSERT INTO likes (user_id, post_id, reaction) VALUES (2, 2, 'Dislike')"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO post_attachments (post_id, user_id, file_path, original_name, content_type, size) VALUES (1, 1, '/static/uploads/notes.pdf', 'notes.pdf', 'application/pdf', 10)"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := utils.MergePost(ctx, db, 1, 2, 2); err != nil {
+		t.Fatalf("MergePost returned error: %v", err)
+	}
+
+	post, err := utils.GetPostByID(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.MergedInto != 2 || !post.IsDeleted {
+		t.Errorf("source should redirect to the target, got %+v", post)
+	}
+
+	tree, err := utils.GetCommentTree(db, 2, 0, utils.CommentSortOldest, utils.MaxThreadDepth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tree) != 1 || len(tree[0].Children) != 2 {
+		t.Fatalf("expected the old opening post with its two comments as replies, got %+v", tree)
+	}
+
+	likes, dislikes, _ := utils.GetPostLikesCount(db, 2)
+	if likes != 1 || dislikes != 1 {
+		t.Errorf("expected 1 like and 1 dislike on target, got %d/%d", likes, dislikes)
+	}
+
+	tags, _ := utils.GetPostTags(db, 2)
+	if len(tags) != 2 {
+		t.Errorf("expected tags of both posts on target, got %v", tags)
+	}
+
+	// The source's image follows the target's, which stays primary
+	rows, err := db.Query("SELECT image_path, is_primary FROM post_images WHERE post_id = 2 ORDER BY order_index")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var images []string
+	for rows.Next() {
+		var path string
+		var primary bool
+		rows.Scan(&path, &primary)
+		images = append(images, fmt.Sprintf("%s:%t", path, primary))
+	}
+	rows.Close()
+	if strings.Join(images, " ") != "/images/post2.png:true /images/post1.png:false" {
+		t.Errorf("expected the images of both posts on target, got %v", images)
+	}
+	var attachments int
+	db.QueryRow("SELECT COUNT(*) FROM post_attachments WHERE post_id = 2").Scan(&attachments)
+	if attachments != 1 {
+		t.Errorf("expected the attachment to move to target, got %d", attachments)
+	}
+
+	if err := utils.MergePost(ctx, db, 2, 2, 2); err != utils.ErrInvalidThreadAction {
+		t.Errorf("merging a post into itself should fail, got %v", err)
+	}
+}
+
+func TestSplitComments(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	reply, err := utils.AddComment(db, 1, 1, 1, "a reply to the split comment")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newID, err := utils.SplitComments(ctx, db, 1, []int{1}, 2, "Split thread")
+	if err != nil {
+		t.Fatalf("SplitComments returned error: %v", err)
+	}
+
+	post, err := utils.GetPostByID(db, newID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Title != "Split thread" || post.Content != "Nice post!" || post.UserID != 2 {
+		t.Errorf("new post should be made from the first selected comment, got %+v", post)
+	}
+
+	tree, _ := utils.GetCommentTree(db, newID, 0, utils.CommentSortOldest, utils.MaxThreadDepth)
+	if len(tree) != 1 || tree[0].ID != reply {
+		t.Errorf("reply should be a top-level comment of the new post, got %+v", tree)
+	}
+
+	left, _ := utils.GetCommentsCount(db, 1)
+	if left != 1 {
+		t.Errorf("expected 1 comment left on the original post, got %d", left)
+	}
+
+	if _, err := utils.SplitComments(ctx, db, 1, []int{reply}, 2, "Wrong post"); err != utils.ErrInvalidThreadAction {
+		t.Errorf("splitting a comment of another post should fail, got %v", err)
+	}
+}
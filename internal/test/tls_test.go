@@ -0,0 +1,218 @@
+package test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"forum/internal/config"
+	"forum/internal/middleware"
+	"forum/internal/security"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert writes a certificate for commonName and its key
+func writeSelfSignedCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func servedCommonName(t *testing.T, r *security.CertReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeSelfSignedCert(t, certFile, keyFile, "first")
+
+	reloader, err := security.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := reloader.Reload(); changed || err != nil {
+		t.Errorf("unchanged files must not be reloaded: %v %v", changed, err)
+	}
+
+	// A renewed certificate is picked up
+	writeSelfSignedCert(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if changed, err := reloader.Reload(); !changed || err != nil {
+		t.Fatalf("expected a reload, got %v %v", changed, err)
+	}
+	if cn := servedCommonName(t, reloader); cn != "second" {
+		t.Errorf("expected the renewed certificate, got %q", cn)
+	}
+
+	// A broken replacement keeps the current certificate
+	os.WriteFile(certFile, []byte("not a certificate"), 0600)
+	later = later.Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if _, err := reloader.Reload(); err == nil {
+		t.Error("expected an error for a broken certificate")
+	}
+	if cn := servedCommonName(t, reloader); cn != "second" {
+		t.Errorf("expected the previous certificate to be kept, got %q", cn)
+	}
+
+	if _, err := security.NewCertReloader(filepath.Join(dir, "missing.pem"), keyFile); err == nil {
+		t.Error("expected an error for missing files")
+	}
+}
+
+func TestAutocertManager(t *testing.T) {
+	cfg := config.Defaults().TLS
+	cfg.Domains = "forum.example, www.forum.example"
+	cfg.CacheDir = t.TempDir()
+	cfg.ACMEDirectory = "https://localhost:14000/dir"
+
+	m := security.NewAutocertManager(cfg)
+	if m.Client == nil || m.Client.DirectoryURL != cfg.ACMEDirectory {
+		t.Error("expected the manager to use the configured ACME directory")
+	}
+	for host, allowed := range map[string]bool{"forum.example": true, "www.forum.example": true, "evil.example": false} {
+		if err := m.HostPolicy(context.Background(), host); (err == nil) != allowed {
+			t.Errorf("host %s: allowed=%v, policy error %v", host, allowed, err)
+		}
+	}
+	if tc := security.AutocertTLSConfig(m); !strings.Contains(strings.Join(tc.NextProtos, " "), "acme-tls/1") {
+		t.Errorf("expected tls-alpn-01 support, got %v", tc.NextProtos)
+	}
+}
+
+func TestTrustProxies(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	var gotIP string
+	var gotHTTPS bool
+	handler := middleware.TrustProxies(trusted, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotIP, gotHTTPS = middleware.ClientIP(r), middleware.IsHTTPS(r)
+	}))
+
+	for _, tc := range []struct {
+		name, remote, xff, proto string
+		wantIP                   string
+		wantHTTPS                bool
+	}{
+		{"direct client", "203.0.113.5:4000", "", "", "203.0.113.5", false},
+		{"untrusted peer cannot spoof", "203.0.113.5:4000", "1.2.3.4", "https", "203.0.113.5", false},
+		{"trusted proxy", "10.0.0.2:5000", "198.51.100.7", "https", "198.51.100.7", true},
+		{"client-supplied hop is ignored", "10.0.0.2:5000", "1.2.3.4, 198.51.100.7", "http", "198.51.100.7", false},
+		{"chain of proxies", "10.0.0.2:5000", "198.51.100.7, 10.0.0.9", "", "198.51.100.7", false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remote
+		if tc.xff != "" {
+			req.Header.Set("X-Forwarded-For", tc.xff)
+		}
+		if tc.proto != "" {
+			req.Header.Set("X-Forwarded-Proto", tc.proto)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if gotIP != tc.wantIP || gotHTTPS != tc.wantHTTPS {
+			t.Errorf("%s: got %s https=%v, want %s https=%v", tc.name, gotIP, gotHTTPS, tc.wantIP, tc.wantHTTPS)
+		}
+	}
+}
+
+func TestHSTSAndRedirect(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	handler := middleware.HSTS(365*24*time.Hour, true, ok)
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://forum.example/", nil))
+	if rr.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS must not be sent over plain HTTP")
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "https://forum.example/", nil))
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("unexpected HSTS header %q", got)
+	}
+
+	for _, tc := range []struct {
+		addr, method, target string
+		wantCode             int
+		wantLocation         string
+	}{
+		{":443", http.MethodGet, "http://forum.example/post_page/1?x=2", http.StatusMovedPermanently, "https://forum.example/post_page/1?x=2"},
+		{":8443", http.MethodGet, "http://forum.example:8080/login", http.StatusMovedPermanently, "https://forum.example:8443/login"},
+		{":443", http.MethodPost, "http://forum.example/login-submit", http.StatusPermanentRedirect, "https://forum.example/login-submit"},
+	} {
+		rr := httptest.NewRecorder()
+		middleware.RedirectToHTTPS(tc.addr).ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, nil))
+		if rr.Code != tc.wantCode || rr.Header().Get("Location") != tc.wantLocation {
+			t.Errorf("%s %s: got %d %q", tc.method, tc.target, rr.Code, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestConfigValidateTLS(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Database.EncryptionKey = "key"
+	cfg.TLS.Mode = config.TLSFiles
+	cfg.Server.TrustedProxies = "10.0.0.0/8, not-an-ip"
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{"TLS_CERT_FILE", "TRUSTED_PROXIES"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %s:\n%v", want, err)
+		}
+	}
+
+	cfg.TLS.Mode = config.TLSAutocert
+	cfg.Server.TrustedProxies = "10.0.0.1, 192.168.0.0/16"
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "TLS_DOMAINS") {
+		t.Errorf("expected TLS_DOMAINS to be required, got %v", err)
+	}
+	cfg.TLS.Domains = "forum.example"
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("expected a valid autocert configuration, got %v", err)
+	}
+}
@@ -0,0 +1,106 @@
+package test
+
+import (
+	"context"
+	"forum/internal/config"
+	"forum/internal/utils"
+	"testing"
+	"time"
+)
+
+func TestSoftDeleteHidesAndRestoresPost(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	if err := utils.SoftDeletePost(ctx, db, 1, 2, "off-topic"); err != nil {
+		t.Fatalf("SoftDeletePost returned error: %v", err)
+	}
+
+	posts, err := utils.SearchPosts(db, "Hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 0 {
+		t.Errorf("deleted post should not be listed, got %d results", len(posts))
+	}
+
+	items, err := utils.GetTrash(db, config.Defaults().Content.TrashRetention)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Kind != "post" || items[0].Reason != "off-topic" || items[0].DeletedByName != "bob" {
+		t.Fatalf("unexpected trash contents: %+v", items)
+	}
+
+	if err := utils.RestorePost(ctx, db, 1); err != nil {
+		t.Fatalf("RestorePost returned error: %v", err)
+	}
+	posts, _ = utils.SearchPosts(db, "Hello")
+	if len(posts) != 1 {
+		t.Errorf("restored post should be listed again, got %d results", len(posts))
+	}
+}
+
+func TestDeletedCommentShowsPlaceholder(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+
+	if err := utils.SoftDeleteComment(context.Background(), db, 1, 2, ""); err != nil {
+		t.Fatalf("SoftDeleteComment returned error: %v", err)
+	}
+
+	comments, err := utils.GetCommentsByPostID(db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(comments) != 2 || !comments[0].IsDeleted || comments[0].Content != utils.DeletedPlaceholder {
+		t.Errorf("expected placeholder for deleted comment, got %+v", comments)
+	}
+
+	count, _ := utils.GetCommentsCount(db, 1)
+	if count != 1 {
+		t.Errorf("deleted comments should not be counted, got %d", count)
+	}
+}
+
+func TestPurgeDeletedContent(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	ctx := context.Background()
+
+	if err := utils.SoftDeletePost(ctx, db, 1, 1, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is old enough yet
+	report, err := utils.PurgeDeletedContent(ctx, db, time.Hour)
+	if err != nil {
+		t.Fatalf("PurgeDeletedContent returned error: %v", err)
+	}
+	if report.Posts != 0 {
+		t.Fatalf("fresh trash should not be purged, got %+v", report)
+	}
+
+	if _, err := db.Exec("UPDATE posts SET deleted_at = datetime('now', '-2 hours') WHERE id = 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err = utils.PurgeDeletedContent(ctx, db, time.Hour)
+	if err != nil {
+		t.Fatalf("PurgeDeletedContent returned error: %v", err)
+	}
+	if report.Posts != 1 {
+		t.Errorf("expected 1 purged post, got %+v", report)
+	}
+
+	var remaining int
+	db.QueryRow("SELECT COUNT(*) FROM posts WHERE id = 1").Scan(&remaining)
+	if remaining != 0 {
+		t.Error("purged post row still exists")
+	}
+	db.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = 1").Scan(&remaining)
+	if remaining != 0 {
+		t.Error("comments of purged post still exist")
+	}
+}
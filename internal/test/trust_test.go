@@ -0,0 +1,155 @@
+package test
+
+import (
+	"context"
+	"forum/internal"
+	"forum/internal/handlers"
+	"forum/internal/models"
+	"forum/internal/utils"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestComputeReputation(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Alice's post has a like from bob and one from herself, which does
+	// not count, and she wrote one comment
+	rep, err := utils.ComputeReputation(ctx, db, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.LikesReceived != 1 || rep.Comments != 1 || rep.Score != 6 {
+		t.Errorf("unexpected reputation %+v", rep)
+	}
+	if rep.Level != utils.TrustNew || rep.NextLevelAt != 10 {
+		t.Errorf("expected alice to be new with 10 reputation to go, got level %d and %d", rep.Level, rep.NextLevelAt)
+	}
+	if rep, _ := utils.ComputeReputation(ctx, db, 2); rep.Level != utils.TrustRegular {
+		t.Errorf("expected moderators at the top level, got %d", rep.Level)
+	}
+
+	// A like on her comment is stored once her reputation is refreshed
+	db.Exec("INSERT INTO likes (user_id, comment_id, reaction) VALUES (2, 2, 'Like')")
+	utils.RefreshContentOwner(ctx, db, "comment", 2)
+	var score, level int
+	db.QueryRow("SELECT reputation, trust_level FROM users WHERE id = 1").Scan(&score, &level)
+	if score != 11 || level != utils.TrustBasic {
+		t.Errorf("expected reputation 11 at Basic, got %d at %d", score, level)
+	}
+
+	// Content a moderator removed costs reputation
+	db.Exec("UPDATE comments SET deleted_at = CURRENT_TIMESTAMP, deleted_by = 2 WHERE id = 2")
+	if rep, _ := utils.ComputeReputation(ctx, db, 1); rep.Removed != 1 || rep.Score != 5-15 {
+		t.Errorf("expected the removed comment to count against alice, got %+v", rep)
+	}
+
+	db.Exec("UPDATE users SET banned = 1 WHERE id = 2")
+	if rep, _ := utils.ComputeReputation(ctx, db, 2); rep.Level != utils.TrustNew {
+		t.Errorf("expected banned members to be new, got %d", rep.Level)
+	}
+}
+
+func TestTrustCapabilities(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	errors.Init(getTemplatePath())
+	ctx := context.Background()
+	utils.SetTrustPolicy(utils.TrustPolicy{
+		Thresholds: []int{10, 50, 150},
+		Required:   map[utils.Capability]int{utils.CapLinks: 1, utils.CapTags: 2, utils.CapWiki: 3},
+	})
+	t.Cleanup(func() { utils.SetTrustPolicy(utils.TrustPolicy{Thresholds: []int{10, 50, 150}}) })
+
+	comment := func(content string) int {
+		form := url.Values{"postId": {"1"}, "content": {content}}
+		req := httptest.NewRequest(http.MethodPost, "/create-comment", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req = req.WithContext(context.WithValue(req.Context(), utils.UserIDKey, 1))
+		rr := httptest.NewRecorder()
+		handlers.CreateCommentHandler(db)(rr, req)
+		return rr.Code
+	}
+	if code := comment("See https://example.com"); code != http.StatusForbidden {
+		t.Errorf("expected a new member's link to be refused, got %d", code)
+	}
+	if code := comment("No links here"); code == http.StatusForbidden {
+		t.Error("expected a comment without links to be allowed")
+	}
+
+	// Alice reached Basic, which is enough for links but not new tags
+	db.Exec("UPDATE users SET trust_level = 1 WHERE id = 1")
+	if msg, err := utils.CheckPostingCapabilities(ctx, db, 1, "", 0, []string{"Go"}); err != nil || msg != "" {
+		t.Errorf("expected an existing tag to be allowed, got %q, %v", msg, err)
+	}
+	if msg, _ := utils.CheckPostingCapabilities(ctx, db, 1, "", 0, []string{"Go", "Rust"}); !strings.Contains(msg, "Rust") {
+		t.Errorf("expected the new tag to be refused, got %q", msg)
+	}
+	if code := comment("See https://example.com"); code == http.StatusForbidden {
+		t.Error("expected a Basic member to post links")
+	}
+	if msg, _ := utils.CheckPostingCapabilities(ctx, db, 2, "", 3, []string{"Rust"}); msg != "" {
+		t.Errorf("expected moderators to have every capability, got %q", msg)
+	}
+
+	// Wiki posts can be edited by members at the wiki level
+	wiki := models.PostView{ID: 2, UserID: 2, IsWiki: true}
+	alice := &models.User{ID: 1, Role: "user", TrustLevel: utils.TrustBasic}
+	if utils.CanEditPost(alice, wiki) {
+		t.Error("expected a Basic member not to edit someone else's wiki post")
+	}
+	alice.TrustLevel = utils.TrustRegular
+	if !utils.CanEditPost(alice, wiki) {
+		t.Error("expected a Regular member to edit a wiki post")
+	}
+	wiki.IsWiki = false
+	if utils.CanEditPost(alice, wiki) {
+		t.Error("expected posts that are not wiki to stay with their author")
+	}
+}
+
+func TestRecalculateStaleReputation(t *testing.T) {
+	db, cleanup := SetupTestDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Nobody has been calculated yet
+	n, err := utils.RecalculateStaleReputation(ctx, db, time.Hour)
+	if err != nil || n != 2 {
+		t.Fatalf("expected both members to be recalculated, got %d, %v", n, err)
+	}
+	var score int
+	db.QueryRow("SELECT reputation FROM users WHERE id = 1").Scan(&score)
+	if score != 6 {
+		t.Errorf("expected alice's reputation to be stored, got %d", score)
+	}
+	if n, _ := utils.RecalculateStaleReputation(ctx, db, time.Hour); n != 0 {
+		t.Errorf("expected nothing stale right after, got %d", n)
+	}
+	week := 7 * 24 * time.Hour
+	db.Exec("UPDATE users SET reputation_updated_at = DATETIME('now', '-2 hours') WHERE id = 2")
+	if n, _ := utils.RecalculateStaleReputation(ctx, db, week); n != 0 {
+		t.Errorf("expected a recent reputation to be left alone, got %d", n)
+	}
+	db.Exec("UPDATE users SET reputation_updated_at = DATETIME('now', '-8 days') WHERE id = 2")
+	if n, _ := utils.RecalculateStaleReputation(ctx, db, week); n != 1 {
+		t.Errorf("expected the stale member to be recalculated, got %d", n)
+	}
+
+	// Alice turned one month old since she was calculated
+	db.Exec("UPDATE users SET created_at = DATETIME('now', '-31 days'), reputation_updated_at = DATETIME('now', '-2 days') WHERE id = 1")
+	if n, _ := utils.RecalculateStaleReputation(ctx, db, week); n != 1 {
+		t.Errorf("expected the member whose account age counts for more to be recalculated, got %d", n)
+	}
+	// Past the months that count, account age changes nothing
+	db.Exec("UPDATE users SET created_at = DATETIME('now', '-900 days'), reputation_updated_at = DATETIME('now', '-2 days') WHERE id = 1")
+	if n, _ := utils.RecalculateStaleReputation(ctx, db, week); n != 0 {
+		t.Errorf("expected a member past the counted months to be left alone, got %d", n)
+	}
+}
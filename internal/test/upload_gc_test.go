@@ -0,0 +1,253 @@
+package test
+
+import (
+	"context"
+	"database/sql"
+	"forum/internal/storage"
+	"forum/internal/utils"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// useTempUploadStore points the upload store at a fresh directory for one test
+func useTempUploadStore(t *testing.T) *storage.LocalStore {
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	utils.SetUploadStore(store)
+	t.Cleanup(func() { utils.SetUploadStore(nil) })
+	return store
+}
+
+func saveTestImage(t *testing.T, w, h int) string {
+	file, header := createTestFile(t, "pic.png", "image/png", encodeTestPNG(t, w, h))
+	defer file.Close()
+	path, err := utils.SaveUploadedFile(file, header)
+	if err != nil {
+		t.Fatalf("SaveUploadedFile returned error: %v", err)
+	}
+	return path
+}
+
+func uploadExists(t *testing.T, store storage.BlobStore, path string) bool {
+	key, err := utils.UploadKey(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := store.Exists(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ok
+}
+
+func TestSaveUploadedFile_Deduplicates(t *testing.T) {
+	store := useTempUploadStore(t)
+
+	first := saveTestImage(t, 40, 30)
+	second := saveTestImage(t, 40, 30)
+	if first != second {
+		t.Errorf("identical uploads should share a file, got %s and %s", first, second)
+	}
+	other := saveTestImage(t, 30, 40)
+	if other == first {
+		t.Error("different images must not share a file")
+	}
+
+	blobs, err := store.List(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two images, each with its thumbnails
+	if want := 2 * (1 + len(utils.ThumbnailSizes)); len(blobs) != want {
+		t.Errorf("expected %d stored files, got %d", want, len(blobs))
+	}
+}
+
+func TestUpdatePostFull_ReleasesSharedImages(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	store := useTempUploadStore(t)
+	ctx := context.Background()
+
+	path := saveTestImage(t, 40, 30)
+	// Both posts use the same file
+	for _, postID := range []int{1, 2} {
+		if _, err := db.Exec("INSERT INTO post_images (post_id, image_path) VALUES (?, ?)", postID, path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if refs, _ := utils.UploadRefCount(ctx, db, path); refs != 2 {
+		t.Fatalf("expected 2 references, got %d", refs)
+	}
+
+	removeFrom := func(postID int) {
+		var imgID int
+		db.QueryRow("SELECT id FROM post_images WHERE post_id = ? AND image_path = ?", postID, path).Scan(&imgID)
+		err := utils.UpdatePostFull(ctx, db, postID, 1, "", "Title", "Content", []string{"1"}, "", nil, []int{imgID})
+		if err != nil {
+			t.Fatalf("UpdatePostFull returned error: %v", err)
+		}
+	}
+
+	removeFrom(1)
+	if !uploadExists(t, store, path) {
+		t.Fatal("file was removed while post 2 still uses it")
+	}
+	removeFrom(2)
+	// An identical upload may be about to use the file, so only the GC removes it
+	if !uploadExists(t, store, path) || orphanCount(t, db) != 1+len(utils.ThumbnailSizes) {
+		t.Fatal("file should be left to the upload GC once no post uses it")
+	}
+	if _, err := utils.CollectUploadGarbage(ctx, db, store, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if uploadExists(t, store, path) {
+		t.Error("file should be removed by the GC once no post uses it")
+	}
+	if uploadExists(t, store, utils.ThumbnailPath(path, "sm")) {
+		t.Error("thumbnail should be removed with the file")
+	}
+}
+
+func orphanCount(t *testing.T, db *sql.DB) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM upload_orphans").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestCollectUploadGarbage(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	store := useTempUploadStore(t)
+	ctx := context.Background()
+	grace := time.Hour
+
+	used := saveTestImage(t, 40, 30)
+	avatar := saveTestImage(t, 20, 20)
+	orphan := saveTestImage(t, 30, 40)
+	db.Exec("INSERT INTO post_images (post_id, image_path, thumb_small, thumb_medium) VALUES (1, ?, ?, ?)",
+		used, utils.ThumbnailPath(used, "sm"), utils.ThumbnailPath(used, "md"))
+	db.Exec("UPDATE users SET avatar_url = ? WHERE id = 1", avatar)
+
+	// Dry run: the orphan and its thumbnails are reported, nothing is recorded
+	report, err := utils.CollectUploadGarbage(ctx, db, store, grace, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Orphans) != 1+len(utils.ThumbnailSizes) || report.Referenced != 2*(1+len(utils.ThumbnailSizes)) {
+		t.Fatalf("unexpected dry-run report: %+v", report)
+	}
+	for _, o := range report.Orphans {
+		if o.Expired || !strings.HasPrefix(o.Key, strings.TrimSuffix(filepath.Base(orphan), ".png")) {
+			t.Errorf("unexpected orphan %+v", o)
+		}
+	}
+	if orphanCount(t, db) != 0 {
+		t.Error("dry run must not record orphans")
+	}
+
+	// A real run starts the grace period but deletes nothing yet
+	report, err = utils.CollectUploadGarbage(ctx, db, store, grace, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 0 || orphanCount(t, db) != len(report.Orphans) {
+		t.Fatalf("files inside the grace period must be kept: %+v", report)
+	}
+
+	// Age both the orphan records and the files past the grace period
+	past := time.Now().Add(-2 * grace)
+	db.Exec("UPDATE upload_orphans SET first_seen = ?", past.UTC())
+	blobs, _ := store.List(ctx, "")
+	for _, b := range blobs {
+		os.Chtimes(filepath.Join(store.Root(), b.Key), past, past)
+	}
+
+	report, err = utils.CollectUploadGarbage(ctx, db, store, grace, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 0 || !uploadExists(t, store, orphan) || !report.Orphans[0].Expired {
+		t.Fatalf("dry run should only report expired files: %+v", report)
+	}
+
+	report, err = utils.CollectUploadGarbage(ctx, db, store, grace, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Deleted != 1+len(utils.ThumbnailSizes) || report.BytesFreed == 0 {
+		t.Errorf("expected the orphan and its thumbnails to be deleted: %+v", report)
+	}
+	if uploadExists(t, store, orphan) {
+		t.Error("orphan still exists")
+	}
+	if !uploadExists(t, store, used) || !uploadExists(t, store, avatar) || !uploadExists(t, store, utils.ThumbnailPath(avatar, "md")) {
+		t.Error("referenced files must be kept")
+	}
+	if orphanCount(t, db) != 0 {
+		t.Error("deleted orphans should be forgotten")
+	}
+}
+
+// interleavingStore runs during once, right after its first listing
+type interleavingStore struct {
+	*storage.LocalStore
+	once   sync.Once
+	during func()
+}
+
+func (s *interleavingStore) List(ctx context.Context, prefix string) ([]storage.BlobInfo, error) {
+	blobs, err := s.LocalStore.List(ctx, prefix)
+	s.once.Do(s.during)
+	return blobs, err
+}
+
+func TestCollectUploadGarbage_KeepsFileSavedAgain(t *testing.T) {
+	db, teardown := SetupTestDB(t)
+	defer teardown()
+	local := useTempUploadStore(t)
+	ctx := context.Background()
+	grace := time.Hour
+
+	orphan := saveTestImage(t, 30, 40)
+	if _, err := utils.CollectUploadGarbage(ctx, db, local, grace, false); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-2 * grace)
+	db.Exec("UPDATE upload_orphans SET first_seen = ?", past.UTC())
+	blobs, _ := local.List(ctx, "")
+	for _, b := range blobs {
+		os.Chtimes(filepath.Join(local.Root(), b.Key), past, past)
+	}
+
+	// The same image is uploaded again while the GC is scanning the expired file
+	var saved string
+	store := &interleavingStore{LocalStore: local, during: func() { saved = saveTestImage(t, 30, 40) }}
+	report, err := utils.CollectUploadGarbage(ctx, db, store, grace, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != orphan {
+		t.Fatalf("expected the upload to be deduplicated, got %s and %s", saved, orphan)
+	}
+	if report.Deleted != 0 || !uploadExists(t, local, orphan) || !uploadExists(t, local, utils.ThumbnailPath(orphan, "sm")) {
+		t.Errorf("a file saved again during the scan must be kept: %+v", report)
+	}
+
+	// Once a row references it, the file is no longer an orphan
+	db.Exec("UPDATE users SET avatar_url = ? WHERE id = 1", saved)
+	if _, err := utils.CollectUploadGarbage(ctx, db, local, grace, false); err != nil {
+		t.Fatal(err)
+	}
+	if orphanCount(t, db) != 0 || !uploadExists(t, local, orphan) {
+		t.Error("a referenced file should be kept and forgotten as an orphan")
+	}
+}
@@ -58,3 +58,8 @@ func GetModerationRequests(db *sql.DB) ([]models.ModerationRequest, error) {
 func IsAdmin(user *models.User) bool {
 	return user != nil && user.Role == "admin"
 }
+
+// IsModerator reports whether the user can use moderation tools (moderators and admins)
+func IsModerator(user *models.User) bool {
+	return user != nil && (user.Role == "moderator" || user.Role == "admin")
+}
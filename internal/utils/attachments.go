@@ -0,0 +1,346 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/metrics"
+	"forum/internal/models"
+	"forum/internal/scanner"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxAttachmentsPerPost limits how many files one upload may attach
+const MaxAttachmentsPerPost = 5
+
+// attachmentPrefix is the store prefix attachments are kept under. Files
+// there are only served through the download handler.
+const attachmentPrefix = "attachments/"
+
+// ErrAttachmentRejected is returned for files that may not be attached: an
+// extension that is not allowed, a file over its limit, content that does
+// not match the extension or a positive scan
+var ErrAttachmentRejected = errors.New("attachment rejected")
+
+// attachmentFormat is a file type the forum knows how to check
+type attachmentFormat struct {
+	contentType string
+	valid       func(data []byte) bool
+}
+
+var attachmentFormats = map[string]attachmentFormat{
+	".pdf":  {"application/pdf", func(b []byte) bool { return bytes.HasPrefix(b, []byte("%PDF-")) }},
+	".txt":  {"text/plain; charset=utf-8", isPlainText},
+	".log":  {"text/plain; charset=utf-8", isPlainText},
+	".md":   {"text/markdown; charset=utf-8", isPlainText},
+	".csv":  {"text/csv; charset=utf-8", isPlainText},
+	".json": {"application/json", json.Valid},
+	".zip":  {"application/zip", isZip},
+}
+
+func isPlainText(b []byte) bool {
+	return utf8.Valid(b) && bytes.IndexByte(b, 0) < 0
+}
+
+func isZip(b []byte) bool {
+	return bytes.HasPrefix(b, []byte("PK\x03\x04")) || bytes.HasPrefix(b, []byte("PK\x05\x06"))
+}
+
+// ParseAttachmentTypes reads the allowed attachment types from a comma
+// separated list of extension:megabytes such as "pdf:10,txt:1". Only
+// extensions the forum can check are accepted.
+func ParseAttachmentTypes(spec string) ([]models.AttachmentType, error) {
+	var types []models.AttachmentType
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		ext, mb, _ := strings.Cut(strings.TrimSpace(entry), ":")
+		ext = "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+		format, known := attachmentFormats[ext]
+		if !known {
+			return nil, fmt.Errorf("attachment type %q: %s files cannot be checked, supported are %s", entry, ext, supportedAttachmentExts())
+		}
+		size, err := strconv.ParseFloat(strings.TrimSpace(mb), 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("attachment type %q: the size limit must be a positive number of megabytes", entry)
+		}
+		if seen[ext] {
+			return nil, fmt.Errorf("attachment type %q: %s is listed twice", entry, ext)
+		}
+		seen[ext] = true
+		maxSize := int64(size * (1 << 20))
+		types = append(types, models.AttachmentType{
+			Ext:          ext,
+			ContentType:  format.contentType,
+			MaxSize:      maxSize,
+			MaxSizeLabel: FormatFileSize(maxSize),
+		})
+	}
+	return types, nil
+}
+
+func supportedAttachmentExts() string {
+	exts := make([]string, 0, len(attachmentFormats))
+	for ext := range attachmentFormats {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return strings.Join(exts, " ")
+}
+
+// FormatFileSize renders a byte count for people, e.g. "1.2 MB"
+func FormatFileSize(n int64) string {
+	switch {
+	case n < 1<<10:
+		return fmt.Sprintf("%d B", n)
+	case n < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	}
+}
+
+var (
+	attachmentScannerMu sync.Mutex
+	attachmentScanner   scanner.Scanner
+)
+
+// SetAttachmentScanner replaces the scanner every attachment must pass
+func SetAttachmentScanner(s scanner.Scanner) {
+	attachmentScannerMu.Lock()
+	defer attachmentScannerMu.Unlock()
+	attachmentScanner = s
+}
+
+// AttachmentScanner returns the configured scanner, accepting everything
+// when none is set
+func AttachmentScanner() scanner.Scanner {
+	attachmentScannerMu.Lock()
+	defer attachmentScannerMu.Unlock()
+	if attachmentScanner == nil {
+		return scanner.Nop{}
+	}
+	return attachmentScanner
+}
+
+// attachmentName keeps the base name of an uploaded file without control
+// characters, so it is safe to offer back in Content-Disposition
+func attachmentName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > 200 {
+		name = string(runes[len(runes)-200:])
+	}
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	return name
+}
+
+// SaveAttachment checks an uploaded file against the allowed types, runs it
+// through the scanner and stores it. The returned attachment is not yet tied
+// to a post. Files are named by their hash, so re-uploads share one file.
+func SaveAttachment(ctx context.Context, file multipart.File, fileHeader *multipart.FileHeader, types []models.AttachmentType) (models.Attachment, error) {
+	name := attachmentName(fileHeader.Filename)
+	ext := strings.ToLower(path.Ext(name))
+
+	var allowed *models.AttachmentType
+	for _, t := range types {
+		if t.Ext == ext {
+			allowed = &t
+			break
+		}
+	}
+	if allowed == nil {
+		return models.Attachment{}, fmt.Errorf("%w: %s files are not allowed", ErrAttachmentRejected, strings.ToUpper(strings.TrimPrefix(ext, ".")))
+	}
+	tooLarge := fmt.Errorf("%w: %s is larger than %s", ErrAttachmentRejected, name, allowed.MaxSizeLabel)
+	if fileHeader.Size > allowed.MaxSize {
+		return models.Attachment{}, tooLarge
+	}
+
+	// The header size comes from the client, so enforce the limit while reading
+	data, err := io.ReadAll(io.LimitReader(file, allowed.MaxSize+1))
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("read %s: %w", name, err)
+	}
+	if int64(len(data)) > allowed.MaxSize {
+		return models.Attachment{}, tooLarge
+	}
+	if len(data) == 0 {
+		return models.Attachment{}, fmt.Errorf("%w: %s is empty", ErrAttachmentRejected, name)
+	}
+	if !attachmentFormats[ext].valid(data) {
+		return models.Attachment{}, fmt.Errorf("%w: %s is not a valid %s file", ErrAttachmentRejected, name, strings.ToUpper(strings.TrimPrefix(ext, ".")))
+	}
+
+	result, err := AttachmentScanner().Scan(ctx, bytes.NewReader(data))
+	if err != nil {
+		return models.Attachment{}, fmt.Errorf("scan %s: %w", name, err)
+	}
+	if !result.Clean {
+		logging.FromContext(ctx).Warn("attachment rejected by scanner", "name", name, "signature", result.Signature)
+		return models.Attachment{}, fmt.Errorf("%w: %s failed the malware scan", ErrAttachmentRejected, name)
+	}
+
+	sum := sha256.Sum256(data)
+	key := attachmentPrefix + hex.EncodeToString(sum[:]) + ext
+	store := UploadStore()
+	// Written even when it exists, like SaveUploadedFile, so the upload GC
+	// keeps a shared file until the new row references it
+	if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), allowed.ContentType); err != nil {
+		return models.Attachment{}, fmt.Errorf("store %s: %w", name, err)
+	}
+	metrics.UploadBytes.With("attachment").Add(float64(len(data)))
+
+	return models.Attachment{
+		Path:        UploadsURLPrefix + key,
+		Name:        name,
+		ContentType: allowed.ContentType,
+		Size:        int64(len(data)),
+		SizeLabel:   FormatFileSize(int64(len(data))),
+	}, nil
+}
+
+// ProcessUploadedAttachments saves the files sent in the attachments[] field.
+// Nothing is attached unless every file passes.
+func ProcessUploadedAttachments(r *http.Request, types []models.AttachmentType) ([]models.Attachment, error) {
+	if r.MultipartForm == nil {
+		return nil, nil
+	}
+	var files []*multipart.FileHeader
+	for _, fh := range r.MultipartForm.File["attachments[]"] {
+		if fh.Filename != "" {
+			files = append(files, fh)
+		}
+	}
+	if len(files) > MaxAttachmentsPerPost {
+		return nil, fmt.Errorf("%w: at most %d files can be attached at once", ErrAttachmentRejected, MaxAttachmentsPerPost)
+	}
+
+	var attachments []models.Attachment
+	for _, fh := range files {
+		file, err := fh.Open()
+		if err != nil {
+			return nil, fmt.Errorf("open %s: %w", fh.Filename, err)
+		}
+		attachment, err := SaveAttachment(r.Context(), file, fh, types)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments, nil
+}
+
+// execer is satisfied by both *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// AddPostAttachments records saved attachments on a post
+func AddPostAttachments(ctx context.Context, db execer, postID int64, userID int, attachments []models.Attachment) error {
+	for _, a := range attachments {
+		if _, err := db.ExecContext(ctx, `
+			INSERT INTO post_attachments (post_id, user_id, file_path, original_name, content_type, size)
+			VALUES (?, ?, ?, ?, ?, ?)`,
+			postID, userID, a.Path, a.Name, a.ContentType, a.Size); err != nil {
+			return fmt.Errorf("insert attachment %s: %w", a.Name, err)
+		}
+	}
+	return nil
+}
+
+const attachmentColumns = `id, post_id, user_id, file_path, original_name, content_type, size, download_count, created_at`
+
+func scanAttachment(scan func(dest ...any) error) (models.Attachment, error) {
+	var a models.Attachment
+	err := scan(&a.ID, &a.PostID, &a.UserID, &a.Path, &a.Name, &a.ContentType, &a.Size, &a.DownloadCount, &a.CreatedAt)
+	a.SizeLabel = FormatFileSize(a.Size)
+	return a, err
+}
+
+// GetPostAttachments returns a post's attachments in upload order
+func GetPostAttachments(db *sql.DB, postID int) ([]models.Attachment, error) {
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM post_attachments WHERE post_id = ? ORDER BY id", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var attachments []models.Attachment
+	for rows.Next() {
+		a, err := scanAttachment(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+	return attachments, rows.Err()
+}
+
+// GetAttachment loads one attachment; sql.ErrNoRows when it does not exist
+func GetAttachment(db *sql.DB, id int) (models.Attachment, error) {
+	return scanAttachment(db.QueryRow("SELECT "+attachmentColumns+" FROM post_attachments WHERE id = ?", id).Scan)
+}
+
+// RecordAttachmentDownload counts one download of an attachment
+func RecordAttachmentDownload(ctx context.Context, db *sql.DB, id int) error {
+	_, err := db.ExecContext(ctx, "UPDATE post_attachments SET download_count = download_count + 1 WHERE id = ?", id)
+	return err
+}
+
+// RemovePostAttachments deletes attachments of a post and releases their
+// files once nothing else uses them. IDs of other posts are ignored.
+func RemovePostAttachments(ctx context.Context, db *sql.DB, postID int, ids []int) error {
+	var removed []string
+	for _, id := range ids {
+		var path string
+		err := db.QueryRowContext(ctx, "SELECT file_path FROM post_attachments WHERE id = ? AND post_id = ?", id, postID).Scan(&path)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("load attachment %d: %w", id, err)
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM post_attachments WHERE id = ?", id); err != nil {
+			return fmt.Errorf("delete attachment %d: %w", id, err)
+		}
+		removed = append(removed, path)
+	}
+	for _, path := range removed {
+		if _, err := ReleaseUpload(ctx, db, path); err != nil {
+			logging.FromContext(ctx).Warn("failed to release attachment", "path", path, "err", err)
+		}
+	}
+	return nil
+}
+
+// IsAttachmentKey reports whether a store key holds an attachment, which
+// must be downloaded through its post rather than opened inline
+func IsAttachmentKey(key string) bool {
+	return strings.HasPrefix(key, attachmentPrefix)
+}
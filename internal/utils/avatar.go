@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/metrics"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"mime/multipart"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// AvatarSizes are the square sizes every avatar is stored in, smallest first.
+// The largest one is the file avatar_url points to.
+var AvatarSizes = []int{32, 64, 128, 256}
+
+// MaxAvatarFrames caps how many frames an animated avatar may keep
+const MaxAvatarFrames = 100
+
+// avatarPrefix is the store directory processed avatars are kept in
+const avatarPrefix = "avatars/"
+
+// DefaultAvatarPath is what OAuth sign-ups used to store when the provider had no picture
+const DefaultAvatarPath = "/static/images/default-avatar.png"
+
+// ProcessedAvatar holds an avatar encoded in every size of AvatarSizes
+type ProcessedAvatar struct {
+	Format string // jpeg, png or gif
+	Sizes  map[int][]byte
+}
+
+// ProcessAvatar center-crops an uploaded image to a square and scales it to
+// every size in AvatarSizes. Animated GIFs are flattened to their first frame
+// unless animated is set.
+func ProcessAvatar(r io.Reader, animated bool) (*ProcessedAvatar, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file")
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || (format != "jpeg" && format != "png" && format != "gif") {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	if format == "gif" {
+		// Frames are counted before decoding, so long or large animations
+		// are flattened without decoding more than their first frame
+		frames, err := countGIFFrames(data, MaxAvatarFrames+1)
+		if err != nil || frames == 0 {
+			return nil, ErrUnsupportedImage
+		}
+		if animated && frames > 1 && frames <= MaxAvatarFrames && cfg.Width*cfg.Height*frames <= MaxImagePixels {
+			g, err := gif.DecodeAll(bytes.NewReader(data))
+			if err != nil || len(g.Image) == 0 {
+				return nil, ErrUnsupportedImage
+			}
+			return processAnimatedAvatar(g)
+		}
+		frame, err := gif.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrUnsupportedImage
+		}
+		first := image.NewRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+		draw.Draw(first, first.Bounds(), frame, image.Point{}, draw.Over)
+		return encodeAvatar(first, "png")
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+	return encodeAvatar(img, format)
+}
+
+func encodeAvatar(img image.Image, format string) (*ProcessedAvatar, error) {
+	square := cropSquare(img)
+	out := &ProcessedAvatar{Format: format, Sizes: make(map[int][]byte, len(AvatarSizes))}
+	for _, size := range AvatarSizes {
+		data, err := encodeImage(resample(square, size, size), format)
+		if err != nil {
+			return nil, err
+		}
+		out.Sizes[size] = data
+	}
+	return out, nil
+}
+
+// processAnimatedAvatar composes every frame onto the full canvas, then crops
+// and scales the result so frames with offsets and disposal stay correct
+func processAnimatedAvatar(g *gif.GIF) (*ProcessedAvatar, error) {
+	bounds := image.Rect(0, 0, g.Config.Width, g.Config.Height)
+	canvas := image.NewRGBA(bounds)
+	frames := make([]image.Image, len(g.Image))
+
+	for i, frame := range g.Image {
+		var previous *image.RGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(bounds)
+			draw.Draw(previous, bounds, canvas, image.Point{}, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		snapshot := image.NewRGBA(bounds)
+		draw.Draw(snapshot, bounds, canvas, image.Point{}, draw.Src)
+		frames[i] = cropSquare(snapshot)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	out := &ProcessedAvatar{Format: "gif", Sizes: make(map[int][]byte, len(AvatarSizes))}
+	for _, size := range AvatarSizes {
+		anim := &gif.GIF{LoopCount: g.LoopCount, Delay: g.Delay}
+		for _, frame := range frames {
+			scaled := resample(frame, size, size)
+			paletted := image.NewPaletted(scaled.Bounds(), append(color.Palette{color.Transparent}, palette.WebSafe...))
+			draw.FloydSteinberg.Draw(paletted, scaled.Bounds(), scaled, image.Point{})
+			anim.Image = append(anim.Image, paletted)
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, anim); err != nil {
+			return nil, fmt.Errorf("encode gif: %w", err)
+		}
+		out.Sizes[size] = buf.Bytes()
+	}
+	return out, nil
+}
+
+// cropSquare cuts the largest centered square out of img
+func cropSquare(img image.Image) image.Image {
+	b := img.Bounds()
+	side := min(b.Dx(), b.Dy())
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+	square := image.NewRGBA(image.Rect(0, 0, side, side))
+	draw.Draw(square, square.Bounds(), img, image.Point{X: x0, Y: y0}, draw.Src)
+	return square
+}
+
+// SaveAvatar processes an uploaded avatar and stores every size. The returned
+// path is the largest size; AvatarSizePath derives the others from it.
+// Animated GIFs keep their frames only when animated is set.
+func SaveAvatar(ctx context.Context, file multipart.File, fileHeader *multipart.FileHeader, animated bool) (string, error) {
+	const maxSize = 20 * 1024 * 1024 // 20MB
+	if fileHeader.Size > maxSize {
+		return "", fmt.Errorf("file is too large")
+	}
+
+	avatar, err := ProcessAvatar(file, animated)
+	if err != nil {
+		logging.FromContext(ctx).Warn("rejected avatar", "filename", fileHeader.Filename, "err", err)
+		return "", err
+	}
+
+	largest := AvatarSizes[len(AvatarSizes)-1]
+	sum := sha256.Sum256(avatar.Sizes[largest])
+	relativePath := UploadsURLPrefix + avatarPrefix + hex.EncodeToString(sum[:]) + ImageExtension(avatar.Format)
+
+	store := UploadStore()
+	for size, data := range avatar.Sizes {
+		key, err := UploadKey(AvatarSizePath(relativePath, size))
+		if err != nil {
+			return "", err
+		}
+		if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), storage.ContentType(key)); err != nil {
+			logging.FromContext(ctx).Error("failed to store avatar", "key", key, "err", err)
+			return "", fmt.Errorf("failed to create file")
+		}
+	}
+	metrics.UploadBytes.With("avatar").Add(float64(len(avatar.Sizes[largest])))
+	return relativePath, nil
+}
+
+// isProcessedAvatar reports whether path was stored by SaveAvatar, so sized
+// variants exist next to it
+func isProcessedAvatar(path string) bool {
+	return strings.HasPrefix(path, UploadsURLPrefix+avatarPrefix) || strings.HasPrefix(path, avatarPrefix)
+}
+
+// AvatarSizePath returns where one size of a processed avatar is stored
+func AvatarSizePath(avatarPath string, size int) string {
+	if size == AvatarSizes[len(AvatarSizes)-1] {
+		return avatarPath
+	}
+	ext := path.Ext(avatarPath)
+	return strings.TrimSuffix(avatarPath, ext) + "_" + strconv.Itoa(size) + ext
+}
+
+// avatarSizeFor picks the smallest stored size that is at least size pixels
+func avatarSizeFor(size int) int {
+	for _, s := range AvatarSizes {
+		if s >= size {
+			return s
+		}
+	}
+	return AvatarSizes[len(AvatarSizes)-1]
+}
+
+// AvatarURL returns the image to show for a user at about size pixels: the
+// matching size of an uploaded avatar, an external (OAuth) picture as is, or
+// a generated identicon for users without one
+func AvatarURL(user models.User, size int) string {
+	size = avatarSizeFor(size)
+	switch {
+	case user.AvatarPath == "" || user.AvatarPath == DefaultAvatarPath:
+		return IdenticonURL(user.ID, size)
+	case isProcessedAvatar(user.AvatarPath):
+		return AvatarSizePath(user.AvatarPath, size)
+	default:
+		return user.AvatarPath
+	}
+}
+
+// AvatarFuncs are the template helpers for avatars: {{ avatar .User 64 }}
+func AvatarFuncs() map[string]any {
+	return map[string]any{
+		"avatar": func(user any, size int) string {
+			switch u := user.(type) {
+			case models.User:
+				return AvatarURL(u, size)
+			case *models.User:
+				if u != nil {
+					return AvatarURL(*u, size)
+				}
+			}
+			return IdenticonURL(0, avatarSizeFor(size))
+		},
+	}
+}
+
+// IdenticonURL is where the generated avatar of a user is served
+func IdenticonURL(userID, size int) string {
+	return "/identicon/" + strconv.Itoa(userID) + ".png?size=" + strconv.Itoa(size)
+}
+
+// Identicon grid: 5x5 cells mirrored around the middle column, with a margin
+// of half a cell around it
+const identiconCells = 5
+
+var (
+	identiconMu    sync.Mutex
+	identiconCache = make(map[string][]byte)
+)
+
+// maxCachedIdenticons bounds the in-memory cache; it is simply cleared when full
+const maxCachedIdenticons = 2048
+
+// Identicon renders the generated PNG avatar for seed at one of AvatarSizes.
+// The same seed always gives the same image; results are cached in memory.
+func Identicon(seed string, size int) ([]byte, error) {
+	size = avatarSizeFor(size)
+	cacheKey := seed + "|" + strconv.Itoa(size)
+
+	identiconMu.Lock()
+	if data, ok := identiconCache[cacheKey]; ok {
+		identiconMu.Unlock()
+		return data, nil
+	}
+	identiconMu.Unlock()
+
+	data, err := renderIdenticon(seed, size)
+	if err != nil {
+		return nil, err
+	}
+
+	identiconMu.Lock()
+	if len(identiconCache) >= maxCachedIdenticons {
+		identiconCache = make(map[string][]byte)
+	}
+	identiconCache[cacheKey] = data
+	identiconMu.Unlock()
+	return data, nil
+}
+
+func renderIdenticon(seed string, size int) ([]byte, error) {
+	sum := sha256.Sum256([]byte(seed))
+
+	// Foreground hue from the hash; saturation and lightness are fixed so
+	// every identicon stays readable on the light background
+	fg := hslColor(float64(int(sum[0])<<8|int(sum[1]))/65536*360, 0.55, 0.5)
+	bg := color.RGBA{240, 240, 240, 255}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	draw.Draw(img, img.Bounds(), &image.Uniform{bg}, image.Point{}, draw.Src)
+
+	cell := size / (identiconCells + 1)
+	margin := (size - cell*identiconCells) / 2
+	half := (identiconCells + 1) / 2
+	for row := 0; row < identiconCells; row++ {
+		for col := 0; col < half; col++ {
+			bit := row*half + col
+			if sum[2+bit/8]>>(bit%8)&1 == 0 {
+				continue
+			}
+			for _, c := range []int{col, identiconCells - 1 - col} {
+				r := image.Rect(margin+c*cell, margin+row*cell, margin+(c+1)*cell, margin+(row+1)*cell)
+				draw.Draw(img, r, &image.Uniform{fg}, image.Point{}, draw.Src)
+			}
+		}
+	}
+	return encodeImage(img, "png")
+}
+
+func hslColor(h, s, l float64) color.RGBA {
+	c := (1 - math.Abs(2*l-1)) * s
+	hp := h / 60
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var r, g, b float64
+	switch {
+	case hp < 1:
+		r, g = c, x
+	case hp < 2:
+		r, g = x, c
+	case hp < 3:
+		g, b = c, x
+	case hp < 4:
+		g, b = x, c
+	case hp < 5:
+		r, b = x, c
+	default:
+		r, b = c, x
+	}
+	m := l - c/2
+	return color.RGBA{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255), 255}
+}
@@ -0,0 +1,316 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+// BadgeRule awards a badge to the members its query finds. The query
+// returns user_id and ref_id rows: ref_id is what earned a repeatable badge,
+// like a post, so each one awards it once more. Badges that are not
+// repeatable are awarded once, whatever ref_id is. Awards are kept when the
+// content that earned them is gone.
+type BadgeRule struct {
+	Key         string
+	Name        string
+	Icon        string
+	Description string
+	Repeatable  bool
+	Query       string
+}
+
+// BadgeRules are the badges members can earn, in the order they are shown.
+// Keys are stored with awards, so a rule must keep its key.
+var BadgeRules = []BadgeRule{
+	{
+		Key: "first_post", Name: "First Post", Icon: "📝",
+		Description: "Published a first post",
+		Query:       `SELECT user_id, 0 AS ref_id FROM posts WHERE deleted_at IS NULL GROUP BY user_id`,
+	},
+	{
+		Key: "first_comment", Name: "First Comment", Icon: "💬",
+		Description: "Published a first comment",
+		Query:       `SELECT user_id, 0 AS ref_id FROM comments WHERE deleted_at IS NULL GROUP BY user_id`,
+	},
+	{
+		Key: "popular_post", Name: "Popular Post", Icon: "🔥", Repeatable: true,
+		Description: "A post liked by 10 members, for every such post",
+		Query: `
+			SELECT p.user_id, p.id AS ref_id FROM posts p
+			JOIN likes l ON l.post_id = p.id AND l.user_id != p.user_id AND l.reaction = 'Like'
+			WHERE p.deleted_at IS NULL
+			GROUP BY p.id HAVING COUNT(*) >= 10`,
+	},
+	{
+		Key: "helpful_commenter", Name: "Helpful Commenter", Icon: "🤝", Repeatable: true,
+		Description: "A comment liked by 5 members, for every such comment",
+		Query: `
+			SELECT c.user_id, c.id AS ref_id FROM comments c
+			JOIN likes l ON l.comment_id = c.id AND l.user_id != c.user_id AND l.reaction = 'Like'
+			WHERE c.deleted_at IS NULL
+			GROUP BY c.id HAVING COUNT(*) >= 5`,
+	},
+	{
+		Key: "well_liked", Name: "Well Liked", Icon: "❤️",
+		Description: "Received 100 likes on posts and comments",
+		Query: `
+			SELECT user_id, 0 AS ref_id FROM (
+				SELECT p.user_id FROM posts p
+				JOIN likes l ON l.post_id = p.id AND l.user_id != p.user_id AND l.reaction = 'Like'
+				WHERE p.deleted_at IS NULL
+				UNION ALL
+				SELECT c.user_id FROM comments c
+				JOIN likes l ON l.comment_id = c.id AND l.user_id != c.user_id AND l.reaction = 'Like'
+				WHERE c.deleted_at IS NULL
+			)
+			GROUP BY user_id HAVING COUNT(*) >= 100`,
+	},
+	{
+		Key: "anniversary", Name: "Anniversary", Icon: "🎂", Repeatable: true,
+		Description: "A year as a member, for every year",
+		Query: `
+			WITH RECURSIVE years(n) AS (SELECT 1 UNION ALL SELECT n + 1 FROM years WHERE n < 100)
+			SELECT u.id AS user_id, years.n AS ref_id FROM users u
+			JOIN years ON julianday(u.created_at) <= julianday('now', '-' || years.n || ' years')`,
+	},
+}
+
+// badgeRule finds a rule by key
+func badgeRule(key string) (BadgeRule, bool) {
+	for _, rule := range BadgeRules {
+		if rule.Key == key {
+			return rule, true
+		}
+	}
+	return BadgeRule{}, false
+}
+
+// AwardBadges evaluates every rule, awards what members earned since the
+// last run and notifies them. Banned members earn nothing. The first run of a
+// rule backfills what members earned before it existed, without notifying
+// them. It returns how many badges were awarded.
+func AwardBadges(ctx context.Context, db *sql.DB) (int, error) {
+	awarded := 0
+	for _, rule := range BadgeRules {
+		n, err := awardBadge(ctx, db, rule)
+		if err != nil {
+			return awarded, fmt.Errorf("award badge %s: %w", rule.Key, err)
+		}
+		awarded += n
+	}
+	return awarded, notifyBadges(ctx, db)
+}
+
+// awardBadge awards one rule. Awards and the mark that the rule was
+// backfilled are written together.
+func awardBadge(ctx context.Context, db *sql.DB, rule BadgeRule) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var backfilled bool
+	err = tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM badge_rules WHERE badge = ?)", rule.Key).Scan(&backfilled)
+	if err != nil {
+		return 0, err
+	}
+	ref := "e.ref_id"
+	if !rule.Repeatable {
+		ref = "0"
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO user_badges (user_id, badge, ref_id, notified)
+		SELECT e.user_id, ?, `+ref+`, ? FROM (`+rule.Query+`) e
+		JOIN users u ON u.id = e.user_id
+		WHERE COALESCE(u.banned, 0) = 0`, rule.Key, !backfilled)
+	if err != nil {
+		return 0, err
+	}
+	if !backfilled {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO badge_rules (badge) VALUES (?)", rule.Key); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	n, _ := res.RowsAffected()
+	return int(n), nil
+}
+
+// notifyBadges tells members about badges they were awarded and not told
+// about yet, once per badge however many times it was awarded since. Awards
+// are marked notified with their notification, so a failed run notifies them
+// next time.
+func notifyBadges(ctx context.Context, db *sql.DB) error {
+	rows, err := db.QueryContext(ctx, "SELECT id, user_id, badge FROM user_badges WHERE notified = 0 ORDER BY id")
+	if err != nil {
+		return fmt.Errorf("query badges to notify: %w", err)
+	}
+	type award struct {
+		userID int
+		badge  string
+	}
+	var awards []award
+	ids := make(map[award][]any)
+	for rows.Next() {
+		var id int
+		var a award
+		if err := rows.Scan(&id, &a.userID, &a.badge); err != nil {
+			rows.Close()
+			return err
+		}
+		if ids[a] == nil {
+			awards = append(awards, a)
+		}
+		ids[a] = append(ids[a], id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, a := range awards {
+		name := a.badge
+		if rule, ok := badgeRule(a.badge); ok {
+			name = rule.Name
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		// Members are their own actor: nobody else awarded the badge
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO notifications (user_id, actor_id, type, message) VALUES (?, ?, 'badge', ?)`,
+			a.userID, a.userID, name)
+		if err == nil {
+			_, err = tx.ExecContext(ctx, "UPDATE user_badges SET notified = 1 WHERE id IN (?"+strings.Repeat(", ?", len(ids[a])-1)+")", ids[a]...)
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("notify badge %s of user %d: %w", a.badge, a.userID, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RunBadgeAwards awards badges every interval until ctx is cancelled
+func RunBadgeAwards(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := AwardBadges(ctx, db)
+		if err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error("badge awards failed", "err", err)
+		} else if n > 0 {
+			logging.FromContext(ctx).Info("badges awarded", "badges", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// GetUserBadges returns the badges a member holds, in the order of
+// BadgeRules
+func GetUserBadges(ctx context.Context, db *sql.DB, userID int) ([]models.Badge, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT badge, awarded_at FROM user_badges
+		WHERE user_id = ? ORDER BY awarded_at, id`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("query badges of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	held := make(map[string]models.Badge)
+	for rows.Next() {
+		var key string
+		var awardedAt time.Time
+		if err := rows.Scan(&key, &awardedAt); err != nil {
+			return nil, err
+		}
+		b, ok := held[key]
+		if !ok {
+			b = models.Badge{Key: key, AwardedAt: awardedAt}
+		}
+		b.Count++
+		held[key] = b
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var badges []models.Badge
+	for _, rule := range BadgeRules {
+		b, ok := held[rule.Key]
+		if !ok {
+			continue
+		}
+		b.Name, b.Icon, b.Description, b.Repeatable = rule.Name, rule.Icon, rule.Description, rule.Repeatable
+		badges = append(badges, b)
+	}
+	return badges, nil
+}
+
+// GetBadgeSummaries returns every badge with the members holding it, those
+// who earned it last first
+func GetBadgeSummaries(ctx context.Context, db *sql.DB) ([]models.BadgeSummary, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT b.badge, b.user_id, u.username, b.awarded_at
+		FROM user_badges b
+		JOIN users u ON u.id = b.user_id
+		ORDER BY b.awarded_at DESC, b.id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("query badge holders: %w", err)
+	}
+	defer rows.Close()
+
+	holders := make(map[string][]models.BadgeHolder)
+	seen := make(map[string]map[int]int) // badge, user: index in holders
+	for rows.Next() {
+		var key string
+		var h models.BadgeHolder
+		if err := rows.Scan(&key, &h.UserID, &h.Username, &h.AwardedAt); err != nil {
+			return nil, err
+		}
+		if seen[key] == nil {
+			seen[key] = make(map[int]int)
+		}
+		if i, ok := seen[key][h.UserID]; ok {
+			holders[key][i].Count++
+			continue
+		}
+		h.Count = 1
+		seen[key][h.UserID] = len(holders[key])
+		holders[key] = append(holders[key], h)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	summaries := make([]models.BadgeSummary, len(BadgeRules))
+	for i, rule := range BadgeRules {
+		summaries[i] = models.BadgeSummary{
+			Key:         rule.Key,
+			Name:        rule.Name,
+			Icon:        rule.Icon,
+			Description: rule.Description,
+			Repeatable:  rule.Repeatable,
+			Holders:     holders[rule.Key],
+		}
+	}
+	return summaries, nil
+}
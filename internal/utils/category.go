@@ -81,8 +81,6 @@ func ReadCategoriesFromFile(filename string) ([]string, error) {
 
 // GetPostCategories retrieves the category IDs associated with a specific post
 func GetPostCategories(db *sql.DB, postID int) ([]int, error) {
-	log.Printf("DEBUG: Getting categories for post ID: %d", postID)
-
 	// SQL query to get all category IDs for a specific post
 	query := `
 		SELECT category_id 
@@ -117,14 +115,11 @@ func GetPostCategories(db *sql.DB, postID int) ([]int, error) {
 		return nil, fmt.Errorf("error during rows iteration: %w", err)
 	}
 
-	log.Printf("DEBUG: Found %d categories for post %d: %+v", len(categoryIDs), postID, categoryIDs)
 	return categoryIDs, nil
 }
 
 // Alternative version that also returns category details (ID and Name)
 func GetPostCategoriesWithDetails(db *sql.DB, postID int) ([]models.Category, error) {
-	log.Printf("DEBUG: Getting category details for post ID: %d", postID)
-
 	// SQL query to get category details for a specific post
 	query := `
 		SELECT c.id, c.name 
@@ -160,14 +155,11 @@ func GetPostCategoriesWithDetails(db *sql.DB, postID int) ([]models.Category, er
 		return nil, fmt.Errorf("error during rows iteration: %w", err)
 	}
 
-	log.Printf("DEBUG: Found %d category details for post %d", len(categories), postID)
 	return categories, nil
 }
 
 // Helper function to check if a post has a specific category
 func PostHasCategory(db *sql.DB, postID, categoryID int) (bool, error) {
-	log.Printf("DEBUG: Checking if post %d has category %d", postID, categoryID)
-
 	query := `
 		SELECT COUNT(*) 
 		FROM post_categories 
@@ -181,6 +173,5 @@ func PostHasCategory(db *sql.DB, postID, categoryID int) (bool, error) {
 	}
 
 	result := count > 0
-	log.Printf("DEBUG: Post %d has category %d: %t", postID, categoryID, result)
 	return result, nil
 }
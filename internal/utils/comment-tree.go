@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"sort"
+	"time"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// Sort orders for comment threads
+const (
+	CommentSortOldest = "oldest"
+	CommentSortNewest = "newest"
+	CommentSortTop    = "top"
+)
+
+// MaxThreadDepth is how many levels of replies are rendered before a
+// "continue this thread" link is shown instead
+const MaxThreadDepth = 6
+
+// CollapseThreshold is the number of replies below which a thread starts expanded
+const CollapseThreshold = 10
+
+// NormalizeCommentSort returns a known sort order, defaulting to oldest first
+func NormalizeCommentSort(s string) string {
+	switch s {
+	case CommentSortNewest, CommentSortTop:
+		return s
+	default:
+		return CommentSortOldest
+	}
+}
+
+// GetCommentTree loads the comments of a post as a tree using one recursive query.
+// With rootID = 0 every top-level comment is a root; otherwise only the thread
+// starting at rootID is loaded. Replies deeper than maxDepth are not returned,
+// their parent is marked with ContinueThread instead.
+func GetCommentTree(db *sql.DB, postID, rootID int, sortBy string, maxDepth int) ([]*models.CommentNode, error) {
+	query := `
+		WITH RECURSIVE thread(id, depth, path) AS (
+			SELECT id, 0, printf('%010d', id)
+			FROM comments
+			WHERE post_id = ?
+			  AND CASE WHEN ? = 0 THEN COALESCE(parent_comment_id, 0) = 0 ELSE id = ? END
+			UNION ALL
+			SELECT c.id, t.depth + 1, t.path || '/' || printf('%010d', c.id)
+			FROM comments c
+			JOIN thread t ON c.parent_comment_id = t.id
+			WHERE t.depth < ?
+		)
+		SELECT c.id, c.post_id, c.user_id, u.username, COALESCE(u.trust_level, 0), c.content, c.created_at,
+		       COALESCE(c.parent_comment_id, 0), t.depth, t.path,
+		       EXISTS(SELECT 1 FROM comment_revisions r WHERE r.comment_id = c.id),
+		       c.deleted_at IS NOT NULL,
+		       (SELECT COUNT(*) FROM likes l WHERE l.comment_id = c.id AND l.reaction = 'Like'),
+		       (SELECT COUNT(*) FROM likes l WHERE l.comment_id = c.id AND l.reaction = 'Dislike')
+		FROM thread t
+		JOIN comments c ON c.id = t.id
+		JOIN users u ON u.id = c.user_id
+		ORDER BY t.path`
+
+	// One extra level is loaded so we know which nodes have hidden replies
+	rows, err := db.Query(query, postID, rootID, rootID, maxDepth)
+	if err != nil {
+		return nil, fmt.Errorf("query comment tree for post %d: %w", postID, err)
+	}
+	defer rows.Close()
+
+	var roots []*models.CommentNode
+	nodes := make(map[int]*models.CommentNode)
+
+	for rows.Next() {
+		node := &models.CommentNode{}
+		var createdAt time.Time
+		if err := rows.Scan(&node.ID, &node.PostID, &node.UserID, &node.UserName, &node.UserTrustLevel, &node.Content, &createdAt,
+			&node.ParentCommentID, &node.Depth, &node.Path, &node.IsEdited, &node.IsDeleted,
+			&node.Likes, &node.Dislikes); err != nil {
+			return nil, fmt.Errorf("scan comment tree row: %w", err)
+		}
+		node.CreatedAt = FormatDate(createdAt)
+		if node.IsDeleted {
+			node.Content = DeletedPlaceholder
+			node.UserName = DeletedPlaceholder
+		}
+
+		parent, hasParent := nodes[node.ParentCommentID]
+		if node.Depth >= maxDepth && hasParent {
+			parent.ContinueThread = true
+			continue
+		}
+		nodes[node.ID] = node
+		if node.Depth == 0 || !hasParent {
+			roots = append(roots, node)
+		} else {
+			parent.Children = append(parent.Children, node)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("iterate comment tree: %w", err)
+	}
+
+	sortCommentNodes(roots, NormalizeCommentSort(sortBy))
+	for _, root := range roots {
+		countDescendants(root)
+	}
+	return roots, nil
+}
+
+// sortCommentNodes orders siblings at every level; rows arrive in id order,
+// which is already oldest first
+func sortCommentNodes(nodes []*models.CommentNode, sortBy string) {
+	switch sortBy {
+	case CommentSortNewest:
+		sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].ID > nodes[j].ID })
+	case CommentSortTop:
+		sort.SliceStable(nodes, func(i, j int) bool {
+			return nodes[i].Likes-nodes[i].Dislikes > nodes[j].Likes-nodes[j].Dislikes
+		})
+	}
+	for _, n := range nodes {
+		sortCommentNodes(n.Children, sortBy)
+	}
+}
+
+// countDescendants fills Descendants and starts large threads collapsed
+func countDescendants(node *models.CommentNode) int {
+	total := 0
+	for _, child := range node.Children {
+		total += 1 + countDescendants(child)
+	}
+	node.Descendants = total
+	node.Collapsed = node.Depth > 0 && total >= CollapseThreshold
+	return total
+}
+
+// WalkCommentTree calls fn for every node in the tree, parents before children
+func WalkCommentTree(nodes []*models.CommentNode, fn func(*models.CommentNode) error) error {
+	for _, n := range nodes {
+		if err := fn(n); err != nil {
+			return err
+		}
+		if err := WalkCommentTree(n.Children, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
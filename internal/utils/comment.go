@@ -3,6 +3,7 @@ package utils
 import (
 	"database/sql"
 	"fmt"
+	"forum/internal/metrics"
 	"log"
 	// "forum/internal"
 	"forum/internal/models"
@@ -14,7 +15,7 @@ import (
 func GetCommentsCount(db *sql.DB, postID int) (int, error) {
 	// Query to count the number of comments
 	var commentsCount int
-	err := db.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ?", postID).Scan(&commentsCount)
+	err := db.QueryRow("SELECT COUNT(*) FROM comments WHERE post_id = ? AND deleted_at IS NULL", postID).Scan(&commentsCount)
 	if err != nil {
 		return 0, err
 	}
@@ -26,7 +27,9 @@ func GetCommentsByPostID(db *sql.DB, postID int) ([]models.Comment, error) {
 	// log.Printf("[DEBUG] GetCommentsByPostID called for postID=%d", postID)
 	var rawCreatedAt time.Time
 	query := `
-       SELECT c.id, c.post_id, c.user_id, u.username, c.content, c.created_at
+       SELECT c.id, c.post_id, c.user_id, u.username, c.content, c.created_at,
+		       EXISTS(SELECT 1 FROM comment_revisions r WHERE r.comment_id = c.id),
+		       c.deleted_at IS NOT NULL
 		FROM comments c
 		JOIN users u ON c.user_id = u.id
 		WHERE c.post_id = ?
@@ -42,12 +45,17 @@ func GetCommentsByPostID(db *sql.DB, postID int) ([]models.Comment, error) {
 	var comments []models.Comment
 	for rows.Next() {
 		var comment models.Comment
-		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.UserName, &comment.Content, &rawCreatedAt); err != nil {
+		if err := rows.Scan(&comment.ID, &comment.PostID, &comment.UserID, &comment.UserName, &comment.Content, &rawCreatedAt, &comment.IsEdited, &comment.IsDeleted); err != nil {
 			log.Printf("[ERROR] Failed to scan comment row: %v", err)
 			return nil, err
 		}
 		// Format the date
 		comment.CreatedAt = FormatDate(rawCreatedAt)
+		// Deleted comments stay in the thread as a placeholder
+		if comment.IsDeleted {
+			comment.Content = DeletedPlaceholder
+			comment.UserName = DeletedPlaceholder
+		}
 		// Get like/dislike counts for each comment
 		comment.Likes, comment.Dislikes, err = GetCommentReactionsCount(db, comment.ID)
 		if err != nil {
@@ -71,7 +79,7 @@ func GetCommentsByPostID(db *sql.DB, postID int) ([]models.Comment, error) {
 
 // Get only comment texts (useful for a concise view)
 func GetCommentTextsByPostID(db *sql.DB, postID int) ([]string, error) {
-	query := "SELECT content FROM comments WHERE post_id = ?"
+	query := "SELECT content FROM comments WHERE post_id = ? AND deleted_at IS NULL"
 	rows, err := db.Query(query, postID)
 	if err != nil {
 		return nil, err
@@ -118,9 +126,21 @@ func AddComment(db *sql.DB, postID, userID, parentCommentID int, content string)
 		return 0, fmt.Errorf("failed to get post: %w", err)
 	}
 
-	// Insert comment and get its ID
+	// A reply must belong to the same post as its parent
+	if parentCommentID != 0 {
+		var parentPostID int
+		err := db.QueryRow("SELECT post_id FROM comments WHERE id = ?", parentCommentID).Scan(&parentPostID)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get parent comment: %w", err)
+		}
+		if parentPostID != postID {
+			return 0, fmt.Errorf("parent comment %d does not belong to post %d", parentCommentID, postID)
+		}
+	}
+
+	// Insert comment and get its ID; top-level comments have a NULL parent
 	res, err := db.Exec(
-		"INSERT INTO comments (post_id, user_id, parent_comment_id, content, created_at) VALUES (?, ?, ?, ?, datetime('now'))",
+		"INSERT INTO comments (post_id, user_id, parent_comment_id, content, created_at) VALUES (?, ?, NULLIF(?, 0), ?, datetime('now'))",
 		postID, userID, parentCommentID, content,
 	)
 	if err != nil {
@@ -132,6 +152,7 @@ func AddComment(db *sql.DB, postID, userID, parentCommentID int, content string)
 		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
 	}
 	commentID := int(commentID64)
+	metrics.CommentsCreated.Inc()
 
 	notificationType := "comment"
 	if parentCommentID != 0 {
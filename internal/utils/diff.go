@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"forum/internal/models"
+	"regexp"
+	"strings"
+)
+
+// wordTokens keeps whitespace as separate tokens so a word diff can be
+// rendered back exactly as it was written
+var wordTokens = regexp.MustCompile(`\s+|[^\s]+`)
+
+// DiffLines compares two texts line by line
+func DiffLines(oldText, newText string) []models.DiffOp {
+	oldLines := splitLines(oldText)
+	newLines := splitLines(newText)
+	return diffTokens(oldLines, newLines)
+}
+
+// maxDiffCells bounds the LCS table, rows times columns, so comparing two
+// large revisions cannot take unbounded memory: 4M cells are 32 MB
+const maxDiffCells = 1 << 22
+
+// DiffWords compares two texts word by word, or line by line when there are
+// too many words to compare
+func DiffWords(oldText, newText string) []models.DiffOp {
+	a, b := wordTokens.FindAllString(oldText, -1), wordTokens.FindAllString(newText, -1)
+	if prefix, suffix := commonEnds(a, b); (len(a)-prefix-suffix)*(len(b)-prefix-suffix) > maxDiffCells {
+		return DiffLines(oldText, newText)
+	}
+	return diffTokens(a, b)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// commonEnds returns how many tokens a and b share at their start and,
+// after that, at their end
+func commonEnds(a, b []string) (prefix, suffix int) {
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	return prefix, suffix
+}
+
+// diffTokens builds the longest common subsequence table and walks it to
+// produce equal/delete/insert chunks. Adjacent chunks of the same type are merged.
+// The common start and end are left out of the table; when what remains is
+// still larger than maxDiffCells it is shown as deleted and inserted whole.
+func diffTokens(a, b []string) []models.DiffOp {
+	var ops []models.DiffOp
+	// The text of the last chunk is built up here and stored when the next
+	// chunk starts, so merging tokens stays linear
+	var text strings.Builder
+	flush := func() {
+		if len(ops) > 0 {
+			ops[len(ops)-1].Text = text.String()
+			text.Reset()
+		}
+	}
+	add := func(opType, token string) {
+		if token == "" {
+			return
+		}
+		if len(ops) == 0 || ops[len(ops)-1].Type != opType {
+			flush()
+			ops = append(ops, models.DiffOp{Type: opType})
+		}
+		text.WriteString(token)
+	}
+
+	prefix, suffix := commonEnds(a, b)
+	add("equal", strings.Join(a[:prefix], ""))
+	common := strings.Join(a[len(a)-suffix:], "")
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(a), len(b)
+
+	if n*m > maxDiffCells {
+		add("delete", strings.Join(a, ""))
+		add("insert", strings.Join(b, ""))
+		add("equal", common)
+		flush()
+		return ops
+	}
+
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n && j < m {
+		switch {
+		case a[i] == b[j]:
+			add("equal", a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			add("delete", a[i])
+			i++
+		default:
+			add("insert", b[j])
+			j++
+		}
+	}
+	for ; i < n; i++ {
+		add("delete", a[i])
+	}
+	for ; j < m; j++ {
+		add("insert", b[j])
+	}
+	add("equal", common)
+	flush()
+
+	return ops
+}
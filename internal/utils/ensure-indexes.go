@@ -14,6 +14,7 @@ func EnsureIndexes(db *sql.DB) {
 		// comments
 		`CREATE INDEX IF NOT EXISTS idx_comments_post_id ON comments(post_id);`,
 		`CREATE INDEX IF NOT EXISTS idx_comments_user_id ON comments(user_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_comments_parent_id ON comments(parent_comment_id);`,
 
 		// post_categories
 		`CREATE INDEX IF NOT EXISTS idx_post_categories_post_id ON post_categories(post_id);`,
@@ -36,6 +37,23 @@ func EnsureIndexes(db *sql.DB) {
 
 		// moderator_requests
 		`CREATE INDEX IF NOT EXISTS idx_moderator_requests_user_id ON moderator_requests(user_id);`,
+
+		// revisions
+		`CREATE INDEX IF NOT EXISTS idx_post_revisions_post_id ON post_revisions(post_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_comment_revisions_comment_id ON comment_revisions(comment_id);`,
+
+		// post_attachments
+		`CREATE INDEX IF NOT EXISTS idx_post_attachments_post_id ON post_attachments(post_id);`,
+		`CREATE INDEX IF NOT EXISTS idx_post_attachments_file_path ON post_attachments(file_path);`,
+
+		// rate_limits, swept by expiry
+		`CREATE INDEX IF NOT EXISTS idx_rate_limits_tat ON rate_limits(tat);`,
+
+		// duplicate content checks look back over recent comments
+		`CREATE INDEX IF NOT EXISTS idx_comments_created_at ON comments(created_at);`,
+
+		// held_content, the review queue
+		`CREATE INDEX IF NOT EXISTS idx_held_content_status ON held_content(status, created_at);`,
 	}
 
 	for _, query := range indexes {
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits for the text attached to a post image
+const (
+	MaxImageCaptionLength = 300
+	MaxImageAltTextLength = 250
+)
+
+// ErrInvalidGallery is returned when a gallery change names images that do
+// not belong to the post or leaves the gallery inconsistent
+var ErrInvalidGallery = errors.New("invalid gallery change")
+
+// postImageIDs returns the IDs of a post's images in their current order
+func postImageIDs(ctx context.Context, tx *sql.Tx, postID int) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, "SELECT id FROM post_images WHERE post_id = ? ORDER BY order_index, id", postID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ReorderPostImages sets the display order of a post's images. imageIDs must
+// list every image of the post exactly once.
+func ReorderPostImages(ctx context.Context, db *sql.DB, postID int, imageIDs []int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	current, err := postImageIDs(ctx, tx, postID)
+	if err != nil {
+		return err
+	}
+	if len(imageIDs) != len(current) {
+		return fmt.Errorf("%w: expected %d images, got %d", ErrInvalidGallery, len(current), len(imageIDs))
+	}
+	owned := make(map[int]bool, len(current))
+	for _, id := range current {
+		owned[id] = true
+	}
+	for i, id := range imageIDs {
+		if !owned[id] {
+			return fmt.Errorf("%w: image %d is not part of the post or listed twice", ErrInvalidGallery, id)
+		}
+		delete(owned, id)
+		if _, err := tx.ExecContext(ctx, "UPDATE post_images SET order_index = ? WHERE id = ?", i, id); err != nil {
+			return fmt.Errorf("reorder image %d: %w", id, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// SetPrimaryPostImage makes imageID the post's only primary image
+func SetPrimaryPostImage(ctx context.Context, db *sql.DB, postID, imageID int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requirePostImage(ctx, tx, postID, imageID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE post_images SET is_primary = (id = ?) WHERE post_id = ?", imageID, postID); err != nil {
+		return fmt.Errorf("set primary image: %w", err)
+	}
+	return tx.Commit()
+}
+
+// UpdatePostImageText sets the caption and alt text of one image
+func UpdatePostImageText(ctx context.Context, db *sql.DB, postID, imageID int, caption, altText string) error {
+	caption, altText = strings.TrimSpace(caption), strings.TrimSpace(altText)
+	if utf8.RuneCountInString(caption) > MaxImageCaptionLength {
+		return fmt.Errorf("%w: caption is longer than %d characters", ErrInvalidGallery, MaxImageCaptionLength)
+	}
+	if utf8.RuneCountInString(altText) > MaxImageAltTextLength {
+		return fmt.Errorf("%w: alt text is longer than %d characters", ErrInvalidGallery, MaxImageAltTextLength)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := requirePostImage(ctx, tx, postID, imageID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE post_images SET caption = ?, alt_text = ? WHERE id = ?", caption, altText, imageID); err != nil {
+		return fmt.Errorf("update image text: %w", err)
+	}
+	return tx.Commit()
+}
+
+func requirePostImage(ctx context.Context, tx *sql.Tx, postID, imageID int) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM post_images WHERE id = ? AND post_id = ?)", imageID, postID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("%w: image %d is not part of the post", ErrInvalidGallery, imageID)
+	}
+	return nil
+}
+
+// normalizePostImages renumbers a post's images from 0 and makes the first one
+// primary when none is, e.g. after the primary image was removed
+func normalizePostImages(ctx context.Context, tx *sql.Tx, postID int) error {
+	ids, err := postImageIDs(ctx, tx, postID)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+	for i, id := range ids {
+		if _, err := tx.ExecContext(ctx, "UPDATE post_images SET order_index = ? WHERE id = ?", i, id); err != nil {
+			return err
+		}
+	}
+	_, err = tx.ExecContext(ctx, `
+		UPDATE post_images SET is_primary = 1
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM post_images WHERE post_id = ? AND is_primary)`,
+		ids[0], postID)
+	return err
+}
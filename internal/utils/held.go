@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+	"time"
+)
+
+// States of held content
+const (
+	HeldPending  = "pending"
+	HeldApproved = "approved"
+	HeldRejected = "rejected"
+)
+
+// HoldContent puts a submission in the review queue instead of publishing
+// it. Posts keep their categories, tags and uploads in payload so they can
+// be created as submitted once approved.
+func HoldContent(ctx context.Context, db *sql.DB, s Submission, payload models.HeldPostPayload, result SpamResult) (int, error) {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("encode held payload: %w", err)
+	}
+	var score any
+	if result.Scored {
+		score = result.Score
+	}
+	res, err := db.ExecContext(ctx, `
+		INSERT INTO held_content (kind, user_id, post_id, parent_comment_id, title, content, payload, reasons, spam_score)
+		VALUES (?, ?, NULLIF(?, 0), NULLIF(?, 0), ?, ?, ?, ?, ?)`,
+		s.Kind, s.UserID, s.PostID, s.ParentCommentID, s.Title, s.Content, string(encoded),
+		strings.Join(result.Reasons, "\n"), score)
+	if err != nil {
+		return 0, fmt.Errorf("hold %s: %w", s.Kind, err)
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+const heldColumns = `
+	h.id, h.kind, h.user_id, u.username, COALESCE(h.post_id, 0), COALESCE(p.title, ''),
+	COALESCE(h.parent_comment_id, 0), h.title, h.content, h.payload, h.reasons,
+	h.spam_score, h.status, h.created_at, COALESCE(h.decision_reason, '')`
+
+func scanHeld(row interface{ Scan(...any) error }) (models.HeldContent, error) {
+	var h models.HeldContent
+	var payload, reasons string
+	var score sql.NullFloat64
+	var createdAt time.Time
+	if err := row.Scan(&h.ID, &h.Kind, &h.UserID, &h.UserName, &h.PostID, &h.PostTitle,
+		&h.ParentCommentID, &h.Title, &h.Content, &payload, &reasons,
+		&score, &h.Status, &createdAt, &h.DecisionReason); err != nil {
+		return h, err
+	}
+	if err := json.Unmarshal([]byte(payload), &h.Payload); err != nil {
+		return h, fmt.Errorf("decode payload of held %s %d: %w", h.Kind, h.ID, err)
+	}
+	if reasons != "" {
+		h.Reasons = strings.Split(reasons, "\n")
+	}
+	h.SpamScore, h.Scored = score.Float64, score.Valid
+	h.CreatedAt = FormatDate(createdAt)
+	return h, nil
+}
+
+// GetHeldContent lists the content waiting for review, oldest first
+func GetHeldContent(ctx context.Context, db *sql.DB) ([]models.HeldContent, error) {
+	return queryHeld(ctx, db, "h.status = ? ORDER BY h.created_at, h.id", HeldPending)
+}
+
+// GetHeldContentByUser lists what a member submitted that awaits review or
+// was rejected in the last 30 days, newest first, for their own page
+func GetHeldContentByUser(ctx context.Context, db *sql.DB, userID int) ([]models.HeldContent, error) {
+	return queryHeld(ctx, db, `h.user_id = ? AND (h.status = ? OR (h.status = ? AND h.reviewed_at >= datetime('now', '-30 days')))
+		ORDER BY h.created_at DESC, h.id DESC`, userID, HeldPending, HeldRejected)
+}
+
+// GetHeldComments lists the comments on a post awaiting review, oldest
+// first: every one for moderators, or only those of authorID
+func GetHeldComments(ctx context.Context, db *sql.DB, postID, authorID int) ([]models.HeldContent, error) {
+	return queryHeld(ctx, db, `h.kind = 'comment' AND h.post_id = ? AND h.status = ? AND (? = 0 OR h.user_id = ?)
+		ORDER BY h.created_at, h.id`, postID, HeldPending, authorID, authorID)
+}
+
+// queryHeld lists held content matching where, which may end in ORDER BY
+func queryHeld(ctx context.Context, db *sql.DB, where string, args ...any) ([]models.HeldContent, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+heldColumns+`
+		FROM held_content h
+		JOIN users u ON u.id = h.user_id
+		LEFT JOIN posts p ON p.id = h.post_id
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("query held content: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.HeldContent
+	for rows.Next() {
+		h, err := scanHeld(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, h)
+	}
+	return items, rows.Err()
+}
+
+// GetHeldItem returns one held submission in any state
+func GetHeldItem(ctx context.Context, db *sql.DB, id int) (models.HeldContent, error) {
+	return scanHeld(db.QueryRowContext(ctx, `
+		SELECT `+heldColumns+`
+		FROM held_content h
+		JOIN users u ON u.id = h.user_id
+		LEFT JOIN posts p ON p.id = h.post_id
+		WHERE h.id = ?`, id))
+}
+
+// ClaimHeldItem moves a pending submission to status on behalf of a
+// moderator, with the reason the author is told. It returns sql.ErrNoRows
+// when the item is not pending, so two moderators deciding at once cannot
+// both publish it.
+func ClaimHeldItem(ctx context.Context, db *sql.DB, id int, status string, moderatorID int, reason string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE held_content
+		SET status = ?, reviewed_by = ?, reviewed_at = CURRENT_TIMESTAMP, decision_reason = ?
+		WHERE id = ? AND status = ?`, status, moderatorID, reason, id, HeldPending)
+	if err != nil {
+		return fmt.Errorf("review held item %d: %w", id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// ReleaseHeldItem puts a claimed submission back in the queue, for when it
+// could not be published after all
+func ReleaseHeldItem(ctx context.Context, db *sql.DB, id int) error {
+	_, err := db.ExecContext(ctx, `
+		UPDATE held_content SET status = ?, reviewed_by = NULL, reviewed_at = NULL, decision_reason = ''
+		WHERE id = ?`, HeldPending, id)
+	return err
+}
+
+// SetHeldPostID records the post an approved submission became, or the post
+// a held comment is in
+func SetHeldPostID(ctx context.Context, db execer, id, postID int) error {
+	_, err := db.ExecContext(ctx, "UPDATE held_content SET post_id = ? WHERE id = ?", postID, id)
+	return err
+}
+
+// heldUploadPaths returns the uploads of held posts awaiting review, which
+// the garbage collector must keep although no post references them yet
+func heldUploadPaths(ctx context.Context, db *sql.DB) ([]string, error) {
+	payloads, err := queryStrings(ctx, db, "SELECT payload FROM held_content WHERE kind = 'post' AND status = 'pending'")
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, encoded := range payloads {
+		var payload models.HeldPostPayload
+		if err := json.Unmarshal([]byte(encoded), &payload); err != nil {
+			return nil, fmt.Errorf("decode held payload: %w", err)
+		}
+		paths = append(paths, payload.Images...)
+		for _, a := range payload.Attachments {
+			paths = append(paths, a.Path)
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,398 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits for uploaded images. Larger stills are scaled down to MaxImageDimension;
+// anything above MaxImagePixels is rejected before it is decoded. The frames of
+// an animated GIF count towards MaxImagePixels together.
+const (
+	MaxImageDimension = 2560
+	MaxImagePixels    = 50_000_000
+	MaxGIFFrames      = 500
+	jpegQuality       = 85
+)
+
+// ThumbnailSize is a variant generated for every uploaded image
+type ThumbnailSize struct {
+	Name   string // suffix added to the file name, e.g. photo_sm.jpg
+	MaxDim int
+}
+
+// ThumbnailSizes are generated for every upload: small for listings, medium for the post page
+var ThumbnailSizes = []ThumbnailSize{
+	{Name: "sm", MaxDim: 320},
+	{Name: "md", MaxDim: 960},
+}
+
+// ProcessedImage is an upload after it was decoded and re-encoded
+type ProcessedImage struct {
+	Format     string // jpeg, png or gif
+	Width      int
+	Height     int
+	Data       []byte
+	Thumbnails map[string][]byte // keyed by ThumbnailSize.Name
+}
+
+// Errors returned by ProcessImage. The messages are shown to users.
+var (
+	ErrUnsupportedImage = errors.New("unsupported file type")
+	ErrImageTooLarge    = errors.New("image dimensions are too large")
+	ErrAnimationTooLong = errors.New("animation has too many frames")
+)
+
+// ProcessImage decodes an uploaded image and re-encodes it. Only real JPEG,
+// PNG and GIF data is accepted, whatever the client claims. Re-encoding drops
+// EXIF, GPS and other metadata; the EXIF orientation is applied first so
+// photos keep the right way up.
+func ProcessImage(r io.Reader) (*ProcessedImage, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file")
+	}
+
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if format != "jpeg" && format != "png" && format != "gif" {
+		return nil, ErrUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	if format == "gif" {
+		return processGIF(data, cfg)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrUnsupportedImage
+	}
+	if format == "jpeg" {
+		img = applyOrientation(img, jpegOrientation(data))
+	}
+	img = resizeToFit(img, MaxImageDimension)
+
+	out := &ProcessedImage{Format: format, Width: img.Bounds().Dx(), Height: img.Bounds().Dy()}
+	if out.Data, err = encodeImage(img, format); err != nil {
+		return nil, err
+	}
+	if out.Thumbnails, err = makeThumbnails(img, format); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// processGIF re-encodes every frame so animations survive. Animated GIFs are
+// not rescaled, so oversized ones are rejected instead.
+func processGIF(data []byte, cfg image.Config) (*ProcessedImage, error) {
+	if cfg.Width > MaxImageDimension || cfg.Height > MaxImageDimension {
+		return nil, ErrImageTooLarge
+	}
+	if err := checkGIFFrames(data, cfg); err != nil {
+		return nil, err
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || len(g.Image) == 0 {
+		return nil, ErrUnsupportedImage
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, fmt.Errorf("encode gif: %w", err)
+	}
+
+	// Thumbnails are stills of the first frame
+	first := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	draw.Draw(first, first.Bounds(), g.Image[0], image.Point{}, draw.Over)
+	thumbs, err := makeThumbnails(first, "png")
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcessedImage{
+		Format:     "gif",
+		Width:      g.Config.Width,
+		Height:     g.Config.Height,
+		Data:       buf.Bytes(),
+		Thumbnails: thumbs,
+	}, nil
+}
+
+// checkGIFFrames counts the frames of a GIF without decoding them and rejects
+// animations whose frames together exceed the limits
+func checkGIFFrames(data []byte, cfg image.Config) error {
+	frames, err := countGIFFrames(data, MaxGIFFrames+1)
+	if err != nil {
+		return ErrUnsupportedImage
+	}
+	if frames > MaxGIFFrames {
+		return ErrAnimationTooLong
+	}
+	if cfg.Width*cfg.Height*frames > MaxImagePixels {
+		return ErrImageTooLarge
+	}
+	return nil
+}
+
+// countGIFFrames walks the blocks of a GIF and counts its image descriptors,
+// stopping once it reaches limit. Data ending between blocks is left for the
+// decoder to judge.
+func countGIFFrames(data []byte, limit int) (int, error) {
+	// Header and logical screen descriptor, then the global color table
+	if len(data) < 13 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	// skipSubBlocks moves past a chain of data sub-blocks and its terminator
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return io.ErrUnexpectedEOF
+			}
+			n := int(data[pos])
+			pos += 1 + n
+			if n == 0 {
+				return nil
+			}
+		}
+	}
+
+	frames := 0
+	for pos < len(data) && frames < limit {
+		switch data[pos] {
+		case 0x21: // extension: introducer, label, sub-blocks
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return frames, err
+			}
+		case 0x2C: // image descriptor, local color table, LZW code size, sub-blocks
+			if pos+10 > len(data) {
+				return frames, io.ErrUnexpectedEOF
+			}
+			frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			if err := skipSubBlocks(); err != nil {
+				return frames, err
+			}
+		case 0x3B: // trailer
+			return frames, nil
+		default:
+			return frames, fmt.Errorf("unknown gif block 0x%02x", data[pos])
+		}
+	}
+	return frames, nil
+}
+
+func makeThumbnails(img image.Image, format string) (map[string][]byte, error) {
+	thumbs := make(map[string][]byte, len(ThumbnailSizes))
+	for _, size := range ThumbnailSizes {
+		data, err := encodeImage(resizeToFit(img, size.MaxDim), format)
+		if err != nil {
+			return nil, err
+		}
+		thumbs[size.Name] = data
+	}
+	return thumbs, nil
+}
+
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality})
+	default:
+		err = png.Encode(&buf, img)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("encode %s: %w", format, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// ImageExtension returns the file extension used for a processed format
+func ImageExtension(format string) string {
+	switch format {
+	case "jpeg":
+		return ".jpg"
+	case "gif":
+		return ".gif"
+	default:
+		return ".png"
+	}
+}
+
+// ThumbnailPath returns where the thumbnail of an uploaded image is stored.
+// GIF thumbnails are still PNG images.
+func ThumbnailPath(imagePath, size string) string {
+	ext := path.Ext(imagePath)
+	base := strings.TrimSuffix(imagePath, ext)
+	if strings.EqualFold(ext, ".gif") {
+		ext = ".png"
+	}
+	return base + "_" + size + ext
+}
+
+// resizeToFit scales img down so neither side exceeds maxDim
+func resizeToFit(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+
+	if w >= h {
+		return resample(img, maxDim, max(1, h*maxDim/w))
+	}
+	return resample(img, max(1, w*maxDim/h), maxDim)
+}
+
+// resample scales img to exactly dw x dh. Shrinking averages the source
+// pixels that fall into each destination pixel; enlarging repeats them.
+func resample(img image.Image, dw, dh int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	src := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+			var r, g, bl, a, n uint32
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					r += uint32(p[0])
+					g += uint32(p[1])
+					bl += uint32(p[2])
+					a += uint32(p[3])
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// applyOrientation rotates or flips img according to an EXIF orientation (1-8)
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = h-1-y, x
+			case 7: // transverse
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(b.Min.X+x, b.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the orientation tag from the EXIF block of a JPEG,
+// returning 1 (normal) when there is none
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return 1
+		}
+		marker := data[pos+1]
+		size := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if marker == 0xDA || size < 2 || pos+2+size > len(data) {
+			return 1 // start of scan: no more metadata
+		}
+		segment := data[pos+4 : pos+2+size]
+		if marker == 0xE1 && len(segment) > 14 && string(segment[:6]) == "Exif\x00\x00" {
+			return exifOrientation(segment[6:])
+		}
+		pos += 2 + size
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < entries; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 { // Orientation
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 1
+}
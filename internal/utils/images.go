@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"forum/internal/logging"
 	"forum/internal/models"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"log"
@@ -13,9 +14,6 @@ import (
 )
 
 func ProcessPostImages(ctx context.Context, tx *sql.Tx, postID int64, imagePaths []string, primaryImageIndex int) error {
-	// Добавляем детальное логирование
-	log.Printf("ProcessPostImages called with postID=%d, imagePaths=%+v, primaryImageIndex=%d", postID, imagePaths, primaryImageIndex)
-
 	// Validate inputs
 	if postID <= 0 {
 		log.Printf("Error: invalid post ID: %d", postID)
@@ -58,8 +56,8 @@ func ProcessPostImages(ctx context.Context, tx *sql.Tx, postID int64, imagePaths
 	// Prepare statement для вставки
 	stmt, err := tx.PrepareContext(ctx, `
         INSERT INTO post_images 
-        (post_id, image_path, is_primary, order_index) 
-        VALUES (?, ?, ?, ?)`)
+        (post_id, image_path, is_primary, order_index, thumb_small, thumb_medium) 
+        VALUES (?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		log.Printf("Error preparing statement: %v", err)
 		return fmt.Errorf("failed to prepare statement: %w", err)
@@ -81,6 +79,8 @@ func ProcessPostImages(ctx context.Context, tx *sql.Tx, postID int64, imagePaths
 			path,
 			isPrimary,
 			i,
+			ThumbnailPath(path, "sm"),
+			ThumbnailPath(path, "md"),
 		)
 		if err != nil {
 			log.Printf("Error inserting image %d (%s): %v", i, path, err)
@@ -111,10 +111,12 @@ func ProcessPostImages(ctx context.Context, tx *sql.Tx, postID int64, imagePaths
 // GetImagesByPostID fetches image paths associated with a specific post
 func GetPostImages(db *sql.DB, postID int) ([]models.Image, error) {
 	rows, err := db.Query(`
-		SELECT image_path, is_primary, order_index, id
+		SELECT image_path, is_primary, order_index, id,
+		       COALESCE(thumb_small, image_path), COALESCE(thumb_medium, image_path),
+		       COALESCE(caption, ''), COALESCE(alt_text, '')
 		FROM post_images
 		WHERE post_id = ?
-		ORDER BY order_index ASC
+		ORDER BY order_index ASC, id ASC
 	`, postID)
 	if err != nil {
 		return nil, err
@@ -124,7 +126,7 @@ func GetPostImages(db *sql.DB, postID int) ([]models.Image, error) {
 	var images []models.Image
 	for rows.Next() {
 		var img models.Image
-		err := rows.Scan(&img.Path, &img.IsPrimary, &img.Order, &img.ID) // додано &img.ID
+		err := rows.Scan(&img.Path, &img.IsPrimary, &img.Order, &img.ID, &img.ThumbSmall, &img.ThumbMedium, &img.Caption, &img.AltText) // додано &img.ID
 		if err != nil {
 			return nil, err
 		}
@@ -198,6 +200,23 @@ func CheckDatabaseSchema(db *sql.DB) {
 	log.Println("=== End schema check ===")
 }
 
+// imageFieldNames are the form fields images may be uploaded in
+var imageFieldNames = []string{"images[]", "images", "image[]", "image", "files[]", "files"}
+
+// CountUploadedImages counts the images a parsed multipart form uploads,
+// before any is saved
+func CountUploadedImages(r *http.Request) int {
+	if r.MultipartForm == nil {
+		return 0
+	}
+	for _, fieldName := range imageFieldNames {
+		if files := r.MultipartForm.File[fieldName]; len(files) > 0 {
+			return len(files)
+		}
+	}
+	return 0
+}
+
 // processUploadedImages processes uploaded files and returns slice of saved image paths and primary image index.
 func ProcessUploadedImages(r *http.Request) ([]string, int, error) {
 	var imagePaths []string
@@ -212,14 +231,13 @@ func ProcessUploadedImages(r *http.Request) ([]string, int, error) {
 		}
 	}
 
-	possibleFieldNames := []string{"images[]", "images", "image[]", "image", "files[]", "files"}
 	var foundFiles []*multipart.FileHeader
 
 	if r.MultipartForm == nil || r.MultipartForm.File == nil {
 		return nil, 0, fmt.Errorf("No files uploaded")
 	}
 
-	for _, fieldName := range possibleFieldNames {
+	for _, fieldName := range imageFieldNames {
 		if files, exists := r.MultipartForm.File[fieldName]; exists && len(files) > 0 {
 			foundFiles = files
 			log.Printf("Found %d files in field '%s'", len(files), fieldName)
@@ -228,7 +246,7 @@ func ProcessUploadedImages(r *http.Request) ([]string, int, error) {
 	}
 
 	if len(foundFiles) == 0 {
-		log.Printf("No files found in any expected field. Available fields: %+v", r.MultipartForm.File)
+		logging.FromContext(r.Context()).Debug("no image files in upload", "fields", len(r.MultipartForm.File))
 		return nil, primaryImageIndex, nil // No error, just no images
 	}
 
@@ -255,6 +273,8 @@ func ProcessUploadedImages(r *http.Request) ([]string, int, error) {
 				return nil, 0, fmt.Errorf("Maximum allowed size is 20MB")
 			case "unsupported file type":
 				return nil, 0, fmt.Errorf("Only JPEG, PNG, and GIF are allowed")
+			case "image dimensions are too large":
+				return nil, 0, fmt.Errorf("Image dimensions are too large")
 			default:
 				continue
 			}
@@ -1,11 +1,11 @@
 package utils
 
 import (
+	"forum/internal/config"
+
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/github"
 	"golang.org/x/oauth2/google"
-
-	"os"
 )
 
 var (
@@ -13,27 +13,19 @@ var (
 	GitHubOAuthConfig *oauth2.Config
 )
 
-func InitOAuthConfigs() {
-	googleClientID := os.Getenv("GOOGLE_CLIENT_ID")
-	googleClientSecret := os.Getenv("GOOGLE_CLIENT_SECRET")
-	googleRedirectURL := os.Getenv("GOOGLE_REDIRECT_URL")
-
-	githubClientID := os.Getenv("GITHUB_CLIENT_ID")
-	githubClientSecret := os.Getenv("GITHUB_CLIENT_SECRET")
-	githubRedirectURL := os.Getenv("GITHUB_REDIRECT_URL")
-
+func InitOAuthConfigs(googleCfg, githubCfg config.OAuthProvider) {
 	GoogleOAuthConfig = &oauth2.Config{
-		ClientID:     googleClientID,
-		ClientSecret: googleClientSecret,
-		RedirectURL:  googleRedirectURL,
+		ClientID:     googleCfg.ClientID,
+		ClientSecret: googleCfg.ClientSecret.Value(),
+		RedirectURL:  googleCfg.RedirectURL,
 		Scopes:       []string{"https://www.googleapis.com/auth/userinfo.email"},
 		Endpoint:     google.Endpoint,
 	}
 
 	GitHubOAuthConfig = &oauth2.Config{
-		ClientID:     githubClientID,
-		ClientSecret: githubClientSecret,
-		RedirectURL:  githubRedirectURL,
+		ClientID:     githubCfg.ClientID,
+		ClientSecret: githubCfg.ClientSecret.Value(),
+		RedirectURL:  githubCfg.RedirectURL,
 		Scopes:       []string{"user:email"},
 		Endpoint:     github.Endpoint,
 	}
@@ -0,0 +1,279 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes of an IP block, by what they stop the blocked network from doing
+const (
+	BlockRegistration = "registration"
+	BlockPosting      = "posting"
+	BlockAll          = "all"
+)
+
+// BlockScopes lists the scopes admins can choose from
+var BlockScopes = []string{BlockRegistration, BlockPosting, BlockAll}
+
+// requestIP returns the IP of the client behind a request; main replaces it
+// with the proxy-aware middleware.ClientIP, which utils cannot import
+var requestIP = func(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// SetRequestIP sets how the client IP of a request is found
+func SetRequestIP(ip func(*http.Request) string) {
+	requestIP = ip
+}
+
+// RequestIP is the IP of the client that made r
+func RequestIP(r *http.Request) string {
+	return requestIP(r)
+}
+
+// ParseBlockedNetwork reads an IPv4 or IPv6 address or CIDR range as the
+// network a block covers. A single address is a network of its own.
+func ParseBlockedNetwork(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		network, err := netip.ParsePrefix(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid CIDR range %q", s)
+		}
+		return network.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP address %q", s)
+	}
+	addr = addr.Unmap().WithZone("")
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// AddIPBlock blocks network for scope. A zero expiresAt blocks it until the
+// entry is removed.
+func AddIPBlock(db *sql.DB, network, scope, reason string, createdBy int, expiresAt time.Time) error {
+	prefix, err := ParseBlockedNetwork(network)
+	if err != nil {
+		return err
+	}
+	if !slices.Contains(BlockScopes, scope) {
+		return fmt.Errorf("unknown scope %q", scope)
+	}
+	var expires sql.NullTime
+	if !expiresAt.IsZero() {
+		expires = sql.NullTime{Time: expiresAt.UTC(), Valid: true}
+	}
+
+	_, err = db.Exec(`
+		INSERT INTO ip_blocks (cidr, scope, reason, created_by, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		prefix.String(), scope, strings.TrimSpace(reason), createdBy, time.Now().UTC(), expires)
+	if err != nil {
+		return fmt.Errorf("failed to add IP block: %v", err)
+	}
+	IPBlocks.Invalidate()
+	return nil
+}
+
+// RemoveIPBlock deletes a block, lifting it at once
+func RemoveIPBlock(db *sql.DB, id int) error {
+	if _, err := db.Exec(`DELETE FROM ip_blocks WHERE id = ?`, id); err != nil {
+		return fmt.Errorf("failed to remove IP block: %v", err)
+	}
+	IPBlocks.Invalidate()
+	return nil
+}
+
+// GetIPBlocks lists every block, newest first, with the expired ones marked
+func GetIPBlocks(db *sql.DB, now time.Time) ([]models.IPBlock, error) {
+	rows, err := db.Query(`
+		SELECT b.id, b.cidr, b.scope, b.reason, COALESCE(u.username, ''), b.created_at, b.expires_at
+		FROM ip_blocks b
+		LEFT JOIN users u ON u.id = b.created_by
+		ORDER BY b.id DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	var blocks []models.IPBlock
+	for rows.Next() {
+		var b models.IPBlock
+		var createdAt time.Time
+		var expiresAt sql.NullTime
+		if err := rows.Scan(&b.ID, &b.Network, &b.Scope, &b.Reason, &b.CreatedByName, &createdAt, &expiresAt); err != nil {
+			return nil, err
+		}
+		b.CreatedAt = FormatDate(createdAt)
+		if expiresAt.Valid {
+			b.ExpiresAt = FormatDate(expiresAt.Time)
+			b.Expired = !expiresAt.Time.After(now)
+		}
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// GetAccountsInNetwork lists the accounts registered or last signed in from
+// network, to see who a block would affect
+func GetAccountsInNetwork(db *sql.DB, network netip.Prefix) ([]models.User, error) {
+	rows, err := db.Query(`
+		SELECT id, username, role, banned, COALESCE(registration_ip, ''), COALESCE(last_login_ip, '')
+		FROM users
+		WHERE COALESCE(registration_ip, '') != '' OR COALESCE(last_login_ip, '') != ''
+		ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	in := func(ip string) bool {
+		addr, err := netip.ParseAddr(ip)
+		return err == nil && network.Contains(addr.Unmap().WithZone(""))
+	}
+	var users []models.User
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Username, &u.Role, &u.Banned, &u.RegistrationIP, &u.LastLoginIP); err != nil {
+			return nil, err
+		}
+		if in(u.RegistrationIP) || in(u.LastLoginIP) {
+			users = append(users, u)
+		}
+	}
+	return users, rows.Err()
+}
+
+type blockEntry struct {
+	network netip.Prefix
+	scope   string
+	expires time.Time // zero for blocks that never expire
+}
+
+// IPBlocklist answers whether a client is blocked from memory. Entries are
+// read again from ip_blocks every refresh, so blocks added by another
+// instance sharing the database apply within that time, and at once after
+// Invalidate.
+type IPBlocklist struct {
+	db      *sql.DB
+	refresh time.Duration
+
+	mu      sync.Mutex
+	entries []blockEntry
+	loaded  time.Time
+}
+
+func NewIPBlocklist(db *sql.DB, refresh time.Duration) *IPBlocklist {
+	return &IPBlocklist{db: db, refresh: refresh}
+}
+
+// Invalidate makes the next check read the blocks again
+func (b *IPBlocklist) Invalidate() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.loaded = time.Time{}
+}
+
+// Blocked reports whether ip may not do what scope covers at now: blocks of
+// that scope and blocks of everything apply. Malformed IPs are never blocked.
+func (b *IPBlocklist) Blocked(ctx context.Context, ip, scope string, now time.Time) (bool, error) {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, nil
+	}
+	addr = addr.Unmap().WithZone("")
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.loaded.IsZero() || now.Sub(b.loaded) >= b.refresh {
+		if err := b.load(ctx); err != nil {
+			return false, err
+		}
+		b.loaded = now
+	}
+
+	for _, e := range b.entries {
+		if e.scope != scope && e.scope != BlockAll {
+			continue
+		}
+		if !e.expires.IsZero() && !e.expires.After(now) {
+			continue
+		}
+		if e.network.Contains(addr) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (b *IPBlocklist) load(ctx context.Context) error {
+	rows, err := b.db.QueryContext(ctx, `SELECT cidr, scope, expires_at FROM ip_blocks`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var entries []blockEntry
+	for rows.Next() {
+		var cidr string
+		var e blockEntry
+		var expires sql.NullTime
+		if err := rows.Scan(&cidr, &e.scope, &expires); err != nil {
+			return err
+		}
+		if e.network, err = netip.ParsePrefix(cidr); err != nil {
+			logging.FromContext(ctx).Warn("skipping IP block with invalid network", "network", cidr)
+			continue
+		}
+		if expires.Valid {
+			e.expires = expires.Time
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	b.entries = entries
+	return nil
+}
+
+// IPBlocks is the forum's blocklist, set by InitIPBlocklist
+var IPBlocks *IPBlocklist
+
+// InitIPBlocklist enables IP blocks read from db; it must be called during
+// app startup, before requests are served
+func InitIPBlocklist(db *sql.DB, refresh time.Duration) {
+	IPBlocks = NewIPBlocklist(db, refresh)
+}
+
+// IPBlocked reports whether the client of r is blocked for scope. Should the
+// blocks not load, the client is let through rather than failing the forum.
+func IPBlocked(r *http.Request, scope string) bool {
+	if IPBlocks == nil {
+		return false
+	}
+	blocked, err := IPBlocks.Blocked(r.Context(), RequestIP(r), scope, time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("checking IP blocks failed, letting the request through", "err", err)
+		return false
+	}
+	return blocked
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"database/sql"
 )
 
@@ -14,3 +15,18 @@ func CreateNotification(db *sql.DB, recipientID, actorID, postID, commentID int,
 		VALUES (?, ?, ?, ?, ?)`, recipientID, actorID, postID, commentID, notifType)
 	return err
 }
+
+// NotifyHeldDecision tells the author of held content what a moderator
+// decided. Approved content links to where it was published; postID and
+// commentID are 0 for content that was never published.
+func NotifyHeldDecision(ctx context.Context, db *sql.DB, authorID, moderatorID int, approved bool, postID, commentID int, reason string) error {
+	notifType := "rejected"
+	if approved {
+		notifType = "approved"
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO notifications (user_id, actor_id, post_id, comment_id, type, message)
+		VALUES (?, ?, NULLIF(?, 0), NULLIF(?, 0), ?, ?)`,
+		authorID, moderatorID, postID, commentID, notifType, reason)
+	return err
+}
@@ -2,24 +2,15 @@ package utils
 
 import (
 	"database/sql"
-	"github.com/joho/godotenv"
+	"forum/internal/config"
+	"forum/internal/metrics"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
-	"os"
 )
 
 // OpenDatabase opens a connection to the SQLite database and returns the connection.
-func OpenDatabase() (*sql.DB, error) {
-	err := godotenv.Load()
-	if err != nil {
-		return nil, err
-	}
-
-	path := os.Getenv("DB_PATH")
-	key := os.Getenv("DB_ENCRYPTION_KEY")
-
-	dsn := path + "?_pragma_key=" + key + "&_pragma_cipher_page_size=4096"
-
-	db, err := sql.Open("sqlite3", dsn)
+// Statements run through it are timed for the metrics endpoint.
+func OpenDatabase(cfg config.Database) (*sql.DB, error) {
+	db, err := sql.Open(metrics.SQLDriver("sqlite3"), cfg.DSN())
 	if err != nil {
 		return nil, err
 	}
@@ -35,5 +26,6 @@ func OpenDatabase() (*sql.DB, error) {
 		return nil, err
 	}
 
+	metrics.WatchDB(db)
 	return db, nil
 }
@@ -12,3 +12,13 @@ func HasPermission(user *models.User, ownerID int, action string) bool {
 		return false
 	}
 }
+
+// CanEditPost reports whether user may edit post: its author and
+// moderators may, and so may members trusted with CapWiki when it is a
+// wiki post
+func CanEditPost(user *models.User, post models.PostView) bool {
+	if user == nil || post.IsDeleted {
+		return false
+	}
+	return HasPermission(user, post.UserID, "edit") || (post.IsWiki && CanUse(user, CapWiki))
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"forum/internal/logging"
 	"forum/internal/models"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"log"
@@ -19,7 +20,11 @@ func GetPostByID(db *sql.DB, id int) (models.PostView, error) {
 
 	// Get basic post data + username + image
 	row := db.QueryRow(`
-		SELECT p.id, p.user_id, p.title, p.content, p.created_at, u.username,  p.updated_at
+		SELECT p.id, p.user_id, p.title, p.content, p.created_at, u.username,  p.updated_at,
+		       p.deleted_at IS NOT NULL,
+		       EXISTS(SELECT 1 FROM post_pins pp WHERE pp.post_id = p.id AND pp.category_id = 0),
+		       p.locked_at IS NOT NULL, COALESCE(p.is_announcement, 0), p.archived_at IS NOT NULL,
+		       COALESCE(p.merged_into, 0), COALESCE(u.trust_level, 0), COALESCE(p.is_wiki, 0)
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
 		WHERE p.id = ?`, id)
@@ -33,6 +38,14 @@ func GetPostByID(db *sql.DB, id int) (models.PostView, error) {
 		&rawCreatedAt,
 		&post.UserName,
 		&rawUpdateAt,
+		&post.IsDeleted,
+		&post.IsPinned,
+		&post.IsLocked,
+		&post.IsAnnouncement,
+		&post.IsArchived,
+		&post.MergedInto,
+		&post.UserTrustLevel,
+		&post.IsWiki,
 	); err != nil {
 		return post, err
 	}
@@ -74,6 +87,10 @@ func GetPostByID(db *sql.DB, id int) (models.PostView, error) {
 	if err != nil {
 		return post, err
 	}
+	post.Attachments, err = GetPostAttachments(db, post.ID)
+	if err != nil {
+		return post, err
+	}
 
 	return post, nil
 }
@@ -82,6 +99,8 @@ func UpdatePostFull(
 	ctx context.Context,
 	db *sql.DB,
 	postID int,
+	editorID int,
+	reason string,
 	title, content string,
 	categories []string,
 	tags string,
@@ -94,6 +113,11 @@ func UpdatePostFull(
 	}
 	defer tx.Rollback()
 
+	// === Keep the original version in the history ===
+	if err := EnsureInitialPostRevision(ctx, tx, postID); err != nil {
+		return err
+	}
+
 	// === Update title and content ===
 	if _, err := tx.ExecContext(ctx, `
 	    UPDATE posts 
@@ -104,11 +128,21 @@ func UpdatePostFull(
 	}
 
 	// === Deleting selected images ===
+	var removedPaths []string
 	for _, imgID := range removeImageIDs {
 		if imgID == 0 {
 			continue
 		}
 		log.Printf("Deleting image ID: %d", imgID)
+		var path string
+		err := tx.QueryRowContext(ctx, "SELECT image_path FROM post_images WHERE id = ? AND post_id = ?", imgID, postID).Scan(&path)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("load image ID %d: %w", imgID, err)
+		}
+		removedPaths = append(removedPaths, path)
 		if _, err := tx.ExecContext(ctx, `
 			DELETE FROM post_images
 			WHERE id = ? AND post_id = ?`,
@@ -117,17 +151,25 @@ func UpdatePostFull(
 		}
 	}
 
-	// === Adding new images ===
-	for i, path := range newImagePaths {
+	// === Adding new images after the existing ones ===
+	var nextOrder int
+	if err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(order_index) + 1, 0) FROM post_images WHERE post_id = ?", postID).Scan(&nextOrder); err != nil {
+		return fmt.Errorf("load image order: %w", err)
+	}
+	for _, path := range newImagePaths {
 		if path == "" {
 			continue
 		}
 		if _, err := tx.ExecContext(ctx, `
-			INSERT INTO post_images (post_id, image_path, order_index)
-			VALUES (?, ?, ?)`,
-			postID, path, i); err != nil {
+			INSERT INTO post_images (post_id, image_path, order_index, thumb_small, thumb_medium)
+			VALUES (?, ?, ?, ?, ?)`,
+			postID, path, nextOrder, ThumbnailPath(path, "sm"), ThumbnailPath(path, "md")); err != nil {
 			return fmt.Errorf("insert image '%s': %w", path, err)
 		}
+		nextOrder++
+	}
+	if err := normalizePostImages(ctx, tx, postID); err != nil {
+		return fmt.Errorf("normalize images: %w", err)
 	}
 
 	// === Update categories ===
@@ -162,15 +204,34 @@ func UpdatePostFull(
 		}
 	}
 
+	// Delete old tags so removed ones don't linger
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM post_tags
+		WHERE post_id = ?`, postID); err != nil {
+		return fmt.Errorf("delete old tags: %w", err)
+	}
+
 	if err := ProcessPostTags(ctx, tx, int64(postID), tagList); err != nil {
 		return fmt.Errorf("processing post tags: %w", err)
 	}
 
+	// === Record the new version ===
+	if err := SavePostRevision(ctx, tx, postID, editorID, reason); err != nil {
+		return err
+	}
+
 	// === Commit all changes ===
 	if err := tx.Commit(); err != nil {
 		return fmt.Errorf("commit transaction: %w", err)
 	}
 
+	// Files of removed images go once no other post or avatar uses them
+	for _, path := range removedPaths {
+		if _, err := ReleaseUpload(ctx, db, path); err != nil {
+			logging.FromContext(ctx).Warn("failed to release upload", "path", path, "err", err)
+		}
+	}
+
 	return nil
 }
 
@@ -191,7 +252,7 @@ func GetCreatedPosts(db *sql.DB, userID int) ([]models.Post, error) {
 	var posts []models.Post
 
 	// First, we get the basic data of the posts
-	query := "SELECT id, title, content, created_at FROM posts WHERE user_id = ?"
+	query := "SELECT id, title, content, created_at FROM posts WHERE user_id = ? AND deleted_at IS NULL"
 	rows, err := db.Query(query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query user posts: %w", err)
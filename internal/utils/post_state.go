@@ -0,0 +1,222 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"time"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+var (
+	// ErrPostLocked is returned when a post no longer accepts comments or reactions
+	ErrPostLocked = errors.New("post is locked")
+	// ErrPostArchived is returned when a post is read-only because it was archived
+	ErrPostArchived = errors.New("post is archived")
+	// ErrPostNotInCategory is returned when pinning a post in a category it is not in
+	ErrPostNotInCategory = errors.New("post is not in category")
+)
+
+// CheckPostOpen reports whether new comments and reactions are allowed on a post.
+// It returns sql.ErrNoRows for missing or deleted posts, ErrPostLocked or ErrPostArchived.
+func CheckPostOpen(db *sql.DB, postID int) error {
+	var locked, archived bool
+	err := db.QueryRow(`
+		SELECT locked_at IS NOT NULL, archived_at IS NOT NULL
+		FROM posts
+		WHERE id = ? AND deleted_at IS NULL`, postID).Scan(&locked, &archived)
+	if err != nil {
+		return err
+	}
+	if archived {
+		return ErrPostArchived
+	}
+	if locked {
+		return ErrPostLocked
+	}
+	return nil
+}
+
+// CheckCommentOpen is CheckPostOpen for the post a comment belongs to
+func CheckCommentOpen(db *sql.DB, commentID int) error {
+	var postID int
+	if err := db.QueryRow("SELECT post_id FROM comments WHERE id = ?", commentID).Scan(&postID); err != nil {
+		return err
+	}
+	return CheckPostOpen(db, postID)
+}
+
+// CheckPostWritable is CheckPostOpen for changes to a post and its comments.
+// Moderators may still change locked and archived threads, members may not.
+func CheckPostWritable(db *sql.DB, user *models.User, postID int) error {
+	err := CheckPostOpen(db, postID)
+	if (errors.Is(err, ErrPostLocked) || errors.Is(err, ErrPostArchived)) && IsModerator(user) {
+		return nil
+	}
+	return err
+}
+
+// PostClosedMessage turns an error from CheckPostOpen into a message for the user
+func PostClosedMessage(err error) string {
+	switch {
+	case errors.Is(err, ErrPostLocked):
+		return "This thread is locked."
+	case errors.Is(err, ErrPostArchived):
+		return "This thread is archived and read-only."
+	case errors.Is(err, sql.ErrNoRows):
+		return "Post not found."
+	default:
+		return "Could not check the thread state."
+	}
+}
+
+// SetPostPinned pins or unpins a post, globally when categoryID is 0 or within one category.
+// A post can only be pinned in a category it is in, or ErrPostNotInCategory is returned.
+func SetPostPinned(ctx context.Context, db *sql.DB, postID, categoryID, moderatorID int, pinned bool) error {
+	var err error
+	if pinned && categoryID != 0 {
+		var inCategory bool
+		err = db.QueryRowContext(ctx, `
+			SELECT EXISTS(SELECT 1 FROM post_categories WHERE post_id = ? AND category_id = ?)`,
+			postID, categoryID).Scan(&inCategory)
+		if err != nil {
+			return fmt.Errorf("check category %d of post %d: %w", categoryID, postID, err)
+		}
+		if !inCategory {
+			return ErrPostNotInCategory
+		}
+	}
+	if pinned {
+		_, err = db.ExecContext(ctx, `
+			INSERT OR IGNORE INTO post_pins (post_id, category_id, pinned_by)
+			VALUES (?, ?, ?)`, postID, categoryID, moderatorID)
+	} else {
+		_, err = db.ExecContext(ctx, "DELETE FROM post_pins WHERE post_id = ? AND category_id = ?", postID, categoryID)
+	}
+	if err != nil {
+		return fmt.Errorf("set pin of post %d in category %d: %w", postID, categoryID, err)
+	}
+	return touchPostState(ctx, db, postID)
+}
+
+// SetPostLocked locks or unlocks a post
+func SetPostLocked(ctx context.Context, db *sql.DB, postID, moderatorID int, locked bool) error {
+	query := "UPDATE posts SET locked_at = NULL, locked_by = NULL, state_changed_at = CURRENT_TIMESTAMP WHERE id = ?"
+	args := []interface{}{postID}
+	if locked {
+		query = "UPDATE posts SET locked_at = CURRENT_TIMESTAMP, locked_by = ?, state_changed_at = CURRENT_TIMESTAMP WHERE id = ?"
+		args = []interface{}{moderatorID, postID}
+	}
+	return execPostState(ctx, db, postID, query, args...)
+}
+
+// SetPostAnnouncement marks a post as an announcement shown in every category
+func SetPostAnnouncement(ctx context.Context, db *sql.DB, postID int, announcement bool) error {
+	return execPostState(ctx, db, postID,
+		"UPDATE posts SET is_announcement = ?, state_changed_at = CURRENT_TIMESTAMP WHERE id = ?",
+		announcement, postID)
+}
+
+// SetPostArchived archives a post or brings it back. Unarchiving counts as
+// activity, so the post is not archived again until it has been idle once more.
+func SetPostArchived(ctx context.Context, db *sql.DB, postID int, archived bool) error {
+	query := "UPDATE posts SET archived_at = NULL, state_changed_at = CURRENT_TIMESTAMP WHERE id = ?"
+	if archived {
+		query = "UPDATE posts SET archived_at = CURRENT_TIMESTAMP, state_changed_at = CURRENT_TIMESTAMP WHERE id = ?"
+	}
+	return execPostState(ctx, db, postID, query, postID)
+}
+
+func execPostState(ctx context.Context, db *sql.DB, postID int, query string, args ...interface{}) error {
+	res, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("update state of post %d: %w", postID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func touchPostState(ctx context.Context, db *sql.DB, postID int) error {
+	return execPostState(ctx, db, postID, "UPDATE posts SET state_changed_at = CURRENT_TIMESTAMP WHERE id = ?", postID)
+}
+
+// GetCategoryPins lists the categories of a post and whether it is pinned in each
+func GetCategoryPins(db *sql.DB, postID int) ([]models.CategoryPin, error) {
+	rows, err := db.Query(`
+		SELECT c.id, c.name,
+		       EXISTS(SELECT 1 FROM post_pins pp WHERE pp.post_id = pc.post_id AND pp.category_id = c.id)
+		FROM post_categories pc
+		JOIN categories c ON c.id = pc.category_id
+		WHERE pc.post_id = ?
+		ORDER BY c.name`, postID)
+	if err != nil {
+		return nil, fmt.Errorf("query category pins of post %d: %w", postID, err)
+	}
+	defer rows.Close()
+
+	var pins []models.CategoryPin
+	for rows.Next() {
+		var pin models.CategoryPin
+		if err := rows.Scan(&pin.ID, &pin.Name, &pin.Pinned); err != nil {
+			return nil, err
+		}
+		pins = append(pins, pin)
+	}
+	return pins, rows.Err()
+}
+
+// ArchiveInactivePosts archives posts whose last comment, edit or state change is
+// older than after. Pinned posts and announcements are never archived automatically.
+func ArchiveInactivePosts(ctx context.Context, db *sql.DB, after time.Duration) (int64, error) {
+	cutoff := fmt.Sprintf("-%d seconds", int64(after.Seconds()))
+	res, err := db.ExecContext(ctx, `
+		UPDATE posts
+		SET archived_at = CURRENT_TIMESTAMP
+		WHERE archived_at IS NULL AND deleted_at IS NULL
+		  AND is_announcement = 0
+		  AND NOT EXISTS (SELECT 1 FROM post_pins pp WHERE pp.post_id = posts.id)
+		  AND MAX(
+		        created_at,
+		        COALESCE(updated_at, created_at),
+		        COALESCE(state_changed_at, created_at),
+		        COALESCE((SELECT MAX(c.created_at) FROM comments c WHERE c.post_id = posts.id), created_at)
+		      ) < datetime('now', ?)`, cutoff)
+	if err != nil {
+		return 0, fmt.Errorf("archive inactive posts: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// RunArchiver archives inactive posts every interval until ctx is cancelled
+func RunArchiver(ctx context.Context, db *sql.DB, after, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := ArchiveInactivePosts(ctx, db, after)
+		if err != nil {
+			logging.FromContext(ctx).Error("archiver failed", "err", err)
+		} else if n > 0 {
+			logging.FromContext(ctx).Info("archived inactive posts", "posts", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SetPostWiki makes a post a wiki post, which members trusted with CapWiki
+// may edit, or makes it the author's alone again
+func SetPostWiki(ctx context.Context, db execer, postID int, wiki bool) error {
+	_, err := db.ExecContext(ctx, "UPDATE posts SET is_wiki = ? WHERE id = ?", wiki, postID)
+	return err
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PreModeration holds content from members who are not trusted yet for a
+// moderator, whatever it contains: their first FirstPosts posts and
+// comments, and posts in Categories, by name. It is a SpamCheck so it runs
+// in the same filter and queue as the spam checks.
+type PreModeration struct {
+	FirstPosts int
+	Categories []string
+}
+
+func (c PreModeration) Check(ctx context.Context, db *sql.DB, s Submission, now time.Time) (SpamFinding, error) {
+	if s.AuthorTrusted {
+		return SpamFinding{}, nil
+	}
+
+	if c.FirstPosts > 0 {
+		var published int
+		err := db.QueryRowContext(ctx, `
+			SELECT (SELECT COUNT(*) FROM posts WHERE user_id = ?1 AND deleted_at IS NULL) +
+			       (SELECT COUNT(*) FROM comments WHERE user_id = ?1 AND deleted_at IS NULL)`, s.UserID).Scan(&published)
+		if err != nil {
+			return SpamFinding{}, fmt.Errorf("count published content of user %d: %w", s.UserID, err)
+		}
+		if published < c.FirstPosts {
+			return SpamFinding{
+				Verdict: SpamHold,
+				Reason:  fmt.Sprintf("new member with %d of %d posts and comments published", published, c.FirstPosts),
+			}, nil
+		}
+	}
+
+	if s.Kind == "post" && len(c.Categories) > 0 && len(s.CategoryIDs) > 0 {
+		args := make([]any, 0, len(s.CategoryIDs)+len(c.Categories))
+		for _, id := range s.CategoryIDs {
+			args = append(args, id)
+		}
+		for _, name := range c.Categories {
+			args = append(args, strings.ToLower(name))
+		}
+		var name string
+		err := db.QueryRowContext(ctx, `
+			SELECT name FROM categories
+			WHERE id IN (?`+strings.Repeat(",?", len(s.CategoryIDs)-1)+`)
+			  AND LOWER(name) IN (?`+strings.Repeat(",?", len(c.Categories)-1)+`)
+			LIMIT 1`, args...).Scan(&name)
+		if err == nil {
+			return SpamFinding{Verdict: SpamHold, Reason: fmt.Sprintf("posted in pre-moderated category %s", name)}, nil
+		}
+		if err != sql.ErrNoRows {
+			return SpamFinding{}, fmt.Errorf("check pre-moderated categories: %w", err)
+		}
+	}
+	return SpamFinding{}, nil
+}
+
+// PromoteIfTrusted marks a member as trusted once at least after of their
+// held submissions were approved; 0 never promotes. It reports whether the
+// member was promoted just now.
+func PromoteIfTrusted(ctx context.Context, db *sql.DB, userID, after int) (bool, error) {
+	if after <= 0 {
+		return false, nil
+	}
+	res, err := db.ExecContext(ctx, `
+		UPDATE users SET trusted_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND trusted_at IS NULL
+		  AND (SELECT COUNT(*) FROM held_content WHERE user_id = ? AND status = ?) >= ?`,
+		userID, userID, HeldApproved, after)
+	if err != nil {
+		return false, fmt.Errorf("promote user %d: %w", userID, err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -43,7 +43,7 @@ func GetLikedPosts(db *sql.DB, userID int) ([]models.LikedPosts, error) {
        SELECT p.id, p.title
         FROM posts p
         JOIN likes l ON p.id = l.post_id
-        WHERE l.user_id = ? AND l.reaction = 'Like'`
+        WHERE l.user_id = ? AND l.reaction = 'Like' AND p.deleted_at IS NULL`
 	rows, err := db.Query(query, userID)
 	if err != nil {
 		return nil, err
@@ -70,7 +70,7 @@ func GetDislikes(db *sql.DB, userID int) ([]models.DislikePosts, error) {
         SELECT p.id, p.title
         FROM posts p
         JOIN likes l ON p.id = l.post_id
-        WHERE l.user_id = ? AND l.reaction = 'Dislike'`
+        WHERE l.user_id = ? AND l.reaction = 'Dislike' AND p.deleted_at IS NULL`
 	rows, err := db.Query(query, userID)
 	if err != nil {
 		return nil, err
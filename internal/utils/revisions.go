@@ -0,0 +1,302 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum/internal/models"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// EnsureInitialPostRevision stores the original version of a post the first
+// time it is edited, so the history always starts with what the author wrote.
+func EnsureInitialPostRevision(ctx context.Context, tx *sql.Tx, postID int) error {
+	var count int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM post_revisions WHERE post_id = ?", postID).Scan(&count); err != nil {
+		return fmt.Errorf("count post revisions: %w", err)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	tags, categories, err := postSnapshotLists(ctx, tx, postID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO post_revisions (post_id, editor_id, title, content, tags, categories, reason, created_at)
+		SELECT id, user_id, title, content, ?, ?, 'Original', created_at
+		FROM posts WHERE id = ?`,
+		tags, categories, postID); err != nil {
+		return fmt.Errorf("insert initial post revision: %w", err)
+	}
+	return nil
+}
+
+// SavePostRevision records the current state of the post as a new revision
+func SavePostRevision(ctx context.Context, tx *sql.Tx, postID, editorID int, reason string) error {
+	tags, categories, err := postSnapshotLists(ctx, tx, postID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO post_revisions (post_id, editor_id, title, content, tags, categories, reason)
+		SELECT id, ?, title, content, ?, ?, ?
+		FROM posts WHERE id = ?`,
+		editorID, tags, categories, strings.TrimSpace(reason), postID); err != nil {
+		return fmt.Errorf("insert post revision: %w", err)
+	}
+	return nil
+}
+
+// postSnapshotLists returns the post tags and category IDs as comma separated strings
+func postSnapshotLists(ctx context.Context, tx *sql.Tx, postID int) (string, string, error) {
+	var tags, categories sql.NullString
+	err := tx.QueryRowContext(ctx, `
+		SELECT
+			(SELECT GROUP_CONCAT(t.name, ', ') FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE pt.post_id = ?),
+			(SELECT GROUP_CONCAT(category_id, ',') FROM post_categories WHERE post_id = ?)`,
+		postID, postID).Scan(&tags, &categories)
+	if err != nil {
+		return "", "", fmt.Errorf("snapshot tags/categories: %w", err)
+	}
+	return tags.String, categories.String, nil
+}
+
+// GetPostRevisions returns every stored revision of a post, oldest first, and the post author ID
+func GetPostRevisions(db *sql.DB, postID int) ([]models.Revision, int, error) {
+	var authorID int
+	if err := db.QueryRow("SELECT user_id FROM posts WHERE id = ?", postID).Scan(&authorID); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT r.id, r.editor_id, u.username, u.role, r.title, r.content, r.tags, r.categories, r.reason, r.created_at
+		FROM post_revisions r
+		JOIN users u ON u.id = r.editor_id
+		WHERE r.post_id = ?
+		ORDER BY r.id ASC`, postID)
+	if err != nil {
+		return nil, 0, fmt.Errorf("query post revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []models.Revision
+	for rows.Next() {
+		var rev models.Revision
+		var createdAt time.Time
+		if err := rows.Scan(&rev.ID, &rev.EditorID, &rev.EditorName, &rev.EditorRole, &rev.Title, &rev.Content,
+			&rev.Tags, &rev.Categories, &rev.Reason, &createdAt); err != nil {
+			return nil, 0, fmt.Errorf("scan post revision: %w", err)
+		}
+		rev.Number = len(revisions) + 1
+		rev.CreatedAt = FormatDate(createdAt)
+		rev.IsModeratorEdit = rev.EditorID != authorID
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return revisions, authorID, nil
+}
+
+// RollbackPostToRevision restores title, content, tags and categories from a
+// stored revision and records the rollback itself as a new revision.
+func RollbackPostToRevision(ctx context.Context, db *sql.DB, postID, revisionID, moderatorID int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var title, content, tags, categories string
+	err = tx.QueryRowContext(ctx, `
+		SELECT title, content, tags, categories
+		FROM post_revisions
+		WHERE id = ? AND post_id = ?`, revisionID, postID).Scan(&title, &content, &tags, &categories)
+	if err != nil {
+		return fmt.Errorf("load revision %d: %w", revisionID, err)
+	}
+
+	var number int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM post_revisions WHERE post_id = ? AND id <= ?", postID, revisionID).Scan(&number); err != nil {
+		return fmt.Errorf("revision number: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE posts SET title = ?, content = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, title, content, postID); err != nil {
+		return fmt.Errorf("restore title/content: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_categories WHERE post_id = ?", postID); err != nil {
+		return fmt.Errorf("delete categories: %w", err)
+	}
+	for _, idStr := range strings.Split(categories, ",") {
+		idStr = strings.TrimSpace(idStr)
+		if idStr == "" {
+			continue
+		}
+		catID, err := strconv.Atoi(idStr)
+		if err != nil {
+			return fmt.Errorf("invalid category ID '%s' in revision: %w", idStr, err)
+		}
+		// The category may have been removed by an admin since this revision
+		if _, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO post_categories (post_id, category_id)
+			SELECT ?, id FROM categories WHERE id = ?`, postID, catID); err != nil {
+			return fmt.Errorf("restore category %d: %w", catID, err)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_tags WHERE post_id = ?", postID); err != nil {
+		return fmt.Errorf("delete tags: %w", err)
+	}
+	if err := ProcessPostTags(ctx, tx, int64(postID), strings.Split(tags, ",")); err != nil {
+		return fmt.Errorf("restore tags: %w", err)
+	}
+
+	if err := SavePostRevision(ctx, tx, postID, moderatorID, fmt.Sprintf("Rolled back to revision #%d", number)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EnsureInitialCommentRevision stores the original comment text before its first edit
+func EnsureInitialCommentRevision(ctx context.Context, tx *sql.Tx, commentID int) error {
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO comment_revisions (comment_id, editor_id, content, reason, created_at)
+		SELECT id, user_id, content, 'Original', created_at
+		FROM comments
+		WHERE id = ? AND NOT EXISTS (SELECT 1 FROM comment_revisions WHERE comment_id = ?)`,
+		commentID, commentID); err != nil {
+		return fmt.Errorf("insert initial comment revision: %w", err)
+	}
+	return nil
+}
+
+// UpdateCommentContent changes the comment text and records the edit as a revision
+func UpdateCommentContent(ctx context.Context, db *sql.DB, commentID, editorID int, content, reason string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := updateCommentContent(ctx, tx, commentID, editorID, content, reason); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func updateCommentContent(ctx context.Context, tx *sql.Tx, commentID, editorID int, content, reason string) error {
+	if err := EnsureInitialCommentRevision(ctx, tx, commentID); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE comments SET content = ? WHERE id = ?", content, commentID); err != nil {
+		return fmt.Errorf("update comment: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO comment_revisions (comment_id, editor_id, content, reason)
+		VALUES (?, ?, ?, ?)`, commentID, editorID, content, strings.TrimSpace(reason)); err != nil {
+		return fmt.Errorf("insert comment revision: %w", err)
+	}
+	return nil
+}
+
+// GetCommentRevisions returns every stored revision of a comment, oldest first,
+// together with the comment author and post IDs
+func GetCommentRevisions(db *sql.DB, commentID int) ([]models.Revision, int, int, error) {
+	var authorID, postID int
+	if err := db.QueryRow("SELECT user_id, post_id FROM comments WHERE id = ?", commentID).Scan(&authorID, &postID); err != nil {
+		return nil, 0, 0, err
+	}
+
+	rows, err := db.Query(`
+		SELECT r.id, r.editor_id, u.username, u.role, r.content, r.reason, r.created_at
+		FROM comment_revisions r
+		JOIN users u ON u.id = r.editor_id
+		WHERE r.comment_id = ?
+		ORDER BY r.id ASC`, commentID)
+	if err != nil {
+		return nil, 0, 0, fmt.Errorf("query comment revisions: %w", err)
+	}
+	defer rows.Close()
+
+	var revisions []models.Revision
+	for rows.Next() {
+		var rev models.Revision
+		var createdAt time.Time
+		if err := rows.Scan(&rev.ID, &rev.EditorID, &rev.EditorName, &rev.EditorRole, &rev.Content, &rev.Reason, &createdAt); err != nil {
+			return nil, 0, 0, fmt.Errorf("scan comment revision: %w", err)
+		}
+		rev.Number = len(revisions) + 1
+		rev.CreatedAt = FormatDate(createdAt)
+		rev.IsModeratorEdit = rev.EditorID != authorID
+		revisions = append(revisions, rev)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, 0, err
+	}
+
+	return revisions, authorID, postID, nil
+}
+
+// RollbackCommentToRevision restores the comment text from a stored revision
+func RollbackCommentToRevision(ctx context.Context, db *sql.DB, commentID, revisionID, moderatorID int) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var content string
+	var number int
+	err = tx.QueryRowContext(ctx, `
+		SELECT content,
+		       (SELECT COUNT(*) FROM comment_revisions WHERE comment_id = ? AND id <= r.id)
+		FROM comment_revisions r
+		WHERE id = ? AND comment_id = ?`, commentID, revisionID, commentID).Scan(&content, &number)
+	if err != nil {
+		return fmt.Errorf("load revision %d: %w", revisionID, err)
+	}
+
+	if err := updateCommentContent(ctx, tx, commentID, moderatorID, content, fmt.Sprintf("Rolled back to revision #%d", number)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// BuildRevisionViews pairs each revision with a diff against the one before it.
+// The result is newest first, the way the history page shows it.
+func BuildRevisionViews(revisions []models.Revision, mode string) []models.RevisionView {
+	diff := DiffLines
+	if mode == "words" {
+		diff = DiffWords
+	}
+
+	views := make([]models.RevisionView, len(revisions))
+	for i, rev := range revisions {
+		var prev models.Revision
+		if i > 0 {
+			prev = revisions[i-1]
+		}
+		view := models.RevisionView{Revision: rev, IsCurrent: i == len(revisions)-1}
+		if rev.Title != "" || prev.Title != "" {
+			view.TitleDiff = DiffWords(prev.Title, rev.Title)
+		}
+		view.ContentDiff = diff(prev.Content, rev.Content)
+		views[len(revisions)-1-i] = view
+	}
+	return views
+}
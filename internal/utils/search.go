@@ -15,8 +15,9 @@ func SearchPosts(db *sql.DB, query string) ([]models.PostView, error) {
 		SELECT p.id, p.user_id, u.username, p.title, p.content, p.created_at
 		FROM posts p
 		JOIN users u ON p.user_id = u.id
-		WHERE LOWER(p.title) LIKE LOWER(?)
-		   OR LOWER(p.content) LIKE LOWER(?)
+		WHERE p.deleted_at IS NULL
+		  AND (LOWER(p.title) LIKE LOWER(?)
+		   OR LOWER(p.content) LIKE LOWER(?))
 	`, likeQuery, likeQuery)
 	if err != nil {
 		return nil, err
@@ -19,7 +19,7 @@ func SearchUserActivity(db *sql.DB, userID int, query, activityType string) (mod
             SELECT p.id, p.title, p.content, p.created_at, u.username
             FROM posts p
             JOIN users u ON p.user_id = u.id
-            WHERE p.user_id = ? AND (LOWER(p.title) LIKE ? OR LOWER(p.content) LIKE ?)
+            WHERE p.user_id = ? AND p.deleted_at IS NULL AND (LOWER(p.title) LIKE ? OR LOWER(p.content) LIKE ?)
         `, userID, likeQuery, likeQuery)
 		if err != nil {
 			return results, fmt.Errorf("error searching posts: %w", err)
@@ -57,7 +57,7 @@ func SearchUserActivity(db *sql.DB, userID int, query, activityType string) (mod
             FROM comments c
             JOIN posts p ON p.id = c.post_id
             JOIN users u ON u.id = c.user_id
-            WHERE c.user_id = ? AND LOWER(c.content) LIKE ?
+            WHERE c.user_id = ? AND c.deleted_at IS NULL AND p.deleted_at IS NULL AND LOWER(c.content) LIKE ?
         `, userID, likeQuery)
 		if err != nil {
 			return results, fmt.Errorf("error searching comments: %w", err)
@@ -96,7 +96,7 @@ func SearchUserActivity(db *sql.DB, userID int, query, activityType string) (mod
             FROM likes l
             JOIN posts p ON p.id = l.post_id
             JOIN users u ON p.user_id = u.id
-            WHERE l.user_id = ? AND (LOWER(p.title) LIKE ? OR LOWER(p.content) LIKE ?)
+            WHERE l.user_id = ? AND p.deleted_at IS NULL AND (LOWER(p.title) LIKE ? OR LOWER(p.content) LIKE ?)
         `, userID, likeQuery, likeQuery)
 		if err != nil {
 			return results, fmt.Errorf("error searching likes: %w", err)
@@ -3,11 +3,12 @@ package utils
 import (
 	"database/sql"
 	"fmt"
+	"forum/internal/logging"
+	"forum/internal/metrics"
 	"forum/internal/models"
 
 	"forum/internal/security"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
-	"log"
 	"net/http"
 	"strings"
 	"time"
@@ -31,7 +32,8 @@ func GetUserFromSession(w http.ResponseWriter, r *http.Request, db *sql.DB) (*mo
 	var createdAt time.Time
 	var avatarURL sql.NullString // Use NullString to handle NULL
 	err := db.QueryRow(`
-        SELECT id, username, email, created_at, role, avatar_url 
+        SELECT id, username, email, created_at, role, avatar_url,
+               COALESCE(reputation, 0), COALESCE(trust_level, 0)
         FROM users 
         WHERE id = ?
     `, userID).Scan(
@@ -41,6 +43,8 @@ func GetUserFromSession(w http.ResponseWriter, r *http.Request, db *sql.DB) (*mo
 		&createdAt,
 		&user.Role,
 		&avatarURL,
+		&user.Reputation,
+		&user.TrustLevel,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("user query error: %v", err)
@@ -56,28 +60,29 @@ func GetUserFromSession(w http.ResponseWriter, r *http.Request, db *sql.DB) (*mo
 	user.CreatedAt = FormatDate(createdAt)
 
 	// Get additional data
+	logger := logging.FromContext(r.Context())
 	user.CreatedPosts, err = GetCreatedPosts(db, user.ID)
 	if err != nil {
-		log.Printf("Warning: failed to get created posts: %v", err)
+		logger.Warn("failed to get created posts", "err", err)
 	}
 
 	user.LikedPosts, err = GetLikedPosts(db, user.ID)
 	if err != nil {
-		log.Printf("Warning: failed to get liked posts: %v", err)
+		logger.Warn("failed to get liked posts", "err", err)
 	}
 
 	user.DislikePosts, err = GetDislikes(db, user.ID)
 	if err != nil {
-		log.Printf("Warning: failed to get dislikes: %v", err)
+		logger.Warn("failed to get dislikes", "err", err)
 	}
-	log.Printf("User in GetUserFromSession: %v", user)
 	return &user, nil
 }
 
 // getAllUsers gets all users from the database
 func GetAllUsers(db *sql.DB) ([]models.User, error) {
 	rows, err := db.Query(`
-		SELECT id, username, email, password, created_at, role, banned
+		SELECT id, username, email, password, created_at, role, banned,
+		       COALESCE(registration_ip, ''), COALESCE(last_login_ip, '')
 		FROM users
 	`)
 	if err != nil {
@@ -88,7 +93,7 @@ func GetAllUsers(db *sql.DB) ([]models.User, error) {
 	var users []models.User
 	for rows.Next() {
 		var u models.User
-		err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.Role, &u.Banned)
+		err := rows.Scan(&u.ID, &u.Username, &u.Email, &u.PasswordHash, &u.CreatedAt, &u.Role, &u.Banned, &u.RegistrationIP, &u.LastLoginIP)
 		if err != nil {
 			return nil, err
 		}
@@ -121,9 +126,15 @@ func CheckUserExists(db *sql.DB, email string) (bool, models.NewUser, error) {
 }
 
 func ValidateAndLoginUser(w http.ResponseWriter, r *http.Request, db *sql.DB, creds LoginCredentials, oauthMarker bool) {
+	method := "password"
+	if oauthMarker {
+		method = "oauth"
+	}
+
 	// Валідатор користувача за email
 	user, err := ValidateUserForLogin(db, creds.Email)
 	if err != nil {
+		metrics.Logins.With(method, "failure").Inc()
 		switch err.Error() {
 		case "invalid credentials":
 			RespondWithError(w, http.StatusUnauthorized, "Invalid email or password")
@@ -141,20 +152,23 @@ func ValidateAndLoginUser(w http.ResponseWriter, r *http.Request, db *sql.DB, cr
 	// Suppose the user model has an OauthMarker bool field
 	if !oauthMarker { // If this is NOT an OAuth user, check the password
 		if !security.CheckPasswordHash(creds.Password, user.PasswordHash) {
+			metrics.Logins.With(method, "failure").Inc()
 			RespondWithError(w, http.StatusUnauthorized, "Invalid email or password check")
 			return
 		}
-	} else {
-		// If OAuth user, skip password verification
-		log.Printf("OAuth user detected, skip password verification for email: %s", user.Email)
 	}
-	log.Printf("SessionValidateAndLoginUser user %d:", user.ID)
+	logger := logging.FromContext(r.Context()).With("user_id", user.ID)
 	// Create a session
 	if err := security.CreateSession(w, user.ID, db); err != nil {
-		log.Printf("Session creation error for user %d: %v", user.ID, err)
+		logger.Error("failed to create session", "err", err)
 		http.Error(w, "Failed to create session", http.StatusInternalServerError)
 		return
 	}
+	if _, err := db.Exec("UPDATE users SET last_login_ip = ? WHERE id = ?", RequestIP(r), user.ID); err != nil {
+		logger.Warn("failed to record login IP", "err", err)
+	}
+	logger.Info("user logged in", "oauth", oauthMarker)
+	metrics.Logins.With(method, "success").Inc()
 
 	if oauthMarker {
 		// ⬇️ we use DelayedRedirect
@@ -0,0 +1,461 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/metrics"
+	"forum/internal/models"
+	"math"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// SpamVerdict is what the spam filter decides about new content; a higher
+// verdict is stricter
+type SpamVerdict int
+
+const (
+	SpamAllow SpamVerdict = iota
+	SpamHold
+	SpamReject
+)
+
+func (v SpamVerdict) String() string {
+	switch v {
+	case SpamHold:
+		return "hold"
+	case SpamReject:
+		return "reject"
+	default:
+		return "allow"
+	}
+}
+
+// What authors are told; the reasons are only shown to moderators, so they
+// do not teach spammers what to avoid
+const (
+	SpamHeldMessage     = "Your submission is awaiting review by a moderator and will appear once it is approved."
+	SpamRejectedMessage = "Your submission looks like spam and was not published."
+)
+
+// Submission is a post or comment about to be created
+type Submission struct {
+	Kind            string // post or comment
+	UserID          int
+	PostID          int // post a comment is for
+	ParentCommentID int
+	Title           string
+	Content         string
+	CategoryIDs     []int // categories of a post
+
+	// Filled in by CheckSpam from the author's account
+	AuthorRole    string
+	AuthorSince   time.Time
+	AuthorTrusted bool // promoted after enough approved submissions
+}
+
+// Text is everything readers would see of the submission
+func (s Submission) Text() string {
+	return strings.TrimSpace(s.Title + "\n" + s.Content)
+}
+
+// SpamFinding is one check's opinion of a submission
+type SpamFinding struct {
+	Verdict SpamVerdict
+	Reason  string
+	// Score is the classifier's spam probability when Scored
+	Score  float64
+	Scored bool
+}
+
+// SpamCheck inspects submissions before they are created. A check with
+// nothing to object returns SpamAllow.
+type SpamCheck interface {
+	Check(ctx context.Context, db *sql.DB, s Submission, now time.Time) (SpamFinding, error)
+}
+
+// SpamResult combines the findings of every check: the strictest verdict
+// wins and the reasons of all objections are kept for moderators
+type SpamResult struct {
+	Verdict SpamVerdict
+	Reasons []string
+	Score   float64
+	Scored  bool
+}
+
+// SpamFilter runs checks in order
+type SpamFilter struct {
+	checks []SpamCheck
+}
+
+func NewSpamFilter(checks ...SpamCheck) *SpamFilter {
+	return &SpamFilter{checks: checks}
+}
+
+// Check runs every check on s. A check that fails is logged and skipped;
+// like the rate limiter, the filter lets content through when it cannot
+// decide.
+func (f *SpamFilter) Check(ctx context.Context, db *sql.DB, s Submission, now time.Time) SpamResult {
+	var result SpamResult
+	for _, check := range f.checks {
+		finding, err := check.Check(ctx, db, s, now)
+		if err != nil {
+			logging.FromContext(ctx).Error("spam check failed", "check", fmt.Sprintf("%T", check), "err", err)
+			continue
+		}
+		if finding.Scored {
+			result.Score, result.Scored = finding.Score, true
+		}
+		if finding.Verdict == SpamAllow {
+			continue
+		}
+		result.Verdict = max(result.Verdict, finding.Verdict)
+		if finding.Reason != "" {
+			result.Reasons = append(result.Reasons, finding.Reason)
+		}
+	}
+	return result
+}
+
+var (
+	spamMu     sync.Mutex
+	spamFilter *SpamFilter
+)
+
+// SetSpamFilter changes the filter new content passes; nil turns it off
+func SetSpamFilter(f *SpamFilter) {
+	spamMu.Lock()
+	defer spamMu.Unlock()
+	spamFilter = f
+}
+
+// CheckSpam runs the configured filter, spam checks and pre-moderation, on
+// a submission. Content from moderators is always allowed.
+func CheckSpam(ctx context.Context, db *sql.DB, s Submission) SpamResult {
+	spamMu.Lock()
+	f := spamFilter
+	spamMu.Unlock()
+	if f == nil {
+		return SpamResult{}
+	}
+
+	var since sql.NullTime
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(role, 'user'), created_at, trusted_at IS NOT NULL FROM users WHERE id = ?", s.UserID).Scan(&s.AuthorRole, &since, &s.AuthorTrusted)
+	if err != nil {
+		logging.FromContext(ctx).Error("spam check could not load user", "user_id", s.UserID, "err", err)
+		return SpamResult{}
+	}
+	if s.AuthorRole == "moderator" || s.AuthorRole == "admin" {
+		return SpamResult{}
+	}
+	s.AuthorSince = since.Time
+
+	result := f.Check(ctx, db, s, time.Now())
+	metrics.SpamVerdicts.With(s.Kind, result.Verdict.String()).Inc()
+	return result
+}
+
+// linkPattern finds links in plain text
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)[^\s<>"']+`)
+
+// CountLinks counts the links in text
+func CountLinks(text string) int {
+	return len(linkPattern.FindAllStringIndex(text, -1))
+}
+
+// LinkLimit holds content from accounts younger than NewAccountAge with more
+// than MaxLinks links
+type LinkLimit struct {
+	NewAccountAge time.Duration
+	MaxLinks      int
+}
+
+func (c LinkLimit) Check(ctx context.Context, db *sql.DB, s Submission, now time.Time) (SpamFinding, error) {
+	if now.Sub(s.AuthorSince) >= c.NewAccountAge {
+		return SpamFinding{}, nil
+	}
+	if n := CountLinks(s.Text()); n > c.MaxLinks {
+		return SpamFinding{
+			Verdict: SpamHold,
+			Reason:  fmt.Sprintf("%d links from an account created %s", n, FormatDate(s.AuthorSince)),
+		}, nil
+	}
+	return SpamFinding{}, nil
+}
+
+// normalizeContent makes copies that differ only in case, spacing or
+// punctuation compare equal
+func normalizeContent(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	return strings.Join(words, " ")
+}
+
+// DuplicateContent holds content repeating a post, comment or held
+// submission from the last Window. Texts shorter than MinLength, such as
+// "Thanks!", are left alone.
+type DuplicateContent struct {
+	Window    time.Duration
+	MinLength int
+}
+
+// duplicateScanLimit caps how much recent content is compared
+const duplicateScanLimit = 2000
+
+func (c DuplicateContent) Check(ctx context.Context, db *sql.DB, s Submission, now time.Time) (SpamFinding, error) {
+	content := normalizeContent(s.Content)
+	if len(content) < c.MinLength {
+		return SpamFinding{}, nil
+	}
+
+	since := now.Add(-c.Window).Unix()
+	rows, err := db.QueryContext(ctx, `
+		SELECT content FROM (
+			SELECT content, created_at FROM posts
+			WHERE created_at >= datetime(?1, 'unixepoch') AND deleted_at IS NULL
+			UNION ALL
+			SELECT content, created_at FROM comments
+			WHERE created_at >= datetime(?1, 'unixepoch') AND deleted_at IS NULL
+			UNION ALL
+			SELECT content, created_at FROM held_content
+			WHERE created_at >= datetime(?1, 'unixepoch') AND status = 'pending'
+		)
+		ORDER BY created_at DESC
+		LIMIT ?2`, since, duplicateScanLimit)
+	if err != nil {
+		return SpamFinding{}, fmt.Errorf("query recent content: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var other string
+		if err := rows.Scan(&other); err != nil {
+			return SpamFinding{}, fmt.Errorf("scan recent content: %w", err)
+		}
+		if normalizeContent(other) == content {
+			return SpamFinding{
+				Verdict: SpamHold,
+				Reason:  "repeats a recent post or comment",
+			}, nil
+		}
+	}
+	return SpamFinding{}, rows.Err()
+}
+
+// BannedContent objects to content containing a banned word or phrase, or
+// matching a banned pattern
+type BannedContent struct {
+	words    *regexp.Regexp
+	patterns []*regexp.Regexp
+	verdict  SpamVerdict
+}
+
+// NewBannedContent matches words as whole words, ignoring case; patterns
+// are used as they are
+func NewBannedContent(words []string, patterns []*regexp.Regexp, verdict SpamVerdict) *BannedContent {
+	c := &BannedContent{patterns: patterns, verdict: verdict}
+	if len(words) > 0 {
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			quoted[i] = regexp.QuoteMeta(strings.ToLower(w))
+		}
+		c.words = regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)(?:$|[^\p{L}\p{N}_])`)
+	}
+	return c
+}
+
+func (c *BannedContent) Check(ctx context.Context, db *sql.DB, s Submission, now time.Time) (SpamFinding, error) {
+	text := s.Text()
+	if c.words != nil {
+		if m := c.words.FindStringSubmatch(text); m != nil {
+			return SpamFinding{Verdict: c.verdict, Reason: fmt.Sprintf("contains banned word %q", m[1])}, nil
+		}
+	}
+	for _, p := range c.patterns {
+		if p.MatchString(text) {
+			return SpamFinding{Verdict: c.verdict, Reason: fmt.Sprintf("matches banned pattern %s", p)}, nil
+		}
+	}
+	return SpamFinding{}, nil
+}
+
+// SpamClassifier is a naive Bayes classifier trained by moderators'
+// decisions on held content. It stays silent until it learned from
+// MinTraining decisions of each kind, then holds content at least Hold
+// likely to be spam and rejects it from Reject; 0 never rejects.
+type SpamClassifier struct {
+	MinTraining int
+	Hold        float64
+	Reject      float64
+}
+
+func (c SpamClassifier) Check(ctx context.Context, db *sql.DB, s Submission, now time.Time) (SpamFinding, error) {
+	training, err := GetSpamTraining(ctx, db)
+	if err != nil {
+		return SpamFinding{}, err
+	}
+	if training.Spam < c.MinTraining || training.Ham < c.MinTraining || training.Spam == 0 || training.Ham == 0 {
+		return SpamFinding{}, nil
+	}
+
+	p, err := SpamProbability(ctx, db, s.Text())
+	if err != nil {
+		return SpamFinding{}, err
+	}
+	finding := SpamFinding{Score: p, Scored: true}
+	switch {
+	case c.Reject > 0 && p >= c.Reject:
+		finding.Verdict = SpamReject
+	case p >= c.Hold:
+		finding.Verdict = SpamHold
+	default:
+		return finding, nil
+	}
+	finding.Reason = fmt.Sprintf("classifier rates it %.1f%% likely spam", p*100)
+	return finding, nil
+}
+
+// maxSpamTokens caps the distinct tokens taken from one text
+const maxSpamTokens = 500
+
+// SpamTokens splits text into the distinct tokens the classifier counts:
+// lower-case words, and the host of every link as "link:host"
+func SpamTokens(text string) []string {
+	seen := make(map[string]bool)
+	var tokens []string
+	add := func(t string) {
+		if len(tokens) < maxSpamTokens && !seen[t] {
+			seen[t] = true
+			tokens = append(tokens, t)
+		}
+	}
+
+	for _, link := range linkPattern.FindAllString(text, -1) {
+		if !strings.Contains(link, "://") {
+			link = "http://" + link
+		}
+		if u, err := url.Parse(link); err == nil && u.Hostname() != "" {
+			add("link:" + strings.TrimPrefix(strings.ToLower(u.Hostname()), "www."))
+		}
+	}
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if n := len(word); n >= 2 && n <= 40 {
+			add(word)
+		}
+	}
+	return tokens
+}
+
+// Classes the classifier learns
+const (
+	spamClass = "spam"
+	hamClass  = "ham"
+)
+
+// TrainSpam teaches the classifier that text is spam, or legitimate when
+// spam is false
+func TrainSpam(ctx context.Context, db *sql.DB, text string, spam bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	class, spamInc, hamInc := hamClass, 0, 1
+	if spam {
+		class, spamInc, hamInc = spamClass, 1, 0
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO spam_tokens (token, spam, ham) VALUES (?, ?, ?)
+		ON CONFLICT(token) DO UPDATE SET spam = spam + excluded.spam, ham = ham + excluded.ham`)
+	if err != nil {
+		return fmt.Errorf("prepare token update: %w", err)
+	}
+	defer stmt.Close()
+	for _, token := range SpamTokens(text) {
+		if _, err := stmt.ExecContext(ctx, token, spamInc, hamInc); err != nil {
+			return fmt.Errorf("count token %q: %w", token, err)
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO spam_training (class, documents) VALUES (?, 1)
+		ON CONFLICT(class) DO UPDATE SET documents = documents + 1`, class); err != nil {
+		return fmt.Errorf("count %s document: %w", class, err)
+	}
+	return tx.Commit()
+}
+
+// GetSpamTraining returns how many spam and legitimate texts the classifier
+// learned from
+func GetSpamTraining(ctx context.Context, db *sql.DB) (models.SpamTraining, error) {
+	var t models.SpamTraining
+	rows, err := db.QueryContext(ctx, "SELECT class, documents FROM spam_training")
+	if err != nil {
+		return t, fmt.Errorf("query spam training: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var class string
+		var n int
+		if err := rows.Scan(&class, &n); err != nil {
+			return t, err
+		}
+		switch class {
+		case spamClass:
+			t.Spam = n
+		case hamClass:
+			t.Ham = n
+		}
+	}
+	return t, rows.Err()
+}
+
+// SpamProbability estimates how likely text is spam from the tokens it
+// contains. Tokens the classifier never saw are ignored, and both classes
+// are assumed equally likely up front so an unbalanced training set does
+// not tip every verdict.
+func SpamProbability(ctx context.Context, db *sql.DB, text string) (float64, error) {
+	training, err := GetSpamTraining(ctx, db)
+	if err != nil {
+		return 0, err
+	}
+	tokens := SpamTokens(text)
+	if len(tokens) == 0 {
+		return 0.5, nil
+	}
+
+	args := make([]any, len(tokens))
+	for i, t := range tokens {
+		args[i] = t
+	}
+	rows, err := db.QueryContext(ctx,
+		"SELECT spam, ham FROM spam_tokens WHERE token IN (?"+strings.Repeat(",?", len(tokens)-1)+")", args...)
+	if err != nil {
+		return 0, fmt.Errorf("query spam tokens: %w", err)
+	}
+	defer rows.Close()
+
+	// Log odds of spam, with Laplace smoothing of each token's frequency
+	var logOdds float64
+	spamDocs, hamDocs := float64(training.Spam), float64(training.Ham)
+	for rows.Next() {
+		var spam, ham float64
+		if err := rows.Scan(&spam, &ham); err != nil {
+			return 0, err
+		}
+		logOdds += math.Log((spam+1)/(spamDocs+2)) - math.Log((ham+1)/(hamDocs+2))
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	return 1 / (1 + math.Exp(-logOdds)), nil
+}
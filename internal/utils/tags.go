@@ -161,3 +161,24 @@ func ProcessPostTags(ctx context.Context, tx *sql.Tx, postID int64, tags []strin
 	log.Printf("Added %d tags to post %d", len(tags), postID)
 	return nil
 }
+
+// NewTags returns the tags in names that do not exist yet, ignoring blanks
+func NewTags(ctx context.Context, db *sql.DB, names []string) ([]string, error) {
+	var missing []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM tags WHERE name = ?)", name).Scan(&exists); err != nil {
+			return nil, fmt.Errorf("look up tag %q: %w", name, err)
+		}
+		if !exists {
+			missing = append(missing, name)
+		}
+	}
+	return missing, nil
+}
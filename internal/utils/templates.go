@@ -0,0 +1,257 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	apperrors "forum/internal"
+	"forum/internal/logging"
+	"forum/internal/security"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// nonceMarker stands in for the CSP nonce in rendered pages. Templates are
+// parsed once and shared, so Render swaps in the nonce of each request. It is
+// random, so content cannot carry it, and escapes to itself.
+var nonceMarker = "csp-nonce-" + security.NewNonce()
+
+// TemplateFuncs are the functions every page template may call. cspNonce
+// writes a marker that Render replaces with the request's nonce.
+func TemplateFuncs() template.FuncMap {
+	funcs := template.FuncMap{
+		"cspNonce":       func() string { return nonceMarker },
+		"formatDate":     FormatDate,
+		"join":           strings.Join,
+		"containsString": slices.Contains[[]string],
+	}
+	for name, fn := range AvatarFuncs() {
+		funcs[name] = fn
+	}
+	for name, fn := range TrustFuncs() {
+		funcs[name] = fn
+	}
+	return funcs
+}
+
+// Layouts are the files pages are built on, by layout: the layout itself
+// and the partials it includes
+var Layouts = map[string][]string{
+	"main":  {"layout.html", "header.html", "nav.html", "notifications.html"},
+	"auth":  {"layout_auth.html", "header_auth.html"},
+	"admin": {"layout_admin.html", "header_auth.html", "nav_admin.html"},
+}
+
+// PageSpec is a page: its layout and the files defining its content. Later
+// files override templates of the same name in earlier ones.
+type PageSpec struct {
+	Layout string
+	Files  []string
+}
+
+// Pages are the pages handlers Render, by name
+var Pages = map[string]PageSpec{
+	"home":                    {"main", []string{"index.html", "post_list.html", "post_list_item.html", "filters.html"}},
+	"user_page":               {"main", []string{"user_page.html", "post_list.html", "post_list_item.html", "filters.html", "held_list.html", "user_badges.html"}},
+	"badges":                  {"main", []string{"badges.html"}},
+	"filters":                 {"main", []string{"filters_page.html", "filters.html", "post_list_item.html", "post_list_filter.html"}},
+	"search_results":          {"main", []string{"search_results.html", "post_list_item.html"}},
+	"post_page":               {"main", []string{"post_page.html", "post_item.html", "comment.html", "add_comment.html", "held_list.html"}},
+	"create_post":             {"main", []string{"create_post.html", "form_group_post.html", "images-post.html", "attachments-post.html"}},
+	"edit_post":               {"main", []string{"edit_post.html", "images-post.html", "attachments-post.html"}},
+	"revision_history":        {"main", []string{"revision_history.html"}},
+	"profile":                 {"main", []string{"profile.html", "user_comments.html", "notification_list.html", "user_badges.html"}},
+	"profile_activity_search": {"main", []string{"profile.html", "user_comments.html", "notification_list.html", "user_badges.html", "profile_activity_search.html"}},
+	"login":                   {"auth", []string{"login.html"}},
+	"registration":            {"auth", []string{"registration.html"}},
+	"forgot_password":         {"auth", []string{"forgot_password.html"}},
+	"reset_password":          {"auth", []string{"reset_password.html"}},
+	"admin_users":             {"admin", []string{"admin_users.html"}},
+	"admin_categories":        {"admin", []string{"admin_categories.html"}},
+	"admin_trash":             {"admin", []string{"admin_trash.html"}},
+	"admin_uploads":           {"admin", []string{"admin_uploads.html"}},
+	"admin_moderation_log":    {"admin", []string{"admin_moderation_log.html"}},
+	"admin_blocklist":         {"admin", []string{"admin_blocklist.html"}},
+	"admin_review":            {"admin", []string{"admin_review.html"}},
+}
+
+// TemplateRegistry holds every page parsed once, so requests only execute
+// templates. With reload set, the files are checked on every render and the
+// pages parsed again when one changed, for editing templates while the
+// forum runs.
+type TemplateRegistry struct {
+	dir     string
+	layouts map[string][]string
+	pages   map[string]PageSpec
+	reload  bool
+
+	mu       sync.RWMutex
+	parsed   map[string]*template.Template
+	modified time.Time
+}
+
+// NewTemplateRegistry parses and escapes every page in dir. It fails when a
+// file is missing, does not parse, or html/template cannot escape it, e.g.
+// because an attribute is left open in only one branch of an if.
+func NewTemplateRegistry(dir string, layouts map[string][]string, pages map[string]PageSpec, reload bool) (*TemplateRegistry, error) {
+	t := &TemplateRegistry{dir: dir, layouts: layouts, pages: pages, reload: reload}
+	modified, err := t.latestModTime()
+	if err != nil {
+		return nil, err
+	}
+	if t.parsed, err = t.parse(); err != nil {
+		return nil, err
+	}
+	t.modified = modified
+	return t, nil
+}
+
+func (t *TemplateRegistry) paths(files []string) []string {
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = filepath.Join(t.dir, f)
+	}
+	return paths
+}
+
+func (t *TemplateRegistry) parse() (map[string]*template.Template, error) {
+	layouts := make(map[string]*template.Template, len(t.layouts))
+	for name, files := range t.layouts {
+		layout, err := template.New(name).Funcs(TemplateFuncs()).ParseFiles(t.paths(files)...)
+		if err != nil {
+			return nil, fmt.Errorf("layout %s: %w", name, err)
+		}
+		layouts[name] = layout
+	}
+
+	parsed := make(map[string]*template.Template, len(t.pages))
+	for name, spec := range t.pages {
+		layout, ok := layouts[spec.Layout]
+		if !ok {
+			return nil, fmt.Errorf("page %s: unknown layout %q", name, spec.Layout)
+		}
+		// Layouts are never executed, so they can always be cloned
+		page := template.Must(layout.Clone())
+		if _, err := page.ParseFiles(t.paths(spec.Files)...); err != nil {
+			return nil, fmt.Errorf("page %s: %w", name, err)
+		}
+		// Escaping happens before anything executes; errors from running
+		// against nil data are expected and ignored
+		var escapeErr *template.Error
+		if err := template.Must(page.Clone()).ExecuteTemplate(io.Discard, "layout", nil); errors.As(err, &escapeErr) {
+			return nil, fmt.Errorf("page %s: %w", name, escapeErr)
+		}
+		parsed[name] = page
+	}
+	return parsed, nil
+}
+
+// latestModTime is when a template in dir last changed
+func (t *TemplateRegistry) latestModTime() (time.Time, error) {
+	files, err := filepath.Glob(filepath.Join(t.dir, "*.html"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(files) == 0 {
+		return time.Time{}, fmt.Errorf("no templates in %s", t.dir)
+	}
+	var latest time.Time
+	for _, f := range files {
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload parses the pages again when a file changed since the last parse. A
+// broken change is reported and the pages parsed before are kept.
+func (t *TemplateRegistry) Reload() (bool, error) {
+	modified, err := t.latestModTime()
+	if err != nil {
+		return false, err
+	}
+	t.mu.RLock()
+	unchanged := modified.Equal(t.modified)
+	t.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	parsed, err := t.parse()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	// Remember the change either way, so a broken file is reported once
+	t.modified = modified
+	if err != nil {
+		return false, err
+	}
+	t.parsed = parsed
+	return true, nil
+}
+
+// Render executes page with data for r. The output is buffered, so a page
+// failing halfway leaves w untouched for an error page.
+func (t *TemplateRegistry) Render(w http.ResponseWriter, r *http.Request, page string, data any) error {
+	if t.reload {
+		if changed, err := t.Reload(); err != nil {
+			logging.FromContext(r.Context()).Error("template reload failed, keeping the previous templates", "err", err)
+		} else if changed {
+			logging.FromContext(r.Context()).Info("templates reloaded", "dir", t.dir)
+		}
+	}
+
+	t.mu.RLock()
+	tmpl, ok := t.parsed[page]
+	t.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown page %q", page)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "layout", data); err != nil {
+		return fmt.Errorf("page %s: %w", page, err)
+	}
+	out := bytes.ReplaceAll(buf.Bytes(), []byte(nonceMarker), []byte(security.CSPNonce(r.Context())))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, err := w.Write(out)
+	return err
+}
+
+// Templates is the registry of the forum's pages, set by InitTemplates
+var Templates *TemplateRegistry
+
+// InitTemplates parses the forum's pages in dir; it must be called during
+// app startup, before any page is rendered
+func InitTemplates(dir string, reload bool) error {
+	registry, err := NewTemplateRegistry(dir, Layouts, Pages, reload)
+	if err != nil {
+		return err
+	}
+	Templates = registry
+	return nil
+}
+
+// Render writes page with data as the response to r, or an error page when
+// it cannot be rendered
+func Render(w http.ResponseWriter, r *http.Request, page string, data any) {
+	if Templates == nil {
+		apperrors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Templates are not loaded.")
+		return
+	}
+	if err := Templates.Render(w, r, page, data); err != nil {
+		logging.FromContext(r.Context()).Error("rendering failed", "page", page, "err", err)
+		apperrors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "The page could not be rendered.")
+	}
+}
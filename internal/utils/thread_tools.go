@@ -0,0 +1,349 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"forum/internal/models"
+	"strings"
+	"time"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// ErrInvalidThreadAction is returned when a move, merge or split request does not make sense
+var ErrInvalidThreadAction = errors.New("invalid thread action")
+
+// MovePost replaces the categories of a post. Category pins that no longer
+// apply are dropped and the change is kept in the post's revision history.
+func MovePost(ctx context.Context, db *sql.DB, postID, moderatorID int, categoryIDs []int) error {
+	if len(categoryIDs) == 0 {
+		return ErrInvalidThreadAction
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireLivePost(ctx, tx, postID); err != nil {
+		return err
+	}
+	if err := EnsureInitialPostRevision(ctx, tx, postID); err != nil {
+		return err
+	}
+
+	from, err := postCategoryNames(ctx, tx, postID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM post_categories WHERE post_id = ?", postID); err != nil {
+		return fmt.Errorf("clear categories: %w", err)
+	}
+	for _, categoryID := range categoryIDs {
+		res, err := tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO post_categories (post_id, category_id)
+			SELECT ?, id FROM categories WHERE id = ?`, postID, categoryID)
+		if err != nil {
+			return fmt.Errorf("insert category %d: %w", categoryID, err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrInvalidThreadAction
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM post_pins
+		WHERE post_id = ? AND category_id != 0
+		  AND category_id NOT IN (SELECT category_id FROM post_categories WHERE post_id = ?)`,
+		postID, postID); err != nil {
+		return fmt.Errorf("drop stale pins: %w", err)
+	}
+
+	to, err := postCategoryNames(ctx, tx, postID)
+	if err != nil {
+		return err
+	}
+	if err := SavePostRevision(ctx, tx, postID, moderatorID, "Moved to "+to); err != nil {
+		return err
+	}
+	if err := logModeration(ctx, tx, moderatorID, "move", postID, 0, fmt.Sprintf("%s → %s", from, to)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MergePost folds a duplicate post into target. The source's opening post becomes
+// a comment in the target with the old comments as its replies; reactions,
+// tags, images, attachments and notifications follow. Images are added after
+// the target's own, which keep the primary image. The source stays behind as a
+// redirect.
+func MergePost(ctx context.Context, db *sql.DB, sourceID, targetID, moderatorID int) error {
+	if sourceID == targetID {
+		return ErrInvalidThreadAction
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireLivePost(ctx, tx, sourceID); err != nil {
+		return err
+	}
+	if err := requireLivePost(ctx, tx, targetID); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO comments (post_id, user_id, content, created_at)
+		SELECT ?, user_id, title || char(10) || char(10) || content, created_at
+		FROM posts WHERE id = ?`, targetID, sourceID)
+	if err != nil {
+		return fmt.Errorf("copy opening post: %w", err)
+	}
+	openerID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	var nextImage int
+	var hasPrimary bool
+	err = tx.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(order_index) + 1, 0), COALESCE(MAX(is_primary), 0)
+		FROM post_images WHERE post_id = ?`, targetID).Scan(&nextImage, &hasPrimary)
+	if err != nil {
+		return fmt.Errorf("load images of post %d: %w", targetID, err)
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// Top-level comments now answer the old opening post
+		{"UPDATE comments SET parent_comment_id = ? WHERE post_id = ? AND COALESCE(parent_comment_id, 0) = 0", []interface{}{openerID, sourceID}},
+		{"UPDATE comments SET post_id = ? WHERE post_id = ?", []interface{}{targetID, sourceID}},
+		// A user who reacted to both posts keeps their reaction on the target
+		{`UPDATE likes SET post_id = ?
+		  WHERE post_id = ? AND comment_id IS NULL
+		    AND user_id NOT IN (SELECT user_id FROM likes WHERE post_id = ? AND comment_id IS NULL)`,
+			[]interface{}{targetID, sourceID, targetID}},
+		{"DELETE FROM likes WHERE post_id = ? AND comment_id IS NULL", []interface{}{sourceID}},
+		{"INSERT OR IGNORE INTO post_tags (post_id, tag_id) SELECT ?, tag_id FROM post_tags WHERE post_id = ?", []interface{}{targetID, sourceID}},
+		{"DELETE FROM post_tags WHERE post_id = ?", []interface{}{sourceID}},
+		{`UPDATE post_images SET post_id = ?, order_index = order_index + ?, is_primary = is_primary AND NOT ?
+		  WHERE post_id = ?`, []interface{}{targetID, nextImage, hasPrimary, sourceID}},
+		{"UPDATE post_attachments SET post_id = ? WHERE post_id = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE notifications SET post_id = ? WHERE post_id = ?", []interface{}{targetID, sourceID}},
+		{"DELETE FROM post_pins WHERE post_id = ?", []interface{}{sourceID}},
+		{`UPDATE posts
+		  SET merged_into = ?, deleted_at = CURRENT_TIMESTAMP, deleted_by = ?, delete_reason = ?
+		  WHERE id = ?`, []interface{}{targetID, moderatorID, fmt.Sprintf("Merged into #%d", targetID), sourceID}},
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt.query, stmt.args...); err != nil {
+			return fmt.Errorf("merge post %d into %d: %w", sourceID, targetID, err)
+		}
+	}
+
+	if err := logModeration(ctx, tx, moderatorID, "merge", sourceID, targetID, ""); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// SplitComments moves the selected comments and all their replies into a new
+// post. The earliest selected comment becomes the new post's body, written by
+// the same author and in the same categories as the original post.
+func SplitComments(ctx context.Context, db *sql.DB, sourceID int, commentIDs []int, moderatorID int, title string) (int, error) {
+	title = strings.TrimSpace(title)
+	if title == "" || len(commentIDs) == 0 {
+		return 0, ErrInvalidThreadAction
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := requireLivePost(ctx, tx, sourceID); err != nil {
+		return 0, err
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(commentIDs)), ",")
+	args := []interface{}{sourceID}
+	for _, id := range commentIDs {
+		args = append(args, id)
+	}
+
+	var selected int
+	if err := tx.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM comments
+		WHERE post_id = ? AND deleted_at IS NULL AND id IN (`+placeholders+`)`, args...).Scan(&selected); err != nil {
+		return 0, fmt.Errorf("check selected comments: %w", err)
+	}
+	if selected != len(commentIDs) {
+		return 0, ErrInvalidThreadAction
+	}
+
+	// Selected comments plus every reply below them, oldest first
+	rows, err := tx.QueryContext(ctx, `
+		WITH RECURSIVE moved(id) AS (
+			SELECT id FROM comments WHERE post_id = ? AND id IN (`+placeholders+`)
+			UNION
+			SELECT c.id FROM comments c JOIN moved m ON c.parent_comment_id = m.id
+		)
+		SELECT c.id, c.user_id, c.content, c.created_at, COALESCE(c.parent_comment_id, 0)
+		FROM comments c JOIN moved m ON m.id = c.id
+		ORDER BY c.id`, args...)
+	if err != nil {
+		return 0, fmt.Errorf("query comments to split: %w", err)
+	}
+	type movedComment struct {
+		id, userID, parentID int
+		content              string
+		createdAt            time.Time
+	}
+	var moved []movedComment
+	inSet := make(map[int]bool)
+	for rows.Next() {
+		var m movedComment
+		if err := rows.Scan(&m.id, &m.userID, &m.content, &m.createdAt, &m.parentID); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("scan comment to split: %w", err)
+		}
+		moved = append(moved, m)
+		inSet[m.id] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	opener := moved[0]
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO posts (user_id, title, content, created_at) VALUES (?, ?, ?, ?)",
+		opener.userID, title, opener.content, opener.createdAt)
+	if err != nil {
+		return 0, fmt.Errorf("create split post: %w", err)
+	}
+	newID64, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	newID := int(newID64)
+
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO post_categories (post_id, category_id)
+		SELECT ?, category_id FROM post_categories WHERE post_id = ?`, newID, sourceID); err != nil {
+		return 0, fmt.Errorf("copy categories: %w", err)
+	}
+
+	for _, m := range moved[1:] {
+		// Replies to the opener, or to comments left behind, become top-level comments
+		parentID := m.parentID
+		if parentID == opener.id || !inSet[parentID] {
+			parentID = 0
+		}
+		if _, err := tx.ExecContext(ctx,
+			"UPDATE comments SET post_id = ?, parent_comment_id = NULLIF(?, 0) WHERE id = ?",
+			newID, parentID, m.id); err != nil {
+			return 0, fmt.Errorf("move comment %d: %w", m.id, err)
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE notifications SET post_id = ? WHERE comment_id = ?", newID, m.id); err != nil {
+			return 0, fmt.Errorf("move notifications of comment %d: %w", m.id, err)
+		}
+	}
+
+	// The opener comment turns into the post itself
+	for _, stmt := range []string{
+		"UPDATE likes SET post_id = ?, comment_id = NULL WHERE comment_id = ?",
+		"UPDATE notifications SET post_id = ?, comment_id = NULL WHERE comment_id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, newID, opener.id); err != nil {
+			return 0, fmt.Errorf("move opener comment %d: %w", opener.id, err)
+		}
+	}
+	for _, stmt := range []string{
+		"DELETE FROM comment_revisions WHERE comment_id = ?",
+		"DELETE FROM comments WHERE id = ?",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt, opener.id); err != nil {
+			return 0, fmt.Errorf("remove opener comment %d: %w", opener.id, err)
+		}
+	}
+
+	details := fmt.Sprintf("%d comments", len(moved))
+	if err := logModeration(ctx, tx, moderatorID, "split", sourceID, newID, details); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit split: %w", err)
+	}
+	return newID, nil
+}
+
+// GetModerationLog returns the most recent moderator thread actions
+func GetModerationLog(db *sql.DB, limit int) ([]models.ModerationLogEntry, error) {
+	rows, err := db.Query(`
+		SELECT m.id, u.username, m.action, m.post_id, COALESCE(m.target_post_id, 0),
+		       COALESCE(m.details, ''), m.created_at
+		FROM moderation_log m
+		JOIN users u ON u.id = m.moderator_id
+		ORDER BY m.id DESC
+		LIMIT ?`, limit)
+	if err != nil {
+		return nil, fmt.Errorf("query moderation log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []models.ModerationLogEntry
+	for rows.Next() {
+		var e models.ModerationLogEntry
+		var createdAt time.Time
+		if err := rows.Scan(&e.ID, &e.ModeratorName, &e.Action, &e.PostID, &e.TargetPostID, &e.Details, &createdAt); err != nil {
+			return nil, fmt.Errorf("scan moderation log: %w", err)
+		}
+		e.CreatedAt = FormatDate(createdAt)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func logModeration(ctx context.Context, tx *sql.Tx, moderatorID int, action string, postID, targetPostID int, details string) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO moderation_log (moderator_id, action, post_id, target_post_id, details)
+		VALUES (?, ?, ?, NULLIF(?, 0), ?)`, moderatorID, action, postID, targetPostID, details)
+	if err != nil {
+		return fmt.Errorf("log %s of post %d: %w", action, postID, err)
+	}
+	return nil
+}
+
+// requireLivePost returns sql.ErrNoRows unless the post exists and is not deleted
+func requireLivePost(ctx context.Context, tx *sql.Tx, postID int) error {
+	var exists bool
+	return tx.QueryRowContext(ctx, "SELECT 1 FROM posts WHERE id = ? AND deleted_at IS NULL", postID).Scan(&exists)
+}
+
+func postCategoryNames(ctx context.Context, tx *sql.Tx, postID int) (string, error) {
+	var names string
+	err := tx.QueryRowContext(ctx, `
+		SELECT COALESCE(GROUP_CONCAT(c.name, ', '), '')
+		FROM post_categories pc
+		JOIN categories c ON c.id = pc.category_id
+		WHERE pc.post_id = ?`, postID).Scan(&names)
+	if err != nil {
+		return "", fmt.Errorf("read categories of post %d: %w", postID, err)
+	}
+	if names == "" {
+		names = "(none)"
+	}
+	return names, nil
+}
@@ -0,0 +1,296 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"strings"
+	"time"
+
+	_ "github.com/mutecomm/go-sqlcipher/v4"
+)
+
+// DeletedPlaceholder replaces the text of a deleted comment that is still shown in a thread
+const DeletedPlaceholder = "[deleted]"
+
+// SoftDeletePost marks a post as deleted without removing any rows
+func SoftDeletePost(ctx context.Context, db *sql.DB, postID, deletedBy int, reason string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE posts
+		SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ?, delete_reason = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		deletedBy, strings.TrimSpace(reason), postID)
+	if err != nil {
+		return fmt.Errorf("soft delete post %d: %w", postID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	RefreshContentOwner(ctx, db, "post", postID)
+	return nil
+}
+
+// SoftDeleteComment marks a comment as deleted; replies to it stay visible
+func SoftDeleteComment(ctx context.Context, db *sql.DB, commentID, deletedBy int, reason string) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE comments
+		SET deleted_at = CURRENT_TIMESTAMP, deleted_by = ?, delete_reason = ?
+		WHERE id = ? AND deleted_at IS NULL`,
+		deletedBy, strings.TrimSpace(reason), commentID)
+	if err != nil {
+		return fmt.Errorf("soft delete comment %d: %w", commentID, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	RefreshContentOwner(ctx, db, "comment", commentID)
+	return nil
+}
+
+// RestorePost brings a soft-deleted post back
+func RestorePost(ctx context.Context, db *sql.DB, postID int) error {
+	if err := restore(ctx, db, "posts", postID); err != nil {
+		return err
+	}
+	RefreshContentOwner(ctx, db, "post", postID)
+	return nil
+}
+
+// RestoreComment brings a soft-deleted comment back
+func RestoreComment(ctx context.Context, db *sql.DB, commentID int) error {
+	if err := restore(ctx, db, "comments", commentID); err != nil {
+		return err
+	}
+	RefreshContentOwner(ctx, db, "comment", commentID)
+	return nil
+}
+
+func restore(ctx context.Context, db *sql.DB, table string, id int) error {
+	res, err := db.ExecContext(ctx, `
+		UPDATE `+table+`
+		SET deleted_at = NULL, deleted_by = NULL, delete_reason = ''
+		WHERE id = ? AND deleted_at IS NOT NULL`, id)
+	if err != nil {
+		return fmt.Errorf("restore %s %d: %w", table, id, err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetTrash lists soft-deleted posts and comments, newest deletion first
+func GetTrash(db *sql.DB, retention time.Duration) ([]models.TrashItem, error) {
+	rows, err := db.Query(`
+		SELECT 'post', p.id, p.id, p.title, p.content, u.username,
+		       COALESCE(d.username, ''), COALESCE(p.delete_reason, ''), p.deleted_at
+		FROM posts p
+		JOIN users u ON u.id = p.user_id
+		LEFT JOIN users d ON d.id = p.deleted_by
+		WHERE p.deleted_at IS NOT NULL AND p.merged_into IS NULL
+		UNION ALL
+		SELECT 'comment', c.id, c.post_id, p.title, c.content, u.username,
+		       COALESCE(d.username, ''), COALESCE(c.delete_reason, ''), c.deleted_at
+		FROM comments c
+		JOIN posts p ON p.id = c.post_id
+		JOIN users u ON u.id = c.user_id
+		LEFT JOIN users d ON d.id = c.deleted_by
+		WHERE c.deleted_at IS NOT NULL
+		ORDER BY 9 DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("query trash: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.TrashItem
+	for rows.Next() {
+		var item models.TrashItem
+		var deletedAt time.Time
+		if err := rows.Scan(&item.Kind, &item.ID, &item.PostID, &item.Title, &item.Content, &item.AuthorName,
+			&item.DeletedByName, &item.Reason, &deletedAt); err != nil {
+			return nil, fmt.Errorf("scan trash item: %w", err)
+		}
+		item.DeletedAt = FormatDate(deletedAt)
+		item.PurgeAfter = FormatDate(deletedAt.Add(retention))
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// PurgeDeletedContent permanently removes posts and comments that have been in
+// the trash for longer than retention, together with their uploaded images
+// and attachments. Deleted comments that still have replies are kept as
+// placeholders, and merged posts are kept so their redirect keeps working.
+func PurgeDeletedContent(ctx context.Context, db *sql.DB, retention time.Duration) (models.PurgeReport, error) {
+	var report models.PurgeReport
+	cutoff := fmt.Sprintf("-%d seconds", int64(retention.Seconds()))
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return report, fmt.Errorf("begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	postIDs, err := queryIDs(ctx, tx, `
+		SELECT id FROM posts
+		WHERE deleted_at IS NOT NULL AND deleted_at < datetime('now', ?)
+		  AND merged_into IS NULL`, cutoff)
+	if err != nil {
+		return report, err
+	}
+
+	var files []string
+	for _, postID := range postIDs {
+		paths, err := queryStrings(ctx, tx, `
+			SELECT image_path FROM post_images WHERE post_id = ?1
+			UNION SELECT file_path FROM post_attachments WHERE post_id = ?1`, postID)
+		if err != nil {
+			return report, err
+		}
+		files = append(files, paths...)
+
+		if err := purgePost(ctx, tx, postID); err != nil {
+			return report, err
+		}
+		report.Posts++
+	}
+
+	// Removing a comment can turn its deleted parent into a leaf, so repeat until nothing changes
+	for {
+		commentIDs, err := queryIDs(ctx, tx, `
+			SELECT c.id FROM comments c
+			WHERE c.deleted_at IS NOT NULL AND c.deleted_at < datetime('now', ?)
+			  AND NOT EXISTS (SELECT 1 FROM comments ch WHERE ch.parent_comment_id = c.id)`, cutoff)
+		if err != nil {
+			return report, err
+		}
+		if len(commentIDs) == 0 {
+			break
+		}
+		for _, commentID := range commentIDs {
+			if err := purgeComment(ctx, tx, commentID); err != nil {
+				return report, err
+			}
+			report.Comments++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return report, fmt.Errorf("commit purge: %w", err)
+	}
+
+	// Files are released only after the rows are gone, and only when nothing
+	// else uses them; the upload GC removes them after its grace period
+	for _, path := range files {
+		released, err := ReleaseUpload(ctx, db, path)
+		if err != nil {
+			logging.FromContext(ctx).Warn("purge failed to release upload", "path", path, "err", err)
+			continue
+		}
+		if released {
+			report.Files++
+		}
+	}
+
+	return report, nil
+}
+
+func purgePost(ctx context.Context, tx *sql.Tx, postID int) error {
+	statements := []string{
+		"DELETE FROM likes WHERE post_id = ? OR comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM notifications WHERE post_id = ? OR comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comment_revisions WHERE comment_id IN (SELECT id FROM comments WHERE post_id = ?)",
+		"DELETE FROM comments WHERE post_id = ?",
+		"DELETE FROM post_revisions WHERE post_id = ?",
+		"DELETE FROM post_pins WHERE post_id = ?",
+		"DELETE FROM post_images WHERE post_id = ?",
+		"DELETE FROM post_attachments WHERE post_id = ?",
+		"DELETE FROM post_categories WHERE post_id = ?",
+		"DELETE FROM post_tags WHERE post_id = ?",
+		"DELETE FROM posts WHERE id = ?",
+	}
+	for _, stmt := range statements {
+		args := make([]interface{}, strings.Count(stmt, "?"))
+		for i := range args {
+			args[i] = postID
+		}
+		if _, err := tx.ExecContext(ctx, stmt, args...); err != nil {
+			return fmt.Errorf("purge post %d: %w", postID, err)
+		}
+	}
+	return nil
+}
+
+func purgeComment(ctx context.Context, tx *sql.Tx, commentID int) error {
+	statements := []string{
+		"DELETE FROM likes WHERE comment_id = ?",
+		"DELETE FROM notifications WHERE comment_id = ?",
+		"DELETE FROM comment_revisions WHERE comment_id = ?",
+		"DELETE FROM comments WHERE id = ?",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, stmt, commentID); err != nil {
+			return fmt.Errorf("purge comment %d: %w", commentID, err)
+		}
+	}
+	return nil
+}
+
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]int, error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func queryStrings(ctx context.Context, q queryer, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var values []string
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, rows.Err()
+}
+
+// RunTrashPurge purges expired trash every interval until ctx is cancelled
+func RunTrashPurge(ctx context.Context, db *sql.DB, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := PurgeDeletedContent(ctx, db, retention)
+		if err != nil {
+			logging.FromContext(ctx).Error("trash purge failed", "err", err)
+		} else if report.Posts+report.Comments > 0 {
+			logging.FromContext(ctx).Info("trash purged", "posts", report.Posts, "comments", report.Comments, "files", report.Files)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
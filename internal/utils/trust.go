@@ -0,0 +1,341 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"html/template"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Trust levels members earn with reputation
+const (
+	TrustNew = iota
+	TrustBasic
+	TrustMember
+	TrustRegular
+)
+
+var trustLevelNames = [...]string{"New", "Basic", "Member", "Regular"}
+
+// TrustLevelName is how a trust level is shown
+func TrustLevelName(level int) string {
+	if level < 0 || level >= len(trustLevelNames) {
+		return ""
+	}
+	return trustLevelNames[level]
+}
+
+// Capability is something only members of some trust level may do
+type Capability int
+
+const (
+	CapImages Capability = iota
+	CapLinks
+	CapTags
+	CapWiki
+)
+
+var capabilityNames = [...]string{"Posting images", "Posting links", "Creating tags", "Editing other members' wiki posts"}
+
+// What reputation is made of. Content counts while it is published; likes
+// and dislikes members give their own content do not count.
+const (
+	repPerLike        = 5
+	repPerDislike     = -2
+	repPerComment     = 1
+	repMaxComments    = 100 // comments beyond this earn nothing more
+	repPerMonth       = 1
+	repMaxMonths      = 24
+	repPerApproved    = 2
+	repPerRejected    = -10
+	repPerRemoved     = -15 // posts and comments a moderator deleted
+	repTrustedAtLeast = TrustBasic
+)
+
+// TrustPolicy is the reputation each trust level above TrustNew needs and
+// the level each capability needs
+type TrustPolicy struct {
+	Thresholds []int
+	Required   map[Capability]int
+}
+
+// Level is the trust level reputation earns
+func (p TrustPolicy) Level(score int) int {
+	level := TrustNew
+	for i, threshold := range p.Thresholds {
+		if score >= threshold {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+var (
+	trustMu sync.Mutex
+	// Until SetTrustPolicy is called every capability is open to everyone
+	trustPolicy = TrustPolicy{Thresholds: []int{10, 50, 150}}
+)
+
+// SetTrustPolicy sets the trust levels and the capabilities they unlock
+func SetTrustPolicy(p TrustPolicy) {
+	trustMu.Lock()
+	defer trustMu.Unlock()
+	trustPolicy = p
+}
+
+func currentTrustPolicy() TrustPolicy {
+	trustMu.Lock()
+	defer trustMu.Unlock()
+	return trustPolicy
+}
+
+// ComputeReputation adds up a member's reputation from the reactions their
+// content received, their comments, how long they are a member and what
+// moderators decided about their content. Banned members are at TrustNew,
+// moderators and admins at the top level, and members pre-moderation
+// trusts at least at TrustBasic.
+func ComputeReputation(ctx context.Context, db *sql.DB, userID int) (models.Reputation, error) {
+	var rep models.Reputation
+	var role string
+	var banned, trusted bool
+	err := db.QueryRowContext(ctx, `
+		SELECT COALESCE(u.role, 'user'), COALESCE(u.banned, 0), u.trusted_at IS NOT NULL,
+		       COALESCE(CAST((julianday('now') - julianday(u.created_at)) / 30 AS INTEGER), 0),
+		       (SELECT COUNT(*) FROM likes l JOIN posts p ON p.id = l.post_id
+		        WHERE p.user_id = u.id AND l.user_id != u.id AND p.deleted_at IS NULL AND l.reaction = 'Like') +
+		       (SELECT COUNT(*) FROM likes l JOIN comments c ON c.id = l.comment_id
+		        WHERE c.user_id = u.id AND l.user_id != u.id AND c.deleted_at IS NULL AND l.reaction = 'Like'),
+		       (SELECT COUNT(*) FROM likes l JOIN posts p ON p.id = l.post_id
+		        WHERE p.user_id = u.id AND l.user_id != u.id AND p.deleted_at IS NULL AND l.reaction = 'Dislike') +
+		       (SELECT COUNT(*) FROM likes l JOIN comments c ON c.id = l.comment_id
+		        WHERE c.user_id = u.id AND l.user_id != u.id AND c.deleted_at IS NULL AND l.reaction = 'Dislike'),
+		       (SELECT COUNT(*) FROM comments WHERE user_id = u.id AND deleted_at IS NULL),
+		       (SELECT COUNT(*) FROM held_content WHERE user_id = u.id AND status = ?2),
+		       (SELECT COUNT(*) FROM held_content WHERE user_id = u.id AND status = ?3),
+		       (SELECT COUNT(*) FROM posts WHERE user_id = u.id AND deleted_by IS NOT NULL AND deleted_by != u.id) +
+		       (SELECT COUNT(*) FROM comments WHERE user_id = u.id AND deleted_by IS NOT NULL AND deleted_by != u.id)
+		FROM users u
+		WHERE u.id = ?1`, userID, HeldApproved, HeldRejected).Scan(
+		&role, &banned, &trusted, &rep.MonthsMember, &rep.LikesReceived, &rep.DislikesReceived,
+		&rep.Comments, &rep.Approved, &rep.Rejected, &rep.Removed)
+	if err != nil {
+		return rep, fmt.Errorf("compute reputation of user %d: %w", userID, err)
+	}
+
+	rep.Score = rep.LikesReceived*repPerLike + rep.DislikesReceived*repPerDislike +
+		min(rep.Comments, repMaxComments)*repPerComment + min(rep.MonthsMember, repMaxMonths)*repPerMonth +
+		rep.Approved*repPerApproved + rep.Rejected*repPerRejected + rep.Removed*repPerRemoved
+
+	policy := currentTrustPolicy()
+	switch {
+	case banned:
+		rep.Level = TrustNew
+	case role == "moderator" || role == "admin":
+		rep.Level = TrustRegular
+	default:
+		rep.Level = policy.Level(rep.Score)
+		if trusted {
+			rep.Level = max(rep.Level, repTrustedAtLeast)
+		}
+		if rep.Level < len(policy.Thresholds) {
+			rep.NextLevelAt = policy.Thresholds[rep.Level]
+		}
+	}
+	rep.LevelName = TrustLevelName(rep.Level)
+	return rep, nil
+}
+
+// RecalculateReputation computes a member's reputation and stores it with
+// their trust level
+func RecalculateReputation(ctx context.Context, db *sql.DB, userID int) (models.Reputation, error) {
+	rep, err := ComputeReputation(ctx, db, userID)
+	if err != nil {
+		return rep, err
+	}
+	var before int
+	if err := db.QueryRowContext(ctx, "SELECT COALESCE(trust_level, 0) FROM users WHERE id = ?", userID).Scan(&before); err != nil {
+		return rep, fmt.Errorf("load trust level of user %d: %w", userID, err)
+	}
+	_, err = db.ExecContext(ctx, `
+		UPDATE users SET reputation = ?, trust_level = ?, reputation_updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?`, rep.Score, rep.Level, userID)
+	if err != nil {
+		return rep, fmt.Errorf("store reputation of user %d: %w", userID, err)
+	}
+	if rep.Level != before {
+		logging.FromContext(ctx).Info("trust level changed", "member_id", userID, "from", before, "to", rep.Level, "reputation", rep.Score)
+	}
+	return rep, nil
+}
+
+// RefreshReputation recalculates the reputation of members after something
+// that counts towards it happened to them. Failures are only logged; the
+// sweep catches up later.
+func RefreshReputation(ctx context.Context, db *sql.DB, userIDs ...int) {
+	for _, id := range userIDs {
+		if id == 0 {
+			continue
+		}
+		if _, err := RecalculateReputation(ctx, db, id); err != nil {
+			logging.FromContext(ctx).Warn("failed to refresh reputation", "err", err)
+		}
+	}
+}
+
+// RefreshContentOwner recalculates the reputation of whoever wrote a post
+// or comment, after it was reacted to, deleted or restored
+func RefreshContentOwner(ctx context.Context, db *sql.DB, kind string, id int) {
+	table := "posts"
+	if kind == "comment" {
+		table = "comments"
+	}
+	var owner int
+	if err := db.QueryRowContext(ctx, "SELECT user_id FROM "+table+" WHERE id = ?", id).Scan(&owner); err != nil {
+		logging.FromContext(ctx).Warn("failed to find author for reputation", "kind", kind, "id", id, "err", err)
+		return
+	}
+	RefreshReputation(ctx, db, owner)
+}
+
+// repStaleAfter is how long the sweep trusts a stored reputation before
+// recalculating it anyway, in case an event that changed it was missed
+const repStaleAfter = 7 * 24 * time.Hour
+
+// RecalculateStaleReputation recalculates the members whose reputation was
+// never calculated, whose membership reached another month that still counts
+// since it was, or who were not recalculated for olderThan, and returns how
+// many there were
+func RecalculateStaleReputation(ctx context.Context, db *sql.DB, olderThan time.Duration) (int, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id FROM users
+		WHERE reputation_updated_at IS NULL
+		   OR reputation_updated_at < datetime(?, 'unixepoch')
+		   OR CAST((julianday(reputation_updated_at) - julianday(created_at)) / 30 AS INTEGER)
+		      < MIN(CAST((julianday('now') - julianday(created_at)) / 30 AS INTEGER), ?)`,
+		time.Now().Add(-olderThan).Unix(), repMaxMonths)
+	if err != nil {
+		return 0, fmt.Errorf("query stale reputation: %w", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		if ctx.Err() != nil {
+			return i, ctx.Err()
+		}
+		if _, err := RecalculateReputation(ctx, db, id); err != nil {
+			return i, err
+		}
+	}
+	return len(ids), nil
+}
+
+// RunReputationSweep brings stale reputation up to date every interval until
+// ctx is cancelled. Events recalculate the members they concern; the sweep
+// only covers what changes by itself, like account age, and members no event
+// reached for a week.
+func RunReputationSweep(ctx context.Context, db *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := RecalculateStaleReputation(ctx, db, repStaleAfter)
+		if err != nil && ctx.Err() == nil {
+			logging.FromContext(ctx).Error("reputation sweep failed", "err", err)
+		} else if n > 0 {
+			logging.FromContext(ctx).Info("reputation sweep recalculated members", "members", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CanUse reports whether user has a capability
+func CanUse(user *models.User, c Capability) bool {
+	if user == nil {
+		return false
+	}
+	return IsModerator(user) || user.TrustLevel >= currentTrustPolicy().Required[c]
+}
+
+// Capabilities lists every capability and whether user has it
+func Capabilities(user *models.User) []models.Capability {
+	policy := currentTrustPolicy()
+	caps := make([]models.Capability, len(capabilityNames))
+	for i, name := range capabilityNames {
+		c := Capability(i)
+		caps[i] = models.Capability{Name: name, Level: policy.Required[c], Allowed: CanUse(user, c)}
+	}
+	return caps
+}
+
+// CapabilityMessage tells a member which trust level a capability needs
+func CapabilityMessage(c Capability) string {
+	level := currentTrustPolicy().Required[c]
+	return fmt.Sprintf("%s needs trust level %d (%s). Your trust level grows as other members like your posts and comments.",
+		capabilityNames[c], level, TrustLevelName(level))
+}
+
+// CheckPostingCapabilities returns why a member may not post text with
+// images images and tags, or "" when they may. Only tags that do not exist
+// yet need CapTags.
+func CheckPostingCapabilities(ctx context.Context, db *sql.DB, userID int, text string, images int, tags []string) (string, error) {
+	user := &models.User{ID: userID}
+	err := db.QueryRowContext(ctx, "SELECT COALESCE(role, 'user'), COALESCE(trust_level, 0) FROM users WHERE id = ?", userID).
+		Scan(&user.Role, &user.TrustLevel)
+	if err != nil {
+		return "", fmt.Errorf("load trust level of user %d: %w", userID, err)
+	}
+
+	if images > 0 && !CanUse(user, CapImages) {
+		return CapabilityMessage(CapImages), nil
+	}
+	if CountLinks(text) > 0 && !CanUse(user, CapLinks) {
+		return CapabilityMessage(CapLinks), nil
+	}
+	if len(tags) > 0 && !CanUse(user, CapTags) {
+		missing, err := NewTags(ctx, db, tags)
+		if err != nil {
+			return "", err
+		}
+		if len(missing) > 0 {
+			return fmt.Sprintf("%s New tags: %s.", CapabilityMessage(CapTags), strings.Join(missing, ", ")), nil
+		}
+	}
+	return "", nil
+}
+
+// TrustFuncs are the template helpers for trust levels: {{ trustBadge .UserTrustLevel }}
+// shows the level next to a username, nothing for new members
+func TrustFuncs() map[string]any {
+	return map[string]any{
+		"trustLevelName": TrustLevelName,
+		"trustBadge": func(level int) template.HTML {
+			name := TrustLevelName(level)
+			if level <= TrustNew || name == "" {
+				return ""
+			}
+			return template.HTML(fmt.Sprintf(`<span class="trust-badge trust-level-%d" title="Trust level %d">%s</span>`, level, level, name))
+		},
+	}
+}
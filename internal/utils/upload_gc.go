@@ -0,0 +1,242 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"forum/internal/logging"
+	"forum/internal/models"
+	"forum/internal/storage"
+	"strings"
+	"time"
+)
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// UploadRefCount returns how many rows use an uploaded file. Identical
+// uploads share one file, so a file may be referenced many times.
+func UploadRefCount(ctx context.Context, q queryer, path string) (int, error) {
+	var refs int
+	err := q.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM post_images WHERE image_path = ?1 OR thumb_small = ?1 OR thumb_medium = ?1) +
+		       (SELECT COUNT(*) FROM posts WHERE image_path = ?1) +
+		       (SELECT COUNT(*) FROM users WHERE avatar_url = ?1) +
+		       (SELECT COUNT(*) FROM post_attachments WHERE file_path = ?1) +
+		       (SELECT COUNT(*) FROM held_content WHERE status = 'pending' AND instr(payload, '"' || ?1 || '"') > 0)`, path).Scan(&refs)
+	return refs, err
+}
+
+// ReleaseUpload hands an uploaded file nothing references any more to the
+// upload GC, which removes it and its thumbnails after the grace period. It is
+// not removed right away: an identical upload may already share the file and
+// be about to reference it. Call it after the referencing row is gone.
+func ReleaseUpload(ctx context.Context, db *sql.DB, path string) (bool, error) {
+	if !strings.HasPrefix(path, UploadsURLPrefix) {
+		return false, nil // external URL, e.g. an OAuth avatar
+	}
+	refs, err := UploadRefCount(ctx, db, path)
+	if err != nil {
+		return false, fmt.Errorf("count references of %s: %w", path, err)
+	}
+	if refs > 0 {
+		return false, nil
+	}
+	key, err := UploadKey(path)
+	if err != nil {
+		return false, err
+	}
+	now := time.Now().UTC()
+	for _, k := range append([]string{key}, uploadVariantKeys(key)...) {
+		if _, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO upload_orphans (key, first_seen) VALUES (?, ?)", k, now); err != nil {
+			return false, fmt.Errorf("record orphan %s: %w", k, err)
+		}
+	}
+	return true, nil
+}
+
+// referencedUploadKeys returns the store keys of every file in use, including
+// the thumbnails and avatar sizes derived from each one
+func referencedUploadKeys(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	paths, err := queryStrings(ctx, db, `
+		SELECT image_path FROM post_images
+		UNION SELECT thumb_small FROM post_images WHERE thumb_small IS NOT NULL
+		UNION SELECT thumb_medium FROM post_images WHERE thumb_medium IS NOT NULL
+		UNION SELECT image_path FROM posts WHERE image_path IS NOT NULL
+		UNION SELECT avatar_url FROM users WHERE avatar_url IS NOT NULL
+		UNION SELECT file_path FROM post_attachments`)
+	if err != nil {
+		return nil, err
+	}
+	// Held posts are not created yet but will use their uploads once approved
+	held, err := heldUploadPaths(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, held...)
+
+	keys := make(map[string]bool)
+	for _, path := range paths {
+		key, err := UploadKey(path)
+		if err != nil {
+			continue
+		}
+		keys[key] = true
+		for _, variant := range uploadVariantKeys(key) {
+			keys[variant] = true
+		}
+	}
+	return keys, nil
+}
+
+// CollectUploadGarbage finds files in the store that no row references. A
+// file is first recorded as orphaned and only removed once both the record
+// and the file itself are older than grace. With dryRun the report lists what
+// would happen and nothing is changed.
+func CollectUploadGarbage(ctx context.Context, db *sql.DB, store storage.BlobStore, grace time.Duration, dryRun bool) (models.UploadGCReport, error) {
+	report := models.UploadGCReport{DryRun: dryRun, Grace: grace}
+	now := time.Now().UTC()
+
+	blobs, err := store.List(ctx, "")
+	if err != nil {
+		return report, fmt.Errorf("list uploads: %w", err)
+	}
+	referenced, err := referencedUploadKeys(ctx, db)
+	if err != nil {
+		return report, fmt.Errorf("load references: %w", err)
+	}
+
+	orphanedSince := make(map[string]time.Time)
+	rows, err := db.QueryContext(ctx, "SELECT key, first_seen FROM upload_orphans")
+	if err != nil {
+		return report, fmt.Errorf("load orphans: %w", err)
+	}
+	for rows.Next() {
+		var key string
+		var seen time.Time
+		if err := rows.Scan(&key, &seen); err != nil {
+			rows.Close()
+			return report, err
+		}
+		orphanedSince[key] = seen
+	}
+	rows.Close()
+
+	seen := make(map[string]bool, len(blobs))
+	var expired []storage.BlobInfo
+	for _, blob := range blobs {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+		report.Scanned++
+		seen[blob.Key] = true
+		if referenced[blob.Key] {
+			report.Referenced++
+			continue
+		}
+
+		since, known := orphanedSince[blob.Key]
+		if !known {
+			since = now
+			if !dryRun {
+				if _, err := db.ExecContext(ctx, "INSERT OR IGNORE INTO upload_orphans (key, first_seen) VALUES (?, ?)", blob.Key, now); err != nil {
+					return report, fmt.Errorf("record orphan %s: %w", blob.Key, err)
+				}
+			}
+		}
+
+		entry := models.UploadGCEntry{
+			Key:           blob.Key,
+			Size:          blob.Size,
+			OrphanedSince: since,
+			Expired:       now.Sub(since) >= grace && now.Sub(blob.ModTime) >= grace,
+		}
+		report.Orphans = append(report.Orphans, entry)
+		if entry.Expired && !dryRun {
+			expired = append(expired, blob)
+		}
+	}
+
+	if len(expired) > 0 {
+		if err := deleteExpiredUploads(ctx, db, store, expired, grace, now, &report); err != nil {
+			return report, err
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+
+	// Forget orphans that disappeared or are referenced again
+	for key := range orphanedSince {
+		if seen[key] && !referenced[key] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM upload_orphans WHERE key = ?", key); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// deleteExpiredUploads removes expired orphans. Saving an upload again
+// rewrites its file, so files are listed once more and those written since
+// the scan are kept: a new row is about to reference them.
+func deleteExpiredUploads(ctx context.Context, db *sql.DB, store storage.BlobStore, expired []storage.BlobInfo, grace time.Duration, now time.Time, report *models.UploadGCReport) error {
+	blobs, err := store.List(ctx, "")
+	if err != nil {
+		return fmt.Errorf("list uploads: %w", err)
+	}
+	modified := make(map[string]time.Time, len(blobs))
+	for _, blob := range blobs {
+		modified[blob.Key] = blob.ModTime
+	}
+
+	for _, blob := range expired {
+		if mod, ok := modified[blob.Key]; !ok || now.Sub(mod) < grace {
+			continue
+		}
+		// A row may have started using the file since the references were loaded
+		refs, err := UploadRefCount(ctx, db, UploadsURLPrefix+blob.Key)
+		if err != nil {
+			return err
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := store.Delete(ctx, blob.Key); err != nil {
+			logging.FromContext(ctx).Warn("upload GC failed to remove file", "key", blob.Key, "err", err)
+			continue
+		}
+		if _, err := db.ExecContext(ctx, "DELETE FROM upload_orphans WHERE key = ?", blob.Key); err != nil {
+			return err
+		}
+		report.Deleted++
+		report.BytesFreed += blob.Size
+	}
+	return nil
+}
+
+// RunUploadGC collects unreferenced uploads every interval until ctx is cancelled
+func RunUploadGC(ctx context.Context, db *sql.DB, grace, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		report, err := CollectUploadGarbage(ctx, db, UploadStore(), grace, false)
+		if err != nil {
+			logging.FromContext(ctx).Error("upload GC failed", "err", err)
+		} else if len(report.Orphans) > 0 {
+			logging.FromContext(ctx).Info("upload GC removed files", "unreferenced", len(report.Orphans), "removed", report.Deleted, "bytes", report.BytesFreed)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
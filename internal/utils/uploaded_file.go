@@ -1,17 +1,23 @@
 package utils
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io"
+	"forum/internal/metrics"
+	"forum/internal/storage"
 	"log"
 	"mime/multipart"
-	"net/http"
-	"os"
-	"path/filepath"
+	"path"
 	"strings"
-	"time"
+	"sync"
 )
 
+// SaveUploadedFile validates an uploaded image, re-encodes it and writes it
+// together with its thumbnails to the upload store. The client's Content-Type
+// and file name are not trusted: the format is detected from the decoded data.
 func SaveUploadedFile(file multipart.File, fileHeader *multipart.FileHeader) (string, error) {
 	log.Printf("=== SaveUploadedFile called ===")
 	log.Printf("Filename: %s", fileHeader.Filename)
@@ -24,109 +30,124 @@ func SaveUploadedFile(file multipart.File, fileHeader *multipart.FileHeader) (st
 		return "", fmt.Errorf("file is too large")
 	}
 
-	// Validate file type
-	allowedTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/jpg":  true,
-		"image/png":  true,
-		"image/gif":  true,
+	img, err := ProcessImage(file)
+	if err != nil {
+		log.Printf("ERROR: Rejected upload %s: %v", fileHeader.Filename, err)
+		return "", err
+	}
+	log.Printf("Decoded %s image %dx%d", img.Format, img.Width, img.Height)
+
+	// Files are named by the hash of their processed content, so posting the
+	// same image twice stores it once. Unused files are left to the upload GC,
+	// which keeps files written within its grace period: a file that already
+	// exists is written again, so the GC does not remove it before the new row
+	// references it.
+	sum := sha256.Sum256(img.Data)
+	filename := hex.EncodeToString(sum[:]) + ImageExtension(img.Format)
+	relativePath := UploadsURLPrefix + filename
+
+	blobs := map[string][]byte{filename: img.Data}
+	for size, data := range img.Thumbnails {
+		blobs[path.Base(ThumbnailPath(relativePath, size))] = data
 	}
 
-	// For content type detection, use the filename extension as fallback
-	contentType := fileHeader.Header.Get("Content-Type")
-	log.Printf("Content-Type from header: %s", contentType)
-
-	// If no content type in header, detect from filename extension
-	if contentType == "" {
-		ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
-		switch ext {
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".png":
-			contentType = "image/png"
-		case ".gif":
-			contentType = "image/gif"
-		default:
-			// Read first 512 bytes for detection
-			buffer := make([]byte, 512)
-			n, readErr := file.Read(buffer)
-			if readErr != nil && readErr != io.EOF {
-				log.Printf("ERROR: Failed to read file for type detection: %v", readErr)
-				return "", fmt.Errorf("failed to read file")
-			}
-			contentType = http.DetectContentType(buffer[:n])
-
-			// Reset file to beginning by reopening it
-			file.Close()
-			var reopenErr error
-			file, reopenErr = fileHeader.Open()
-			if reopenErr != nil {
-				log.Printf("ERROR: Failed to reopen file: %v", reopenErr)
-				return "", fmt.Errorf("failed to reopen file")
-			}
+	store := UploadStore()
+	ctx := context.Background()
+	for key, data := range blobs {
+		if err := store.Put(ctx, key, bytes.NewReader(data), int64(len(data)), storage.ContentType(key)); err != nil {
+			log.Printf("ERROR: Failed to store %s: %v", key, err)
+			return "", fmt.Errorf("failed to create file")
 		}
 	}
-	log.Printf("Detected content type: %s", contentType)
 
-	if !allowedTypes[contentType] {
-		log.Printf("ERROR: Unsupported file type: %s", contentType)
-		return "", fmt.Errorf("unsupported file type")
-	}
+	log.Printf("Successfully saved file: %s (%d bytes)", relativePath, len(img.Data))
+	metrics.UploadBytes.With("image").Add(float64(len(img.Data)))
+	return relativePath, nil
+}
 
-	// Generate unique filename
-	ext := filepath.Ext(fileHeader.Filename)
-	if ext == "" {
-		switch contentType {
-		case "image/jpeg":
-			ext = ".jpg"
-		case "image/png":
-			ext = ".png"
-		case "image/gif":
-			ext = ".gif"
-		default:
-			ext = ".jpg"
+// UploadsURLPrefix is the URL path uploads are served under
+const UploadsURLPrefix = "/static/uploads/"
+
+var (
+	uploadStoreMu sync.Mutex
+	uploadStore   storage.BlobStore
+)
+
+// SetUploadStore replaces the store uploads are written to
+func SetUploadStore(store storage.BlobStore) {
+	uploadStoreMu.Lock()
+	defer uploadStoreMu.Unlock()
+	uploadStore = store
+}
+
+// UploadStore returns the configured store, falling back to the local
+// filesystem under storage.DefaultLocalRoot
+func UploadStore() storage.BlobStore {
+	uploadStoreMu.Lock()
+	defer uploadStoreMu.Unlock()
+	if uploadStore == nil {
+		store, err := storage.NewLocalStore(storage.DefaultLocalRoot())
+		if err != nil {
+			log.Printf("ERROR: Failed to create uploads directory: %v", err)
 		}
+		uploadStore = store
 	}
+	return uploadStore
+}
 
-	// Generate unique filename using timestamp + random number
-	filename := fmt.Sprintf("%d%s", time.Now().UnixNano(), ext)
-	log.Printf("Generated filename: %s", filename)
-
-	// Create uploads directory if it doesn't exist
-	exe, _ := os.Executable()
-	exeDir := filepath.Dir(exe)
-	uploadsDir := filepath.Join(exeDir, "static", "uploads")
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		log.Printf("ERROR: Failed to create uploads directory: %v", err)
-		return "", fmt.Errorf("failed to create uploads directory")
+// UploadsDir returns the directory the local store writes uploaded files to
+func UploadsDir() string {
+	if local, ok := UploadStore().(*storage.LocalStore); ok {
+		return local.Root()
 	}
+	return storage.DefaultLocalRoot()
+}
 
-	// Full file path
-	filePath := filepath.Join(uploadsDir, filename)
-	log.Printf("Full file path: %s", filePath)
+// UploadKey converts a path returned by SaveUploadedFile into a store key
+func UploadKey(relativePath string) (string, error) {
+	if !strings.HasPrefix(relativePath, UploadsURLPrefix) {
+		return "", fmt.Errorf("invalid upload path %q", relativePath)
+	}
+	return storage.CleanKey(strings.TrimPrefix(relativePath, UploadsURLPrefix))
+}
 
-	// Create the file
-	dst, err := os.Create(filePath)
-	if err != nil {
-		log.Printf("ERROR: Failed to create file %s: %v", filePath, err)
-		return "", fmt.Errorf("failed to create file")
+// uploadVariantKeys returns the keys derived from an upload: the sizes of a
+// processed avatar, or the thumbnails of any other image. Attachments have none.
+func uploadVariantKeys(key string) []string {
+	if IsAttachmentKey(key) {
+		return nil
+	}
+	var keys []string
+	if isProcessedAvatar(key) {
+		for _, size := range AvatarSizes {
+			if variant := AvatarSizePath(key, size); variant != key {
+				keys = append(keys, variant)
+			}
+		}
+		return keys
+	}
+	for _, size := range ThumbnailSizes {
+		keys = append(keys, ThumbnailPath(key, size.Name))
 	}
-	defer dst.Close()
+	return keys
+}
 
-	// Write file content to disk
-	log.Println("== START COPY ==")
-	bytesWritten, err := io.Copy(dst, file)
-	log.Println("== END COPY ==")
+// RemoveUploadedFile deletes a file by the relative path SaveUploadedFile,
+// SaveAvatar or SaveAttachment returned, along with its thumbnails or avatar
+// sizes. A file that is already gone is not an error.
+// Uploads are shared between identical images, so callers that drop a
+// reference should use ReleaseUpload instead.
+func RemoveUploadedFile(relativePath string) error {
+	key, err := UploadKey(relativePath)
 	if err != nil {
-		log.Printf("ERROR: Failed to copy file content: %v", err)
-		return "", fmt.Errorf("failed to copy file content")
+		return err
 	}
 
-	log.Printf("Successfully saved file: %s (%d bytes written)", filePath, bytesWritten)
-
-	// Return relative path for database storage
-	relativePath := "/static/uploads/" + filename
-	log.Printf("Returning relative path: %s", relativePath)
-
-	return relativePath, nil
+	store := UploadStore()
+	for _, k := range append([]string{key}, uploadVariantKeys(key)...) {
+		if err := store.Delete(context.Background(), k); err != nil {
+			return err
+		}
+	}
+	return nil
 }
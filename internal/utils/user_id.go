@@ -2,7 +2,6 @@ package utils
 
 import (
 	"errors"
-	"log"
 	"net/http"
 )
 
@@ -34,7 +33,6 @@ func MustGetUserID(w http.ResponseWriter, r *http.Request) (int, bool) {
 	if err != nil {
 		return 0, false
 	}
-	log.Printf("MustGetUserID in MustGetUserID: %v", userID)
 	return userID, true
 }
 
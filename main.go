@@ -1,40 +1,62 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"flag"
+	"fmt"
 	"forum/database"
 	"forum/internal"
+	"forum/internal/config"
 	"forum/internal/handlers"
+	"forum/internal/logging"
+	"forum/internal/metrics"
 	"forum/internal/middleware"
 	"forum/internal/models"
+	"forum/internal/scanner"
+	"forum/internal/security"
+	"forum/internal/storage"
 	"forum/internal/utils"
-	"github.com/joho/godotenv"
 	_ "github.com/mutecomm/go-sqlcipher/v4"
 	"log"
 	"mime"
 	"net/http"
+	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
-	"text/template"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
 type App struct {
-	DB *sql.DB
+	DB     *sql.DB
+	Config *config.Config
+	// AttachmentTypes are parsed from Config.Uploads.AttachmentTypes
+	AttachmentTypes []models.AttachmentType
+	// Hub tracks WebSocket connections so they can be closed on shutdown
+	Hub *handlers.Hub
 }
 
 var databaseInitialized bool = false // Global variable to check if initialization has occurred
 
 // NewApp opens the database, creates indexes, and returns App
-func NewApp() (*App, error) {
-	db, err := utils.OpenDatabase()
+func NewApp(cfg *config.Config) (*App, error) {
+	attachmentTypes, err := utils.ParseAttachmentTypes(cfg.Uploads.AttachmentTypes)
+	if err != nil {
+		return nil, fmt.Errorf("ATTACHMENT_TYPES: %w", err)
+	}
+
+	db, err := utils.OpenDatabase(cfg.Database)
 	if err != nil {
 		return nil, err
 	}
 
 	utils.EnsureIndexes(db)
 
-	return &App{DB: db}, nil
+	return &App{DB: db, Config: cfg, AttachmentTypes: attachmentTypes, Hub: handlers.NewHub()}, nil
 }
 
 func init() {
@@ -83,14 +105,7 @@ func staticFileHandler(w http.ResponseWriter, r *http.Request) {
 
 // Handler function to process requests
 func (app *App) handler(w http.ResponseWriter, r *http.Request) {
-	// Open the database connection
-	db, err := utils.OpenDatabase()
-	if err != nil {
-		log.Printf("Error opening DB: %v", err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
-	}
-	defer db.Close()
+	db := app.DB
 
 	// Get current user from session
 	currentUser, _ := utils.GetUserFromSession(w, r, app.DB) // Ignore error if not logged in
@@ -114,7 +129,6 @@ func (app *App) handler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal server error", http.StatusInternalServerError)
 		return
 	}
-	log.Println("DEBUG: Fetched categories-main")
 
 	// We get a list of posts from the database
 	posts, err := handlers.GetPosts(db, currentUser)
@@ -133,49 +147,49 @@ func (app *App) handler(w http.ResponseWriter, r *http.Request) {
 		CurrentUser: currentUser,
 		Categories:  categories,
 	}
-	// Download the post creation page template
-	tmpl, err := template.ParseFiles(
-		"templates/layout.html",
-		"templates/index.html",
-		"templates/header.html",
-		"templates/nav.html",
-		"templates/post_list.html",
-		"templates/post_list_item.html",
-		"templates/filters.html",
-		"templates/notifications.html",
-	)
-	if err != nil {
-		errors.RenderError(w, http.StatusInternalServerError, "Internal Server Error", "Template not load.")
-		return
-	}
-	tmpl.ExecuteTemplate(w, "layout", data)
+	utils.Render(w, r, "home", data)
 }
 
 func setupRoutes(app *App) *http.ServeMux {
-	// Create WebSocket hub
-	hub := handlers.NewHub()
-	go hub.Run()
+	hub := app.Hub
+	metrics.WebSocketConnections.Set(func() float64 { return float64(hub.ActiveConnections()) })
 	mux := http.NewServeMux()
 
 	// Home
 	mux.HandleFunc("/", middleware.AuthMiddleware(app.DB, app.handler))
 
 	// Posting
-	mux.HandleFunc("/create", middleware.AuthMiddleware(app.DB, handlers.ServeFormCreatePost(app.DB)))
-	mux.HandleFunc("/createin", middleware.AuthMiddleware(app.DB, handlers.HandlerCreatePost(app.DB)))
+	mux.HandleFunc("/create", middleware.AuthMiddleware(app.DB, handlers.ServeFormCreatePost(app.DB, app.AttachmentTypes)))
+	mux.HandleFunc("/createin", middleware.AuthMiddleware(app.DB, handlers.HandlerCreatePost(app.DB, app.AttachmentTypes)))
 	mux.HandleFunc("/post_page/", middleware.AuthMiddleware(app.DB, handlers.ServePostByID(app.DB)))
 	mux.HandleFunc("/delete_post/", middleware.AuthMiddleware(app.DB, handlers.HandlerDeletePost(app.DB)))
-	mux.HandleFunc("/edit_post/", middleware.AuthMiddleware(app.DB, handlers.EditPostHandler(app.DB)))
+	mux.HandleFunc("/edit_post/", middleware.AuthMiddleware(app.DB, handlers.EditPostHandler(app.DB, app.AttachmentTypes)))
 	mux.HandleFunc("/filters_page", middleware.AuthMiddleware(app.DB, handlers.HandlePostsFilter(app.DB)))
 	mux.HandleFunc("/create-comment", middleware.AuthMiddleware(app.DB, handlers.CreateCommentHandler(app.DB)))
 	mux.HandleFunc("/delete_comment/", middleware.AuthMiddleware(app.DB, handlers.HandlerDeleteComment(app.DB)))
 	mux.HandleFunc("/edit_comment/", middleware.AuthMiddleware(app.DB, handlers.UpdateCommentHandler(app.DB)))
 	mux.HandleFunc("/like", middleware.AuthMiddleware(app.DB, handlers.HandleReaction(app.DB)))
+	mux.HandleFunc("/post_images/reorder", middleware.AuthMiddleware(app.DB, handlers.HandlerReorderImages(app.DB)))
+	mux.HandleFunc("/post_images/primary", middleware.AuthMiddleware(app.DB, handlers.HandlerSetPrimaryImage(app.DB)))
+	mux.HandleFunc("/post_images/text", middleware.AuthMiddleware(app.DB, handlers.HandlerUpdateImageText(app.DB)))
+	mux.HandleFunc("/attachments/", middleware.AuthMiddleware(app.DB, handlers.DownloadAttachment(app.DB)))
+	mux.HandleFunc("/post_state", middleware.AuthMiddleware(app.DB, handlers.HandlerPostState(app.DB)))
+	mux.HandleFunc("/moderate/move", middleware.AuthMiddleware(app.DB, handlers.HandlerMovePost(app.DB)))
+	mux.HandleFunc("/moderate/merge", middleware.AuthMiddleware(app.DB, handlers.HandlerMergePost(app.DB)))
+	mux.HandleFunc("/moderate/split", middleware.AuthMiddleware(app.DB, handlers.HandlerSplitComments(app.DB)))
+
+	// Revision history
+	mux.HandleFunc("/post_history/", middleware.AuthMiddleware(app.DB, handlers.HandlerPostHistory(app.DB)))
+	mux.HandleFunc("/comment_history/", middleware.AuthMiddleware(app.DB, handlers.HandlerCommentHistory(app.DB)))
+	mux.HandleFunc("/rollback_post", middleware.AuthMiddleware(app.DB, handlers.HandlerRollbackPost(app.DB)))
+	mux.HandleFunc("/rollback_comment", middleware.AuthMiddleware(app.DB, handlers.HandlerRollbackComment(app.DB)))
 
 	// Account
 	mux.HandleFunc("/profile", middleware.AuthMiddleware(app.DB, handlers.HandlerProfile(app.DB)))
-	mux.HandleFunc("/upload_avatar", middleware.AuthMiddleware(app.DB, handlers.UploadAvatarHandler(app.DB)))
+	mux.HandleFunc("/upload_avatar", middleware.AuthMiddleware(app.DB, handlers.UploadAvatarHandler(app.DB, app.Config.Uploads.AnimatedAvatars)))
+	mux.HandleFunc("/identicon/", handlers.IdenticonHandler())
 	mux.HandleFunc("/user_page", middleware.AuthMiddleware(app.DB, handlers.HandlerUser(app.DB)))
+	mux.HandleFunc("/badges", middleware.AuthMiddleware(app.DB, handlers.BadgesHandler(app.DB)))
 
 	// Authentication
 	mux.HandleFunc("/register", handlers.ServeFormRegister(app.DB))
@@ -188,9 +202,10 @@ func setupRoutes(app *App) *http.ServeMux {
 	mux.HandleFunc("/auth/github/callback", handlers.HandleGitHubCallback(app.DB))
 	mux.HandleFunc("/logout", middleware.AuthMiddleware(app.DB, handlers.LogoutHandler(app.DB)))
 	mux.HandleFunc("/forgot-password", handlers.ForgotPasswordHandler(app.DB))
-	mux.HandleFunc("/forgot-password-submit", handlers.ForgotPasswordSubmitHandler(app.DB))
+	mux.HandleFunc("/forgot-password-submit", handlers.ForgotPasswordSubmitHandler(app.DB, app.Config.Email, app.Config.BaseURL))
 	mux.HandleFunc("/reset-password", handlers.ResetPasswordHandler(app.DB))
 	mux.HandleFunc("/reset-password-submit", handlers.ResetPasswordSubmitHandler(app.DB))
+	mux.HandleFunc("/pow/challenge", middleware.PoWChallenge())
 
 	// Search
 	mux.HandleFunc("/search", middleware.AuthMiddleware(app.DB, handlers.HandlerSearch(app.DB)))
@@ -208,6 +223,15 @@ func setupRoutes(app *App) *http.ServeMux {
 	mux.HandleFunc("/admin/categories/delete", middleware.AuthMiddleware(app.DB, handlers.DeleteCategoryHandler(app.DB)))
 	mux.HandleFunc("/admin/ban", middleware.AuthMiddleware(app.DB, handlers.BanUserHandler(app.DB)))
 	mux.HandleFunc("/admin/unban", middleware.AuthMiddleware(app.DB, handlers.UnbanUserHandler(app.DB)))
+	mux.HandleFunc("/admin/trash", middleware.AuthMiddleware(app.DB, handlers.AdminTrashPage(app.DB, app.Config.Content.TrashRetention)))
+	mux.HandleFunc("/admin/trash/restore", middleware.AuthMiddleware(app.DB, handlers.RestoreFromTrashHandler(app.DB)))
+	mux.HandleFunc("/admin/moderation_log", middleware.AuthMiddleware(app.DB, handlers.AdminModerationLogPage(app.DB)))
+	mux.HandleFunc("/admin/uploads", middleware.AuthMiddleware(app.DB, handlers.AdminUploadGCPage(app.DB, app.Config.Uploads.GCGrace)))
+	mux.HandleFunc("/admin/blocklist", middleware.AuthMiddleware(app.DB, handlers.AdminBlocklistPage(app.DB)))
+	mux.HandleFunc("/admin/blocklist/add", middleware.AuthMiddleware(app.DB, handlers.AddIPBlockHandler(app.DB)))
+	mux.HandleFunc("/admin/blocklist/remove", middleware.AuthMiddleware(app.DB, handlers.RemoveIPBlockHandler(app.DB)))
+	mux.HandleFunc("/admin/review", middleware.AuthMiddleware(app.DB, handlers.AdminReviewQueuePage(app.DB, app.Config.Spam.BayesMinTraining)))
+	mux.HandleFunc("/admin/review/decide", middleware.AuthMiddleware(app.DB, handlers.ReviewHeldContentHandler(app.DB, app.Config.PreMod.TrustAfter)))
 
 	// Notifications
 	mux.HandleFunc("/notifications", middleware.AuthMiddleware(app.DB, handlers.HandlerGetNotifications(app.DB)))
@@ -219,68 +243,347 @@ func setupRoutes(app *App) *http.ServeMux {
 }
 
 func main() {
-	if err := godotenv.Load(".env"); err != nil {
-		log.Fatal("❌ Error loading .env file:", err)
+	cfg, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		log.Fatal("❌ Configuration error: ", err)
+	}
+	if cfg.PrintOnly {
+		fmt.Print(cfg.Dump())
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal("❌ ", err)
 	}
+	logger, err := logging.Setup(cfg.Log)
+	if err != nil {
+		log.Fatal("❌ Logger setup failed: ", err)
+	}
+	if cfg.File != "" {
+		logger.Info("configuration loaded", "file", cfg.File)
+	}
+
+	// Sessions signed with a random key do not survive a restart, which is
+	// acceptable in development only; Validate requires a key in production
+	hmacSecret := cfg.Session.HMACSecret.Value()
+	if hmacSecret == "" {
+		logger.Warn("SESSION_HMAC_SECRET is not set, using a random key; sessions end when the server restarts")
+		hmacSecret = utils.GenerateToken()
+	}
+	security.InitHMACSecret(hmacSecret)
+	// Cookies are Secure whenever clients reach the forum over HTTPS: when it
+	// terminates TLS itself, and in production behind a TLS proxy
+	security.SetSecureCookies(cfg.TLS.Enabled() || cfg.Production())
 
-	utils.LoadAndVerify()
+	utils.InitOAuthConfigs(cfg.Google, cfg.GitHub)
+	middleware.SetRateLimit(cfg.RateLimit.Interval, cfg.RateLimit.Burst)
 
-	utils.InitOAuthConfigs()
+	// Uploads go to the backend selected by BLOB_BACKEND (local disk by default)
+	store, err := storage.Open(cfg.Storage)
+	if err != nil {
+		log.Fatal("❌ Blob store setup failed:", err)
+	}
+	utils.SetUploadStore(store)
+
+	// Attachments must pass the scanner selected by SCANNER_BACKEND before they are published
+	attachmentScanner, err := scanner.Open(cfg.Scanner)
+	if err != nil {
+		log.Fatal("❌ Scanner setup failed:", err)
+	}
+	if clamd, ok := attachmentScanner.(*scanner.Clamd); ok {
+		if err := clamd.Ping(context.Background()); err != nil {
+			logger.Warn("clamd is not reachable, attachments will be refused until it is", "err", err)
+		}
+	}
+	utils.SetAttachmentScanner(attachmentScanner)
 
 	// Initialize database
-	if err := db.SetupDatabase(); err != nil {
+	if err := db.SetupDatabase(cfg.Database); err != nil {
 		log.Fatal("❌ Database setup failed:", err)
 	}
 
-	app, err := NewApp()
+	app, err := NewApp(cfg)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer app.DB.Close()
+	// Stricter limits for routes such as logins on top of the default one,
+	// shared between instances when RATE_LIMIT_STORE=sqlite
+	routeLimits, _ := cfg.RateLimit.RoutePolicies() // checked by Validate
+	policies := make(map[string]middleware.Policy, len(routeLimits))
+	for _, p := range routeLimits {
+		policies[p.Path] = middleware.Policy{Burst: p.Requests, Interval: p.Period / time.Duration(p.Requests), PerUser: p.PerUser}
+	}
+	middleware.SetRouteRateLimits(policies)
+	middleware.SetRateLimitUser(func(r *http.Request) int {
+		userID, _ := security.ValidateSession(app.DB, r)
+		return userID
+	})
+	if cfg.RateLimit.Store == config.LimitStoreSQLite {
+		middleware.SetRateLimitStore(middleware.NewSQLiteLimitStore(app.DB))
+	}
+	middleware.SetProofOfWork(middleware.PoWPolicy{
+		Enabled:       cfg.PoW.Enabled,
+		Threshold:     cfg.PoW.Threshold,
+		Window:        cfg.PoW.Window,
+		Difficulty:    cfg.PoW.Difficulty,
+		MaxDifficulty: cfg.PoW.MaxDifficulty,
+		TTL:           cfg.PoW.TTL,
+	})
+	// Blocks added on another instance apply here within a minute
+	utils.InitIPBlocklist(app.DB, time.Minute)
+	utils.SetRequestIP(middleware.ClientIP)
+	var checks []utils.SpamCheck
+	if cfg.PreMod.Enabled() {
+		checks = append(checks, utils.PreModeration{FirstPosts: cfg.PreMod.FirstPosts, Categories: cfg.PreMod.CategoryList()})
+	}
+	if cfg.Spam.Enabled {
+		checks = append(checks, spamChecks(cfg.Spam)...)
+	}
+	if len(checks) > 0 {
+		utils.SetSpamFilter(utils.NewSpamFilter(checks...))
+	}
+	// Validate has already checked the thresholds
+	thresholds, _ := cfg.Trust.Thresholds()
+	utils.SetTrustPolicy(utils.TrustPolicy{
+		Thresholds: thresholds,
+		Required: map[utils.Capability]int{
+			utils.CapImages: cfg.Trust.ImagesLevel,
+			utils.CapLinks:  cfg.Trust.LinksLevel,
+			utils.CapTags:   cfg.Trust.TagsLevel,
+			utils.CapWiki:   cfg.Trust.WikiLevel,
+		},
+	})
 	// Initialize error templates
-	errors.Init("templates/error.html")
+	errors.Init(filepath.Join(cfg.Templates.Dir, "error.html"))
+	// Parse every page now, so a missing or broken template stops the start
+	if err := utils.InitTemplates(cfg.Templates.Dir, cfg.Templates.Reload); err != nil {
+		log.Fatal("❌ Template setup failed: ", err)
+	}
+	if cfg.Templates.Reload {
+		logger.Warn("templates are reloaded when they change; do not use this in production")
+	}
+
+	// SIGINT and SIGTERM cancel ctx, which stops the background jobs and
+	// starts the shutdown below
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	mux := setupRoutes(app)
 
+	// Probes for the orchestrator; /readyz fails as soon as shutdown starts
+	// so no new traffic is routed here while requests drain
+	var draining atomic.Bool
+	mux.HandleFunc("/healthz", handlers.Healthz())
+	mux.HandleFunc("/readyz", handlers.Readyz(app.readinessChecks(&draining)...))
+
+	var background sync.WaitGroup
+	runInBackground := func(job func(context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			job(ctx)
+		}()
+	}
+	runInBackground(func(context.Context) { app.Hub.Run() })
+	runInBackground(middleware.RunVisitorCleanup)
+	// Permanently remove content that has been in the trash past the retention period
+	runInBackground(func(ctx context.Context) {
+		utils.RunTrashPurge(ctx, app.DB, cfg.Content.TrashRetention, cfg.Content.SweepInterval)
+	})
+	runInBackground(func(ctx context.Context) {
+		utils.RunArchiver(ctx, app.DB, cfg.Content.ArchiveAfter, cfg.Content.SweepInterval)
+	})
+	// Account age only changes reputation with time, so it is swept
+	runInBackground(func(ctx context.Context) {
+		utils.RunReputationSweep(ctx, app.DB, cfg.Trust.RecalcInterval)
+	})
+	runInBackground(func(ctx context.Context) {
+		utils.RunBadgeAwards(ctx, app.DB, cfg.Badges.Interval)
+	})
+	// Remove uploaded files nothing has referenced for the grace period
+	runInBackground(func(ctx context.Context) {
+		utils.RunUploadGC(ctx, app.DB, cfg.Uploads.GCGrace, cfg.Uploads.GCInterval)
+	})
+
+	// Uploads are served from the blob store, everything else from disk
+	mux.HandleFunc("/static/uploads/", handlers.ServeUploads(cfg.Storage.URLExpiry))
 	// Add static file handler with correct MIME types
 	mux.HandleFunc("/static/", staticFileHandler)
 
-	// Server with middleware: RateLimit + ForceHTTPS
-	handler := middleware.RateLimit(mux)
+	// Servers report fatal errors here; a nil error never arrives
+	serveErr := make(chan error, 3)
+	var servers []*http.Server
+
+	// Prometheus metrics, on a private listener or behind a bearer token
+	metricsHandler := metrics.Handler(metrics.Default, cfg.Metrics.Token.Value())
+	switch {
+	case cfg.Metrics.Addr != "":
+		metricsMux := http.NewServeMux()
+		metricsMux.Handle("/metrics", metricsHandler)
+		metricsServer := &http.Server{Addr: cfg.Metrics.Addr, Handler: metricsMux, ReadTimeout: cfg.Server.ReadTimeout}
+		servers = append(servers, metricsServer)
+		go serve(metricsServer, serveErr)
+		logger.Info("metrics listener started", "addr", cfg.Metrics.Addr)
+	case cfg.Metrics.Enabled():
+		mux.Handle("/metrics", metricsHandler)
+	}
+
+	trustedProxies, _ := cfg.Server.ProxyPrefixes() // checked by Validate
+
+	// Server with middleware: TrustProxies + RequestLogger + HSTS + SecurityHeaders + Metrics + Blocklist + RateLimit + ProofOfWork
+	handler := middleware.TrustProxies(trustedProxies,
+		middleware.RequestLogger(
+			middleware.HSTS(cfg.TLS.HSTSMaxAge, cfg.TLS.HSTSSubdomains,
+				middleware.SecurityHeaders(
+					middleware.Metrics(middleware.Blocklist(middleware.RateLimit(middleware.ProofOfWork(mux))))))))
 
 	server := &http.Server{
-		Addr:         ":8080", // port for local HTTP
+		Addr:         cfg.Server.Addr,
 		Handler:      handler, // your mux with middleware
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  120 * time.Second,
-	}
-
-	// Start the server on port 8080
-	log.Println("Server started on http://localhost:8080")
-	log.Fatal(server.ListenAndServe()) // run without TLS
-
-	// Configuring TLS with autocert for the yourforum.com domain
-	// handler := middleware.RateLimit(middleware.ForceHTTPS(mux))
-	// tlsConfig := security.SetupTLS("yourforum.com")
-	// server := &http.Server{
-	//     Addr:         ":443",
-	//     Handler:      handler,
-	//     TLSConfig:    tlsConfig,
-	//     ReadTimeout:  5 * time.Second,
-	//     WriteTimeout: 10 * time.Second,
-	//     IdleTimeout:  120 * time.Second,
-	// }
-
-	// // HTTP -> HTTPS редірект
-	// go func() {
-	//     log.Fatal(http.ListenAndServe(":80", http.HandlerFunc(redirectToHTTPS)))
-	// }()
-
-	// fmt.Println("Server started on port 8080...")
-	// log.Fatal(server.ListenAndServeTLS("", "")) // Сертифікати через autocert або інші
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+		IdleTimeout:  cfg.Server.IdleTimeout,
+	}
+
+	// Hijacked WebSocket connections are not drained by Shutdown
+	server.RegisterOnShutdown(app.Hub.Close)
+	servers = append(servers, server)
+
+	// TLS_MODE picks where certificates come from; with off the forum
+	// expects a proxy in front of it to terminate TLS
+	redirect := middleware.RedirectToHTTPS(cfg.Server.Addr)
+	switch cfg.TLS.Mode {
+	case config.TLSFiles:
+		certs, err := security.NewCertReloader(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.Fatal("❌ TLS setup failed: ", err)
+		}
+		server.TLSConfig = security.TLSConfig(certs.GetCertificate)
+		runInBackground(func(ctx context.Context) { certs.Run(ctx, cfg.TLS.ReloadInterval) })
+	case config.TLSAutocert:
+		manager := security.NewAutocertManager(cfg.TLS)
+		server.TLSConfig = security.AutocertTLSConfig(manager)
+		// The redirect listener also answers http-01 challenges
+		redirect = manager.HTTPHandler(redirect)
+	}
+	if cfg.TLS.Enabled() && cfg.TLS.RedirectAddr != "" {
+		redirectServer := &http.Server{
+			Addr:         cfg.TLS.RedirectAddr,
+			Handler:      redirect,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+		}
+		servers = append(servers, redirectServer)
+		go serve(redirectServer, serveErr)
+		logger.Info("redirecting HTTP to HTTPS", "addr", cfg.TLS.RedirectAddr)
+	}
+
+	go serve(server, serveErr)
+	logger.Info("server started", "addr", cfg.Server.Addr, "env", cfg.Env, "tls", cfg.TLS.Mode)
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		logger.Info("shutting down", "timeout", cfg.Server.ShutdownTimeout)
+	case err := <-serveErr:
+		logger.Error("server failed, shutting down", "err", err)
+		exitCode = 1
+	}
+	stop()
+	draining.Store(true)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if err := s.Shutdown(shutdownCtx); err != nil {
+			logger.Error("requests did not finish in time", "addr", s.Addr, "err", err)
+			exitCode = 1
+		}
+	}
+	app.Hub.Close()
+	background.Wait()
+
+	if err := app.DB.Close(); err != nil {
+		logger.Error("closing the database failed", "err", err)
+		exitCode = 1
+	}
+	logger.Info("shutdown complete")
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
 
+// serve runs s until it is shut down and reports any other error on errs.
+// Servers with a TLS config serve HTTPS with the certificates it provides.
+func serve(s *http.Server, errs chan<- error) {
+	var err error
+	if s.TLSConfig != nil {
+		err = s.ListenAndServeTLS("", "")
+	} else {
+		err = s.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		errs <- fmt.Errorf("%s: %w", s.Addr, err)
+	}
 }
-func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
-	http.Redirect(w, r, "https://"+r.Host+r.URL.String(), http.StatusMovedPermanently)
+
+// spamChecks builds the spam checks new posts and comments pass. The
+// patterns file was read once already when the configuration was validated.
+func spamChecks(c config.Spam) []utils.SpamCheck {
+	patterns, err := c.BannedPatternList()
+	if err != nil {
+		log.Fatal("❌ Spam patterns: ", err)
+	}
+	banned := utils.SpamReject
+	if c.BannedAction == config.SpamActionHold {
+		banned = utils.SpamHold
+	}
+	return []utils.SpamCheck{
+		utils.NewBannedContent(c.BannedWordList(), patterns, banned),
+		utils.LinkLimit{NewAccountAge: c.NewAccountAge, MaxLinks: c.NewAccountMaxLinks},
+		utils.DuplicateContent{Window: c.DuplicateWindow, MinLength: 20},
+		utils.SpamClassifier{
+			MinTraining: c.BayesMinTraining,
+			Hold:        float64(c.BayesHoldPercent) / 100,
+			Reject:      float64(c.BayesRejectPercent) / 100,
+		},
+	}
+}
+
+// readinessChecks are what /readyz verifies: the database answers, its
+// schema is up to date, the templates are in place, and the server is not
+// shutting down
+func (app *App) readinessChecks(draining *atomic.Bool) []handlers.ReadinessCheck {
+	return []handlers.ReadinessCheck{
+		{Name: "database", Check: func(ctx context.Context) error {
+			return app.DB.PingContext(ctx)
+		}},
+		{Name: "migrations", Check: func(ctx context.Context) error {
+			pending, err := db.PendingColumnMigrations(app.DB)
+			if err != nil {
+				return err
+			}
+			if len(pending) > 0 {
+				return fmt.Errorf("pending: %s", strings.Join(pending, ", "))
+			}
+			return nil
+		}},
+		{Name: "templates", Check: func(ctx context.Context) error {
+			if errors.ErrorTmpl == nil {
+				return fmt.Errorf("error template not loaded")
+			}
+			if utils.Templates == nil {
+				return fmt.Errorf("page templates not loaded")
+			}
+			return nil
+		}},
+		{Name: "shutdown", Check: func(ctx context.Context) error {
+			if draining.Load() {
+				return fmt.Errorf("shutting down")
+			}
+			return nil
+		}},
+	}
 }
@@ -0,0 +1,38 @@
+// Behaviour declared with data- attributes. The Content-Security-Policy
+// forbids inline event handlers, so templates use these instead:
+//
+//   data-confirm="Text"         ask before a form is submitted or a button acts
+//   data-prompt-reason="Text"   ask for a reason and put it in the form's "reason" field
+//   data-action="fnName"        call a global function, e.g. closeModal
+//   data-href="/path"           navigate on click
+document.addEventListener("click", function (event) {
+  const el = event.target.closest("[data-confirm]:not(form), [data-prompt-reason], [data-action], [data-href]");
+  if (!el) return;
+
+  if (el.dataset.confirm && !confirm(el.dataset.confirm)) {
+    event.preventDefault();
+    return;
+  }
+  if (el.dataset.promptReason) {
+    const reason = prompt(el.dataset.promptReason);
+    if (reason === null) {
+      event.preventDefault();
+      return;
+    }
+    if (el.form && el.form.reason) el.form.reason.value = reason;
+  }
+  if (el.dataset.action) {
+    const fn = window[el.dataset.action];
+    if (typeof fn === "function") fn.call(el, event);
+  }
+  if (el.dataset.href) {
+    location.href = el.dataset.href;
+  }
+});
+
+document.addEventListener("submit", function (event) {
+  const form = event.target;
+  if (form.dataset && form.dataset.confirm && !confirm(form.dataset.confirm)) {
+    event.preventDefault();
+  }
+});
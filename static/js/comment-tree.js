@@ -0,0 +1,47 @@
+document.addEventListener('DOMContentLoaded', function () {
+    const section = document.querySelector('.comments-section');
+    if (!section) return;
+
+    // Collapse state is remembered per post; it overrides the server default
+    const storageKey = 'collapsed-comments-' + section.dataset.postId;
+    let saved = {};
+    try {
+        saved = JSON.parse(localStorage.getItem(storageKey)) || {};
+    } catch (e) {
+        saved = {};
+    }
+
+    function setCollapsed(comment, collapsed) {
+        comment.classList.toggle('collapsed', collapsed);
+        const toggle = comment.querySelector(':scope > .comment-meta > .collapse-toggle');
+        if (toggle) {
+            toggle.textContent = collapsed ? '[+]' : '[−]';
+            toggle.title = collapsed ? 'Expand thread' : 'Collapse thread';
+        }
+    }
+
+    section.querySelectorAll('.comment').forEach(function (comment) {
+        const id = comment.dataset.commentId;
+        if (id in saved) {
+            setCollapsed(comment, saved[id]);
+        } else {
+            setCollapsed(comment, comment.dataset.defaultCollapsed === 'true');
+        }
+    });
+
+    section.querySelectorAll('.collapse-toggle').forEach(function (btn) {
+        btn.addEventListener('click', function () {
+            const comment = this.closest('.comment');
+            const collapsed = !comment.classList.contains('collapsed');
+            setCollapsed(comment, collapsed);
+
+            const id = comment.dataset.commentId;
+            if (collapsed === (comment.dataset.defaultCollapsed === 'true')) {
+                delete saved[id];
+            } else {
+                saved[id] = collapsed;
+            }
+            localStorage.setItem(storageKey, JSON.stringify(saved));
+        });
+    });
+});
@@ -3,6 +3,7 @@ document.addEventListener('DOMContentLoaded', function() {
   const modal = document.getElementById("editCommentModal");
   const idField = document.getElementById("commentId");
   const contentField = document.getElementById("commentContent");
+  const reasonField = document.getElementById("editReason");
   const editForm = document.getElementById("editCommentForm");
 
   const closeModalBtn = document.getElementById("closeModalBtn");
@@ -38,6 +39,7 @@ document.addEventListener('DOMContentLoaded', function() {
       modal.style.display = "none";
       if (idField) idField.value = '';
       if (contentField) contentField.value = '';
+      if (reasonField) reasonField.value = '';
     }
   }
 
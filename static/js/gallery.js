@@ -0,0 +1,79 @@
+document.addEventListener('DOMContentLoaded', function () {
+    const gallery = document.querySelector('.gallery-editor');
+    if (!gallery) return;
+
+    const postId = parseInt(gallery.dataset.postId, 10);
+    const status = document.querySelector('.gallery-status');
+
+    function showStatus(message, isError) {
+        if (!status) return;
+        status.textContent = message;
+        status.style.color = isError ? '#d93025' : '';
+    }
+
+    function send(url, body) {
+        body.post_id = postId;
+        return fetch(url, {
+            method: 'POST',
+            headers: {'Content-Type': 'application/json'},
+            body: JSON.stringify(body)
+        }).then(function (res) {
+            return res.json().then(function (data) {
+                if (!res.ok) throw new Error(data.error || 'Request failed');
+                showStatus('Saved', false);
+                return data;
+            });
+        }).catch(function (err) {
+            showStatus(err.message, true);
+            throw err;
+        });
+    }
+
+    function items() {
+        return Array.from(gallery.querySelectorAll('.gallery-item'));
+    }
+
+    // Reordering moves the element first and restores it if the server rejects the order
+    gallery.querySelectorAll('.gallery-move').forEach(function (button) {
+        button.addEventListener('click', function () {
+            const item = button.closest('.gallery-item');
+            const list = items();
+            const index = list.indexOf(item);
+            const target = index + parseInt(button.dataset.direction, 10);
+            if (target < 0 || target >= list.length) return;
+
+            const before = list.slice();
+            if (target < index) {
+                gallery.insertBefore(item, list[target]);
+            } else {
+                gallery.insertBefore(item, list[target].nextSibling);
+            }
+            const ids = items().map(function (el) { return parseInt(el.dataset.imageId, 10); });
+            send('/post_images/reorder', {image_ids: ids}).catch(function () {
+                before.forEach(function (el) { gallery.appendChild(el); });
+            });
+        });
+    });
+
+    gallery.querySelectorAll('.gallery-primary').forEach(function (radio) {
+        radio.addEventListener('change', function () {
+            send('/post_images/primary', {image_id: parseInt(radio.value, 10)}).catch(function () {});
+        });
+    });
+
+    // Caption and alt text are saved together when either field loses focus after a change
+    items().forEach(function (item) {
+        item.querySelectorAll('.gallery-caption, .gallery-alt').forEach(function (input) {
+            input.addEventListener('change', function () {
+                send('/post_images/text', {
+                    image_id: parseInt(item.dataset.imageId, 10),
+                    caption: item.querySelector('.gallery-caption').value,
+                    alt_text: item.querySelector('.gallery-alt').value
+                }).then(function () {
+                    const alt = item.querySelector('.gallery-alt').value.trim();
+                    item.querySelector('img').alt = alt || 'Post image';
+                }).catch(function () {});
+            });
+        });
+    });
+});
@@ -8,4 +8,10 @@ document.addEventListener('click', function (e) {
   });
 
   img.classList.toggle('enlarged');
-});
\ No newline at end of file
+
+  // Load the full-size image the first time a thumbnail is enlarged
+  const picture = img.querySelector('img[data-full]');
+  if (picture && img.classList.contains('enlarged') && picture.src !== picture.dataset.full) {
+    picture.src = picture.dataset.full;
+  }
+});
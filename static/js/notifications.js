@@ -40,12 +40,23 @@ document.addEventListener('DOMContentLoaded', function () {
     const typeText = getNotificationText(n.type);
         const isUnread = !n.is_read;
 
+        // Moderation decisions read differently and may carry a reason
+        const subject = n.type === 'rejected' ? 'your submission'
+            : n.type === 'approved' ? `your submission in "${escapeHTML(n.post_title)}"`
+            : `your post "${n.post_title}"`;
+        const reason = n.message ? `: ${escapeHTML(n.message)}` : '';
+        const linkText = n.type === 'rejected' ? 'See your submissions'
+            : n.type === 'badge' ? 'See badges' : 'Go to this post';
+        // Badges are earned, nobody did anything to the member
+        const text = n.type === 'badge' ? `You earned the <strong>${escapeHTML(n.message)}</strong> badge`
+            : `<strong>${n.actor}</strong> ${typeText} ${subject}${reason}`;
+
         el.innerHTML = `
             <div class="notification-content">
-            <strong>${n.actor}</strong> ${typeText} your post "${n.post_title}"
+            ${text}
             <small>${formatTime(n.created_at)}</small>
             <br>
-                <a href="/post_page/${n.post_id}" class="notification-link">Go to this post</a>
+                <a href="${n.link}" class="notification-link">${linkText}</a>
             </div>
             ${isUnread ? '<div class="notification-dot"></div>' : ''}
         `;
@@ -77,11 +88,19 @@ document.addEventListener('DOMContentLoaded', function () {
             'comment': 'commented on',
             'like': 'liked',
             'dislike': 'disliked',
-            'mention': 'mentioned you in'
+            'mention': 'mentioned you in',
+            'approved': 'approved',
+            'rejected': 'rejected'
         };
         return types[type] || 'interacted with';
         }
 
+    function escapeHTML(text) {
+        const div = document.createElement('div');
+        div.textContent = text;
+        return div.innerHTML;
+    }
+
     function formatTime(dateString) {
         const date = new Date(dateString);
         return date.toLocaleTimeString([], {hour: '2-digit', minute:'2-digit'});
@@ -99,7 +118,7 @@ document.addEventListener('DOMContentLoaded', function () {
                 console.error("Error marking notification as read:", error);
             }
         }
-        window.location.href = `/post_page/${n.post_id}`;
+        window.location.href = n.link;
     }
 
     async function markAsRead(notificationId) {
@@ -18,7 +18,7 @@ document.addEventListener("DOMContentLoaded", function() {
         password: formData.get("password")
       };
 
-      const response = await fetch("/login-submit", {
+      const response = await powFetch("login", "/login-submit", {
         method: "POST",
         headers: {
           "Content-Type": "application/json",
@@ -6,7 +6,7 @@ document.addEventListener("DOMContentLoaded", function () {
             e.preventDefault();
             const formData = new FormData(resetForm);
 
-            const res = await fetch("/forgot-password-submit", {
+            const res = await powFetch("reset", "/forgot-password-submit", {
                 method: "POST",
                 body: formData,
             });
@@ -21,7 +21,7 @@ document.addEventListener("DOMContentLoaded", function () {
     }
 
     try {
-      const response = await fetch("/register-submit", {
+      const response = await powFetch("register", "/register-submit", {
         method: "POST",
         body: formData,
         headers: { Accept: "application/json" },
@@ -38,13 +38,17 @@ document.addEventListener('DOMContentLoaded', function () {
                         const replyBtn = form.previousElementSibling;
                         replyBtn.textContent = '↩️ Reply';
 
-                        if (typeof addReplyToDOM === 'function') {
+                        // Held for review: nothing to show in the thread yet
+                        if (data.held) {
+                            form.reset();
+                            alert(data.message);
+                        } else if (typeof addReplyToDOM === 'function') {
                             addReplyToDOM(data.reply);
                         } else {
                             location.reload();
                         }
                     } else {
-                        alert('Error: ' + data.message);
+                        alert('Error: ' + (data.error || data.message));
                     }
                 })
                 .catch(error => {